@@ -0,0 +1,491 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"mynewt.apache.org/newt/util"
+)
+
+var batchContinueOnError bool
+
+type batchStep struct {
+	lineNum int
+	text    string
+
+	// Name of the variable to store the command's output in; empty if the
+	// output is not captured.
+	capture string
+
+	// Value of the --timeout flag for this step; empty for the default.
+	// This limits each request the command sends, not the whole step.
+	reqTimeout string
+
+	// How long the whole step may run; 0 for no limit.
+	timeout time.Duration
+
+	continueOnError bool
+}
+
+var batchCaptureRe = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)\s*:=\s*(.*)$`)
+var batchVarRe = regexp.MustCompile(`\$\{([^}]*)\}`)
+
+func batchParseTimeout(s string) (string, error) {
+	if _, err := strconv.ParseFloat(s, 64); err != nil {
+		return "", util.FmtNewtError("invalid timeout: \"%s\"", s)
+	}
+
+	return s, nil
+}
+
+func batchParseDuration(s string) (time.Duration, error) {
+	secs, err := strconv.ParseFloat(s, 64)
+	if err != nil || secs < 0 {
+		return 0, util.FmtNewtError("invalid duration: \"%s\"", s)
+	}
+
+	return time.Duration(secs * float64(time.Second)), nil
+}
+
+func batchParseBool(s string) (bool, error) {
+	switch s {
+	case "on", "true", "1":
+		return true, nil
+	case "off", "false", "0":
+		return false, nil
+	default:
+		return false, util.FmtNewtError("invalid boolean: \"%s\"", s)
+	}
+}
+
+// Parses a batch file into a list of steps.  The whole file is parsed up
+// front so that a syntax error doesn't leave a device half provisioned.
+func batchParse(filename string) ([]batchStep, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+	defer f.Close()
+
+	steps := []batchStep{}
+	reqTimeout := ""
+	timeout := time.Duration(0)
+	contOnErr := batchContinueOnError
+
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fail := func(err error) error {
+			return util.FmtNewtError("%s:%d: %s", filename, lineNum,
+				err.Error())
+		}
+
+		// set <option> <value>: applies to all subsequent steps.
+		if fields := strings.Fields(line); fields[0] == "set" {
+			if len(fields) != 3 {
+				return nil, fail(util.NewNewtError(
+					"usage: set <step-timeout|request-timeout|" +
+						"continue-on-error> <value>"))
+			}
+
+			switch fields[1] {
+			case "step-timeout":
+				timeout, err = batchParseDuration(fields[2])
+			case "request-timeout":
+				reqTimeout, err = batchParseTimeout(fields[2])
+			case "continue-on-error":
+				contOnErr, err = batchParseBool(fields[2])
+			default:
+				err = util.FmtNewtError("unknown option: \"%s\"", fields[1])
+			}
+			if err != nil {
+				return nil, fail(err)
+			}
+			continue
+		}
+
+		step := batchStep{
+			lineNum:         lineNum,
+			reqTimeout:      reqTimeout,
+			timeout:         timeout,
+			continueOnError: contOnErr,
+		}
+
+		// [opt,opt,...]: applies to this step only.
+		if strings.HasPrefix(line, "[") {
+			end := strings.Index(line, "]")
+			if end == -1 {
+				return nil, fail(util.NewNewtError("unterminated \"[\""))
+			}
+
+			for _, opt := range strings.Split(line[1:end], ",") {
+				opt = strings.TrimSpace(opt)
+				switch {
+				case opt == "continue-on-error":
+					step.continueOnError = true
+				case strings.HasPrefix(opt, "step-timeout="):
+					step.timeout, err = batchParseDuration(
+						strings.TrimPrefix(opt, "step-timeout="))
+				case strings.HasPrefix(opt, "request-timeout="):
+					step.reqTimeout, err = batchParseTimeout(
+						strings.TrimPrefix(opt, "request-timeout="))
+				default:
+					err = util.FmtNewtError("unknown step option: \"%s\"", opt)
+				}
+				if err != nil {
+					return nil, fail(err)
+				}
+			}
+
+			line = strings.TrimSpace(line[end+1:])
+		}
+
+		if m := batchCaptureRe.FindStringSubmatch(line); m != nil {
+			step.capture = m[1]
+			line = m[2]
+		}
+
+		if _, err := shellSplit(line); err != nil {
+			return nil, fail(err)
+		}
+		if line == "" {
+			return nil, fail(util.NewNewtError("missing command"))
+		}
+
+		step.text = line
+		steps = append(steps, step)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+
+	return steps, nil
+}
+
+// Converts a captured value to the text that replaces a variable reference.
+func batchVarStr(itf interface{}) string {
+	switch v := itf.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	case nil:
+		return ""
+	default:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+}
+
+// Looks up a variable reference of the form name[.field|.index]...
+func batchLookup(vars map[string]interface{}, ref string) (interface{},
+	error) {
+
+	parts := strings.Split(ref, ".")
+	cur, ok := vars[parts[0]]
+	if !ok {
+		return nil, util.FmtNewtError("undefined variable: \"%s\"", parts[0])
+	}
+
+	for _, p := range parts[1:] {
+		switch v := cur.(type) {
+		case map[string]interface{}:
+			cur, ok = v[p]
+		case []interface{}:
+			idx, err := strconv.Atoi(p)
+			ok = err == nil && idx >= 0 && idx < len(v)
+			if ok {
+				cur = v[idx]
+			}
+		default:
+			ok = false
+		}
+
+		if !ok {
+			return nil, util.FmtNewtError("\"%s\" not found in ${%s}", p, ref)
+		}
+	}
+
+	return cur, nil
+}
+
+// Replaces each ${var} reference in a command with the captured value.
+func batchExpand(text string, vars map[string]interface{}) (string, error) {
+	var err error
+
+	out := batchVarRe.ReplaceAllStringFunc(text, func(ref string) string {
+		val, lerr := batchLookup(vars, ref[2:len(ref)-1])
+		if lerr != nil {
+			err = lerr
+			return ""
+		}
+		return batchVarStr(val)
+	})
+
+	return out, err
+}
+
+// Runs a command and parses its JSON output.  The output is still written to
+// stdout.
+func batchExecCapture(words []string,
+	flags map[string]string) (int, interface{}, error) {
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return 1, nil, util.ChildNewtError(err)
+	}
+
+	outCh := make(chan []byte)
+	go func() {
+		b, _ := ioutil.ReadAll(r)
+		outCh <- b
+	}()
+
+	stdout := os.Stdout
+	os.Stdout = w
+	status := shellExec(words, flags)
+	os.Stdout = stdout
+
+	w.Close()
+	out := <-outCh
+	r.Close()
+
+	stdout.Write(out)
+
+	var val interface{}
+	dec := json.NewDecoder(bytes.NewReader(out))
+	dec.UseNumber()
+	if err := dec.Decode(&val); err != nil {
+		return status, nil, util.FmtNewtError(
+			"command did not produce JSON output: %s", err.Error())
+	}
+
+	return status, val, nil
+}
+
+// Calls fn, aborting any commands it runs if it takes longer than the
+// specified duration.  The session is closed so that the commands can't
+// reconnect and carry on; the next step reopens it.  Returns true if the
+// deadline expired.
+func batchRunDeadline(d time.Duration, fn func()) bool {
+	if d <= 0 {
+		fn()
+		return false
+	}
+
+	expired := make(chan struct{})
+	timer := time.AfterFunc(d, func() {
+		abortXactCmds()
+		if s, err := GetSesnIfOpen(); err == nil && s.IsOpen() {
+			s.Close()
+		}
+		close(expired)
+	})
+
+	fn()
+
+	timedOut := !timer.Stop()
+	if timedOut {
+		<-expired
+		resumeXactCmds()
+	}
+
+	return timedOut
+}
+
+// Executes a single step.  Returns nil if the step succeeded.
+func batchRunStep(step batchStep, rootFlags map[string]string,
+	vars map[string]interface{}) error {
+
+	text, err := batchExpand(step.text, vars)
+	if err != nil {
+		return err
+	}
+
+	words, err := shellSplit(text)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("=== %d: %s\n", step.lineNum, text)
+
+	// Builtins.
+	switch words[0] {
+	case "sleep":
+		if len(words) != 2 {
+			return util.NewNewtError("usage: sleep <seconds>")
+		}
+		d, err := batchParseDuration(words[1])
+		if err != nil {
+			return err
+		}
+		time.Sleep(d)
+		return nil
+
+	case "shell", "batch":
+		return util.FmtNewtError("\"%s\" cannot be used in a batch file",
+			words[0])
+	}
+
+	flags := make(map[string]string, len(rootFlags)+2)
+	for k, v := range rootFlags {
+		flags[k] = v
+	}
+	if step.reqTimeout != "" {
+		flags["timeout"] = step.reqTimeout
+	}
+
+	shellEnsureOpen()
+
+	var status int
+	var val interface{}
+	timedOut := batchRunDeadline(step.timeout, func() {
+		if step.capture == "" {
+			status = shellExec(words, flags)
+		} else {
+			flags["output"] = OUTPUT_FORMAT_JSON
+			status, val, err = batchExecCapture(words, flags)
+		}
+	})
+
+	if timedOut {
+		return util.FmtNewtError("timed out after %s", step.timeout)
+	}
+	if val != nil {
+		vars[step.capture] = val
+	}
+	if status != 0 {
+		return util.FmtNewtError("exit status %d", status)
+	}
+
+	return err
+}
+
+// Runs each step in turn.  Returns the number of steps that failed, or an
+// error if a failing step stopped the batch.
+func batchRun(filename string, steps []batchStep,
+	rootFlags map[string]string) (int, error) {
+
+	vars := map[string]interface{}{}
+	failed := 0
+	for _, step := range steps {
+		if err := batchRunStep(step, rootFlags, vars); err != nil {
+			failed++
+			fmt.Fprintf(os.Stderr, "Error: %s:%d: %s\n", filename,
+				step.lineNum, err.Error())
+			if !step.continueOnError {
+				return failed, util.FmtNewtError("batch aborted")
+			}
+		}
+	}
+
+	return failed, nil
+}
+
+func batchRunCmd(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		nmUsage(cmd, nil)
+	}
+
+	steps, err := batchParse(args[0])
+	if err != nil {
+		nmUsage(nil, err)
+	}
+
+	rootFlags := shellRootFlags(cmd)
+
+	if _, err := GetSesn(); err != nil {
+		nmUsage(nil, err)
+	}
+
+	failed, err := batchRun(args[0], steps, rootFlags)
+	if err != nil {
+		nmUsage(nil, err)
+	}
+
+	if failed > 0 {
+		nmUsage(nil, util.FmtNewtError("%d of %d steps failed", failed,
+			len(steps)))
+	}
+
+	fmt.Printf("Done; %d steps succeeded\n", len(steps))
+}
+
+func batchCmd() *cobra.Command {
+	batchHelpText := "Run the newtmgr commands in a file over a single " +
+		"session.  Each line is a command, without the leading \"newtmgr\".  " +
+		"Blank lines and lines starting with \"#\" are ignored.\n\n" +
+		"A line of the form \"<name> := <command>\" runs the command with " +
+		"JSON output and stores the result in a variable.  Later commands " +
+		"can refer to it as ${name}, ${name.field} or ${name.list.index}.\n\n" +
+		"\"set step-timeout <seconds>\", \"set request-timeout <seconds>\" " +
+		"and \"set continue-on-error on|off\" apply to all following " +
+		"commands.  A command can be prefixed with \"[step-timeout=<seconds>," +
+		"request-timeout=<seconds>,continue-on-error]\" to apply the options " +
+		"to it alone.  The step timeout limits how long the command as a " +
+		"whole may run; a command that exceeds it is aborted and counts as " +
+		"failed.  The request timeout is the same as the --timeout flag: it " +
+		"limits how long each request waits for a response.  \"sleep " +
+		"<seconds>\" pauses between commands.\n\n" +
+		"The batch stops at the first failing command unless " +
+		"continue-on-error is set.  newtmgr exits with a nonzero status if " +
+		"any command failed."
+
+	batchEx := "  newtmgr -c myserial batch provision.nmb\n\n"
+	batchEx += "  # provision.nmb\n"
+	batchEx += "  [step-timeout=300] up := image upload bin/slinky.img\n"
+	batchEx += "  image test ${up.hash}\n"
+	batchEx += "  [continue-on-error] reset\n"
+	batchEx += "  sleep 5\n"
+	batchEx += "  [request-timeout=30] image confirm\n"
+
+	batchCmd := &cobra.Command{
+		Use:     "batch <file> -c <conn_profile>",
+		Short:   "Run a file of commands over a single session",
+		Long:    batchHelpText,
+		Example: batchEx,
+		Run:     batchRunCmd,
+	}
+	batchCmd.Flags().BoolVar(&batchContinueOnError, "continue-on-error",
+		false, "Keep going after a command fails")
+
+	return batchCmd
+}
@@ -0,0 +1,207 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"mynewt.apache.org/newtmgr/nmxact/sim"
+)
+
+// Writes a batch file and parses it.
+func testBatchParse(t *testing.T, text string) (string, []batchStep, error) {
+	f, err := ioutil.TempFile("", "newtmgr-batch")
+	if err != nil {
+		t.Fatalf("failed to create batch file: %s", err.Error())
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(text); err != nil {
+		t.Fatalf("failed to write batch file: %s", err.Error())
+	}
+
+	steps, err := batchParse(f.Name())
+	return f.Name(), steps, err
+}
+
+func TestBatchParse(t *testing.T) {
+	filename, steps, err := testBatchParse(t,
+		"# provisioning\n"+
+			"\n"+
+			"up := image upload x.img\n"+
+			"set request-timeout 5\n"+
+			"[continue-on-error,step-timeout=1.5] image test ${up.hash}\n"+
+			"set continue-on-error on\n"+
+			"[request-timeout=0.5] v := echo \"a b\"\n")
+	defer os.Remove(filename)
+	if err != nil {
+		t.Fatalf("parse failed: %s", err.Error())
+	}
+
+	want := []batchStep{
+		{
+			lineNum: 3,
+			text:    "image upload x.img",
+			capture: "up",
+		},
+		{
+			lineNum:         5,
+			text:            "image test ${up.hash}",
+			reqTimeout:      "5",
+			timeout:         1500 * time.Millisecond,
+			continueOnError: true,
+		},
+		{
+			lineNum:         7,
+			text:            "echo \"a b\"",
+			capture:         "v",
+			reqTimeout:      "0.5",
+			continueOnError: true,
+		},
+	}
+	if !reflect.DeepEqual(steps, want) {
+		t.Errorf("got steps %+v; want %+v", steps, want)
+	}
+}
+
+func TestBatchParseInvalid(t *testing.T) {
+	for _, text := range []string{
+		"set continue-on-error\n",
+		"set bogus 1\n",
+		"set continue-on-error maybe\n",
+		"set request-timeout soon\n",
+		"set step-timeout -1\n",
+		"[continue-on-error reset\n",
+		"[retries=3] reset\n",
+		"[step-timeout=x] reset\n",
+		"[continue-on-error]\n",
+		"x := \n",
+		"echo \"unterminated\n",
+	} {
+		filename, _, err := testBatchParse(t, text)
+		os.Remove(filename)
+		if err == nil {
+			t.Errorf("accepted invalid batch file: %q", text)
+		}
+	}
+}
+
+func TestBatchExpand(t *testing.T) {
+	vars := map[string]interface{}{
+		"up": map[string]interface{}{
+			"hash": "c0ffee",
+			"images": []interface{}{
+				map[string]interface{}{"slot": json.Number("1")},
+			},
+		},
+		"ok": true,
+	}
+
+	tests := []struct {
+		text  string
+		out   string
+		valid bool
+	}{
+		{"image test ${up.hash}", "image test c0ffee", true},
+		{"${up.images.0.slot} ${ok}", "1 true", true},
+		{"${up.images}", `[{"slot":1}]`, true},
+		{"no variables", "no variables", true},
+		{"${missing}", "", false},
+		{"${up.nope}", "", false},
+		{"${up.images.1}", "", false},
+		{"${up.hash.0}", "", false},
+	}
+
+	for _, test := range tests {
+		out, err := batchExpand(test.text, vars)
+		if test.valid {
+			if err != nil {
+				t.Errorf("\"%s\": %s", test.text, err.Error())
+			} else if out != test.out {
+				t.Errorf("\"%s\": got \"%s\"; want \"%s\"", test.text, out,
+					test.out)
+			}
+		} else if err == nil {
+			t.Errorf("\"%s\": expanded to \"%s\"; want error", test.text,
+				out)
+		}
+	}
+}
+
+func TestBatchStepTimeout(t *testing.T) {
+	// The device stops responding after it resets, so the echo commands
+	// stall until the step deadline aborts them.
+	cfg := sim.NewXportCfg()
+	cfg.ResetDelay = time.Hour
+	_, done := testSimGlobalSesn(t, cfg)
+	defer done()
+
+	// Without the step deadline, each command would wait this long.
+	rootFlags := map[string]string{"timeout": "30"}
+
+	tests := []struct {
+		text    string
+		failed  int
+		aborted bool
+	}{
+		{
+			text: "reset\n" +
+				"[step-timeout=0.2,continue-on-error] echo stalled\n" +
+				"sleep 0\n",
+			failed:  1,
+			aborted: false,
+		},
+		{
+			text: "set step-timeout 0.2\n" +
+				"echo stalled\n" +
+				"sleep 0\n",
+			failed:  1,
+			aborted: true,
+		},
+	}
+
+	for i, test := range tests {
+		filename, steps, err := testBatchParse(t, test.text)
+		defer os.Remove(filename)
+		if err != nil {
+			t.Fatalf("test %d: %s", i, err.Error())
+		}
+
+		start := time.Now()
+		failed, err := batchRun(filename, steps, rootFlags)
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("test %d: batch took %s", i, elapsed)
+		}
+
+		if failed != test.failed {
+			t.Errorf("test %d: %d steps failed; want %d", i, failed,
+				test.failed)
+		}
+		if (err != nil) != test.aborted {
+			t.Errorf("test %d: aborted=%v; want %v", i, err != nil,
+				test.aborted)
+		}
+	}
+}
@@ -24,6 +24,7 @@ import (
 	"github.com/spf13/cobra"
 
 	"mynewt.apache.org/newt/util"
+	"mynewt.apache.org/newtmgr/newtmgr/daemon"
 	"mynewt.apache.org/newtmgr/newtmgr/nmutil"
 	"mynewt.apache.org/newtmgr/nmxact/nmxutil"
 )
@@ -31,6 +32,11 @@ import (
 var NewtmgrLogLevel log.Level
 var NewtmgrHelp bool
 
+// The log level most recently applied to nmxact, if any.  The shell and batch
+// commands run many commands in one process while transport goroutines log,
+// so the shared loggers are only reconfigured when the level changes.
+var nmxactLogLevel *log.Level
+
 func Commands() *cobra.Command {
 	logLevelStr := ""
 	nmCmd := &cobra.Command{
@@ -46,7 +52,18 @@ func Commands() *cobra.Command {
 			if err != nil {
 				nmUsage(nil, err)
 			}
-			nmxutil.SetLogLevel(NewtmgrLogLevel)
+			if nmxactLogLevel == nil || *nmxactLogLevel != NewtmgrLogLevel {
+				nmxutil.SetLogLevel(NewtmgrLogLevel)
+				nmxactLogLevel = &NewtmgrLogLevel
+			}
+
+			if err := checkOutputFormat(); err != nil {
+				nmUsage(nil, err)
+			}
+
+			if nmutil.Fleet != "" {
+				fleetRunCmd(cmd, args)
+			}
 		},
 		Run: func(cmd *cobra.Command, args []string) {
 			cmd.HelpFunc()(cmd, args)
@@ -81,6 +98,24 @@ func Commands() *cobra.Command {
 	nmCmd.PersistentFlags().StringVar(&nmutil.ConnExtra, "connextra", "",
 		"Additional key-value pair to append to the connstring")
 
+	nmCmd.PersistentFlags().StringVarP(&nmutil.OutputFormat, "output", "o",
+		OUTPUT_FORMAT_TEXT, "Output format for device responses: text, json, "+
+			"or yaml")
+
+	nmCmd.PersistentFlags().StringVar(&nmutil.Daemon, "daemon", "",
+		"Send requests through the newtmgr daemon listening on this socket "+
+			"(--daemon=<path>); the default socket if no path is given")
+	nmCmd.PersistentFlags().Lookup("daemon").NoOptDefVal =
+		daemon.DefaultSockPath()
+
+	nmCmd.PersistentFlags().StringVar(&nmutil.Fleet, "fleet", "",
+		"Run the command against several devices: a YAML device list, a "+
+			"file of connection profile names, or a comma-separated list "+
+			"of profile names")
+
+	nmCmd.PersistentFlags().IntVar(&nmutil.FleetJobs, "jobs", 8,
+		"Maximum number of devices to run a --fleet command against at once")
+
 	nmCmd.AddCommand(crashCmd())
 	nmCmd.AddCommand(dateTimeCmd())
 	nmCmd.AddCommand(fsCmd())
@@ -95,6 +130,13 @@ func Commands() *cobra.Command {
 	nmCmd.AddCommand(connProfileCmd())
 	nmCmd.AddCommand(echoCmd())
 	nmCmd.AddCommand(resCmd())
+	nmCmd.AddCommand(shellCmd())
+	nmCmd.AddCommand(batchCmd())
+	nmCmd.AddCommand(daemonCmd())
+	nmCmd.AddCommand(rolloutCmd())
+	nmCmd.AddCommand(healthCmd())
+	nmCmd.AddCommand(topCmd())
+	nmCmd.AddCommand(shellExecCmd())
 
 	return nmCmd
 }
@@ -21,18 +21,24 @@ package cli
 
 import (
 	"fmt"
+	"os"
+	"sync"
 
 	log "github.com/Sirupsen/logrus"
 
 	"mynewt.apache.org/newt/util"
 	"mynewt.apache.org/newtmgr/newtmgr/bll"
 	"mynewt.apache.org/newtmgr/newtmgr/config"
+	"mynewt.apache.org/newtmgr/newtmgr/daemon"
 	"mynewt.apache.org/newtmgr/newtmgr/nmutil"
 	"mynewt.apache.org/newtmgr/nmxact/mtech_lora"
 	"mynewt.apache.org/newtmgr/nmxact/nmble"
 	"mynewt.apache.org/newtmgr/nmxact/nmserial"
 	"mynewt.apache.org/newtmgr/nmxact/sesn"
+	"mynewt.apache.org/newtmgr/nmxact/sim"
+	"mynewt.apache.org/newtmgr/nmxact/tcp"
 	"mynewt.apache.org/newtmgr/nmxact/udp"
+	"mynewt.apache.org/newtmgr/nmxact/xact"
 	"mynewt.apache.org/newtmgr/nmxact/xport"
 )
 
@@ -101,16 +107,9 @@ func getConnProfile() (*config.ConnProfile, error) {
 	return globalP, nil
 }
 
-func GetXport() (xport.Xport, error) {
-	if globalXport != nil {
-		return globalXport, nil
-	}
-
-	cp, err := getConnProfile()
-	if err != nil {
-		return nil, err
-	}
-
+// Creates a transport for the specified connection profile.  The transport
+// is not started.
+func buildXport(cp *config.ConnProfile) (xport.Xport, error) {
 	switch cp.Type {
 	case config.CONN_TYPE_SERIAL_PLAIN, config.CONN_TYPE_SERIAL_OIC:
 		sc, err := config.ParseSerialConnString(cp.ConnString)
@@ -118,7 +117,7 @@ func GetXport() (xport.Xport, error) {
 			return nil, err
 		}
 
-		globalXport = nmserial.NewSerialXport(sc)
+		return nmserial.NewSerialXport(sc), nil
 
 	case config.CONN_TYPE_BLL_PLAIN, config.CONN_TYPE_BLL_OIC:
 		bc, err := config.ParseBllConnString(cp.ConnString)
@@ -130,30 +129,54 @@ func GetXport() (xport.Xport, error) {
 		if bc.CtlrName != "" {
 			cfg.CtlrName = bc.CtlrName
 		}
-		globalXport = bll.NewBllXport(cfg)
+		return bll.NewBllXport(cfg), nil
 
 	case config.CONN_TYPE_BLE_PLAIN, config.CONN_TYPE_BLE_OIC:
 		bc, err := config.ParseBleConnString(cp.ConnString)
 		if err != nil {
 			return nil, err
 		}
-		globalXport, err = config.BuildBleXport(bc)
-		if err != nil {
-			return nil, err
-		}
+		return config.BuildBleXport(bc)
 
 	case config.CONN_TYPE_UDP_PLAIN, config.CONN_TYPE_UDP_OIC:
-		globalXport = udp.NewUdpXport()
+		return udp.NewUdpXport(), nil
+
+	case config.CONN_TYPE_TCP_PLAIN, config.CONN_TYPE_TCP_OIC:
+		return tcp.NewTcpXport(), nil
 
 	case config.CONN_TYPE_MTECH_LORA_OIC:
 		cfg := mtech_lora.NewXportCfg()
-		globalXport = mtech_lora.NewLoraXport(cfg)
+		return mtech_lora.NewLoraXport(cfg), nil
+
+	case config.CONN_TYPE_SIM_PLAIN, config.CONN_TYPE_SIM_OIC:
+		cfg, err := config.ParseSimConnString(cp.ConnString)
+		if err != nil {
+			return nil, err
+		}
+		return sim.NewSimXport(cfg), nil
 
 	default:
 		return nil, util.FmtNewtError("Unknown connection type: %s (%d)",
 			config.ConnTypeToString(cp.Type), int(cp.Type))
 	}
+}
+
+func GetXport() (xport.Xport, error) {
+	if globalXport != nil {
+		return globalXport, nil
+	}
 
+	cp, err := getConnProfile()
+	if err != nil {
+		return nil, err
+	}
+
+	x, err := buildXport(cp)
+	if err != nil {
+		return nil, err
+	}
+
+	globalXport = x
 	globalXportSet = true
 
 	if err := globalXport.Start(); err != nil {
@@ -171,13 +194,10 @@ func GetXportIfOpen() (xport.Xport, error) {
 	return globalXport, nil
 }
 
-func buildSesnCfg() (sesn.SesnCfg, error) {
-	sc := sesn.NewSesnCfg()
+func buildSesnCfg(cp *config.ConnProfile,
+	x xport.Xport) (sesn.SesnCfg, error) {
 
-	cp, err := getConnProfile()
-	if err != nil {
-		return sc, err
-	}
+	sc := sesn.NewSesnCfg()
 
 	switch cp.Type {
 	case config.CONN_TYPE_SERIAL_PLAIN:
@@ -194,10 +214,6 @@ func buildSesnCfg() (sesn.SesnCfg, error) {
 			return sc, err
 		}
 
-		x, err := GetXport()
-		if err != nil {
-			return sc, err
-		}
 		bx := x.(*nmble.BleXport)
 
 		sc.MgmtProto = sesn.MGMT_PROTO_NMP
@@ -213,10 +229,6 @@ func buildSesnCfg() (sesn.SesnCfg, error) {
 			return sc, err
 		}
 
-		x, err := GetXport()
-		if err != nil {
-			return sc, err
-		}
 		bx := x.(*nmble.BleXport)
 
 		sc.MgmtProto = sesn.MGMT_PROTO_OMP
@@ -238,6 +250,18 @@ func buildSesnCfg() (sesn.SesnCfg, error) {
 
 		return sc, nil
 
+	case config.CONN_TYPE_TCP_PLAIN:
+		sc.MgmtProto = sesn.MGMT_PROTO_NMP
+		sc.PeerSpec.Tcp = cp.ConnString
+
+		return sc, nil
+
+	case config.CONN_TYPE_TCP_OIC:
+		sc.MgmtProto = sesn.MGMT_PROTO_OMP
+		sc.PeerSpec.Tcp = cp.ConnString
+
+		return sc, nil
+
 	case config.CONN_TYPE_MTECH_LORA_OIC:
 		mc, err := config.ParseMtechLoraConnString(cp.ConnString)
 		if err != nil {
@@ -247,6 +271,14 @@ func buildSesnCfg() (sesn.SesnCfg, error) {
 		err = config.FillMtechLoraSesnCfg(mc, &sc)
 		return sc, err
 
+	case config.CONN_TYPE_SIM_PLAIN:
+		sc.MgmtProto = sesn.MGMT_PROTO_NMP
+		return sc, nil
+
+	case config.CONN_TYPE_SIM_OIC:
+		sc.MgmtProto = sesn.MGMT_PROTO_OMP
+		return sc, nil
+
 	default:
 		return sc, util.FmtNewtError("Unknown connection type: %s (%d)",
 			config.ConnTypeToString(cp.Type), int(cp.Type))
@@ -254,16 +286,12 @@ func buildSesnCfg() (sesn.SesnCfg, error) {
 
 }
 
-func buildBllSesn(cp *config.ConnProfile) (sesn.Sesn, error) {
+func buildBllSesn(cp *config.ConnProfile, x xport.Xport) (sesn.Sesn, error) {
 	bc, err := config.ParseBllConnString(cp.ConnString)
 	if err != nil {
 		return nil, err
 	}
 
-	x, err := GetXport()
-	if err != nil {
-		return nil, err
-	}
 	bx := x.(*bll.BllXport)
 
 	sc, err := config.BuildBllSesnCfg(bc)
@@ -290,6 +318,32 @@ func buildBllSesn(cp *config.ConnProfile) (sesn.Sesn, error) {
 	return s, nil
 }
 
+// Creates a session for the specified connection profile over the specified
+// transport.  The session is not opened.
+func buildSesn(cp *config.ConnProfile, x xport.Xport) (sesn.Sesn, error) {
+	if cp.Type == config.CONN_TYPE_BLL_PLAIN ||
+		cp.Type == config.CONN_TYPE_BLL_OIC {
+
+		s, err := buildBllSesn(cp, x)
+		if err != nil {
+			return nil, util.ChildNewtError(err)
+		}
+		return s, nil
+	}
+
+	sc, err := buildSesnCfg(cp, x)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := x.BuildSesn(sc)
+	if err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+
+	return s, nil
+}
+
 func GetSesn() (sesn.Sesn, error) {
 	if globalSesn != nil {
 		return globalSesn, nil
@@ -301,27 +355,17 @@ func GetSesn() (sesn.Sesn, error) {
 	}
 
 	var s sesn.Sesn
-	if cp.Type == config.CONN_TYPE_BLL_PLAIN ||
-		cp.Type == config.CONN_TYPE_BLL_OIC {
-
-		s, err = buildBllSesn(cp)
-		if err != nil {
-			return nil, util.ChildNewtError(err)
-		}
+	if nmutil.Daemon != "" {
+		s = daemon.NewDaemonSesn(nmutil.Daemon, daemonConnSpec(cp))
 	} else {
-		sc, err := buildSesnCfg()
-		if err != nil {
-			return nil, err
-		}
-
 		x, err := GetXport()
 		if err != nil {
 			return nil, err
 		}
 
-		s, err = x.BuildSesn(sc)
+		s, err = buildSesn(cp, x)
 		if err != nil {
-			return nil, util.ChildNewtError(err)
+			return nil, err
 		}
 	}
 
@@ -340,3 +384,83 @@ func GetSesnIfOpen() (sesn.Sesn, error) {
 
 	return globalSesn, nil
 }
+
+// Commands that are currently running.  A batch step that exceeds its
+// deadline aborts them.
+var xactCmds = struct {
+	running map[xact.Cmd]struct{}
+
+	// While set, new commands fail immediately.
+	aborted bool
+
+	mtx sync.Mutex
+}{
+	running: map[xact.Cmd]struct{}{},
+}
+
+// Runs a command, allowing abortXactCmds() to interrupt it.
+func runXactCmd(c xact.Cmd, s sesn.Sesn) (xact.Result, error) {
+	xactCmds.mtx.Lock()
+	if xactCmds.aborted {
+		xactCmds.mtx.Unlock()
+		return nil, fmt.Errorf("Command aborted")
+	}
+	xactCmds.running[c] = struct{}{}
+	xactCmds.mtx.Unlock()
+
+	defer func() {
+		xactCmds.mtx.Lock()
+		delete(xactCmds.running, c)
+		xactCmds.mtx.Unlock()
+	}()
+
+	return c.Run(s)
+}
+
+// Aborts all running commands and prevents new ones from starting until
+// resumeXactCmds() is called.
+func abortXactCmds() {
+	xactCmds.mtx.Lock()
+	defer xactCmds.mtx.Unlock()
+
+	xactCmds.aborted = true
+	for c := range xactCmds.running {
+		c.Abort()
+	}
+}
+
+func resumeXactCmds() {
+	xactCmds.mtx.Lock()
+	defer xactCmds.mtx.Unlock()
+
+	xactCmds.aborted = false
+}
+
+// Opens the temporary file that a download to dst is written to until it is
+// complete.  Data already in the file is left over from an interrupted
+// download, so the file is opened for appending; the caller can resume the
+// download after the returned number of bytes.  If restart is set, leftover
+// data is discarded instead.
+func openDownloadTmp(dst string, restart bool) (*os.File, string, int) {
+	tmpName := dst + ".tmp"
+	if restart {
+		if err := os.Remove(tmpName); err != nil && !os.IsNotExist(err) {
+			nmUsage(nil, util.ChildNewtError(err))
+		}
+	}
+
+	file, err := os.OpenFile(tmpName, os.O_WRONLY|os.O_CREATE|os.O_APPEND,
+		0660)
+	if err != nil {
+		nmUsage(nil, util.FmtNewtError(
+			"Cannot open file %s - %s", tmpName, err.Error()))
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		nmUsage(nil, util.ChildNewtError(err))
+	}
+
+	return file, tmpName, int(info.Size())
+}
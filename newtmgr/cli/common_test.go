@@ -0,0 +1,78 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"mynewt.apache.org/newtmgr/newtmgr/nmutil"
+	"mynewt.apache.org/newtmgr/nmxact/sesn"
+	"mynewt.apache.org/newtmgr/nmxact/sim"
+)
+
+// Makes a simulated device the global session and sets the transaction
+// options that commands use.  The returned function restores the previous
+// state.
+func testSimGlobalSesn(t *testing.T, cfg *sim.XportCfg) (*sim.SimXport,
+	func()) {
+
+	sx := sim.NewSimXport(cfg)
+	if err := sx.Start(); err != nil {
+		t.Fatalf("failed to start sim transport: %s", err.Error())
+	}
+
+	s, err := sx.BuildSesn(sesn.NewSesnCfg())
+	if err != nil {
+		sx.Stop()
+		t.Fatalf("failed to build sim session: %s", err.Error())
+	}
+	if err := s.Open(); err != nil {
+		sx.Stop()
+		t.Fatalf("failed to open sim session: %s", err.Error())
+	}
+
+	timeout, tries := nmutil.Timeout, nmutil.Tries
+	nmutil.Timeout, nmutil.Tries = 10, 1
+
+	globalSesn = s
+	return sx, func() {
+		globalSesn = nil
+		nmutil.Timeout, nmutil.Tries = timeout, tries
+		sx.Stop()
+	}
+}
+
+// Writes text to a temporary file.  The returned function removes the file.
+func testTempFile(t *testing.T, text string) (string, func()) {
+	f, err := ioutil.TempFile("", "newtmgr-test")
+	if err != nil {
+		t.Fatalf("failed to create file: %s", err.Error())
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(text); err != nil {
+		os.Remove(f.Name())
+		t.Fatalf("failed to write file: %s", err.Error())
+	}
+
+	return f.Name(), func() { os.Remove(f.Name()) }
+}
@@ -35,14 +35,19 @@ func configRead(s sesn.Sesn, args []string) {
 	c.SetTxOptions(nmutil.TxOptions())
 	c.Name = args[0]
 
-	res, err := c.Run(s)
+	res, err := runXactCmd(c, s)
 	if err != nil {
 		nmUsage(nil, util.ChildNewtError(err))
 	}
 
 	sres := res.(*xact.ConfigReadResult)
+	if outputStructured() {
+		outputRsp(sres.Rsp, sres.Rsp.Rc)
+		return
+	}
+
 	if sres.Rsp.Rc != 0 {
-		fmt.Printf("Error: %d\n", sres.Rsp.Rc)
+		rspError(sres.Rsp.Rc)
 	} else {
 		fmt.Printf("Value: %s\n", sres.Rsp.Val)
 	}
@@ -54,14 +59,19 @@ func configWrite(s sesn.Sesn, args []string) {
 	c.Name = args[0]
 	c.Val = args[1]
 
-	res, err := c.Run(s)
+	res, err := runXactCmd(c, s)
 	if err != nil {
 		nmUsage(nil, util.ChildNewtError(err))
 	}
 
 	sres := res.(*xact.ConfigWriteResult)
+	if outputStructured() {
+		outputRsp(sres.Rsp, sres.Rsp.Rc)
+		return
+	}
+
 	if sres.Rsp.Rc != 0 {
-		fmt.Printf("Error: %d\n", sres.Rsp.Rc)
+		rspError(sres.Rsp.Rc)
 	} else {
 		fmt.Printf("Done\n")
 	}
@@ -92,5 +102,9 @@ func configCmd() *cobra.Command {
 		Run:   configRunCmd,
 	}
 
+	configCmd.AddCommand(configExportCmd())
+	configCmd.AddCommand(configImportCmd())
+	configCmd.AddCommand(configDiffCmd())
+
 	return configCmd
 }
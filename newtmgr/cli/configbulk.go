@@ -0,0 +1,645 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"mynewt.apache.org/newt/util"
+	"mynewt.apache.org/newtmgr/newtmgr/config"
+	"mynewt.apache.org/newtmgr/newtmgr/daemon"
+	"mynewt.apache.org/newtmgr/newtmgr/nmutil"
+	"mynewt.apache.org/newtmgr/nmxact/sesn"
+	"mynewt.apache.org/newtmgr/nmxact/xact"
+	"mynewt.apache.org/newtmgr/nmxact/xport"
+)
+
+var configNamesFile string
+var configDiffWith string
+var configDiffAll bool
+
+// The config group has no request that lists settings, so the names always
+// come from the user.
+const configNoNamesText = "no setting names specified; the device cannot " +
+	"report them, so list them as arguments or with --names"
+
+// Reads one setting.  A nonzero status (e.g., the setting doesn't exist) is
+// returned as rc rather than as an error.
+func configReadVal(s sesn.Sesn, name string) (string, int, error) {
+	c := xact.NewConfigReadCmd()
+	c.SetTxOptions(nmutil.TxOptions())
+	c.Name = name
+
+	res, err := runXactCmd(c, s)
+	if err != nil {
+		return "", 0, util.ChildNewtError(err)
+	}
+
+	rsp := res.(*xact.ConfigReadResult).Rsp
+	return rsp.Val, rsp.Rc, nil
+}
+
+func configWriteVal(s sesn.Sesn, name string, val string) (int, error) {
+	c := xact.NewConfigWriteCmd()
+	c.SetTxOptions(nmutil.TxOptions())
+	c.Name = name
+	c.Val = val
+
+	res, err := runXactCmd(c, s)
+	if err != nil {
+		return 0, util.ChildNewtError(err)
+	}
+
+	return res.(*xact.ConfigWriteResult).Rsp.Rc, nil
+}
+
+// Reads a list of setting names, one per line.  Blank lines and lines
+// starting with '#' are ignored.
+func configReadNames(filename string) ([]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+	defer f.Close()
+
+	names := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		names = append(names, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+
+	return names, nil
+}
+
+// Gathers setting names from the command line and the --names file.
+func configNames(args []string) ([]string, error) {
+	names := append([]string{}, args...)
+
+	if configNamesFile != "" {
+		more, err := configReadNames(configNamesFile)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, more...)
+	}
+
+	return names, nil
+}
+
+// Parses the quoted or plain YAML scalar at the start of s and returns the
+// rest of the line.  A plain key ends at the first ": "; a plain value ends
+// at a comment.
+func configScalar(s string, key bool) (string, string, error) {
+	if s == "" {
+		return "", "", nil
+	}
+
+	switch s[0] {
+	case '"':
+		for i := 1; i < len(s); i++ {
+			switch s[i] {
+			case '\\':
+				i++
+			case '"':
+				val, err := strconv.Unquote(s[:i+1])
+				if err != nil {
+					return "", "", util.FmtNewtError("invalid string %s",
+						s[:i+1])
+				}
+				return val, s[i+1:], nil
+			}
+		}
+		return "", "", util.NewNewtError("unterminated string")
+
+	case '\'':
+		for i := 1; i < len(s); i++ {
+			if s[i] != '\'' {
+				continue
+			}
+			if i+1 < len(s) && s[i+1] == '\'' {
+				i++
+				continue
+			}
+			return strings.Replace(s[1:i], "''", "'", -1), s[i+1:], nil
+		}
+		return "", "", util.NewNewtError("unterminated string")
+
+	case '[', '{', '|', '>', '&', '*', '!':
+		return "", "", util.NewNewtError("expected a plain or quoted string")
+	}
+
+	if key {
+		end := strings.Index(s, ": ")
+		if end < 0 && strings.HasSuffix(s, ":") {
+			end = len(s) - 1
+		}
+		if end < 0 {
+			end = len(s)
+		}
+		return strings.TrimSpace(s[:end]), s[end:], nil
+	}
+
+	end := strings.Index(s, " #")
+	if end < 0 {
+		end = len(s)
+	}
+	val := strings.TrimSpace(s[:end])
+	if val == "~" || val == "null" {
+		val = ""
+	}
+	return val, "", nil
+}
+
+// Reads a settings file: a YAML mapping of setting names to values, one per
+// line, as written by "config export".  The YAML library converts anything
+// that looks like a number or a boolean, even if it is quoted, so the file is
+// parsed here instead; each value is written exactly as spelled (007 is not
+// 7).
+func configReadFile(filename string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+
+	settings := map[string]string{}
+	for i, line := range strings.Split(string(data), "\n") {
+		fail := func(msg string) (map[string]string, error) {
+			return nil, util.FmtNewtError("%s:%d: %s", filename, i+1, msg)
+		}
+
+		line = strings.TrimRight(line, " \t\r")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed[0] == '#' ||
+			trimmed == "---" || trimmed == "..." {
+
+			continue
+		}
+
+		// Only a flat mapping is accepted.
+		if trimmed != line || line[0] == '-' {
+			return fail("expected <var-name>: <var-value>")
+		}
+
+		name, rest, err := configScalar(line, true)
+		if err != nil {
+			return fail(err.Error())
+		}
+		if name == "" || !strings.HasPrefix(rest, ":") {
+			return fail("expected <var-name>: <var-value>")
+		}
+		rest = rest[1:]
+		if rest != "" && rest[0] != ' ' && rest[0] != '\t' {
+			return fail("expected a space after \":\"")
+		}
+
+		val, rest, err := configScalar(strings.TrimLeft(rest, " \t"), false)
+		if err != nil {
+			return fail(fmt.Sprintf("value of \"%s\": %s", name,
+				err.Error()))
+		}
+		rest = strings.TrimLeft(rest, " \t")
+		if rest != "" && rest[0] != '#' {
+			return fail(fmt.Sprintf("unexpected text after value of \"%s\"",
+				name))
+		}
+
+		if _, ok := settings[name]; ok {
+			return fail(fmt.Sprintf("\"%s\" is listed more than once",
+				name))
+		}
+		settings[name] = val
+	}
+
+	return settings, nil
+}
+
+func configSortedNames(settings map[string]string) []string {
+	names := make([]string, 0, len(settings))
+	for n, _ := range settings {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Opens a session to the device described by the named connection profile,
+// sharing the current transport if the two devices are reached through the
+// same one.  The returned function closes the session and any transport
+// that was created for it.
+func configPeerSesn(profile string) (sesn.Sesn, func(), error) {
+	cp, err := config.GlobalConnProfileMgr().GetConnProfile(profile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	spec := daemonConnSpec(cp)
+
+	// --name identifies the primary device only.
+	spec.Name = ""
+	savedName := nmutil.DeviceName
+	nmutil.DeviceName = ""
+	defer func() { nmutil.DeviceName = savedName }()
+
+	var s sesn.Sesn
+	var ownXport xport.Xport
+
+	if nmutil.Daemon != "" {
+		s = daemon.NewDaemonSesn(nmutil.Daemon, spec)
+	} else {
+		gcp, err := getConnProfile()
+		if err != nil {
+			return nil, nil, err
+		}
+		key, err := daemonXportKey(spec)
+		if err != nil {
+			return nil, nil, err
+		}
+		gkey, err := daemonXportKey(daemonConnSpec(gcp))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var x xport.Xport
+		if key == gkey {
+			x, err = GetXport()
+			if err != nil {
+				return nil, nil, err
+			}
+		} else {
+			x, err = buildXport(cp)
+			if err != nil {
+				return nil, nil, err
+			}
+			if err := x.Start(); err != nil {
+				return nil, nil, util.ChildNewtError(err)
+			}
+			ownXport = x
+		}
+
+		s, err = buildSesn(cp, x)
+		if err != nil {
+			if ownXport != nil {
+				ownXport.Stop()
+			}
+			return nil, nil, err
+		}
+	}
+
+	if err := s.Open(); err != nil {
+		if ownXport != nil {
+			ownXport.Stop()
+		}
+		return nil, nil, util.ChildNewtError(err)
+	}
+
+	closeFn := func() {
+		s.Close()
+		if ownXport != nil {
+			ownXport.Stop()
+		}
+	}
+
+	return s, closeFn, nil
+}
+
+func configExportRunCmd(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		nmUsage(cmd, nil)
+	}
+	filename := args[0]
+
+	names, err := configNames(args[1:])
+	if err != nil {
+		nmUsage(nil, err)
+	}
+	if len(names) == 0 {
+		nmUsage(cmd, util.NewNewtError(configNoNamesText))
+	}
+
+	s, err := GetSesn()
+	if err != nil {
+		nmUsage(nil, err)
+	}
+
+	settings := map[string]interface{}{}
+	failed := 0
+	for _, n := range names {
+		val, rc, err := configReadVal(s, n)
+		if err != nil {
+			nmUsage(nil, err)
+		}
+		if rc != 0 {
+			fmt.Fprintf(os.Stderr, "%s: not exported; rc=%d\n", n, rc)
+			failed++
+			continue
+		}
+		settings[n] = val
+	}
+
+	buf := &bytes.Buffer{}
+	buf.WriteString("---\n")
+	yamlWrite(buf, settings, 0)
+
+	if filename == "-" {
+		fmt.Print(buf.String())
+	} else {
+		if err := ioutil.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+			nmUsage(nil, util.ChildNewtError(err))
+		}
+		fmt.Fprintf(progressWriter(), "Exported %d settings to %s\n",
+			len(settings), filename)
+	}
+
+	if failed > 0 {
+		nmCmdExit(1)
+	}
+}
+
+func configImportRunCmd(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		nmUsage(cmd, nil)
+	}
+
+	settings, err := configReadFile(args[0])
+	if err != nil {
+		nmUsage(nil, err)
+	}
+
+	s, err := GetSesn()
+	if err != nil {
+		nmUsage(nil, err)
+	}
+
+	results := []interface{}{}
+	failed := 0
+	for _, n := range configSortedNames(settings) {
+		result := map[string]interface{}{"name": n}
+
+		rc, err := configWriteVal(s, n, settings[n])
+		if err != nil {
+			result["error"] = err.Error()
+			failed++
+		} else {
+			result["rc"] = rc
+			if rc != 0 {
+				failed++
+			}
+		}
+		results = append(results, result)
+
+		if !outputStructured() {
+			if err != nil {
+				fmt.Printf("  %s: error: %s\n", n, err.Error())
+			} else if rc != 0 {
+				fmt.Printf("  %s: error: rc=%d\n", n, rc)
+			} else {
+				fmt.Printf("  %s: ok\n", n)
+			}
+		}
+	}
+
+	if outputStructured() {
+		outputPrintValue(map[string]interface{}{
+			"ok":      failed == 0,
+			"results": results,
+		})
+	} else {
+		fmt.Printf("Wrote %d of %d settings\n", len(settings)-failed,
+			len(settings))
+	}
+
+	if failed > 0 {
+		nmCmdExit(1)
+	}
+}
+
+// Reads the named settings; settings that can't be read are absent from the
+// result.
+func configReadAll(s sesn.Sesn, names []string) (map[string]string, error) {
+	settings := map[string]string{}
+	for _, n := range names {
+		val, rc, err := configReadVal(s, n)
+		if err != nil {
+			return nil, err
+		}
+		if rc == 0 {
+			settings[n] = val
+		}
+	}
+
+	return settings, nil
+}
+
+func configDiffRunCmd(cmd *cobra.Command, args []string) {
+	var names []string
+	var right map[string]string
+	var rightDesc string
+	var err error
+
+	if configDiffWith == "" {
+		if len(args) != 1 {
+			nmUsage(cmd, nil)
+		}
+		right, err = configReadFile(args[0])
+		if err != nil {
+			nmUsage(nil, err)
+		}
+		names = configSortedNames(right)
+		rightDesc = args[0]
+	} else {
+		names, err = configNames(args)
+		if err != nil {
+			nmUsage(nil, err)
+		}
+		if len(names) == 0 {
+			nmUsage(cmd, util.NewNewtError(configNoNamesText))
+		}
+		rightDesc = configDiffWith
+	}
+
+	s, err := GetSesn()
+	if err != nil {
+		nmUsage(nil, err)
+	}
+
+	left, err := configReadAll(s, names)
+	if err != nil {
+		nmUsage(nil, err)
+	}
+
+	if configDiffWith != "" {
+		s2, closeFn, err := configPeerSesn(configDiffWith)
+		if err != nil {
+			nmUsage(nil, err)
+		}
+		right, err = configReadAll(s2, names)
+		closeFn()
+		if err != nil {
+			nmUsage(nil, err)
+		}
+	}
+
+	leftDesc := nmutil.ConnProfile
+	if leftDesc == "" {
+		leftDesc = "device"
+	}
+
+	sort.Strings(names)
+	diffs := []interface{}{}
+	for _, n := range names {
+		lv, lok := left[n]
+		rv, rok := right[n]
+		same := lok == rok && lv == rv
+
+		if !same {
+			d := map[string]interface{}{"name": n}
+			if lok {
+				d["left"] = lv
+			}
+			if rok {
+				d["right"] = rv
+			}
+			diffs = append(diffs, d)
+		}
+
+		if outputStructured() || (same && !configDiffAll) {
+			continue
+		}
+
+		show := func(v string, ok bool) string {
+			if !ok {
+				return "(unset)"
+			}
+			return fmt.Sprintf("%q", v)
+		}
+		mark := "!"
+		if same {
+			mark = " "
+		}
+		fmt.Printf("%s %s: %s %s\n", mark, n, show(lv, lok), show(rv, rok))
+	}
+
+	if outputStructured() {
+		outputPrintValue(map[string]interface{}{
+			"left":        leftDesc,
+			"right":       rightDesc,
+			"same":        len(diffs) == 0,
+			"differences": diffs,
+		})
+	} else {
+		fmt.Printf("%d of %d settings differ between %s and %s\n",
+			len(diffs), len(names), leftDesc, rightDesc)
+	}
+
+	if len(diffs) > 0 {
+		nmCmdExit(1)
+	}
+}
+
+func configExportCmd() *cobra.Command {
+	exportHelpText := "Read the named settings from a device and write " +
+		"them to a YAML file that \"config import\" accepts.  Names are " +
+		"given as arguments, with --names, or both; the device cannot list " +
+		"its settings.  Use - as the filename to write to stdout."
+
+	exportEx := "  newtmgr -c olimex config export unit1.yaml --names " +
+		"settings.txt\n"
+	exportEx += "  newtmgr -c olimex config export - id/serial ble_hs/addr\n"
+
+	exportCmd := &cobra.Command{
+		Use:     "export <file> [var-name...] -c <conn_profile>",
+		Short:   "Export config values from a device to a file",
+		Long:    exportHelpText,
+		Example: exportEx,
+		Run:     configExportRunCmd,
+	}
+
+	exportCmd.Flags().StringVar(&configNamesFile, "names", "",
+		"File listing setting names to export, one per line")
+
+	return exportCmd
+}
+
+func configImportCmd() *cobra.Command {
+	importHelpText := "Write every setting in a YAML file of " +
+		"<var-name>: <var-value> pairs to a device, and report the result " +
+		"for each.  Each value is written exactly as spelled in the file " +
+		"(007 is not converted to 7); quote a value that has leading or " +
+		"trailing spaces or a \" #\".  Exits with a nonzero status if any " +
+		"write fails."
+
+	importEx := "  newtmgr -c olimex config import unit1.yaml\n"
+
+	importCmd := &cobra.Command{
+		Use:     "import <file> -c <conn_profile>",
+		Short:   "Import config values from a file to a device",
+		Long:    importHelpText,
+		Example: importEx,
+		Run:     configImportRunCmd,
+	}
+
+	return importCmd
+}
+
+func configDiffCmd() *cobra.Command {
+	diffHelpText := "Compare a device's settings against a YAML file, or " +
+		"against another device with --with.  When comparing against a " +
+		"file, the file's settings are compared; when comparing two " +
+		"devices, the names are given as arguments or with --names.  " +
+		"Exits with a nonzero status if any setting differs."
+
+	diffEx := "  newtmgr -c unit1 config diff unit1.yaml\n"
+	diffEx += "  newtmgr -c unit1 config diff --with unit2 --names " +
+		"settings.txt\n"
+
+	diffCmd := &cobra.Command{
+		Use:     "diff [file | --with <conn_profile>] -c <conn_profile>",
+		Short:   "Compare config values between devices or files",
+		Long:    diffHelpText,
+		Example: diffEx,
+		Run:     configDiffRunCmd,
+	}
+
+	diffCmd.Flags().StringVar(&configDiffWith, "with", "",
+		"Connection profile of the device to compare against")
+	diffCmd.Flags().StringVar(&configNamesFile, "names", "",
+		"File listing setting names to compare, one per line")
+	diffCmd.Flags().BoolVar(&configDiffAll, "all", false,
+		"Also show settings that match")
+
+	return diffCmd
+}
@@ -0,0 +1,145 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestConfigReadFile(t *testing.T) {
+	tests := []struct {
+		text string
+		want map[string]string
+	}{
+		{"", map[string]string{}},
+		{"---\n", map[string]string{}},
+		{"ble/name: \"nimble\"\n", map[string]string{"ble/name": "nimble"}},
+		{
+			// Values keep their spelling, quoted or not.
+			"id/serial: 007\nlora/enable: \"true\"\nlog/level:\n" +
+				"split/status: TRUE\nbsp/gain: 1.50\n",
+			map[string]string{
+				"id/serial":    "007",
+				"lora/enable":  "true",
+				"log/level":    "",
+				"split/status": "TRUE",
+				"bsp/gain":     "1.50",
+			},
+		},
+		{
+			"# comment\n'a/b': 'it''s'  # trailing\n\"c:d\": \"x\\ty\"\n" +
+				"e/f:   spaced out   \ng/h: ~\n",
+			map[string]string{
+				"a/b": "it's",
+				"c:d": "x\ty",
+				"e/f": "spaced out",
+				"g/h": "",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		filename, cleanup := testTempFile(t, test.text)
+		settings, err := configReadFile(filename)
+		cleanup()
+
+		if err != nil {
+			t.Errorf("%q: %s", test.text, err.Error())
+		} else if !reflect.DeepEqual(settings, test.want) {
+			t.Errorf("%q: got %v; want %v", test.text, settings, test.want)
+		}
+	}
+}
+
+func TestConfigReadFileExported(t *testing.T) {
+	want := map[string]interface{}{
+		"id/serial":   "007",
+		"lora/enable": "true",
+		"ble/name":    "say \"hi\" # now",
+		"log/level":   "",
+		"app/pad":     "  x  ",
+	}
+
+	buf := &bytes.Buffer{}
+	buf.WriteString("---\n")
+	yamlWrite(buf, want, 0)
+
+	filename, cleanup := testTempFile(t, buf.String())
+	defer cleanup()
+
+	settings, err := configReadFile(filename)
+	if err != nil {
+		t.Fatalf("failed to read exported settings: %s", err.Error())
+	}
+	for k, v := range want {
+		if settings[k] != v {
+			t.Errorf("%s: got %q; want %q", k, settings[k], v)
+		}
+	}
+	if len(settings) != len(want) {
+		t.Errorf("got %d settings; want %d", len(settings), len(want))
+	}
+}
+
+func TestConfigReadFileInvalid(t *testing.T) {
+	texts := []string{
+		"ble/name: [a, b]\n",
+		"ble/name: {a: b}\n",
+		"ble/name:\n    a: b\n",
+		"ble/name: \"unterminated\n",
+		"ble/name: 'unterminated\n",
+		"ble/name: \"a\" b\n",
+		"ble/name: a\nble/name: b\n",
+		"ble/name:a\n",
+		"ble/name\n",
+		": value\n",
+		"- a\n- b\n",
+	}
+
+	for _, text := range texts {
+		filename, cleanup := testTempFile(t, text)
+		if settings, err := configReadFile(filename); err == nil {
+			t.Errorf("%q: got %v; want error", text, settings)
+		}
+		cleanup()
+	}
+
+	if _, err := configReadFile("/nonexistent/settings.yml"); err == nil {
+		t.Errorf("missing file: no error")
+	}
+}
+
+func TestConfigReadNames(t *testing.T) {
+	text := "# settings to back up\nble/name\n\n  id/serial  \n#x\n"
+	filename, cleanup := testTempFile(t, text)
+	defer cleanup()
+
+	names, err := configReadNames(filename)
+	if err != nil {
+		t.Fatalf("failed to read names: %s", err.Error())
+	}
+
+	want := []string{"ble/name", "id/serial"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("got names %v; want %v", names, want)
+	}
+}
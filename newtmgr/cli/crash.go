@@ -49,14 +49,19 @@ func crashRunCmd(cmd *cobra.Command, args []string) {
 	c.SetTxOptions(nmutil.TxOptions())
 	c.CrashType = ct
 
-	res, err := c.Run(s)
+	res, err := runXactCmd(c, s)
 	if err != nil {
 		nmUsage(nil, util.ChildNewtError(err))
 	}
 
 	sres := res.(*xact.CrashResult)
+	if outputStructured() {
+		outputRsp(sres.Rsp, sres.Rsp.Rc)
+		return
+	}
+
 	if sres.Rsp.Rc != 0 {
-		fmt.Printf("Error: %d\n", sres.Rsp.Rc)
+		rspError(sres.Rsp.Rc)
 	} else {
 		fmt.Printf("Done\n")
 	}
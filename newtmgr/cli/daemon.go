@@ -0,0 +1,367 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"mynewt.apache.org/newt/util"
+	"mynewt.apache.org/newtmgr/newtmgr/config"
+	"mynewt.apache.org/newtmgr/newtmgr/daemon"
+	"mynewt.apache.org/newtmgr/newtmgr/nmutil"
+	"mynewt.apache.org/newtmgr/nmxact/sesn"
+	"mynewt.apache.org/newtmgr/nmxact/xport"
+)
+
+var daemonSockPath string
+var daemonHttpAddr string
+var daemonAllowRemote bool
+var daemonTokenPath string
+
+// Describes the connection profile to a daemon.  Settings that are normally
+// read from global flags when the session is built travel with the profile.
+func daemonConnSpec(cp *config.ConnProfile) daemon.ConnSpec {
+	return daemon.ConnSpec{
+		Type:       config.ConnTypeToString(cp.Type),
+		ConnString: cp.ConnString,
+		Name:       nmutil.DeviceName,
+		WriteRsp:   nmutil.BleWriteRsp,
+	}
+}
+
+func daemonConnProfile(cs daemon.ConnSpec) (*config.ConnProfile, error) {
+	t, err := config.ConnTypeFromString(cs.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	cp := config.NewConnProfile()
+	cp.Name = "daemon"
+	cp.Type = t
+	cp.ConnString = cs.ConnString
+
+	return cp, nil
+}
+
+func daemonXportKey(cs daemon.ConnSpec) (string, error) {
+	cp, err := daemonConnProfile(cs)
+	if err != nil {
+		return "", err
+	}
+
+	switch cp.Type {
+	case config.CONN_TYPE_SERIAL_PLAIN, config.CONN_TYPE_SERIAL_OIC:
+		sc, err := config.ParseSerialConnString(cp.ConnString)
+		if err != nil {
+			return "", err
+		}
+		return "serial:" + sc.DevPath, nil
+
+	case config.CONN_TYPE_BLL_PLAIN, config.CONN_TYPE_BLL_OIC:
+		bc, err := config.ParseBllConnString(cp.ConnString)
+		if err != nil {
+			return "", err
+		}
+		return "bll:" + bc.CtlrName, nil
+
+	case config.CONN_TYPE_BLE_PLAIN, config.CONN_TYPE_BLE_OIC:
+		// All blehostd transports use the same socket, so there can only be
+		// one.
+		return "bhd", nil
+
+	case config.CONN_TYPE_UDP_PLAIN, config.CONN_TYPE_UDP_OIC:
+		return "udp", nil
+
+	case config.CONN_TYPE_TCP_PLAIN, config.CONN_TYPE_TCP_OIC:
+		return "tcp", nil
+
+	case config.CONN_TYPE_MTECH_LORA_OIC:
+		return "mtech_lora", nil
+
+	case config.CONN_TYPE_SIM_PLAIN, config.CONN_TYPE_SIM_OIC:
+		return "sim:" + cp.ConnString, nil
+
+	default:
+		return "", util.FmtNewtError("Unknown connection type: %s",
+			cs.Type)
+	}
+}
+
+func daemonBuildXport(cs daemon.ConnSpec) (xport.Xport, error) {
+	cp, err := daemonConnProfile(cs)
+	if err != nil {
+		return nil, err
+	}
+
+	return buildXport(cp)
+}
+
+// Called with the daemon's build lock held, so the global flags can safely
+// be borrowed for the duration of the call.
+func daemonBuildSesn(cs daemon.ConnSpec, x xport.Xport) (sesn.Sesn, error) {
+	cp, err := daemonConnProfile(cs)
+	if err != nil {
+		return nil, err
+	}
+
+	name, writeRsp := nmutil.DeviceName, nmutil.BleWriteRsp
+	nmutil.DeviceName, nmutil.BleWriteRsp = cs.Name, cs.WriteRsp
+	defer func() {
+		nmutil.DeviceName, nmutil.BleWriteRsp = name, writeRsp
+	}()
+
+	return buildSesn(cp, x)
+}
+
+// The address of the daemon that client subcommands talk to.
+func daemonAddr() string {
+	if nmutil.Daemon != "" {
+		return nmutil.Daemon
+	}
+	return daemon.DefaultSockPath()
+}
+
+func newDaemonServer(sockPath string, httpAddr string) *daemon.Server {
+	return daemon.NewServer(daemon.ServerCfg{
+		SockPath:    sockPath,
+		HttpAddr:    httpAddr,
+		AllowRemote: daemonAllowRemote,
+		TokenPath:   daemonTokenPath,
+		XportKey:    daemonXportKey,
+		BuildXport:  daemonBuildXport,
+		BuildSesn:   daemonBuildSesn,
+	})
+}
+
+func daemonRunCmd(cmd *cobra.Command, args []string) {
+	srv := newDaemonServer(daemonSockPath, daemonHttpAddr)
+
+	if err := srv.Start(); err != nil {
+		nmUsage(nil, err)
+	}
+
+	prevOnExit := onExit
+	SetOnExit(func() {
+		srv.Stop()
+		if prevOnExit != nil {
+			prevOnExit()
+		}
+	})
+
+	fmt.Printf("Listening on %s\n", daemonSockPath)
+	if daemonHttpAddr != "" {
+		fmt.Printf("Listening on http://%s\n", daemonHttpAddr)
+		fmt.Printf("Access token written to %s\n", daemonTokenPath)
+	}
+
+	// Run until interrupted.
+	select {}
+}
+
+func daemonTimeStr(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+func daemonSessionsCmd(cmd *cobra.Command, args []string) {
+	infos, err := daemon.NewClient(daemonAddr()).Sessions()
+	if err != nil {
+		nmUsage(nil, util.ChildNewtError(err))
+	}
+
+	if outputStructured() {
+		vals := make([]map[string]interface{}, len(infos))
+		for i, info := range infos {
+			vals[i] = map[string]interface{}{
+				"id":         info.Id,
+				"type":       info.Conn.Type,
+				"connstring": info.Conn.ConnString,
+				"name":       info.Conn.Name,
+				"open":       info.Open,
+				"pending":    info.Pending,
+				"tx_count":   info.TxCount,
+				"created":    daemonTimeStr(info.Created),
+				"last_used":  daemonTimeStr(info.LastUsed),
+			}
+		}
+		outputPrint(vals)
+		return
+	}
+
+	if len(infos) == 0 {
+		fmt.Printf("No sessions\n")
+		return
+	}
+
+	for _, info := range infos {
+		state := "closed"
+		if info.Open {
+			state = "open"
+		}
+		fmt.Printf("%d: %s (%s)\n", info.Id, info.Conn.String(), state)
+		fmt.Printf("    pending: %d\n", info.Pending)
+		fmt.Printf("    requests: %d\n", info.TxCount)
+		if !info.LastUsed.IsZero() {
+			fmt.Printf("    last used: %s\n", daemonTimeStr(info.LastUsed))
+		}
+	}
+}
+
+func daemonEventStr(e daemon.Event) string {
+	s := fmt.Sprintf("%s sesn=%d %s", e.Time.Format("15:04:05.000"),
+		e.Sesn, e.Type)
+
+	switch e.Type {
+	case daemon.EVENT_TX, daemon.EVENT_RSP, daemon.EVENT_ERROR:
+		if e.Uri != "" {
+			s += " uri=" + e.Uri
+		} else {
+			s += fmt.Sprintf(" op=%d group=%d id=%d seq=%d",
+				e.Op, e.Group, e.Id, e.Seq)
+		}
+	}
+
+	if e.Type == daemon.EVENT_RSP {
+		s += fmt.Sprintf(" rc=%d", e.Rc)
+		if e.Off != 0 {
+			s += fmt.Sprintf(" off=%d", e.Off)
+		}
+	}
+	if e.Err != "" {
+		s += fmt.Sprintf(" err=\"%s\"", e.Err)
+	}
+
+	return s + fmt.Sprintf(" pending=%d", e.Pending)
+}
+
+func daemonEventsCmd(cmd *cobra.Command, args []string) {
+	err := daemon.NewClient(daemonAddr()).Events(func(e daemon.Event) bool {
+		if outputStructured() {
+			outputPrint(map[string]interface{}{
+				"time":    e.Time.Format(time.RFC3339Nano),
+				"type":    e.Type,
+				"sesn":    e.Sesn,
+				"pending": e.Pending,
+				"op":      e.Op,
+				"group":   e.Group,
+				"id":      e.Id,
+				"seq":     e.Seq,
+				"uri":     e.Uri,
+				"off":     e.Off,
+				"rc":      e.Rc,
+				"err":     e.Err,
+			})
+		} else {
+			fmt.Printf("%s\n", daemonEventStr(e))
+		}
+		return true
+	})
+
+	if err != nil {
+		nmUsage(nil, util.ChildNewtError(err))
+	}
+}
+
+func daemonCloseCmd(cmd *cobra.Command, args []string) {
+	cp, err := getConnProfile()
+	if err != nil {
+		nmUsage(nil, err)
+	}
+
+	err = daemon.NewClient(daemonAddr()).Close(daemonConnSpec(cp))
+	if err != nil {
+		nmUsage(nil, util.ChildNewtError(err))
+	}
+
+	fmt.Printf("Done\n")
+}
+
+func daemonCmd() *cobra.Command {
+	daemonHelpText := "Run a long-lived process that owns newtmgr " +
+		"transports and sessions.  Other newtmgr invocations pass " +
+		"--daemon to send their requests through it instead of setting up " +
+		"their own transport.  Sessions stay open between invocations, so " +
+		"only the first request to a peer pays the connection cost, and " +
+		"several processes can share one BLE controller.\n\n" +
+		"Requests to the same peer are queued and sent one at a time; " +
+		"requests to different peers proceed in parallel.\n\n" +
+		"The daemon serves a JSON API over HTTP on a Unix domain socket " +
+		"(and, with --http, on a local TCP port).  Only the socket's " +
+		"owner can use it.  TCP clients must send the access token that " +
+		"the daemon writes to --token-file as an \"Authorization: Bearer\" " +
+		"header; newtmgr reads it from $" + daemon.TOKEN_ENV + " or the " +
+		"default token file.  Requests from web pages are refused.\n\n" +
+		"Endpoints:\n" +
+		"  POST " + daemon.PATH_OPEN + "      open a session\n" +
+		"  POST " + daemon.PATH_CLOSE + "     close a session\n" +
+		"  POST " + daemon.PATH_NMP + "       send an NMP request\n" +
+		"  POST " + daemon.PATH_COAP + "      send a CoAP request\n" +
+		"  GET  " + daemon.PATH_SESSIONS + "  list sessions\n" +
+		"  GET  " + daemon.PATH_EVENTS + "    stream events, one JSON " +
+		"object per line"
+
+	daemonEx := "  newtmgr daemon &\n"
+	daemonEx += "  newtmgr -c mybleprofile --daemon image list\n"
+	daemonEx += "  newtmgr daemon sessions\n"
+
+	daemonCmd := &cobra.Command{
+		Use:     "daemon",
+		Short:   "Run a daemon that shares sessions between newtmgr clients",
+		Long:    daemonHelpText,
+		Example: daemonEx,
+		Run:     daemonRunCmd,
+	}
+	daemonCmd.Flags().StringVar(&daemonSockPath, "socket",
+		daemon.DefaultSockPath(), "Unix domain socket to listen on")
+	daemonCmd.Flags().StringVar(&daemonHttpAddr, "http", "",
+		"Also listen on this local TCP address (e.g., 127.0.0.1:8900)")
+	daemonCmd.Flags().BoolVar(&daemonAllowRemote, "allow-remote", false,
+		"Allow --http to listen on a non-loopback address")
+	daemonCmd.Flags().StringVar(&daemonTokenPath, "token-file",
+		daemon.DefaultTokenPath(), "File to write the TCP access token to")
+
+	sessionsCmd := &cobra.Command{
+		Use:   "sessions",
+		Short: "List the sessions a running daemon has",
+		Run:   daemonSessionsCmd,
+	}
+	daemonCmd.AddCommand(sessionsCmd)
+
+	eventsCmd := &cobra.Command{
+		Use:   "events",
+		Short: "Stream session activity from a running daemon",
+		Run:   daemonEventsCmd,
+	}
+	daemonCmd.AddCommand(eventsCmd)
+
+	closeCmd := &cobra.Command{
+		Use:   "close -c <conn_profile>",
+		Short: "Close a running daemon's session with a peer",
+		Run:   daemonCloseCmd,
+	}
+	daemonCmd.AddCommand(closeCmd)
+
+	return daemonCmd
+}
@@ -34,12 +34,20 @@ func dateTimeRead(s sesn.Sesn) error {
 	c := xact.NewDateTimeReadCmd()
 	c.SetTxOptions(nmutil.TxOptions())
 
-	res, err := c.Run(s)
+	res, err := runXactCmd(c, s)
 	if err != nil {
 		return util.ChildNewtError(err)
 	}
 
 	sres := res.(*xact.DateTimeReadResult)
+	if outputStructured() {
+		outputRsp(sres.Rsp, sres.Rsp.Rc)
+		return nil
+	}
+
+	if sres.Rsp.Rc != 0 {
+		rspError(sres.Rsp.Rc)
+	}
 	fmt.Println("Datetime(RFC 3339 format):", sres.Rsp.DateTime)
 
 	return nil
@@ -50,14 +58,19 @@ func dateTimeWrite(s sesn.Sesn, args []string) error {
 	c.SetTxOptions(nmutil.TxOptions())
 	c.DateTime = args[0]
 
-	res, err := c.Run(s)
+	res, err := runXactCmd(c, s)
 	if err != nil {
 		return util.ChildNewtError(err)
 	}
 
 	sres := res.(*xact.DateTimeWriteResult)
+	if outputStructured() {
+		outputRsp(sres.Rsp, sres.Rsp.Rc)
+		return nil
+	}
+
 	if sres.Rsp.Rc != 0 {
-		fmt.Printf("Error: %d\n", sres.Rsp.Rc)
+		rspError(sres.Rsp.Rc)
 	} else {
 		fmt.Printf("Done\n")
 	}
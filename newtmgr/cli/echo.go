@@ -43,12 +43,20 @@ func echoRunCmd(cmd *cobra.Command, args []string) {
 	c.SetTxOptions(nmutil.TxOptions())
 	c.Payload = args[0]
 
-	res, err := c.Run(s)
+	res, err := runXactCmd(c, s)
 	if err != nil {
 		nmUsage(nil, util.ChildNewtError(err))
 	}
 
 	eres := res.(*xact.EchoResult)
+	if outputStructured() {
+		outputRsp(eres.Rsp, eres.Rsp.Rc)
+		return
+	}
+
+	if eres.Rsp.Rc != 0 {
+		rspError(eres.Rsp.Rc)
+	}
 	fmt.Println(eres.Rsp.Payload)
 }
 
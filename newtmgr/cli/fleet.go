@@ -0,0 +1,460 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"mynewt.apache.org/newt/util"
+	"mynewt.apache.org/newt/yaml"
+	"mynewt.apache.org/newtmgr/newtmgr/config"
+	"mynewt.apache.org/newtmgr/newtmgr/nmutil"
+)
+
+// Flags that select a device or that the fleet runner sets itself.  They are
+// replaced with each device's settings when the command is run against a
+// fleet.  Child output is always JSON when structured output is requested,
+// so the output format isn't passed through either.
+var fleetDeviceFlags = map[string]bool{
+	"conn":       true,
+	"conntype":   true,
+	"connstring": true,
+	"name":       true,
+	"daemon":     true,
+	"fleet":      true,
+	"jobs":       true,
+	"output":     true,
+}
+
+// Commands that don't talk to a device.
+var fleetExcludedCmds = map[string]bool{
+	"conn":   true,
+	"daemon": true,
+	"shell":  true,
+	"help":   true,
+
+	// Takes its own device list.
+	"rollout": true,
+}
+
+type fleetDevice struct {
+	label      string
+	connType   config.ConnType
+	connString string
+	name       string
+}
+
+type fleetResult struct {
+	dev    *fleetDevice
+	err    error
+	output []byte
+	stderr []byte
+}
+
+func fleetDeviceFromProfile(name string) (*fleetDevice, error) {
+	cp, err := config.GlobalConnProfileMgr().GetConnProfile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fleetDevice{
+		label:      cp.Name,
+		connType:   cp.Type,
+		connString: cp.ConnString,
+	}, nil
+}
+
+// Parses an entry in a YAML device list.  An entry either names a connection
+// profile or specifies a type and connstring.
+func fleetDeviceFromYaml(itf interface{}) (*fleetDevice, error) {
+	m, ok := itf.(map[interface{}]interface{})
+	if !ok {
+		return nil, util.FmtNewtError("invalid device entry: %v", itf)
+	}
+
+	fields := map[string]string{}
+	for k, v := range m {
+		ks := fmt.Sprintf("%v", k)
+		switch ks {
+		case "profile", "type", "connstring", "name", "label":
+			fields[ks] = fmt.Sprintf("%v", v)
+		default:
+			return nil, util.FmtNewtError("invalid device field: \"%s\"", ks)
+		}
+	}
+
+	var dev *fleetDevice
+	if fields["profile"] != "" {
+		var err error
+		dev, err = fleetDeviceFromProfile(fields["profile"])
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		if fields["type"] == "" {
+			return nil, util.FmtNewtError(
+				"device entry needs a profile or a type: %v", itf)
+		}
+
+		t, err := config.ConnTypeFromString(fields["type"])
+		if err != nil {
+			return nil, err
+		}
+
+		dev = &fleetDevice{
+			connType: t,
+		}
+	}
+
+	if cs, ok := fields["connstring"]; ok {
+		dev.connString = cs
+	}
+	if name := fields["name"]; name != "" {
+		dev.name = name
+	}
+
+	switch {
+	case fields["label"] != "":
+		dev.label = fields["label"]
+	case dev.label != "":
+	case dev.name != "":
+		dev.label = dev.name
+	default:
+		dev.label = dev.connString
+	}
+
+	return dev, nil
+}
+
+func fleetReadYaml(filename string, data []byte) ([]*fleetDevice, error) {
+	vals := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &vals); err != nil {
+		return nil, util.FmtNewtError("%s: %s", filename, err.Error())
+	}
+
+	entries, ok := vals["devices"].([]interface{})
+	if !ok {
+		return nil, util.FmtNewtError("%s: missing \"devices\" list",
+			filename)
+	}
+
+	devs := make([]*fleetDevice, 0, len(entries))
+	for _, e := range entries {
+		dev, err := fleetDeviceFromYaml(e)
+		if err != nil {
+			return nil, util.FmtNewtError("%s: %s", filename, err.Error())
+		}
+		devs = append(devs, dev)
+	}
+
+	return devs, nil
+}
+
+// Reads the devices specified by --fleet.  The argument is a YAML device
+// list, a file containing one connection profile name per line, or a
+// comma-separated list of connection profile names.
+func fleetReadDevices(arg string) ([]*fleetDevice, error) {
+	var names []string
+
+	data, err := ioutil.ReadFile(arg)
+	if err == nil {
+		ext := filepath.Ext(arg)
+		if ext == ".yaml" || ext == ".yml" {
+			return fleetReadYaml(arg, data)
+		}
+
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line != "" && !strings.HasPrefix(line, "#") {
+				names = append(names, line)
+			}
+		}
+	} else if os.IsNotExist(err) &&
+		!strings.ContainsRune(arg, os.PathSeparator) {
+
+		names = strings.Split(arg, ",")
+	} else {
+		return nil, util.ChildNewtError(err)
+	}
+
+	devs := make([]*fleetDevice, 0, len(names))
+	for _, name := range names {
+		dev, err := fleetDeviceFromProfile(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		devs = append(devs, dev)
+	}
+
+	return devs, nil
+}
+
+// Builds the arguments that rerun the current command without the flags in
+// fleetDeviceFlags.
+func fleetCmdArgs(cmd *cobra.Command, args []string) []string {
+	words := strings.Fields(cmd.CommandPath())[1:]
+
+	addFlag := func(f *pflag.Flag) {
+		if !fleetDeviceFlags[f.Name] {
+			words = append(words, "--"+f.Name+"="+f.Value.String())
+		}
+	}
+	cmd.InheritedFlags().Visit(addFlag)
+	cmd.LocalFlags().Visit(addFlag)
+
+	words = append(words, "--")
+	return append(words, args...)
+}
+
+// Runs newtmgr with the specified arguments against a single device.  If
+// splitStderr is false, stderr is collected with stdout.
+func fleetRunDevice(exe string, sockPath string, cmdArgs []string,
+	dev *fleetDevice, splitStderr bool) *fleetResult {
+
+	args := []string{
+		"--conntype=" + config.ConnTypeToString(dev.connType),
+		"--connstring=" + dev.connString,
+		"--daemon=" + sockPath,
+	}
+
+	name := dev.name
+	if name == "" {
+		name = nmutil.DeviceName
+	}
+	if name != "" {
+		args = append(args, "--name="+name)
+	}
+
+	args = append(args, cmdArgs...)
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+
+	c := exec.Command(exe, args...)
+	c.Stdout = stdout
+	if splitStderr {
+		c.Stderr = stderr
+	} else {
+		c.Stderr = stdout
+	}
+
+	err := c.Run()
+
+	return &fleetResult{
+		dev:    dev,
+		err:    err,
+		output: stdout.Bytes(),
+		stderr: stderr.Bytes(),
+	}
+}
+
+func fleetPrintText(r *fleetResult) {
+	status := "ok"
+	if r.err != nil {
+		status = "FAILED"
+	}
+	fmt.Printf("=== %s (%s)\n", r.dev.label, status)
+
+	scanner := bufio.NewScanner(bytes.NewReader(r.output))
+	for scanner.Scan() {
+		fmt.Printf("%s: %s\n", r.dev.label, scanner.Text())
+	}
+}
+
+func fleetResultValue(r *fleetResult) map[string]interface{} {
+	m := map[string]interface{}{
+		"device": r.dev.label,
+		"ok":     r.err == nil,
+	}
+
+	if r.err != nil {
+		m["error"] = r.err.Error()
+	}
+	if len(r.stderr) > 0 {
+		m["stderr"] = string(r.stderr)
+	}
+
+	var val interface{}
+	dec := json.NewDecoder(bytes.NewReader(r.output))
+	dec.UseNumber()
+	if err := dec.Decode(&val); err == nil {
+		m["output"] = val
+	} else if len(r.output) > 0 {
+		m["output"] = string(r.output)
+	}
+
+	return m
+}
+
+// Returns the socket of the daemon the user specified, or starts one that
+// runs until the returned function is called.
+func fleetStartDaemon() (string, func()) {
+	if nmutil.Daemon != "" {
+		return nmutil.Daemon, func() {}
+	}
+
+	dir, err := ioutil.TempDir("", "newtmgr-fleet")
+	if err != nil {
+		nmUsage(nil, util.ChildNewtError(err))
+	}
+
+	sockPath := filepath.Join(dir, "daemon.sock")
+	srv := newDaemonServer(sockPath, "")
+	if err := srv.Start(); err != nil {
+		os.RemoveAll(dir)
+		nmUsage(nil, err)
+	}
+
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			srv.Stop()
+			os.RemoveAll(dir)
+		})
+	}
+
+	// Clean up if newtmgr is interrupted.
+	prevOnExit := onExit
+	SetOnExit(func() {
+		stop()
+		if prevOnExit != nil {
+			prevOnExit()
+		}
+	})
+
+	return sockPath, stop
+}
+
+// Runs the current command against every device in the fleet and exits.
+// Each device gets its own newtmgr process; the processes share transports
+// through a daemon.
+func fleetRunCmd(cmd *cobra.Command, args []string) {
+	path := strings.Fields(cmd.CommandPath())
+	if len(path) < 2 || fleetExcludedCmds[path[1]] {
+		nmUsage(nil, util.FmtNewtError(
+			"\"%s\" cannot be used with --fleet", cmd.CommandPath()))
+	}
+
+	if nmutil.FleetJobs < 1 {
+		nmUsage(nil, util.NewNewtError("--jobs must be at least 1"))
+	}
+
+	devs, err := fleetReadDevices(nmutil.Fleet)
+	if err != nil {
+		nmUsage(nil, err)
+	}
+	if len(devs) == 0 {
+		nmUsage(nil, util.NewNewtError("fleet contains no devices"))
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		nmUsage(nil, util.ChildNewtError(err))
+	}
+
+	prevOnExit := onExit
+	sockPath, stopDaemon := fleetStartDaemon()
+	defer SetOnExit(prevOnExit)
+
+	// Child output is collected with JSON output so that it can be combined
+	// into a single document.
+	cmdArgs := fleetCmdArgs(cmd, args)
+	if outputStructured() {
+		cmdArgs = append([]string{"--output=" + OUTPUT_FORMAT_JSON},
+			cmdArgs...)
+	}
+
+	results := make([]*fleetResult, len(devs))
+	idxCh := make(chan int)
+	var printMtx sync.Mutex
+	var wg sync.WaitGroup
+
+	for i := 0; i < nmutil.FleetJobs && i < len(devs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range idxCh {
+				// Keep errors next to the output they belong to unless the
+				// output needs to be parsed.
+				r := fleetRunDevice(exe, sockPath, cmdArgs, devs[idx],
+					outputStructured())
+				results[idx] = r
+
+				if !outputStructured() {
+					printMtx.Lock()
+					fleetPrintText(r)
+					printMtx.Unlock()
+				}
+			}
+		}()
+	}
+
+	for i, _ := range devs {
+		idxCh <- i
+	}
+	close(idxCh)
+	wg.Wait()
+	stopDaemon()
+
+	failed := 0
+	for _, r := range results {
+		if r.err != nil {
+			failed++
+		}
+	}
+
+	if outputStructured() {
+		vals := make([]interface{}, len(results))
+		for i, r := range results {
+			vals[i] = fleetResultValue(r)
+		}
+		outputPrintValue(vals)
+	} else {
+		fmt.Printf("\nSummary: %d succeeded, %d failed\n",
+			len(results)-failed, failed)
+		for _, r := range results {
+			if r.err == nil {
+				fmt.Printf("    ok      %s\n", r.dev.label)
+			} else {
+				fmt.Printf("    FAILED  %s (%s)\n", r.dev.label,
+					r.err.Error())
+			}
+		}
+	}
+
+	status := 0
+	if failed > 0 {
+		status = 1
+	}
+	nmCmdExit(status)
+}
@@ -20,28 +20,102 @@
 package cli
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"hash/crc32"
 	"io/ioutil"
 	"os"
+	"sort"
+	"time"
 
 	"github.com/spf13/cobra"
 
 	"mynewt.apache.org/newt/util"
 	"mynewt.apache.org/newtmgr/newtmgr/nmutil"
 	"mynewt.apache.org/newtmgr/nmxact/nmp"
+	"mynewt.apache.org/newtmgr/nmxact/sesn"
 	"mynewt.apache.org/newtmgr/nmxact/xact"
 )
 
+var fsVerifyHash string
+var fsRestart bool
+
+// Checks that a transferred file matches the copy on the device, using a
+// hash computed by the device if it supports one, or else the file's length.
+// Returns the method used.
+func fsVerify(s sesn.Sesn, remote string, data []byte) (string, error) {
+	if fsVerifyHash != "none" {
+		hash, err := fsRemoteHash(s, fsVerifyHash, remote, 0, 0)
+		if err != nil {
+			return "", err
+		}
+		if hash != "" {
+			if hash != fsDataHash(fsVerifyHash, data) {
+				return "", util.FmtNewtError(
+					"%s hash of %s doesn't match the device's", fsVerifyHash,
+					remote)
+			}
+			return fsVerifyHash, nil
+		}
+	}
+
+	c := xact.NewFsStatCmd()
+	c.SetTxOptions(nmutil.TxOptions())
+	c.Name = remote
+
+	res, err := runXactCmd(c, s)
+	if err != nil {
+		return "", util.ChildNewtError(err)
+	}
+
+	sres := res.(*xact.FsStatResult)
+	if sres.Rsp.Rc != 0 {
+		return "none", nil
+	}
+	if int(sres.Rsp.Len) != len(data) {
+		return "", util.FmtNewtError(
+			"%s is %d bytes, but the device reports %d", remote, len(data),
+			sres.Rsp.Len)
+	}
+
+	return "length", nil
+}
+
+func fsCheckHashType(cmd *cobra.Command) {
+	switch fsVerifyHash {
+	case nmp.FS_HASH_CRC32, nmp.FS_HASH_SHA256, "none":
+	default:
+		nmUsage(cmd, util.FmtNewtError("invalid hash type: \"%s\"",
+			fsVerifyHash))
+	}
+}
+
+// Determines whether a partial download can be resumed: the device must be
+// able to hash the part that was already downloaded, and the hash must match.
+// Returns false if the download must start over.
+func fsDownloadCanResume(s sesn.Sesn, name string, partial []byte) (bool,
+	error) {
+
+	if fsVerifyHash == "none" {
+		return false, nil
+	}
+
+	hash, err := fsRemoteHash(s, fsVerifyHash, name, 0, uint32(len(partial)))
+	if err != nil || hash == "" {
+		return false, err
+	}
+
+	return hash == fsDataHash(fsVerifyHash, partial), nil
+}
+
 func fsDownloadRunCmd(cmd *cobra.Command, args []string) {
 	if len(args) < 2 {
 		nmUsage(cmd, nil)
 	}
+	fsCheckHashType(cmd)
 
-	file, err := os.OpenFile(args[1], os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0660)
-	if err != nil {
-		nmUsage(cmd, util.FmtNewtError(
-			"Cannot open file %s - %s", args[1], err.Error()))
-	}
+	file, tmpName, have := openDownloadTmp(args[1], fsRestart)
 	defer file.Close()
 
 	s, err := GetSesn()
@@ -49,35 +123,157 @@ func fsDownloadRunCmd(cmd *cobra.Command, args []string) {
 		nmUsage(nil, err)
 	}
 
+	// Only resume if the partial download is known to match the device's
+	// copy of the file.
+	if have > 0 {
+		partial, err := ioutil.ReadFile(tmpName)
+		if err != nil {
+			nmUsage(nil, util.ChildNewtError(err))
+		}
+
+		ok, err := fsDownloadCanResume(s, args[0], partial)
+		if err != nil {
+			nmUsage(nil, err)
+		}
+
+		if ok {
+			fmt.Fprintf(progressWriter(),
+				"Resuming download at offset %d\n", have)
+		} else {
+			fmt.Fprintf(progressWriter(), "Cannot verify the partial "+
+				"download against the device; starting over\n")
+			if err := file.Truncate(0); err != nil {
+				nmUsage(nil, util.ChildNewtError(err))
+			}
+			have = 0
+		}
+	}
+
 	c := xact.NewFsDownloadCmd()
 	c.SetTxOptions(nmutil.TxOptions())
 	c.Name = args[0]
+	c.StartOff = have
 	c.ProgressCb = func(c *xact.FsDownloadCmd, rsp *nmp.FsDownloadRsp) {
-		fmt.Printf("%d\n", rsp.Off)
+		fmt.Fprintf(progressWriter(), "%d\n", rsp.Off)
 		if _, err := file.Write(rsp.Data); err != nil {
 			nmUsage(nil, util.ChildNewtError(err))
 		}
 	}
 
-	res, err := c.Run(s)
+	res, err := runXactCmd(c, s)
 	if err != nil {
 		nmUsage(nil, util.ChildNewtError(err))
 	}
 
+	// A mismatch means the partial download didn't come from the current
+	// version of the file, so it can't be resumed.
+	failVerify := func(err error) {
+		file.Close()
+		os.Remove(tmpName)
+		nmUsage(nil, util.FmtNewtError("%s; discarded the download",
+			err.Error()))
+	}
+
 	sres := res.(*xact.FsDownloadResult)
+	if sres.Len != 0 && have > int(sres.Len) {
+		failVerify(util.FmtNewtError(
+			"partial download is %d bytes, but the device reports %d", have,
+			sres.Len))
+	}
+
 	rsp := sres.Rsps[len(sres.Rsps)-1]
 	if rsp.Rc != 0 {
-		fmt.Printf("Error: %d\n", rsp.Rc)
+		if outputStructured() {
+			outputRsp(map[string]interface{}{
+				"rc":   rsp.Rc,
+				"name": c.Name,
+			}, rsp.Rc)
+		}
+		rspError(rsp.Rc)
+	}
+
+	file.Close()
+	data, err := ioutil.ReadFile(tmpName)
+	if err != nil {
+		nmUsage(nil, util.ChildNewtError(err))
+	}
+
+	if sres.Len != 0 && int(sres.Len) != len(data) {
+		failVerify(util.FmtNewtError(
+			"downloaded %d bytes, but the device reports %d", len(data),
+			sres.Len))
+	}
+	method, err := fsVerify(s, c.Name, data)
+	if err != nil {
+		failVerify(err)
+	}
+	if method == "none" && sres.Len != 0 {
+		method = "length"
+	}
+
+	// Part of a resumed download may be stale, and a length check wouldn't
+	// notice; don't keep it unless the whole file was hashed.
+	if have > 0 && method != fsVerifyHash {
+		failVerify(util.FmtNewtError(
+			"cannot verify resumed download of %s; the device didn't "+
+				"report its hash", c.Name))
+	}
+
+	if err := os.Rename(tmpName, args[1]); err != nil {
+		nmUsage(nil, util.ChildNewtError(err))
+	}
+
+	if outputStructured() {
+		outputRsp(map[string]interface{}{
+			"rc":       0,
+			"name":     c.Name,
+			"len":      len(data),
+			"verified": method,
+		}, 0)
 		return
 	}
 
-	fmt.Printf("Done\n")
+	fmt.Printf("Done (verified: %s)\n", method)
+}
+
+// Determines where an interrupted upload can resume: the device's copy of
+// the file must be shorter than the local one and match its beginning.
+// Returns 0 if the upload must start over.
+func fsUploadResumeOff(s sesn.Sesn, name string, data []byte) (int, error) {
+	if fsVerifyHash == "none" {
+		return 0, nil
+	}
+
+	c := xact.NewFsStatCmd()
+	c.SetTxOptions(nmutil.TxOptions())
+	c.Name = name
+
+	res, err := runXactCmd(c, s)
+	if err != nil {
+		return 0, util.ChildNewtError(err)
+	}
+
+	rsp := res.(*xact.FsStatResult).Rsp
+	if rsp.Rc != 0 || rsp.Dir || rsp.Len == 0 || int(rsp.Len) >= len(data) {
+		return 0, nil
+	}
+
+	hash, err := fsRemoteHash(s, fsVerifyHash, name, 0, rsp.Len)
+	if err != nil || hash == "" {
+		return 0, err
+	}
+	if hash != fsDataHash(fsVerifyHash, data[:rsp.Len]) {
+		return 0, nil
+	}
+
+	return int(rsp.Len), nil
 }
 
 func fsUploadRunCmd(cmd *cobra.Command, args []string) {
 	if len(args) < 2 {
 		nmUsage(cmd, nil)
 	}
+	fsCheckHashType(cmd)
 
 	data, err := ioutil.ReadFile(args[0])
 	if err != nil {
@@ -89,29 +285,365 @@ func fsUploadRunCmd(cmd *cobra.Command, args []string) {
 		nmUsage(nil, err)
 	}
 
-	c := xact.NewFsUploadCmd()
+	startOff := 0
+	if !fsRestart {
+		startOff, err = fsUploadResumeOff(s, args[1], data)
+		if err != nil {
+			nmUsage(nil, err)
+		}
+	}
+
+	run := func(startOff int) xact.Result {
+		if startOff > 0 {
+			fmt.Fprintf(progressWriter(), "Resuming upload at offset %d\n",
+				startOff)
+		}
+
+		c := xact.NewFsUploadCmd()
+		c.SetTxOptions(nmutil.TxOptions())
+		c.Name = args[1]
+		c.Data = data
+		c.StartOff = startOff
+		c.ProgressCb = func(c *xact.FsUploadCmd, rsp *nmp.FsUploadRsp) {
+			fmt.Fprintf(progressWriter(), "%d\n", rsp.Off)
+		}
+
+		res, err := runXactCmd(c, s)
+		if err != nil {
+			nmUsage(nil, util.ChildNewtError(err))
+		}
+
+		return res
+	}
+
+	res := run(startOff)
+	if res.Status() != 0 && startOff > 0 {
+		// The device wouldn't continue the file where it left off.
+		fmt.Fprintf(progressWriter(), "Cannot resume (rc=%d); restarting\n",
+			res.Status())
+		res = run(0)
+	}
+
+	if res.Status() != 0 {
+		if outputStructured() {
+			outputRsp(map[string]interface{}{
+				"rc":   res.Status(),
+				"name": args[1],
+			}, res.Status())
+		}
+		rspError(res.Status())
+	}
+
+	method, err := fsVerify(s, args[1], data)
+	if err != nil {
+		nmUsage(nil, err)
+	}
+
+	if outputStructured() {
+		outputRsp(map[string]interface{}{
+			"rc":       0,
+			"name":     args[1],
+			"len":      len(data),
+			"verified": method,
+		}, 0)
+		return
+	}
+
+	fmt.Printf("Done (verified: %s)\n", method)
+}
+
+func fsFormatMtime(mtime int64) string {
+	if mtime == 0 {
+		return "-"
+	}
+	return time.Unix(mtime, 0).Format("2006-01-02 15:04:05")
+}
+
+func fsLsRunCmd(cmd *cobra.Command, args []string) {
+	dir := "/"
+	if len(args) > 0 {
+		dir = args[0]
+	}
+
+	s, err := GetSesn()
+	if err != nil {
+		nmUsage(nil, err)
+	}
+
+	c := xact.NewFsDirListCmd()
+	c.SetTxOptions(nmutil.TxOptions())
+	c.Name = dir
+
+	res, err := runXactCmd(c, s)
+	if err != nil {
+		nmUsage(nil, util.ChildNewtError(err))
+	}
+
+	sres := res.(*xact.FsDirListResult)
+	entries := sres.Entries()
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+
+	if outputStructured() {
+		outputRsp(map[string]interface{}{
+			"rc":      sres.Status(),
+			"name":    dir,
+			"entries": entries,
+		}, sres.Status())
+		return
+	}
+
+	if sres.Status() != 0 {
+		rspError(sres.Status())
+	}
+
+	for _, e := range entries {
+		if e.Dir {
+			fmt.Printf("%10s  %19s  %s/\n", "-", fsFormatMtime(e.Mtime),
+				e.Name)
+		} else {
+			fmt.Printf("%10d  %19s  %s\n", e.Len, fsFormatMtime(e.Mtime),
+				e.Name)
+		}
+	}
+}
+
+func fsStatRunCmd(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		nmUsage(cmd, nil)
+	}
+
+	s, err := GetSesn()
+	if err != nil {
+		nmUsage(nil, err)
+	}
+
+	c := xact.NewFsStatCmd()
 	c.SetTxOptions(nmutil.TxOptions())
-	c.Name = args[1]
-	c.Data = data
-	c.ProgressCb = func(c *xact.FsUploadCmd, rsp *nmp.FsUploadRsp) {
-		fmt.Printf("%d\n", rsp.Off)
+	c.Name = args[0]
+
+	res, err := runXactCmd(c, s)
+	if err != nil {
+		nmUsage(nil, util.ChildNewtError(err))
+	}
+
+	sres := res.(*xact.FsStatResult)
+	if outputStructured() {
+		outputRsp(sres.Rsp, sres.Rsp.Rc)
+		return
+	}
+
+	if sres.Rsp.Rc != 0 {
+		rspError(sres.Rsp.Rc)
+	}
+
+	kind := "file"
+	if sres.Rsp.Dir {
+		kind = "directory"
 	}
 
-	res, err := c.Run(s)
+	fmt.Printf("name:  %s\n", c.Name)
+	fmt.Printf("type:  %s\n", kind)
+	fmt.Printf("size:  %d\n", sres.Rsp.Len)
+	fmt.Printf("mtime: %s\n", fsFormatMtime(sres.Rsp.Mtime))
+}
+
+// Runs a command that only reports a status and prints "Done" on success.
+func fsRunSimple(c xact.Cmd) {
+	s, err := GetSesn()
+	if err != nil {
+		nmUsage(nil, err)
+	}
+
+	c.SetTxOptions(nmutil.TxOptions())
+
+	res, err := runXactCmd(c, s)
 	if err != nil {
 		nmUsage(nil, util.ChildNewtError(err))
 	}
 
-	sres := res.(*xact.FsUploadResult)
-	rsp := sres.Rsps[len(sres.Rsps)-1]
-	if rsp.Rc != 0 {
-		fmt.Printf("Error: %d\n", rsp.Rc)
+	if outputStructured() {
+		outputRsp(map[string]interface{}{"rc": res.Status()}, res.Status())
 		return
 	}
 
+	if res.Status() != 0 {
+		rspError(res.Status())
+	}
+
 	fmt.Printf("Done\n")
 }
 
+func fsRmRunCmd(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		nmUsage(cmd, nil)
+	}
+
+	c := xact.NewFsUnlinkCmd()
+	c.Name = args[0]
+	fsRunSimple(c)
+}
+
+func fsMkdirRunCmd(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		nmUsage(cmd, nil)
+	}
+
+	c := xact.NewFsMkdirCmd()
+	c.Name = args[0]
+	fsRunSimple(c)
+}
+
+func fsMvRunCmd(cmd *cobra.Command, args []string) {
+	if len(args) < 2 {
+		nmUsage(cmd, nil)
+	}
+
+	c := xact.NewFsRenameCmd()
+	c.From = args[0]
+	c.To = args[1]
+	fsRunSimple(c)
+}
+
+var fsHashType string
+var fsHashVerify string
+
+// Converts a hash response's output to a hex string.
+func fsHashString(output interface{}) (string, error) {
+	switch v := output.(type) {
+	case []byte:
+		return hex.EncodeToString(v), nil
+	case uint64:
+		return fmt.Sprintf("%08x", v), nil
+	case int64:
+		return fmt.Sprintf("%08x", uint32(v)), nil
+	default:
+		return "", util.FmtNewtError("unexpected hash output: %v", output)
+	}
+}
+
+func fsDataHash(hashType string, data []byte) string {
+	switch hashType {
+	case nmp.FS_HASH_CRC32:
+		return fmt.Sprintf("%08x", crc32.ChecksumIEEE(data))
+	default:
+		sum := sha256.Sum256(data)
+		return hex.EncodeToString(sum[:])
+	}
+}
+
+func fsLocalHash(hashType string, filename string) (string, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", util.ChildNewtError(err)
+	}
+
+	return fsDataHash(hashType, data), nil
+}
+
+// Asks the device to hash part of a file.  Returns "" if the device can't.
+func fsRemoteHash(s sesn.Sesn, hashType string, name string, off uint32,
+	length uint32) (string, error) {
+
+	c := xact.NewFsHashCmd()
+	c.SetTxOptions(nmutil.TxOptions())
+	c.Name = name
+	c.Type = hashType
+	c.Off = off
+	c.Len = length
+
+	res, err := runXactCmd(c, s)
+	if err != nil {
+		return "", util.ChildNewtError(err)
+	}
+
+	sres := res.(*xact.FsHashResult)
+	if sres.Rsp.Rc != 0 {
+		return "", nil
+	}
+
+	return fsHashString(sres.Rsp.Output)
+}
+
+func fsHashRunCmd(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		nmUsage(cmd, nil)
+	}
+
+	switch fsHashType {
+	case nmp.FS_HASH_CRC32, nmp.FS_HASH_SHA256:
+	default:
+		nmUsage(cmd, util.FmtNewtError("invalid hash type: \"%s\"",
+			fsHashType))
+	}
+
+	s, err := GetSesn()
+	if err != nil {
+		nmUsage(nil, err)
+	}
+
+	c := xact.NewFsHashCmd()
+	c.SetTxOptions(nmutil.TxOptions())
+	c.Name = args[0]
+	c.Type = fsHashType
+
+	res, err := runXactCmd(c, s)
+	if err != nil {
+		nmUsage(nil, util.ChildNewtError(err))
+	}
+
+	sres := res.(*xact.FsHashResult)
+	if sres.Rsp.Rc != 0 {
+		if outputStructured() {
+			outputRsp(sres.Rsp, sres.Rsp.Rc)
+		}
+		rspError(sres.Rsp.Rc)
+	}
+
+	hash, err := fsHashString(sres.Rsp.Output)
+	if err != nil {
+		nmUsage(nil, err)
+	}
+
+	match := true
+	if fsHashVerify != "" {
+		local, err := fsLocalHash(fsHashType, fsHashVerify)
+		if err != nil {
+			nmUsage(nil, err)
+		}
+		match = local == hash
+	}
+
+	if outputStructured() {
+		val := map[string]interface{}{
+			"rc":   0,
+			"name": c.Name,
+			"type": fsHashType,
+			"len":  int(sres.Rsp.Len),
+			"hash": hash,
+		}
+		if fsHashVerify != "" {
+			val["match"] = match
+		}
+		outputPrintValue(val)
+	} else {
+		fmt.Printf("%s  %s (%s, %d bytes)\n", hash, c.Name, fsHashType,
+			sres.Rsp.Len)
+		if fsHashVerify != "" {
+			if match {
+				fmt.Printf("Matches %s\n", fsHashVerify)
+			} else {
+				fmt.Printf("Does not match %s\n", fsHashVerify)
+			}
+		}
+	}
+
+	if !match {
+		nmCmdExit(1)
+	}
+}
+
 func fsCmd() *cobra.Command {
 	fsCmd := &cobra.Command{
 		Use:   "fs",
@@ -123,23 +655,121 @@ func fsCmd() *cobra.Command {
 
 	uploadEx := "  newtmgr -c olimex fs upload sample.lua /sample.lua\n"
 
+	uploadHelpText := "Upload a file to a device and verify it using a hash " +
+		"computed by the device, or the file's length if the device can't " +
+		"hash files.  If the device already holds the beginning of the " +
+		"file from an interrupted upload, the upload resumes where it left " +
+		"off."
+
 	uploadCmd := &cobra.Command{
 		Use:     "upload <src-filename> <dst-filename> -c <conn_profile>",
 		Short:   "Upload file to a device",
+		Long:    uploadHelpText,
 		Example: uploadEx,
 		Run:     fsUploadRunCmd,
 	}
+	uploadCmd.Flags().StringVar(&fsVerifyHash, "hash", nmp.FS_HASH_SHA256,
+		"Hash used to verify the file: sha256, crc32, or none")
+	uploadCmd.Flags().BoolVar(&fsRestart, "restart", false,
+		"Upload the whole file even if part of it is already on the device")
 	fsCmd.AddCommand(uploadCmd)
 
 	downloadEx := "  newtmgr -c olimex image download /cfg/mfg mfg.txt\n"
 
+	downloadHelpText := "Download a file from a device and verify it using " +
+		"a hash computed by the device, or the file's length if the device " +
+		"can't hash files.  The file is written to <dst-filename>.tmp until " +
+		"it is verified; if that file exists, it is assumed to be left over " +
+		"from an interrupted download.  The download resumes where it left " +
+		"off if the device's hash of that part of the file matches; " +
+		"otherwise, it starts over.  A resumed download is only kept if " +
+		"the device's hash of the whole file matches."
+
 	downloadCmd := &cobra.Command{
 		Use:     "download <src-filename> <dst-filename> -c <conn_profile>",
 		Short:   "Download file from a device",
+		Long:    downloadHelpText,
 		Example: downloadEx,
 		Run:     fsDownloadRunCmd,
 	}
+	downloadCmd.Flags().StringVar(&fsVerifyHash, "hash", nmp.FS_HASH_SHA256,
+		"Hash used to verify the file: sha256, crc32, or none")
+	downloadCmd.Flags().BoolVar(&fsRestart, "restart", false,
+		"Discard any partial download and start over")
 	fsCmd.AddCommand(downloadCmd)
 
+	lsEx := "  newtmgr -c olimex fs ls /cfg\n"
+
+	lsCmd := &cobra.Command{
+		Use:     "ls [directory] -c <conn_profile>",
+		Short:   "List the contents of a directory on a device",
+		Example: lsEx,
+		Run:     fsLsRunCmd,
+	}
+	fsCmd.AddCommand(lsCmd)
+
+	statEx := "  newtmgr -c olimex fs stat /cfg/mfg\n"
+
+	statCmd := &cobra.Command{
+		Use:     "stat <filename> -c <conn_profile>",
+		Short:   "Show the size and modification time of a file on a device",
+		Example: statEx,
+		Run:     fsStatRunCmd,
+	}
+	fsCmd.AddCommand(statCmd)
+
+	rmEx := "  newtmgr -c olimex fs rm /cfg/old.txt\n"
+
+	rmCmd := &cobra.Command{
+		Use:     "rm <filename> -c <conn_profile>",
+		Short:   "Delete a file or empty directory on a device",
+		Example: rmEx,
+		Run:     fsRmRunCmd,
+	}
+	fsCmd.AddCommand(rmCmd)
+
+	mkdirEx := "  newtmgr -c olimex fs mkdir /scripts\n"
+
+	mkdirCmd := &cobra.Command{
+		Use:     "mkdir <directory> -c <conn_profile>",
+		Short:   "Create a directory on a device",
+		Example: mkdirEx,
+		Run:     fsMkdirRunCmd,
+	}
+	fsCmd.AddCommand(mkdirCmd)
+
+	mvEx := "  newtmgr -c olimex fs mv /sample.lua /scripts/sample.lua\n"
+
+	mvCmd := &cobra.Command{
+		Use:     "mv <src-filename> <dst-filename> -c <conn_profile>",
+		Short:   "Rename a file on a device",
+		Example: mvEx,
+		Run:     fsMvRunCmd,
+	}
+	fsCmd.AddCommand(mvCmd)
+
+	hashHelpText := "Compute a hash of a file on the device itself, without " +
+		"downloading it.  With --verify, compare it to the hash of a local " +
+		"file and exit with a nonzero status if they differ."
+
+	hashEx := "  newtmgr -c olimex fs hash /sample.lua\n"
+	hashEx += "  newtmgr -c olimex fs hash /sample.lua --type crc32 " +
+		"--verify sample.lua\n"
+
+	hashCmd := &cobra.Command{
+		Use:     "hash <filename> -c <conn_profile>",
+		Short:   "Compute a hash of a file on a device",
+		Long:    hashHelpText,
+		Example: hashEx,
+		Run:     fsHashRunCmd,
+	}
+	hashCmd.Flags().StringVar(&fsHashType, "type", nmp.FS_HASH_SHA256,
+		"Hash algorithm: sha256 or crc32")
+	hashCmd.Flags().StringVar(&fsHashVerify, "verify", "",
+		"Local file to compare the hash against")
+	fsCmd.AddCommand(hashCmd)
+
+	fsCmd.AddCommand(fsSyncCmd())
+
 	return fsCmd
 }
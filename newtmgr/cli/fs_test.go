@@ -0,0 +1,89 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"mynewt.apache.org/newtmgr/nmxact/sim"
+)
+
+func TestFsDownloadPartial(t *testing.T) {
+	sx, done := testSimGlobalSesn(t, sim.NewXportCfg())
+	defer done()
+
+	data := make([]byte, 3000)
+	for i := range data {
+		data[i] = byte(i * 13)
+	}
+	sx.Device().SetFile("/f", data)
+
+	dir, err := ioutil.TempDir("", "newtmgr-fs")
+	if err != nil {
+		t.Fatalf("failed to create directory: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	stale := bytes.Repeat([]byte{0xff}, 1000)
+	longer := append(append([]byte(nil), data...), stale...)
+
+	tests := []struct {
+		name    string
+		partial []byte
+		args    []string
+	}{
+		{"resume", data[:1000], nil},
+		{"complete", data, nil},
+		{"stale", stale, nil},
+		{"longer", longer, nil},
+		{"crc32", data[:1000], []string{"--hash", "crc32"}},
+		{"nohash", data[:1000], []string{"--hash", "none"}},
+	}
+
+	for _, test := range tests {
+		dst := filepath.Join(dir, test.name)
+		err := ioutil.WriteFile(dst+".tmp", test.partial, 0644)
+		if err != nil {
+			t.Fatalf("failed to write partial download: %s", err.Error())
+		}
+
+		words := append([]string{"fs", "download", "/f", dst},
+			test.args...)
+		if status := shellExec(words, map[string]string{}); status != 0 {
+			t.Errorf("%s: download failed: status=%d", test.name, status)
+			continue
+		}
+
+		got, err := ioutil.ReadFile(dst)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err.Error())
+		} else if !bytes.Equal(got, data) {
+			t.Errorf("%s: downloaded %d bytes that don't match the file",
+				test.name, len(got))
+		}
+		if _, err := os.Stat(dst + ".tmp"); !os.IsNotExist(err) {
+			t.Errorf("%s: partial download left behind", test.name)
+		}
+	}
+}
@@ -0,0 +1,460 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"mynewt.apache.org/newt/util"
+	"mynewt.apache.org/newtmgr/newtmgr/nmutil"
+	"mynewt.apache.org/newtmgr/nmxact/nmp"
+	"mynewt.apache.org/newtmgr/nmxact/sesn"
+	"mynewt.apache.org/newtmgr/nmxact/xact"
+)
+
+const (
+	FS_SYNC_ACTION_MKDIR  = "mkdir"
+	FS_SYNC_ACTION_UPLOAD = "upload"
+	FS_SYNC_ACTION_DELETE = "delete"
+)
+
+var fsSyncDelete bool
+var fsSyncDryRun bool
+var fsSyncHashType string
+
+// A file or directory, keyed by its slash-separated path relative to the
+// root being synced.
+type fsSyncEntry struct {
+	dir bool
+	len int
+}
+
+type fsSyncAction struct {
+	action string
+	path   string
+	reason string
+}
+
+func fsSyncWalkLocal(root string) (map[string]fsSyncEntry, error) {
+	entries := map[string]fsSyncEntry{}
+
+	err := filepath.Walk(root,
+		func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if p == root {
+				return nil
+			}
+
+			rel, err := filepath.Rel(root, p)
+			if err != nil {
+				return err
+			}
+			entries[filepath.ToSlash(rel)] = fsSyncEntry{
+				dir: info.IsDir(),
+				len: int(info.Size()),
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+
+	return entries, nil
+}
+
+// Lists a remote directory tree.  Returns false if the root doesn't exist.
+func fsSyncWalkRemote(s sesn.Sesn, root string) (map[string]fsSyncEntry,
+	bool, error) {
+
+	entries := map[string]fsSyncEntry{}
+
+	var walk func(rel string) (bool, error)
+	walk = func(rel string) (bool, error) {
+		c := xact.NewFsDirListCmd()
+		c.SetTxOptions(nmutil.TxOptions())
+		c.Name = path.Join(root, rel)
+
+		res, err := runXactCmd(c, s)
+		if err != nil {
+			return false, util.ChildNewtError(err)
+		}
+
+		sres := res.(*xact.FsDirListResult)
+		if sres.Status() != 0 {
+			if rel == "" && sres.Status() == nmp.NMP_ERR_ENOENT {
+				return false, nil
+			}
+			return false, util.FmtNewtError(
+				"cannot list remote directory %s: rc=%d", c.Name,
+				sres.Status())
+		}
+
+		for _, e := range sres.Entries() {
+			if e.Name == "." || e.Name == ".." {
+				continue
+			}
+
+			erel := path.Join(rel, e.Name)
+			entries[erel] = fsSyncEntry{dir: e.Dir, len: int(e.Len)}
+
+			if e.Dir {
+				if _, err := walk(erel); err != nil {
+					return false, err
+				}
+			}
+		}
+
+		return true, nil
+	}
+
+	exists, err := walk("")
+	if err != nil {
+		return nil, false, err
+	}
+
+	return entries, exists, nil
+}
+
+func fsDownload(s sesn.Sesn, name string) ([]byte, error) {
+	c := xact.NewFsDownloadCmd()
+	c.SetTxOptions(nmutil.TxOptions())
+	c.Name = name
+
+	res, err := runXactCmd(c, s)
+	if err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+
+	sres := res.(*xact.FsDownloadResult)
+	if sres.Status() != 0 {
+		return nil, util.FmtNewtError("cannot download %s: rc=%d", name,
+			sres.Status())
+	}
+
+	data := []byte{}
+	for _, rsp := range sres.Rsps {
+		data = append(data, rsp.Data...)
+	}
+
+	return data, nil
+}
+
+func fsUpload(s sesn.Sesn, name string, data []byte) error {
+	c := xact.NewFsUploadCmd()
+	c.SetTxOptions(nmutil.TxOptions())
+	c.Name = name
+	c.Data = data
+
+	res, err := runXactCmd(c, s)
+	if err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	if res.Status() != 0 {
+		return util.FmtNewtError("cannot upload %s: rc=%d", name,
+			res.Status())
+	}
+
+	return nil
+}
+
+// Determines whether a remote file has the same contents as a local one of
+// the same size.  The device hashes the file if it can; otherwise, the file
+// is downloaded and compared.
+func fsSyncSame(s sesn.Sesn, remote string, local string) (bool, error) {
+	if fsSyncHashType != "none" {
+		rhash, err := fsRemoteHash(s, fsSyncHashType, remote, 0, 0)
+		if err != nil {
+			return false, err
+		}
+		if rhash != "" {
+			lhash, err := fsLocalHash(fsSyncHashType, local)
+			if err != nil {
+				return false, err
+			}
+
+			return rhash == lhash, nil
+		}
+	}
+
+	rdata, err := fsDownload(s, remote)
+	if err != nil {
+		return false, err
+	}
+	ldata, err := ioutil.ReadFile(local)
+	if err != nil {
+		return false, util.ChildNewtError(err)
+	}
+
+	return bytes.Equal(rdata, ldata), nil
+}
+
+// Compares the two trees and returns the actions that make the remote tree
+// match the local one.  Directories are created parents first and deleted
+// children first.
+func fsSyncPlan(s sesn.Sesn, localRoot string, remoteRoot string) (
+	[]fsSyncAction, int, error) {
+
+	local, err := fsSyncWalkLocal(localRoot)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	remote, exists, err := fsSyncWalkRemote(s, remoteRoot)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	actions := []fsSyncAction{}
+	unchanged := 0
+
+	if !exists {
+		actions = append(actions,
+			fsSyncAction{FS_SYNC_ACTION_MKDIR, "", "new"})
+	}
+
+	lpaths := make([]string, 0, len(local))
+	for p, _ := range local {
+		lpaths = append(lpaths, p)
+	}
+	sort.Strings(lpaths)
+
+	for _, p := range lpaths {
+		l := local[p]
+		r, ok := remote[p]
+
+		if ok && l.dir != r.dir {
+			return nil, 0, util.FmtNewtError(
+				"%s is a file on one side and a directory on the other",
+				path.Join(remoteRoot, p))
+		}
+
+		switch {
+		case l.dir && !ok:
+			actions = append(actions,
+				fsSyncAction{FS_SYNC_ACTION_MKDIR, p, "new"})
+
+		case l.dir:
+
+		case !ok:
+			actions = append(actions,
+				fsSyncAction{FS_SYNC_ACTION_UPLOAD, p, "new"})
+
+		case l.len != r.len:
+			actions = append(actions,
+				fsSyncAction{FS_SYNC_ACTION_UPLOAD, p, "size differs"})
+
+		default:
+			same, err := fsSyncSame(s, path.Join(remoteRoot, p),
+				filepath.Join(localRoot, filepath.FromSlash(p)))
+			if err != nil {
+				return nil, 0, err
+			}
+			if same {
+				unchanged++
+			} else {
+				actions = append(actions,
+					fsSyncAction{FS_SYNC_ACTION_UPLOAD, p, "contents differ"})
+			}
+		}
+	}
+
+	if fsSyncDelete {
+		rpaths := make([]string, 0, len(remote))
+		for p, _ := range remote {
+			if _, ok := local[p]; !ok {
+				rpaths = append(rpaths, p)
+			}
+		}
+
+		// Reverse order puts a directory's contents before the directory.
+		sort.Sort(sort.Reverse(sort.StringSlice(rpaths)))
+		for _, p := range rpaths {
+			actions = append(actions,
+				fsSyncAction{FS_SYNC_ACTION_DELETE, p, "not in local tree"})
+		}
+	}
+
+	return actions, unchanged, nil
+}
+
+func fsSyncApply(s sesn.Sesn, a fsSyncAction, localRoot string,
+	remoteRoot string) error {
+
+	remote := path.Join(remoteRoot, a.path)
+
+	var c xact.Cmd
+	switch a.action {
+	case FS_SYNC_ACTION_UPLOAD:
+		data, err := ioutil.ReadFile(
+			filepath.Join(localRoot, filepath.FromSlash(a.path)))
+		if err != nil {
+			return util.ChildNewtError(err)
+		}
+		return fsUpload(s, remote, data)
+
+	case FS_SYNC_ACTION_MKDIR:
+		mc := xact.NewFsMkdirCmd()
+		mc.Name = remote
+		c = mc
+
+	default:
+		uc := xact.NewFsUnlinkCmd()
+		uc.Name = remote
+		c = uc
+	}
+
+	c.SetTxOptions(nmutil.TxOptions())
+	res, err := runXactCmd(c, s)
+	if err != nil {
+		return util.ChildNewtError(err)
+	}
+	if res.Status() != 0 {
+		return util.FmtNewtError("cannot %s %s: rc=%d", a.action, remote,
+			res.Status())
+	}
+
+	return nil
+}
+
+func fsSyncRunCmd(cmd *cobra.Command, args []string) {
+	if len(args) < 2 {
+		nmUsage(cmd, nil)
+	}
+	localRoot := args[0]
+	remoteRoot := path.Clean("/" + strings.TrimPrefix(args[1], "/"))
+
+	switch fsSyncHashType {
+	case nmp.FS_HASH_CRC32, nmp.FS_HASH_SHA256, "none":
+	default:
+		nmUsage(cmd, util.FmtNewtError("invalid hash type: \"%s\"",
+			fsSyncHashType))
+	}
+
+	info, err := os.Stat(localRoot)
+	if err != nil {
+		nmUsage(nil, util.ChildNewtError(err))
+	}
+	if !info.IsDir() {
+		nmUsage(cmd, util.FmtNewtError("%s is not a directory", localRoot))
+	}
+
+	s, err := GetSesn()
+	if err != nil {
+		nmUsage(nil, err)
+	}
+
+	actions, unchanged, err := fsSyncPlan(s, localRoot, remoteRoot)
+	if err != nil {
+		nmUsage(nil, err)
+	}
+
+	done := []interface{}{}
+	var applyErr error
+	for _, a := range actions {
+		if !outputStructured() {
+			fmt.Printf("  %-6s %s (%s)\n", a.action,
+				path.Join(remoteRoot, a.path), a.reason)
+		}
+
+		if !fsSyncDryRun {
+			applyErr = fsSyncApply(s, a, localRoot, remoteRoot)
+			if applyErr != nil {
+				break
+			}
+		}
+
+		done = append(done, map[string]interface{}{
+			"action": a.action,
+			"path":   path.Join(remoteRoot, a.path),
+			"reason": a.reason,
+		})
+	}
+
+	if outputStructured() {
+		val := map[string]interface{}{
+			"ok":        applyErr == nil,
+			"dry_run":   fsSyncDryRun,
+			"actions":   done,
+			"unchanged": unchanged,
+		}
+		if applyErr != nil {
+			val["error"] = applyErr.Error()
+		}
+		outputPrintValue(val)
+	} else if applyErr == nil {
+		verb := "Applied"
+		if fsSyncDryRun {
+			verb = "Would apply"
+		}
+		fmt.Printf("%s %d changes; %d files unchanged\n", verb, len(done),
+			unchanged)
+	}
+
+	if applyErr != nil {
+		if !outputStructured() {
+			nmUsage(nil, applyErr)
+		}
+		nmCmdExit(1)
+	}
+}
+
+func fsSyncCmd() *cobra.Command {
+	syncHelpText := "Make a directory on a device match a local directory.  " +
+		"Files that are missing from the device, or whose size or contents " +
+		"differ, are uploaded; unchanged files are left alone.  Contents " +
+		"are compared by hashing the file on the device, or by downloading " +
+		"it if the device can't hash files.\n\n" +
+		"With --delete, files and directories on the device that aren't in " +
+		"the local directory are removed.  With --dry-run, the changes are " +
+		"listed but not made."
+
+	syncEx := "  newtmgr -c olimex fs sync ./bundle /cfg\n"
+	syncEx += "  newtmgr -c olimex fs sync ./bundle /cfg --delete --dry-run\n"
+
+	syncCmd := &cobra.Command{
+		Use:     "sync <local-dir> <remote-dir> -c <conn_profile>",
+		Short:   "Copy changed files from a local directory to a device",
+		Long:    syncHelpText,
+		Example: syncEx,
+		Run:     fsSyncRunCmd,
+	}
+
+	syncCmd.Flags().BoolVar(&fsSyncDelete, "delete", false,
+		"Delete remote files that aren't in the local directory")
+	syncCmd.Flags().BoolVarP(&fsSyncDryRun, "dry-run", "n", false,
+		"Show what would change without changing anything")
+	syncCmd.Flags().StringVar(&fsSyncHashType, "hash", nmp.FS_HASH_SHA256,
+		"Hash used to compare files: sha256, crc32, or none to always "+
+			"download")
+
+	return syncCmd
+}
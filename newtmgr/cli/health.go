@@ -0,0 +1,670 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"mynewt.apache.org/newt/util"
+	"mynewt.apache.org/newt/yaml"
+	"mynewt.apache.org/newtmgr/newtmgr/nmutil"
+	"mynewt.apache.org/newtmgr/nmxact/nmp"
+	"mynewt.apache.org/newtmgr/nmxact/sesn"
+	"mynewt.apache.org/newtmgr/nmxact/xact"
+)
+
+// A threshold on a single stat, e.g., "os.reboots<=1".
+type statCheck struct {
+	group string
+	field string
+	op    string
+	val   float64
+}
+
+var statCheckRe = regexp.MustCompile(
+	`^([^.]+)\.([^<>=!]+)(<=|>=|==|!=|<|>)(-?[0-9.]+)$`)
+
+func parseStatCheck(s string) (*statCheck, error) {
+	m := statCheckRe.FindStringSubmatch(s)
+	if m == nil {
+		return nil, util.FmtNewtError(
+			"invalid stat check \"%s\"; expected <group>.<field><op><value>",
+			s)
+	}
+
+	val, err := strconv.ParseFloat(m[4], 64)
+	if err != nil {
+		return nil, util.FmtNewtError("invalid stat check value: %s", m[4])
+	}
+
+	return &statCheck{
+		group: m[1],
+		field: m[2],
+		op:    m[3],
+		val:   val,
+	}, nil
+}
+
+func (sc *statCheck) String() string {
+	return fmt.Sprintf("%s.%s%s%v", sc.group, sc.field, sc.op, sc.val)
+}
+
+func (sc *statCheck) passes(v float64) bool {
+	switch sc.op {
+	case "<=":
+		return v <= sc.val
+	case ">=":
+		return v >= sc.val
+	case "==":
+		return v == sc.val
+	case "!=":
+		return v != sc.val
+	case "<":
+		return v < sc.val
+	default:
+		return v > sc.val
+	}
+}
+
+func statNum(itf interface{}) (float64, bool) {
+	switch v := itf.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// Thresholds read from a rules file.  Negative numbers mean "no limit".
+type healthRules struct {
+	echoCount      int
+	echoMaxLatency time.Duration
+
+	taskMaxStackPct float64
+	taskStackPct    map[string]float64
+
+	// Tasks whose context switch count must advance between two samples.
+	taskMustRun    []string
+	sampleInterval time.Duration
+
+	poolMinFree  float64
+	poolMinFrees map[string]float64
+
+	allowCore bool
+
+	stats []*statCheck
+}
+
+type healthResult struct {
+	name   string
+	ok     bool
+	detail string
+}
+
+func newHealthRules() *healthRules {
+	return &healthRules{
+		echoCount:       1,
+		echoMaxLatency:  -1,
+		taskMaxStackPct: -1,
+		taskStackPct:    map[string]float64{},
+		sampleInterval:  time.Second,
+		poolMinFree:     -1,
+		poolMinFrees:    map[string]float64{},
+	}
+}
+
+// Indicates whether any rule applies to tasks.  Not all devices support the
+// taskstat command, so it is only sent if needed.
+func (rules *healthRules) checksTasks() bool {
+	return rules.taskMaxStackPct >= 0 || len(rules.taskStackPct) > 0 ||
+		len(rules.taskMustRun) > 0
+}
+
+// Indicates whether any rule applies to memory pools.
+func (rules *healthRules) checksPools() bool {
+	return rules.poolMinFree >= 0 || len(rules.poolMinFrees) > 0
+}
+
+func healthSection(vals map[string]interface{},
+	key string) (map[string]interface{}, error) {
+
+	itf, ok := vals[key]
+	if !ok || itf == nil {
+		return map[string]interface{}{}, nil
+	}
+
+	m, ok := itf.(map[interface{}]interface{})
+	if !ok {
+		return nil, util.FmtNewtError("\"%s\" must be a mapping", key)
+	}
+
+	section := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		section[fmt.Sprintf("%v", k)] = v
+	}
+
+	return section, nil
+}
+
+func healthNum(section string, key string, itf interface{}) (float64, error) {
+	v, ok := statNum(itf)
+	if !ok {
+		return 0, util.FmtNewtError("%s.%s must be a number", section, key)
+	}
+	return v, nil
+}
+
+// Reads a mapping of names to numbers (e.g., per-task overrides).
+func healthNumMap(section string, key string,
+	itf interface{}) (map[string]float64, error) {
+
+	m, ok := itf.(map[interface{}]interface{})
+	if !ok {
+		return nil, util.FmtNewtError("%s.%s must be a mapping", section, key)
+	}
+
+	nums := make(map[string]float64, len(m))
+	for k, v := range m {
+		name := fmt.Sprintf("%v", k)
+		n, err := healthNum(section+"."+key, name, v)
+		if err != nil {
+			return nil, err
+		}
+		nums[name] = n
+	}
+
+	return nums, nil
+}
+
+func healthStrList(section string, key string, itf interface{}) ([]string,
+	error) {
+
+	l, ok := itf.([]interface{})
+	if !ok {
+		return nil, util.FmtNewtError("%s.%s must be a list", section, key)
+	}
+
+	strs := make([]string, len(l))
+	for i, e := range l {
+		strs[i] = fmt.Sprintf("%v", e)
+	}
+
+	return strs, nil
+}
+
+func healthReadRules(filename string) (*healthRules, error) {
+	rules := newHealthRules()
+	if filename == "" {
+		return rules, nil
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+
+	vals := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &vals); err != nil {
+		return nil, util.FmtNewtError("%s: %s", filename, err.Error())
+	}
+
+	fail := func(err error) (*healthRules, error) {
+		return nil, util.FmtNewtError("%s: %s", filename, err.Error())
+	}
+
+	for key, _ := range vals {
+		switch key {
+		case "echo", "tasks", "mempools", "core", "stats":
+		default:
+			return fail(util.FmtNewtError("unknown section: \"%s\"", key))
+		}
+	}
+
+	echo, err := healthSection(vals, "echo")
+	if err != nil {
+		return fail(err)
+	}
+	for k, v := range echo {
+		n, err := healthNum("echo", k, v)
+		if err != nil {
+			return fail(err)
+		}
+
+		switch k {
+		case "count":
+			rules.echoCount = int(n)
+		case "max_latency_ms":
+			rules.echoMaxLatency = time.Duration(n * float64(time.Millisecond))
+		default:
+			return fail(util.FmtNewtError("unknown setting: echo.%s", k))
+		}
+	}
+
+	tasks, err := healthSection(vals, "tasks")
+	if err != nil {
+		return fail(err)
+	}
+	for k, v := range tasks {
+		switch k {
+		case "max_stack_pct":
+			rules.taskMaxStackPct, err = healthNum("tasks", k, v)
+		case "stack_pct":
+			rules.taskStackPct, err = healthNumMap("tasks", k, v)
+		case "must_run":
+			rules.taskMustRun, err = healthStrList("tasks", k, v)
+		case "sample_interval_ms":
+			var n float64
+			n, err = healthNum("tasks", k, v)
+			rules.sampleInterval = time.Duration(n * float64(time.Millisecond))
+		default:
+			err = util.FmtNewtError("unknown setting: tasks.%s", k)
+		}
+		if err != nil {
+			return fail(err)
+		}
+	}
+
+	pools, err := healthSection(vals, "mempools")
+	if err != nil {
+		return fail(err)
+	}
+	for k, v := range pools {
+		switch k {
+		case "min_free":
+			rules.poolMinFree, err = healthNum("mempools", k, v)
+		case "pools":
+			rules.poolMinFrees, err = healthNumMap("mempools", k, v)
+		default:
+			err = util.FmtNewtError("unknown setting: mempools.%s", k)
+		}
+		if err != nil {
+			return fail(err)
+		}
+	}
+
+	core, err := healthSection(vals, "core")
+	if err != nil {
+		return fail(err)
+	}
+	for k, v := range core {
+		switch k {
+		case "allow":
+			b, ok := v.(bool)
+			if !ok {
+				return fail(util.NewNewtError("core.allow must be a boolean"))
+			}
+			rules.allowCore = b
+		default:
+			return fail(util.FmtNewtError("unknown setting: core.%s", k))
+		}
+	}
+
+	if itf, ok := vals["stats"]; ok {
+		strs, err := healthStrList("", "stats", itf)
+		if err != nil {
+			return fail(util.NewNewtError("\"stats\" must be a list"))
+		}
+		for _, str := range strs {
+			sc, err := parseStatCheck(str)
+			if err != nil {
+				return fail(err)
+			}
+			rules.stats = append(rules.stats, sc)
+		}
+	}
+
+	return rules, nil
+}
+
+func healthCheckEcho(s sesn.Sesn, rules *healthRules) []healthResult {
+	name := "echo"
+
+	var worst time.Duration
+	for i := 0; i < rules.echoCount; i++ {
+		c := xact.NewEchoCmd()
+		c.SetTxOptions(nmutil.TxOptions())
+		c.Payload = fmt.Sprintf("health %d", i)
+
+		start := time.Now()
+		res, err := runXactCmd(c, s)
+		elapsed := time.Since(start)
+
+		if err != nil {
+			return []healthResult{{name, false, err.Error()}}
+		}
+		eres := res.(*xact.EchoResult)
+		if eres.Status() != 0 {
+			return []healthResult{{name, false,
+				fmt.Sprintf("rc=%d", eres.Status())}}
+		}
+		if eres.Rsp.Payload != c.Payload {
+			return []healthResult{{name, false, "payload mismatch"}}
+		}
+
+		if elapsed > worst {
+			worst = elapsed
+		}
+	}
+
+	detail := fmt.Sprintf("max latency %dms over %d requests",
+		worst/time.Millisecond, rules.echoCount)
+	ok := true
+	if rules.echoMaxLatency >= 0 {
+		detail += fmt.Sprintf(" (limit %dms)",
+			rules.echoMaxLatency/time.Millisecond)
+		ok = worst <= rules.echoMaxLatency
+	}
+
+	return []healthResult{{name, ok, detail}}
+}
+
+func healthCheckTasks(s sesn.Sesn, rules *healthRules) []healthResult {
+	if !rules.checksTasks() {
+		return nil
+	}
+
+	rsp, err := taskStatRead(s)
+	if err != nil {
+		return []healthResult{{"tasks", false, err.Error()}}
+	}
+
+	names := make([]string, 0, len(rsp.Tasks))
+	for n, _ := range rsp.Tasks {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	results := []healthResult{}
+	for _, n := range names {
+		limit, ok := rules.taskStackPct[n]
+		if !ok {
+			limit = rules.taskMaxStackPct
+		}
+		if limit < 0 {
+			continue
+		}
+
+		t := rsp.Tasks[n]
+		if t["stksiz"] == 0 {
+			continue
+		}
+
+		pct := 100 * float64(t["stkuse"]) / float64(t["stksiz"])
+		results = append(results, healthResult{
+			name: "task " + n + " stack",
+			ok:   pct <= limit,
+			detail: fmt.Sprintf("%.0f%% used (%d of %d; limit %.0f%%)",
+				pct, t["stkuse"], t["stksiz"], limit),
+		})
+	}
+
+	if len(rules.taskMustRun) == 0 {
+		return results
+	}
+
+	time.Sleep(rules.sampleInterval)
+	rsp2, err := taskStatRead(s)
+	if err != nil {
+		return append(results, healthResult{"tasks", false, err.Error()})
+	}
+
+	for _, n := range rules.taskMustRun {
+		name := "task " + n + " runs"
+
+		t1, ok1 := rsp.Tasks[n]
+		t2, ok2 := rsp2.Tasks[n]
+		if !ok1 || !ok2 {
+			results = append(results,
+				healthResult{name, false, "task not reported"})
+			continue
+		}
+
+		csw := t2["cswcnt"] - t1["cswcnt"]
+		results = append(results, healthResult{
+			name: name,
+			ok:   csw > 0,
+			detail: fmt.Sprintf("%d context switches, runtime +%d in %s",
+				csw, t2["runtime"]-t1["runtime"], rules.sampleInterval),
+		})
+	}
+
+	return results
+}
+
+func healthCheckPools(s sesn.Sesn, rules *healthRules) []healthResult {
+	if !rules.checksPools() {
+		return nil
+	}
+
+	rsp, err := mempoolStatRead(s)
+	if err != nil {
+		return []healthResult{{"mempools", false, err.Error()}}
+	}
+
+	names := make([]string, 0, len(rsp.Mpools))
+	for n, _ := range rsp.Mpools {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	results := []healthResult{}
+	for _, n := range names {
+		limit, ok := rules.poolMinFrees[n]
+		if !ok {
+			limit = rules.poolMinFree
+		}
+		if limit < 0 {
+			continue
+		}
+
+		mp := rsp.Mpools[n]
+		results = append(results, healthResult{
+			name: "mempool " + n,
+			ok:   float64(mp["min"]) >= limit,
+			detail: fmt.Sprintf("min free %d of %d blocks (limit %.0f)",
+				mp["min"], mp["nblks"], limit),
+		})
+	}
+
+	return results
+}
+
+func healthCheckCore(s sesn.Sesn, rules *healthRules) []healthResult {
+	present, err := rolloutCorePresent(s)
+	if err != nil {
+		return []healthResult{{"core", false, err.Error()}}
+	}
+
+	if !present {
+		return []healthResult{{"core", true, "no core file"}}
+	}
+
+	return []healthResult{{"core", rules.allowCore, "core file present"}}
+}
+
+func healthCheckStats(s sesn.Sesn, rules *healthRules) []healthResult {
+	results := []healthResult{}
+
+	// Read each group once.
+	groups := map[string]*nmp.StatReadRsp{}
+	for _, sc := range rules.stats {
+		name := "stat " + sc.String()
+
+		rsp, ok := groups[sc.group]
+		if !ok {
+			c := xact.NewStatReadCmd()
+			c.SetTxOptions(nmutil.TxOptions())
+			c.Name = sc.group
+
+			res, err := runXactCmd(c, s)
+			if err != nil {
+				results = append(results,
+					healthResult{name, false, err.Error()})
+				continue
+			}
+			rsp = res.(*xact.StatReadResult).Rsp
+			groups[sc.group] = rsp
+		}
+
+		if rsp.Rc != 0 {
+			results = append(results,
+				healthResult{name, false, fmt.Sprintf("rc=%d", rsp.Rc)})
+			continue
+		}
+
+		v, ok := statNum(rsp.Fields[sc.field])
+		if !ok {
+			results = append(results,
+				healthResult{name, false, "not reported by device"})
+			continue
+		}
+
+		results = append(results, healthResult{
+			name:   name,
+			ok:     sc.passes(v),
+			detail: fmt.Sprintf("value %v", v),
+		})
+	}
+
+	return results
+}
+
+func healthRunCmd(cmd *cobra.Command, args []string) {
+	filename := ""
+	if len(args) > 0 {
+		filename = args[0]
+	}
+
+	rules, err := healthReadRules(filename)
+	if err != nil {
+		nmUsage(nil, err)
+	}
+
+	s, err := GetSesn()
+	if err != nil {
+		nmUsage(nil, err)
+	}
+
+	results := []healthResult{}
+	results = append(results, healthCheckEcho(s, rules)...)
+	results = append(results, healthCheckTasks(s, rules)...)
+	results = append(results, healthCheckPools(s, rules)...)
+	results = append(results, healthCheckCore(s, rules)...)
+	results = append(results, healthCheckStats(s, rules)...)
+
+	failed := 0
+	for _, r := range results {
+		if !r.ok {
+			failed++
+		}
+	}
+
+	if outputStructured() {
+		checks := make([]interface{}, len(results))
+		for i, r := range results {
+			checks[i] = map[string]interface{}{
+				"name":   r.name,
+				"ok":     r.ok,
+				"detail": r.detail,
+			}
+		}
+		outputPrintValue(map[string]interface{}{
+			"ok":     failed == 0,
+			"checks": checks,
+		})
+	} else {
+		for _, r := range results {
+			status := "PASS"
+			if !r.ok {
+				status = "FAIL"
+			}
+			fmt.Printf("%s  %-28s %s\n", status, r.name, r.detail)
+		}
+
+		if failed == 0 {
+			fmt.Printf("Result: PASS (%d checks)\n", len(results))
+		} else {
+			fmt.Printf("Result: FAIL (%d of %d checks failed)\n", failed,
+				len(results))
+		}
+	}
+
+	if failed > 0 {
+		nmCmdExit(1)
+	}
+}
+
+func healthCmd() *cobra.Command {
+	healthHelpText := "Check a device's health and exit with a nonzero " +
+		"status if any check fails.  The checks are: echo round trips, " +
+		"task stack usage and progress, memory pool low-water marks, the " +
+		"presence of a core file, and stat thresholds.  Limits come from " +
+		"an optional YAML rules file; without one, only the echo and core " +
+		"checks are run.  Task and memory pool statistics are only read " +
+		"if a rule refers to them."
+
+	healthEx := "  newtmgr -c olimex health rules.yaml\n\n"
+	healthEx += "  # rules.yaml\n"
+	healthEx += "  echo:\n"
+	healthEx += "      count: 5\n"
+	healthEx += "      max_latency_ms: 250\n"
+	healthEx += "  tasks:\n"
+	healthEx += "      max_stack_pct: 90\n"
+	healthEx += "      stack_pct:\n"
+	healthEx += "          idle: 95\n"
+	healthEx += "      must_run: [main]\n"
+	healthEx += "      sample_interval_ms: 1000\n"
+	healthEx += "  mempools:\n"
+	healthEx += "      min_free: 1\n"
+	healthEx += "      pools:\n"
+	healthEx += "          msys_1: 4\n"
+	healthEx += "  core:\n"
+	healthEx += "      allow: false\n"
+	healthEx += "  stats:\n"
+	healthEx += "      - os.reboots<=3\n"
+
+	healthCmd := &cobra.Command{
+		Use:     "health [rules-file] -c <conn_profile>",
+		Short:   "Check a device's health against thresholds",
+		Long:    healthHelpText,
+		Example: healthEx,
+		Run:     healthRunCmd,
+	}
+
+	return healthCmd
+}
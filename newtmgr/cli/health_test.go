@@ -0,0 +1,185 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseStatCheck(t *testing.T) {
+	tests := []struct {
+		str  string
+		want statCheck
+		pass []float64
+		fail []float64
+	}{
+		{"os.reboots<=3", statCheck{"os", "reboots", "<=", 3},
+			[]float64{0, 3}, []float64{4}},
+		{"ble_ll.rx_crc_err<10", statCheck{"ble_ll", "rx_crc_err", "<", 10},
+			[]float64{9.5}, []float64{10, 11}},
+		{"os.uptime>=60.5", statCheck{"os", "uptime", ">=", 60.5},
+			[]float64{60.5, 100}, []float64{60}},
+		{"a.b>-1", statCheck{"a", "b", ">", -1},
+			[]float64{0}, []float64{-1, -2}},
+		{"a.b==0", statCheck{"a", "b", "==", 0},
+			[]float64{0}, []float64{1}},
+		{"a.b!=0", statCheck{"a", "b", "!=", 0},
+			[]float64{1}, []float64{0}},
+	}
+
+	for _, test := range tests {
+		sc, err := parseStatCheck(test.str)
+		if err != nil {
+			t.Errorf("%s: %s", test.str, err.Error())
+			continue
+		}
+		if *sc != test.want {
+			t.Errorf("%s: got %+v; want %+v", test.str, *sc, test.want)
+		}
+
+		for _, v := range test.pass {
+			if !sc.passes(v) {
+				t.Errorf("%s: %v doesn't pass", test.str, v)
+			}
+		}
+		for _, v := range test.fail {
+			if sc.passes(v) {
+				t.Errorf("%s: %v passes", test.str, v)
+			}
+		}
+	}
+}
+
+func TestParseStatCheckInvalid(t *testing.T) {
+	strs := []string{
+		"",
+		"reboots<=3",
+		"os.reboots",
+		"os.reboots<=",
+		"os.reboots=>3",
+		"os.reboots<=abc",
+		"os.reboots<=1.2.3",
+	}
+
+	for _, str := range strs {
+		if sc, err := parseStatCheck(str); err == nil {
+			t.Errorf("\"%s\": got %+v; want error", str, *sc)
+		}
+	}
+}
+
+func TestHealthReadRules(t *testing.T) {
+	text := `
+echo:
+    count: 5
+    max_latency_ms: 250
+tasks:
+    max_stack_pct: 90
+    stack_pct:
+        idle: 95
+    must_run: [main, ble_ll]
+    sample_interval_ms: 500
+mempools:
+    min_free: 1
+    pools:
+        msys_1: 4
+core:
+    allow: true
+stats:
+    - os.reboots<=3
+`
+	filename, cleanup := testTempFile(t, text)
+	defer cleanup()
+
+	rules, err := healthReadRules(filename)
+	if err != nil {
+		t.Fatalf("failed to read rules: %s", err.Error())
+	}
+
+	want := &healthRules{
+		echoCount:       5,
+		echoMaxLatency:  250 * time.Millisecond,
+		taskMaxStackPct: 90,
+		taskStackPct:    map[string]float64{"idle": 95},
+		taskMustRun:     []string{"main", "ble_ll"},
+		sampleInterval:  500 * time.Millisecond,
+		poolMinFree:     1,
+		poolMinFrees:    map[string]float64{"msys_1": 4},
+		allowCore:       true,
+		stats:           []*statCheck{{"os", "reboots", "<=", 3}},
+	}
+	if !reflect.DeepEqual(rules, want) {
+		t.Errorf("got rules %+v; want %+v", rules, want)
+	}
+	if !rules.checksTasks() || !rules.checksPools() {
+		t.Errorf("task and memory pool checks not enabled")
+	}
+}
+
+func TestHealthReadRulesDefault(t *testing.T) {
+	rules, err := healthReadRules("")
+	if err != nil {
+		t.Fatalf("failed to read rules: %s", err.Error())
+	}
+	if !reflect.DeepEqual(rules, newHealthRules()) {
+		t.Errorf("got rules %+v; want defaults", rules)
+	}
+	if rules.checksTasks() || rules.checksPools() {
+		t.Errorf("default rules check tasks or memory pools")
+	}
+
+	// A section on its own only enables its checks.
+	filename, cleanup := testTempFile(t, "mempools:\n    min_free: 2\n")
+	defer cleanup()
+
+	rules, err = healthReadRules(filename)
+	if err != nil {
+		t.Fatalf("failed to read rules: %s", err.Error())
+	}
+	if rules.checksTasks() || !rules.checksPools() {
+		t.Errorf("mempools section enabled the wrong checks")
+	}
+}
+
+func TestHealthReadRulesInvalid(t *testing.T) {
+	texts := []string{
+		"bogus:\n    x: 1\n",
+		"echo:\n    count: many\n",
+		"echo:\n    retries: 1\n",
+		"echo: 5\n",
+		"tasks:\n    stack_pct: 90\n",
+		"tasks:\n    must_run: main\n",
+		"mempools:\n    max_free: 1\n",
+		"core:\n    allow: maybe\n",
+		"stats: os.reboots<=3\n",
+		"stats:\n    - os.reboots\n",
+		"echo: [\n",
+	}
+
+	for _, text := range texts {
+		filename, cleanup := testTempFile(t, text)
+		if _, err := healthReadRules(filename); err == nil {
+			t.Errorf("%q: no error", text)
+		}
+		cleanup()
+	}
+}
@@ -20,29 +20,43 @@
 package cli
 
 import (
+	"bytes"
 	"encoding/hex"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"strings"
+	"sync"
+	"time"
 
+	log "github.com/Sirupsen/logrus"
 	"github.com/cheggaaa/pb"
 	"github.com/spf13/cobra"
 
 	"mynewt.apache.org/newt/util"
+	"mynewt.apache.org/newtmgr/newtmgr/config"
 	"mynewt.apache.org/newtmgr/newtmgr/core"
+	"mynewt.apache.org/newtmgr/newtmgr/image"
 	"mynewt.apache.org/newtmgr/newtmgr/nmutil"
 	"mynewt.apache.org/newtmgr/nmxact/nmp"
+	"mynewt.apache.org/newtmgr/nmxact/sesn"
 	"mynewt.apache.org/newtmgr/nmxact/xact"
 )
 
 var (
-	coreElfify   bool
-	coreOffset   uint32
-	coreNumBytes uint32
+	coreElfify    bool
+	coreOffset    uint32
+	coreNumBytes  uint32
+	coreImageFile string
+	coreMaxFrames int
+	coreArch      string
+
+	// Empty to use the ELF file's architecture.
+	coreAnalyzeArch string
 )
 
 var noerase bool
+var uploadWinSz int
+var bootTimeout int
 
 func imageFlagsStr(image nmp.ImageStateEntry) string {
 	strs := []string{}
@@ -95,55 +109,154 @@ func imageStateListCmd(cmd *cobra.Command, args []string) {
 	c := xact.NewImageStateReadCmd()
 	c.SetTxOptions(nmutil.TxOptions())
 
-	res, err := c.Run(s)
+	res, err := runXactCmd(c, s)
 	if err != nil {
 		nmUsage(nil, util.ChildNewtError(err))
 	}
 	ires := res.(*xact.ImageStateReadResult)
+	if outputStructured() {
+		outputRsp(ires.Rsp, ires.Rsp.Rc)
+		return
+	}
+
+	if ires.Rsp.Rc != 0 {
+		rspError(ires.Rsp.Rc)
+	}
 
 	if err := imageStatePrintRsp(ires.Rsp); err != nil {
 		nmUsage(nil, err)
 	}
 }
 
-func imageStateTestCmd(cmd *cobra.Command, args []string) {
-	if len(args) < 1 {
-		nmUsage(cmd, nil)
+// Retrieves the hash specified on the command line.  The argument is either
+// the path of an image file or a hex string.
+func imageHashFromArg(arg string) ([]byte, error) {
+	if info, err := os.Stat(arg); err == nil && info.Mode().IsRegular() {
+		img, err := image.ReadImage(arg)
+		if err != nil {
+			return nil, err
+		}
+
+		return img.Hash()
 	}
 
-	hexBytes, err := hex.DecodeString(args[0])
+	hash, err := hex.DecodeString(arg)
 	if err != nil {
-		nmUsage(cmd, util.ChildNewtError(err))
+		return nil, util.FmtNewtError(
+			"\"%s\" is neither an image file nor a hex hash", arg)
 	}
 
-	s, err := GetSesn()
+	return hash, nil
+}
+
+func imageStateRead(s sesn.Sesn) (*nmp.ImageStateRsp, error) {
+	c := xact.NewImageStateReadCmd()
+	c.SetTxOptions(nmutil.TxOptions())
+
+	res, err := runXactCmd(c, s)
 	if err != nil {
-		nmUsage(nil, err)
+		return nil, util.ChildNewtError(err)
+	}
+	ires := res.(*xact.ImageStateReadResult)
+	if ires.Status() != 0 {
+		return nil, util.FmtNewtError("Failed to read image state: %d",
+			ires.Status())
+	}
+
+	return ires.Rsp, nil
+}
+
+func imageStateFind(rsp *nmp.ImageStateRsp,
+	hash []byte) *nmp.ImageStateEntry {
+
+	for i, _ := range rsp.Images {
+		if bytes.Equal(rsp.Images[i].Hash, hash) {
+			return &rsp.Images[i]
+		}
+	}
+
+	return nil
+}
+
+func imageStateHashesStr(rsp *nmp.ImageStateRsp) string {
+	strs := []string{}
+	for _, e := range rsp.Images {
+		strs = append(strs, fmt.Sprintf("slot%d=%x", e.Slot, e.Hash))
 	}
 
+	return strings.Join(strs, " ")
+}
+
+// Ensures the device contains an image with the specified hash.
+func imageCheckPresent(s sesn.Sesn, hash []byte) error {
+	rsp, err := imageStateRead(s)
+	if err != nil {
+		return err
+	}
+
+	if imageStateFind(rsp, hash) == nil {
+		return util.FmtNewtError(
+			"Device does not contain an image with hash %x (%s)",
+			hash, imageStateHashesStr(rsp))
+	}
+
+	return nil
+}
+
+func imageStateWrite(s sesn.Sesn, hash []byte, confirm bool) {
 	c := xact.NewImageStateWriteCmd()
 	c.SetTxOptions(nmutil.TxOptions())
-	c.Hash = hexBytes
-	c.Confirm = false
+	c.Hash = hash
+	c.Confirm = confirm
 
-	res, err := c.Run(s)
+	res, err := runXactCmd(c, s)
 	if err != nil {
 		nmUsage(nil, util.ChildNewtError(err))
 	}
 	ires := res.(*xact.ImageStateWriteResult)
+	if outputStructured() {
+		outputRsp(ires.Rsp, ires.Rsp.Rc)
+		return
+	}
+
+	if ires.Rsp.Rc != 0 {
+		rspError(ires.Rsp.Rc)
+	}
 
 	if err := imageStatePrintRsp(ires.Rsp); err != nil {
 		nmUsage(nil, err)
 	}
 }
 
+func imageStateTestCmd(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		nmUsage(cmd, nil)
+	}
+
+	hash, err := imageHashFromArg(args[0])
+	if err != nil {
+		nmUsage(cmd, err)
+	}
+
+	s, err := GetSesn()
+	if err != nil {
+		nmUsage(nil, err)
+	}
+
+	if err := imageCheckPresent(s, hash); err != nil {
+		nmUsage(nil, err)
+	}
+
+	imageStateWrite(s, hash, false)
+}
+
 func imageStateConfirmCmd(cmd *cobra.Command, args []string) {
-	var hexBytes []byte
+	var hash []byte
 	if len(args) >= 1 {
 		var err error
-		hexBytes, err = hex.DecodeString(args[0])
+		hash, err = imageHashFromArg(args[0])
 		if err != nil {
-			nmUsage(cmd, util.ChildNewtError(err))
+			nmUsage(cmd, err)
 		}
 	}
 
@@ -152,19 +265,88 @@ func imageStateConfirmCmd(cmd *cobra.Command, args []string) {
 		nmUsage(nil, err)
 	}
 
-	c := xact.NewImageStateWriteCmd()
-	c.SetTxOptions(nmutil.TxOptions())
-	c.Hash = hexBytes
-	c.Confirm = true
+	if hash != nil {
+		if err := imageCheckPresent(s, hash); err != nil {
+			nmUsage(nil, err)
+		}
+	}
+
+	imageStateWrite(s, hash, true)
+}
 
-	res, err := c.Run(s)
+// Reads an image file and checks that its contents match its hash.
+func imageReadVerified(filename string) (*image.Image, []byte, error) {
+	img, err := image.ReadImage(filename)
 	if err != nil {
-		nmUsage(nil, util.ChildNewtError(err))
+		return nil, nil, err
+	}
+	if err := img.VerifyHash(); err != nil {
+		return nil, nil, util.FmtNewtError("Refusing to upload %s: %s",
+			filename, err.Error())
 	}
-	ires := res.(*xact.ImageStateWriteResult)
 
-	if err := imageStatePrintRsp(ires.Rsp); err != nil {
-		nmUsage(nil, err)
+	hash, err := img.Hash()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return img, hash, nil
+}
+
+// Saving the upload state after every chunk would rewrite the state file
+// hundreds of times per image.  Instead, the offset is saved after this much
+// progress or this much time, and once more if the upload stops early.
+const (
+	UPLOAD_STATE_SAVE_BYTES    = 32 * 1024
+	UPLOAD_STATE_SAVE_INTERVAL = 5 * time.Second
+)
+
+// Records the progress of an image upload so that it can be resumed if it
+// gets interrupted.
+type uploadStateSaver struct {
+	state    *config.UploadState
+	savedOff uint32
+	lastSave time.Time
+	mtx      sync.Mutex
+}
+
+func newUploadStateSaver(state *config.UploadState) *uploadStateSaver {
+	return &uploadStateSaver{
+		state:    state,
+		savedOff: state.Off,
+		lastSave: time.Now(),
+	}
+}
+
+func (u *uploadStateSaver) save() {
+	if err := config.SaveUploadState(u.state); err != nil {
+		log.Warnf("Failed to save upload state: %s", err.Error())
+	}
+	u.savedOff = u.state.Off
+	u.lastSave = time.Now()
+}
+
+// Records the device's offset; saves it if enough has changed since the last
+// save.
+func (u *uploadStateSaver) update(off uint32) {
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+
+	u.state.Off = off
+	if off >= u.savedOff+UPLOAD_STATE_SAVE_BYTES ||
+		time.Since(u.lastSave) >= UPLOAD_STATE_SAVE_INTERVAL {
+
+		u.save()
+	}
+}
+
+// Saves the most recent offset if it hasn't been saved yet.
+func (u *uploadStateSaver) flush() {
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+
+	if u.state.Off != u.savedOff {
+		u.save()
 	}
 }
 
@@ -173,43 +355,311 @@ func imageUploadCmd(cmd *cobra.Command, args []string) {
 		nmUsage(cmd, util.NewNewtError("Need to specify image to upload"))
 	}
 
-	imageFile, err := ioutil.ReadFile(args[0])
+	img, hash, err := imageReadVerified(args[0])
 	if err != nil {
-		nmUsage(cmd, util.NewNewtError(err.Error()))
+		nmUsage(nil, err)
 	}
+	imageFile := img.Data
 
 	s, err := GetSesn()
 	if err != nil {
 		nmUsage(nil, err)
 	}
 
+	state, err := imageUploadState(hash)
+	if err != nil {
+		nmUsage(nil, err)
+	}
+
 	c := xact.NewImageUpgradeCmd()
 	c.SetTxOptions(nmutil.TxOptions())
 	c.Data = imageFile
 	if noerase == true {
 		c.NoErase = true
 	}
-	c.ProgressBar = pb.StartNew(len(imageFile))
-	c.ProgressBar.SetUnits(pb.U_BYTES)
-	c.LastOff = 0
+	if int(state.Off) < len(imageFile) {
+		c.StartOff = int(state.Off)
+	}
+	if c.StartOff > 0 {
+		fmt.Fprintf(progressWriter(), "Resuming upload at offset %d\n",
+			c.StartOff)
+	}
+	c.MaxWinSz = uploadWinSz
+	c.ProgressBar = newProgressBar(len(imageFile))
+	c.ProgressBar.Set(c.StartOff)
+	c.LastOff = uint32(c.StartOff)
+
+	// Remember the device's offset in case the upload gets interrupted.
+	saver := newUploadStateSaver(state)
+	prevOnExit := onExit
+	SetOnExit(func() {
+		saver.flush()
+		if prevOnExit != nil {
+			prevOnExit()
+		}
+	})
+	defer SetOnExit(prevOnExit)
+
 	c.ProgressCb = func(cmd *xact.ImageUploadCmd, rsp *nmp.ImageUploadRsp) {
-		c.ProgressBar.Add(int(rsp.Off - c.LastOff))
-		c.LastOff = rsp.Off
+		if rsp.Rc == 0 {
+			saver.update(rsp.Off)
+		}
+
+		// With a window of outstanding requests, responses can report an
+		// offset lower than one already seen.
+		if rsp.Off > c.LastOff {
+			c.ProgressBar.Add(int(rsp.Off - c.LastOff))
+			c.LastOff = rsp.Off
+		}
 	}
 
-	res, err := c.Run(s)
+	res, err := runXactCmd(c, s)
+	c.ProgressBar.Finish()
 	if err != nil {
+		saver.flush()
 		nmUsage(nil, util.ChildNewtError(err))
 	}
 
 	if res.Status() != 0 {
-		fmt.Printf("Error: %d\n", res.Status())
+		saver.flush()
+		if outputStructured() {
+			outputRsp(imageUploadResultMap(res.Status(), hash), res.Status())
+		}
+		rspError(res.Status())
+	}
+
+	// The upload is complete; there is nothing left to resume.
+	SetOnExit(prevOnExit)
+	if err := config.ClearUploadState(state.Peer); err != nil {
+		nmUsage(nil, err)
+	}
+
+	if err := imageVerifyUploaded(img); err != nil {
+		nmUsage(nil, err)
+	}
+
+	if outputStructured() {
+		outputRsp(imageUploadResultMap(0, hash), 0)
 		return
 	}
 
 	fmt.Printf("Done\n")
 }
 
+func imageUploadResultMap(rc int, hash []byte) map[string]interface{} {
+	return map[string]interface{}{
+		"rc":   rc,
+		"hash": hash,
+	}
+}
+
+// Retrieves the saved state of an interrupted upload of the specified image to
+// the current peer.  If a different image was being uploaded, or no upload was
+// in progress, the returned state starts at offset 0.
+func imageUploadState(hash []byte) (*config.UploadState, error) {
+	cp, err := getConnProfile()
+	if err != nil {
+		return nil, err
+	}
+
+	peer := cp.PeerId()
+	hashStr := hex.EncodeToString(hash)
+
+	state, err := config.GetUploadState(peer)
+	if err != nil {
+		return nil, err
+	}
+
+	if state == nil || state.Hash != hashStr {
+		state = &config.UploadState{
+			Peer: peer,
+			Hash: hashStr,
+		}
+	}
+
+	return state, nil
+}
+
+// Ensures the device reports an image with the same hash as the one that was
+// just uploaded.
+func imageVerifyUploaded(img *image.Image) error {
+	hash, err := img.Hash()
+	if err != nil {
+		return err
+	}
+
+	s, err := GetSesn()
+	if err != nil {
+		return err
+	}
+
+	rsp, err := imageStateRead(s)
+	if err != nil {
+		return err
+	}
+
+	if imageStateFind(rsp, hash) == nil {
+		return util.FmtNewtError(
+			"Uploaded image hash %x not reported by device (%s)",
+			hash, imageStateHashesStr(rsp))
+	}
+
+	return nil
+}
+
+func imageUpgradeVerifyCmd(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		nmUsage(cmd, util.NewNewtError("Need to specify image to upload"))
+	}
+
+	img, hash, err := imageReadVerified(args[0])
+	if err != nil {
+		nmUsage(nil, err)
+	}
+
+	s, err := GetSesn()
+	if err != nil {
+		nmUsage(nil, err)
+	}
+
+	var bar *pb.ProgressBar
+	var lastOff uint32
+
+	c := xact.NewImageUpgradeVerifyCmd()
+	c.SetTxOptions(nmutil.TxOptions())
+	c.Data = img.Data
+	c.Hash = hash
+	c.NoErase = noerase
+	c.MaxWinSz = uploadWinSz
+	c.BootTimeout = time.Duration(bootTimeout) * time.Second
+	c.StepCb = func(step xact.ImageUpgradeVerifyStep) {
+		if bar != nil {
+			bar.Finish()
+			bar = nil
+		}
+
+		fmt.Fprintf(progressWriter(), "%s...\n", step.String())
+		if step == xact.IMAGE_UPGRADE_VERIFY_STEP_UPLOAD {
+			bar = newProgressBar(len(img.Data))
+		}
+	}
+	c.ProgressCb = func(cmd *xact.ImageUploadCmd, rsp *nmp.ImageUploadRsp) {
+		if bar != nil && rsp.Off > lastOff {
+			bar.Add(int(rsp.Off - lastOff))
+			lastOff = rsp.Off
+		}
+	}
+
+	res, err := runXactCmd(c, s)
+	if bar != nil {
+		bar.Finish()
+	}
+	if err != nil {
+		nmUsage(nil, util.ChildNewtError(err))
+	}
+	vres := res.(*xact.ImageUpgradeVerifyResult)
+
+	if outputStructured() {
+		m := map[string]interface{}{
+			"rc":          vres.Status(),
+			"hash":        hash,
+			"rolled_back": vres.RolledBack,
+		}
+		if vres.BootRes != nil {
+			m["boot"] = vres.BootRes.Rsp
+		}
+		if vres.ConfirmRes != nil {
+			m["confirm"] = vres.ConfirmRes.Rsp
+		}
+
+		rc := vres.Status()
+		if rc == 0 && vres.RolledBack {
+			rc = nmp.NMP_ERR_EUNKNOWN
+		}
+		outputRsp(m, rc)
+		return
+	}
+
+	if vres.Status() != 0 {
+		rspError(vres.Status())
+	}
+
+	if vres.RolledBack {
+		imageStatePrintRsp(vres.BootRes.Rsp)
+		nmUsage(nil, util.FmtNewtError(
+			"Device rolled back; image %x is not running", hash))
+	}
+
+	if err := imageStatePrintRsp(vres.ConfirmRes.Rsp); err != nil {
+		nmUsage(nil, err)
+	}
+
+	fmt.Printf("Done; image %x confirmed\n", hash)
+}
+
+func imageInfoCmd(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		nmUsage(cmd, util.NewNewtError("Need to specify an image file"))
+	}
+
+	img, err := image.ReadImage(args[0])
+	if err != nil {
+		nmUsage(nil, err)
+	}
+
+	hdr := img.Header
+	format := 2
+	if img.IsV1() {
+		format = 1
+	}
+
+	fmt.Printf("Image: %s\n", args[0])
+	fmt.Printf("    magic: 0x%08x (format %d)\n", hdr.Magic, format)
+	fmt.Printf("    header size: %d\n", hdr.HdrSz)
+	fmt.Printf("    image size: %d\n", hdr.ImgSz)
+	fmt.Printf("    version: %s\n", hdr.Vers.String())
+	if hdr.Flags == 0 {
+		fmt.Printf("    flags: none\n")
+	} else {
+		fmt.Printf("    flags: 0x%08x %s\n", hdr.Flags,
+			image.FlagsString(hdr.Flags))
+	}
+	if img.IsV1() {
+		fmt.Printf("    key id: %d\n", hdr.KeyId)
+	}
+
+	if hash, err := img.Hash(); err != nil {
+		fmt.Printf("    hash: %s\n", err.Error())
+	} else {
+		status := "verified"
+		if err := img.VerifyHash(); err != nil {
+			status = "MISMATCH"
+		} else if hdr.Flags&image.IMAGE_F_NON_BOOTABLE != 0 {
+			status = "not verified; split image"
+		}
+		fmt.Printf("    hash: %x (%s)\n", hash, status)
+	}
+
+	for _, tlv := range img.KeyHashTlvs() {
+		fmt.Printf("    key hash: %x\n", tlv.Data)
+	}
+
+	sigs := img.SigTlvs()
+	if len(sigs) == 0 {
+		fmt.Printf("    signatures: none\n")
+	}
+	for _, tlv := range sigs {
+		fmt.Printf("    signature: %s (%d bytes)\n",
+			img.TlvTypeName(tlv.Type), len(tlv.Data))
+	}
+
+	fmt.Printf("TLVs:\n")
+	for _, tlv := range img.Tlvs {
+		fmt.Printf("    offset=%d type=%s len=%d\n",
+			tlv.Offset, img.TlvTypeName(tlv.Type), len(tlv.Data))
+	}
+}
+
 func coreListCmd(cmd *cobra.Command, args []string) {
 	s, err := GetSesn()
 	if err != nil {
@@ -219,11 +669,20 @@ func coreListCmd(cmd *cobra.Command, args []string) {
 	c := xact.NewCoreListCmd()
 	c.SetTxOptions(nmutil.TxOptions())
 
-	res, err := c.Run(s)
+	res, err := runXactCmd(c, s)
 	if err != nil {
 		nmUsage(nil, util.ChildNewtError(err))
 	}
 	ires := res.(*xact.CoreListResult)
+	if outputStructured() {
+		// A missing core is a valid answer, not an error.
+		rc := ires.Status()
+		if rc == nmp.NMP_ERR_ENOENT {
+			rc = 0
+		}
+		outputRsp(ires.Rsp, rc)
+		return
+	}
 
 	switch ires.Status() {
 	case 0:
@@ -231,7 +690,7 @@ func coreListCmd(cmd *cobra.Command, args []string) {
 	case nmp.NMP_ERR_ENOENT:
 		fmt.Printf("No corefiles\n")
 	default:
-		fmt.Printf("Error: %d\n", ires.Status())
+		rspError(ires.Status())
 	}
 }
 
@@ -240,50 +699,95 @@ func coreDownloadCmd(cmd *cobra.Command, args []string) {
 		nmUsage(cmd, nil)
 	}
 
-	tmpName := args[0] + ".tmp"
-	file, err := os.OpenFile(tmpName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0660)
-	if err != nil {
-		nmUsage(cmd, util.NewNewtError(fmt.Sprintf(
-			"Cannot open file %s - %s", tmpName, err.Error())))
+	if coreElfify && (coreOffset != 0 || coreNumBytes != 0) {
+		nmUsage(cmd, util.NewNewtError(
+			"Cannot create an elf file from a partial core"))
 	}
-	defer os.Remove(tmpName)
-	defer file.Close()
 
-	s, err := GetSesn()
+	arch, err := core.CoreArchFromString(coreArch)
 	if err != nil {
-		nmUsage(nil, err)
+		nmUsage(cmd, err)
 	}
 
-	c := xact.NewCoreLoadCmd()
-	c.SetTxOptions(nmutil.TxOptions())
-	c.ProgressCb = func(c *xact.CoreLoadCmd, rsp *nmp.CoreLoadRsp) {
-		fmt.Printf("%d\n", rsp.Off)
-		if _, err := file.Write(rsp.Data); err != nil {
+	file, tmpName, n := openDownloadTmp(args[0], false)
+	defer file.Close()
+	have := uint32(n)
+
+	if coreNumBytes != 0 && have >= coreNumBytes {
+		// The previous attempt downloaded everything but didn't finish up.
+		if err := file.Truncate(int64(coreNumBytes)); err != nil {
 			nmUsage(nil, util.ChildNewtError(err))
 		}
-	}
+	} else {
+		if have > 0 {
+			fmt.Fprintf(progressWriter(), "Resuming download at offset %d\n",
+				coreOffset+have)
+		}
 
-	res, err := c.Run(s)
-	if err != nil {
-		nmUsage(nil, util.ChildNewtError(err))
-	}
+		s, err := GetSesn()
+		if err != nil {
+			nmUsage(nil, err)
+		}
 
-	sres := res.(*xact.CoreLoadResult)
-	if sres.Status() != 0 {
-		fmt.Printf("Error: %d\n", sres.Status())
-		return
+		c := xact.NewCoreLoadCmd()
+		c.SetTxOptions(nmutil.TxOptions())
+		c.StartOff = int(coreOffset + have)
+		if coreNumBytes != 0 {
+			c.NumBytes = int(coreNumBytes - have)
+		}
+		c.ProgressCb = func(c *xact.CoreLoadCmd, rsp *nmp.CoreLoadRsp) {
+			fmt.Fprintf(progressWriter(), "%d\n", rsp.Off)
+			if _, err := file.Write(rsp.Data); err != nil {
+				nmUsage(nil, util.ChildNewtError(err))
+			}
+		}
+
+		res, err := runXactCmd(c, s)
+		if err != nil {
+			nmUsage(nil, util.ChildNewtError(err))
+		}
+
+		sres := res.(*xact.CoreLoadResult)
+		if sres.Status() != 0 {
+			if outputStructured() {
+				outputRsp(map[string]interface{}{
+					"rc": sres.Status(),
+				}, sres.Status())
+			}
+			rspError(sres.Status())
+		}
 	}
+	file.Close()
 
 	if !coreElfify {
-		os.Rename(tmpName, args[0])
+		if err := os.Rename(tmpName, args[0]); err != nil {
+			nmUsage(nil, util.ChildNewtError(err))
+		}
+		if outputStructured() {
+			outputRsp(map[string]interface{}{
+				"rc":   0,
+				"file": args[0],
+			}, 0)
+			return
+		}
 		fmt.Printf("Done writing core file to %s\n", args[0])
 	} else {
-		coreConvert, err := core.ConvertFilenames(tmpName, args[0])
+		coreConvert, err := core.ConvertFilenamesArch(tmpName, args[0],
+			arch)
 		if err != nil {
 			nmUsage(nil, err)
 			return
 		}
-
+		os.Remove(tmpName)
+
+		if outputStructured() {
+			outputRsp(map[string]interface{}{
+				"rc":   0,
+				"file": args[0],
+				"hash": coreConvert.ImageHash,
+			}, 0)
+			return
+		}
 		fmt.Printf("Done writing core file to %s; hash=%x\n", args[0],
 			coreConvert.ImageHash)
 	}
@@ -298,15 +802,18 @@ func coreEraseCmd(cmd *cobra.Command, args []string) {
 	c := xact.NewCoreEraseCmd()
 	c.SetTxOptions(nmutil.TxOptions())
 
-	res, err := c.Run(s)
+	res, err := runXactCmd(c, s)
 	if err != nil {
 		nmUsage(nil, util.ChildNewtError(err))
 	}
 	ires := res.(*xact.CoreEraseResult)
+	if outputStructured() {
+		outputRsp(ires.Rsp, ires.Status())
+		return
+	}
 
 	if ires.Status() != 0 {
-		fmt.Printf("Error: %d\n", ires.Status())
-		return
+		rspError(ires.Status())
 	}
 
 	fmt.Printf("Done\n")
@@ -321,15 +828,18 @@ func imageEraseCmd(cmd *cobra.Command, args []string) {
 	c := xact.NewImageEraseCmd()
 	c.SetTxOptions(nmutil.TxOptions())
 
-	res, err := c.Run(s)
+	res, err := runXactCmd(c, s)
 	if err != nil {
 		nmUsage(nil, util.ChildNewtError(err))
 	}
 	ires := res.(*xact.ImageEraseResult)
+	if outputStructured() {
+		outputRsp(ires.Rsp, ires.Status())
+		return
+	}
 
 	if ires.Status() != 0 {
-		fmt.Printf("Error: %d\n", ires.Status())
-		return
+		rspError(ires.Status())
 	}
 
 	fmt.Printf("Done\n")
@@ -341,7 +851,12 @@ func coreConvertCmd(cmd *cobra.Command, args []string) {
 		return
 	}
 
-	coreConvert, err := core.ConvertFilenames(args[0], args[1])
+	arch, err := core.CoreArchFromString(coreArch)
+	if err != nil {
+		nmUsage(cmd, err)
+	}
+
+	coreConvert, err := core.ConvertFilenamesArch(args[0], args[1], arch)
 	if err != nil {
 		nmUsage(nil, err)
 		return
@@ -350,6 +865,141 @@ func coreConvertCmd(cmd *cobra.Command, args []string) {
 	fmt.Printf("Corefile created for\n   %x\n", coreConvert.ImageHash)
 }
 
+// Compares the core's image hash against that of the image built alongside
+// the ELF file.
+func coreAnalyzeHashStr(cd *core.CoreDump, elfFilename string) string {
+	if len(cd.ImageHash) == 0 {
+		return "not present in core"
+	}
+
+	imgFilename := coreImageFile
+	if imgFilename == "" {
+		imgFilename = strings.TrimSuffix(elfFilename, ".elf") + ".img"
+	}
+
+	img, err := image.ReadImage(imgFilename)
+	if err != nil {
+		return fmt.Sprintf("%x (not checked: %s)", cd.ImageHash, err.Error())
+	}
+	hash, err := img.Hash()
+	if err != nil {
+		return fmt.Sprintf("%x (not checked: %s)", cd.ImageHash, err.Error())
+	}
+
+	if !bytes.Equal(hash, cd.ImageHash) {
+		return fmt.Sprintf("%x (MISMATCH: %s has %x)", cd.ImageHash,
+			imgFilename, hash)
+	}
+
+	return fmt.Sprintf("%x (matches %s)", cd.ImageHash, imgFilename)
+}
+
+// Determines the architecture of the core being analyzed.  Without --arch,
+// the ELF file's machine type decides.
+func coreAnalyzeArchFor(cs *core.CoreSymbolizer) (*core.CoreArch, error) {
+	if coreAnalyzeArch == "" {
+		return core.CoreArchFromMachine(cs.Machine)
+	}
+
+	arch, err := core.CoreArchFromString(coreAnalyzeArch)
+	if err != nil {
+		return nil, err
+	}
+	if arch.Machine != cs.Machine {
+		fmt.Fprintf(os.Stderr, "Warning: ELF file is for %s, not %s\n",
+			cs.Machine.String(), arch.Name)
+	}
+
+	return arch, nil
+}
+
+// Prints the Cortex-M fault status registers, if the core contains them.
+func coreAnalyzeCortexMFault(cd *core.CoreDump) {
+	if cfsr, ok := cd.ReadWord(core.SCB_CFSR); ok {
+		fmt.Printf("    cfsr: 0x%08x %s\n", cfsr, core.CfsrString(cfsr))
+		if hfsr, ok := cd.ReadWord(core.SCB_HFSR); ok {
+			fmt.Printf("    hfsr: 0x%08x %s\n", hfsr, core.HfsrString(hfsr))
+		}
+		if mmfar, ok := cd.ReadWord(core.SCB_MMFAR); ok {
+			fmt.Printf("    mmfar: 0x%08x\n", mmfar)
+		}
+		if bfar, ok := cd.ReadWord(core.SCB_BFAR); ok {
+			fmt.Printf("    bfar: 0x%08x\n", bfar)
+		}
+	} else {
+		fmt.Printf("    fault status: Unavailable (SCB not in core)\n")
+	}
+}
+
+func coreAnalyzeCmd(cmd *cobra.Command, args []string) {
+	if len(args) < 2 {
+		nmUsage(cmd, nil)
+	}
+
+	cd, err := core.ReadCoreDump(args[0])
+	if err != nil {
+		nmUsage(nil, err)
+	}
+
+	cs, err := core.NewCoreSymbolizer(args[1])
+	if err != nil {
+		nmUsage(nil, err)
+	}
+
+	arch, err := coreAnalyzeArchFor(cs)
+	if err != nil {
+		nmUsage(nil, err)
+	}
+	cortexM := arch.Name == core.CORE_ARCH_CORTEX_M
+
+	fmt.Printf("Core: %s\n", args[0])
+	fmt.Printf("    architecture: %s\n", arch.Name)
+	fmt.Printf("    image hash: %s\n", coreAnalyzeHashStr(cd, args[1]))
+
+	fmt.Printf("Fault:\n")
+	if cortexM {
+		if xpsr, ok := cd.Reg(core.COREDUMP_REG_XPSR); ok {
+			fmt.Printf("    exception: %s\n", core.ExceptionString(xpsr))
+		}
+	}
+	if pc, ok := cd.Reg(arch.PcReg); ok {
+		fmt.Printf("    pc: %s\n", cs.Lookup(pc).String())
+	}
+	if lr, ok := cd.Reg(arch.LrReg); ok {
+		fmt.Printf("    %s: %s\n", arch.RegNames[arch.LrReg],
+			cs.LookupRet(lr).String())
+	}
+	if sp, ok := cd.Reg(arch.SpReg); ok {
+		fmt.Printf("    sp: 0x%08x\n", sp)
+	}
+	if cortexM {
+		if xpsr, ok := cd.Reg(core.COREDUMP_REG_XPSR); ok {
+			fmt.Printf("    xpsr: 0x%08x\n", xpsr)
+		}
+		coreAnalyzeCortexMFault(cd)
+	} else {
+		fmt.Printf("    fault status: Unsupported for %s\n", arch.Name)
+	}
+
+	fmt.Printf("Registers:\n")
+	for i, reg := range cd.Regs {
+		if i >= len(arch.RegNames) {
+			break
+		}
+		fmt.Printf("    %s: 0x%08x\n", arch.RegNames[i], reg)
+	}
+
+	if !cortexM {
+		fmt.Printf("Backtrace: Unsupported for %s\n", arch.Name)
+		return
+	}
+
+	fmt.Printf("Backtrace (stack scan; may contain stale frames):\n")
+	for i, loc := range cs.Backtrace(cd, coreMaxFrames) {
+		fmt.Printf("    #%d %s\n", i, loc.String())
+	}
+}
+
 func imageCmd() *cobra.Command {
 	imageCmd := &cobra.Command{
 		Use:   "image",
@@ -367,17 +1017,23 @@ func imageCmd() *cobra.Command {
 	imageCmd.AddCommand(listCmd)
 
 	testCmd := &cobra.Command{
-		Use:   "test <hex-image-hash>",
+		Use:   "test <image-file | hex-image-hash>",
 		Short: "Test an image on next reboot",
-		Run:   imageStateTestCmd,
+		Long: "Mark an image for testing on the next reboot.  The image is " +
+			"identified by its hash, either specified directly or read from " +
+			"an image file.",
+		Example: "  newtmgr -c olimex image test " +
+			"bin/slinky_zero/apps/slinky.img\n",
+		Run: imageStateTestCmd,
 	}
 	imageCmd.AddCommand(testCmd)
 
 	confirmCmd := &cobra.Command{
-		Use:   "confirm [hex-image-hash] -c <conn_profile>",
+		Use:   "confirm [image-file | hex-image-hash] -c <conn_profile>",
 		Short: "Permanently run image",
-		Long: "If a hash is specified, permanently switch to the " +
-			"corresponding image.  If no hash is specified, the current " +
+		Long: "If an image is specified, permanently switch to it.  The " +
+			"image is identified by its hash, either specified directly or " +
+			"read from an image file.  If no image is specified, the current " +
 			"image setup is made permanent.",
 		Run: imageStateConfirmCmd,
 	}
@@ -395,8 +1051,42 @@ func imageCmd() *cobra.Command {
 	uploadCmd.PersistentFlags().BoolVarP(&noerase,
 		"noerase", "e", false,
 		"Don't send specific image erase command to start with")
+	uploadCmd.PersistentFlags().IntVarP(&uploadWinSz,
+		"window", "w", 1,
+		"Maximum number of upload requests to have outstanding at once")
 	imageCmd.AddCommand(uploadCmd)
 
+	upgradeVerifyHelpText := "Upload an image, mark it for test, and reset the device.  " +
+		"Once the device comes back, the image is confirmed if it is " +
+		"running; otherwise a rollback is reported."
+
+	upgradeVerifyCmd := &cobra.Command{
+		Use:   "upgrade-and-verify <image-file> -c <conn_profile>",
+		Short: "Upload, test, and confirm an image on a device",
+		Long:  upgradeVerifyHelpText,
+		Example: "  newtmgr -c olimex image upgrade-and-verify " +
+			"bin/slinky_zero/apps/slinky.img\n",
+		Run: imageUpgradeVerifyCmd,
+	}
+	upgradeVerifyCmd.PersistentFlags().BoolVarP(&noerase,
+		"noerase", "e", false,
+		"Don't send specific image erase command to start with")
+	upgradeVerifyCmd.PersistentFlags().IntVarP(&uploadWinSz,
+		"window", "w", 1,
+		"Maximum number of upload requests to have outstanding at once")
+	upgradeVerifyCmd.PersistentFlags().IntVar(&bootTimeout,
+		"boot-timeout", 60,
+		"Seconds to wait for the device to come back after reset")
+	imageCmd.AddCommand(upgradeVerifyCmd)
+
+	infoCmd := &cobra.Command{
+		Use:     "info <image-file>",
+		Short:   "Show the contents of an image file",
+		Example: "  newtmgr image info bin/slinky_zero/apps/slinky.img\n",
+		Run:     imageInfoCmd,
+	}
+	imageCmd.AddCommand(infoCmd)
+
 	coreListCmd := &cobra.Command{
 		Use:     "corelist -c <conn_profile>",
 		Short:   "List core(s) on a device",
@@ -408,14 +1098,24 @@ func imageCmd() *cobra.Command {
 	coreEx := "  newtmgr -c olimex image coredownload -e core\n"
 	coreEx += "  newtmgr -c olimex image coredownload --offset 10 -n 10 core\n"
 
+	coreDownloadHelpText := "Download a core from a device.  The core is " +
+		"written to <core-file>.tmp as it arrives.  If that file already " +
+		"exists, it is assumed to hold the start of the requested range " +
+		"from an interrupted download, and the download resumes where it " +
+		"left off."
+
 	coreDownloadCmd := &cobra.Command{
 		Use:     "coredownload <core-file> -c <conn_profile>",
 		Short:   "Download core from a device",
+		Long:    coreDownloadHelpText,
 		Example: coreEx,
 		Run:     coreDownloadCmd,
 	}
 	coreDownloadCmd.Flags().BoolVarP(&coreElfify, "elfify", "e", false,
 		"Create an elf file")
+	coreDownloadCmd.Flags().StringVar(&coreArch, "arch", core.CORE_ARCH_DFLT,
+		"Register layout of the elf file; one of: "+
+			strings.Join(core.CoreArchNames(), ", "))
 	coreDownloadCmd.Flags().Uint32Var(&coreOffset, "offset", 0, "Start offset")
 	coreDownloadCmd.Flags().Uint32VarP(&coreNumBytes, "bytes", "n", 0,
 		"Number of bytes of the core to download")
@@ -451,7 +1151,36 @@ func imageCmd() *cobra.Command {
 		Short: "Convert core to ELF",
 		Run:   coreConvertCmd,
 	}
+	coreConvertCmd.Flags().StringVar(&coreArch, "arch", core.CORE_ARCH_DFLT,
+		"Register layout of the elf file; one of: "+
+			strings.Join(core.CoreArchNames(), ", "))
 	imageCmd.AddCommand(coreConvertCmd)
 
+	coreAnalyzeHelpText := "Print the fault registers and a backtrace from " +
+		"a core downloaded with coredownload.  Addresses are resolved " +
+		"using the application's ELF file.  The core's image hash is " +
+		"checked against the image file built alongside the ELF file.\n\n" +
+		"The register layout is taken from the ELF file's machine type " +
+		"unless --arch is given.  The fault status decode and the " +
+		"backtrace are only available for cortex_m."
+
+	coreAnalyzeCmd := &cobra.Command{
+		Use:   "coreanalyze <core-filename> <elf-filename>",
+		Short: "Show a symbolized crash report from a core",
+		Long:  coreAnalyzeHelpText,
+		Example: "  newtmgr image coreanalyze core " +
+			"bin/targets/slinky/app/apps/slinky/slinky.elf\n",
+		Run: coreAnalyzeCmd,
+	}
+	coreAnalyzeCmd.Flags().StringVarP(&coreImageFile, "image", "i", "",
+		"Image file to check the core's hash against (default: the ELF "+
+			"filename with a .img extension)")
+	coreAnalyzeCmd.Flags().IntVar(&coreMaxFrames, "frames", 16,
+		"Maximum number of backtrace frames to show")
+	coreAnalyzeCmd.Flags().StringVar(&coreAnalyzeArch, "arch", "",
+		"Register layout of the core (default: from the ELF file); one of: "+
+			strings.Join(core.CoreArchNames(), ", "))
+	imageCmd.AddCommand(coreAnalyzeCmd)
+
 	return imageCmd
 }
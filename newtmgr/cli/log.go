@@ -21,18 +21,78 @@ package cli
 
 import (
 	"fmt"
+	"os"
 	"sort"
 	"strconv"
+	"time"
 
 	"github.com/spf13/cobra"
 
 	"mynewt.apache.org/newt/util"
 	"mynewt.apache.org/newtmgr/newtmgr/nmutil"
 	"mynewt.apache.org/newtmgr/nmxact/nmp"
+	"mynewt.apache.org/newtmgr/nmxact/sesn"
 	"mynewt.apache.org/newtmgr/nmxact/xact"
 )
 
+var logFollow bool
+var logFollowInterval float64
+var logModuleStr string
+var logMinLevelStr string
+
+// Module and minimum level to display; -1 means no filtering.
+var logModule int = -1
+var logMinLevel int = -1
+
+func logEntryPasses(entry nmp.LogEntry) bool {
+	if logModule >= 0 && int(entry.Module) != logModule {
+		return false
+	}
+	if logMinLevel >= 0 && int(entry.Level) < logMinLevel {
+		return false
+	}
+
+	return true
+}
+
+func logShowPrintHdr() {
+	fmt.Printf("%10s %22s | %11s %11s %s\n",
+		"[index]", "[timestamp]", "[module]", "[level]", "[message]")
+}
+
+func logShowPrintEntry(entry nmp.LogEntry) {
+	fmt.Printf("%10d %20dus | %10s: %10s: %s\n",
+		entry.Index,
+		entry.Timestamp,
+		nmp.LogModuleToString(int(entry.Module)),
+		nmp.LogLevelToString(int(entry.Level)),
+		entry.Msg)
+}
+
+func logShowParseFilters(cmd *cobra.Command) {
+	logModule = -1
+	logMinLevel = -1
+
+	if logModuleStr != "" {
+		var err error
+		logModule, err = nmp.LogModuleFromString(logModuleStr)
+		if err != nil {
+			nmUsage(cmd, util.ChildNewtError(err))
+		}
+	}
+
+	if logMinLevelStr != "" {
+		var err error
+		logMinLevel, err = nmp.LogLevelFromString(logMinLevelStr)
+		if err != nil {
+			nmUsage(cmd, util.ChildNewtError(err))
+		}
+	}
+}
+
 func logShowCmd(cmd *cobra.Command, args []string) {
+	logShowParseFilters(cmd)
+
 	c := xact.NewLogShowCmd()
 	c.SetTxOptions(nmutil.TxOptions())
 
@@ -67,12 +127,36 @@ func logShowCmd(cmd *cobra.Command, args []string) {
 		nmUsage(nil, err)
 	}
 
-	res, err := c.Run(s)
+	if logFollow {
+		logFollowRun(s, c)
+		return
+	}
+
+	res, err := runXactCmd(c, s)
 	if err != nil {
 		nmUsage(nil, util.ChildNewtError(err))
 	}
 
 	sres := res.(*xact.LogShowResult)
+	if outputStructured() {
+		for i, _ := range sres.Rsp.Logs {
+			log := &sres.Rsp.Logs[i]
+			entries := []nmp.LogEntry{}
+			for _, entry := range log.Entries {
+				if logEntryPasses(entry) {
+					entries = append(entries, entry)
+				}
+			}
+			log.Entries = entries
+		}
+		outputRsp(sres.Rsp, sres.Rsp.Rc)
+		return
+	}
+
+	if sres.Status() != 0 {
+		rspError(sres.Status())
+	}
+
 	fmt.Printf("Status: %d\n", sres.Status())
 	fmt.Printf("Next index: %d\n", sres.Rsp.NextIndex)
 	if len(sres.Rsp.Logs) == 0 {
@@ -89,15 +173,86 @@ func logShowCmd(cmd *cobra.Command, args []string) {
 			return
 		}
 
-		fmt.Printf("%10s %22s | %11s %11s %s\n",
-			"[index]", "[timestamp]", "[module]", "[level]", "[message]")
+		logShowPrintHdr()
 		for _, entry := range log.Entries {
-			fmt.Printf("%10d %20dus | %10s: %10s: %s\n",
-				entry.Index,
-				entry.Timestamp,
-				nmp.LogModuleToString(int(entry.Module)),
-				nmp.LogLevelToString(int(entry.Level)),
-				entry.Msg)
+			if logEntryPasses(entry) {
+				logShowPrintEntry(entry)
+			}
+		}
+	}
+}
+
+// Repeatedly polls the device for log entries, printing only the ones that
+// haven't been seen yet.  Runs until the process is killed.
+func logFollowRun(s sesn.Sesn, c *xact.LogShowCmd) {
+	interval := time.Duration(logFollowInterval * float64(time.Second))
+	next := c.Index
+
+	if !outputStructured() {
+		logShowPrintHdr()
+	}
+	for {
+		res, err := runXactCmd(c, s)
+		if err != nil {
+			// Keep following across transient failures; reopen the session
+			// if the device disconnected.
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+			if !s.IsOpen() {
+				s.Open()
+			}
+			time.Sleep(interval)
+			continue
+		}
+
+		sres := res.(*xact.LogShowResult)
+		if sres.Status() != 0 {
+			if outputStructured() {
+				outputRsp(sres.Rsp, sres.Status())
+			}
+			rspError(sres.Status())
+		}
+
+		if sres.Rsp.NextIndex < next {
+			// The log was cleared or the device rebooted.  Start over from
+			// the beginning.
+			fmt.Fprintf(progressWriter(), "(log index went backwards: "+
+				"%d -> %d; restarting from 0)\n", next, sres.Rsp.NextIndex)
+			next = 0
+			c.Index = 0
+			c.Timestamp = 0
+			continue
+		}
+
+		got := false
+		for _, log := range sres.Rsp.Logs {
+			for _, entry := range log.Entries {
+				if entry.Index < next && c.Timestamp != -1 {
+					continue
+				}
+				got = true
+				if !logEntryPasses(entry) {
+					continue
+				}
+
+				if outputStructured() {
+					outputPrint(map[string]interface{}{
+						"log_name": log.Name,
+						"entry":    entry,
+					})
+				} else {
+					logShowPrintEntry(entry)
+				}
+			}
+		}
+
+		next = sres.Rsp.NextIndex
+		c.Index = next
+		c.Timestamp = 0
+
+		// The response may have been truncated to fit the MTU; if anything
+		// new arrived, ask again right away.
+		if !got {
+			time.Sleep(interval)
 		}
 	}
 }
@@ -111,17 +266,21 @@ func logListCmd(cmd *cobra.Command, args []string) {
 	c := xact.NewLogListCmd()
 	c.SetTxOptions(nmutil.TxOptions())
 
-	res, err := c.Run(s)
+	res, err := runXactCmd(c, s)
 	if err != nil {
 		nmUsage(nil, util.ChildNewtError(err))
 	}
 
 	sres := res.(*xact.LogListResult)
-	if sres.Rsp.Rc != 0 {
-		fmt.Printf("error: %d\n", sres.Rsp.Rc)
+	if outputStructured() {
+		outputRsp(sres.Rsp, sres.Rsp.Rc)
 		return
 	}
 
+	if sres.Rsp.Rc != 0 {
+		rspError(sres.Rsp.Rc)
+	}
+
 	sort.Strings(sres.Rsp.List)
 
 	fmt.Printf("available logs:\n")
@@ -139,17 +298,21 @@ func logModuleListCmd(cmd *cobra.Command, args []string) {
 	c := xact.NewLogModuleListCmd()
 	c.SetTxOptions(nmutil.TxOptions())
 
-	res, err := c.Run(s)
+	res, err := runXactCmd(c, s)
 	if err != nil {
 		nmUsage(nil, util.ChildNewtError(err))
 	}
 
 	sres := res.(*xact.LogModuleListResult)
-	if sres.Rsp.Rc != 0 {
-		fmt.Printf("error: %d\n", sres.Rsp.Rc)
+	if outputStructured() {
+		outputRsp(sres.Rsp, sres.Rsp.Rc)
 		return
 	}
 
+	if sres.Rsp.Rc != 0 {
+		rspError(sres.Rsp.Rc)
+	}
+
 	names := make([]string, 0, len(sres.Rsp.Map))
 	for k, _ := range sres.Rsp.Map {
 		names = append(names, k)
@@ -171,17 +334,21 @@ func logLevelListCmd(cmd *cobra.Command, args []string) {
 	c := xact.NewLogLevelListCmd()
 	c.SetTxOptions(nmutil.TxOptions())
 
-	res, err := c.Run(s)
+	res, err := runXactCmd(c, s)
 	if err != nil {
 		nmUsage(nil, util.ChildNewtError(err))
 	}
 
 	sres := res.(*xact.LogLevelListResult)
-	if sres.Rsp.Rc != 0 {
-		fmt.Printf("error: %d\n", sres.Rsp.Rc)
+	if outputStructured() {
+		outputRsp(sres.Rsp, sres.Rsp.Rc)
 		return
 	}
 
+	if sres.Rsp.Rc != 0 {
+		rspError(sres.Rsp.Rc)
+	}
+
 	vals := make([]int, 0, len(sres.Rsp.Map))
 	revmap := make(map[int]string, len(sres.Rsp.Map))
 	for name, val := range sres.Rsp.Map {
@@ -205,17 +372,21 @@ func logClearCmd(cmd *cobra.Command, args []string) {
 	c := xact.NewLogClearCmd()
 	c.SetTxOptions(nmutil.TxOptions())
 
-	res, err := c.Run(s)
+	res, err := runXactCmd(c, s)
 	if err != nil {
 		nmUsage(nil, util.ChildNewtError(err))
 	}
 
 	sres := res.(*xact.LogClearResult)
-	if sres.Rsp.Rc != 0 {
-		fmt.Printf("error: %d\n", sres.Rsp.Rc)
+	if outputStructured() {
+		outputRsp(sres.Rsp, sres.Rsp.Rc)
 		return
 	}
 
+	if sres.Rsp.Rc != 0 {
+		rspError(sres.Rsp.Rc)
+	}
+
 	fmt.Printf("done\n")
 }
 
@@ -232,13 +403,15 @@ func logCmd() *cobra.Command {
 	logShowHelpText += "- log-name specifies the log to display.  If log-name is not specified, all\nlogs are displayed.\n\n"
 	logShowHelpText += "- min-index specifies to only display the log entries with an index value equal to or higher than min-index.  "
 	logShowHelpText += "If \"last\"  is specified for min-index, the last\nlog entry is displayed.\n\n"
-	logShowHelpText += "- min-timestamp specifies to only display the log entries with a timestamp\nequal to or later than min-timestamp. Log entries with a timestamp equal to\nmin-timestamp are only displayed if the entry index is equal to or higher than min-index.\n"
+	logShowHelpText += "- min-timestamp specifies to only display the log entries with a timestamp\nequal to or later than min-timestamp. Log entries with a timestamp equal to\nmin-timestamp are only displayed if the entry index is equal to or higher than min-index.\n\n"
+	logShowHelpText += "With --follow, the device is polled for new entries until newtmgr is\ninterrupted.  If the log is cleared or the device reboots, entries are\ndisplayed from the start of the log again.\n"
 
 	logShowEx := "newtmgr log show -c myserial\n"
 	logShowEx += "newtmgr log show reboot_log -c myserial\n"
 	logShowEx += "newtmgr log show reboot_log last -c myserial\n"
 	logShowEx += "newtmgr log show reboot_log 5 -c myserial\n"
 	logShowEx += "newtmgr log show reboot_log 3 1122222 -c myserial\n"
+	logShowEx += "newtmgr log show --follow --level warn -c myserial\n"
 
 	showCmd := &cobra.Command{
 		Use:     "show [log-name [min-index [min-timestamp]]] -c <conn_profile>",
@@ -247,6 +420,14 @@ func logCmd() *cobra.Command {
 		Short:   "Show the logs on a device",
 		Run:     logShowCmd,
 	}
+	showCmd.Flags().BoolVarP(&logFollow, "follow", "f", false,
+		"Keep polling the device for new log entries")
+	showCmd.Flags().Float64Var(&logFollowInterval, "interval", 1.0,
+		"Seconds between polls when following")
+	showCmd.Flags().StringVar(&logModuleStr, "module", "",
+		"Only show entries from this module (name or number)")
+	showCmd.Flags().StringVar(&logMinLevelStr, "level", "",
+		"Only show entries at or above this level (name or number)")
 	logCmd.AddCommand(showCmd)
 
 	clearCmd := &cobra.Command{
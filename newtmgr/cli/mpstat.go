@@ -27,9 +27,30 @@ import (
 
 	"mynewt.apache.org/newt/util"
 	"mynewt.apache.org/newtmgr/newtmgr/nmutil"
+	"mynewt.apache.org/newtmgr/nmxact/nmp"
+	"mynewt.apache.org/newtmgr/nmxact/sesn"
 	"mynewt.apache.org/newtmgr/nmxact/xact"
 )
 
+// Reads mempool statistics, treating an error status from the device as a
+// failure.
+func mempoolStatRead(s sesn.Sesn) (*nmp.MempoolStatRsp, error) {
+	c := xact.NewMempoolStatCmd()
+	c.SetTxOptions(nmutil.TxOptions())
+
+	res, err := runXactCmd(c, s)
+	if err != nil {
+		return nil, err
+	}
+
+	rsp := res.(*xact.MempoolStatResult).Rsp
+	if rsp.Rc != 0 {
+		return nil, util.FmtNewtError("mpstat failed: rc=%d", rsp.Rc)
+	}
+
+	return rsp, nil
+}
+
 func mempoolStatRunCmd(cmd *cobra.Command, args []string) {
 	s, err := GetSesn()
 	if err != nil {
@@ -39,14 +60,19 @@ func mempoolStatRunCmd(cmd *cobra.Command, args []string) {
 	c := xact.NewMempoolStatCmd()
 	c.SetTxOptions(nmutil.TxOptions())
 
-	res, err := c.Run(s)
+	res, err := runXactCmd(c, s)
 	if err != nil {
 		nmUsage(nil, util.ChildNewtError(err))
 	}
 
 	sres := res.(*xact.MempoolStatResult)
+	if outputStructured() {
+		outputRsp(sres.Rsp, sres.Rsp.Rc)
+		return
+	}
+
 	if sres.Rsp.Rc != 0 {
-		fmt.Printf("Error: %d\n", sres.Rsp.Rc)
+		rspError(sres.Rsp.Rc)
 		return
 	}
 
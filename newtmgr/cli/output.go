@@ -0,0 +1,219 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cheggaaa/pb"
+
+	"mynewt.apache.org/newt/util"
+	"mynewt.apache.org/newtmgr/newtmgr/nmutil"
+	"mynewt.apache.org/newtmgr/nmxact/nmxutil"
+)
+
+const (
+	OUTPUT_FORMAT_TEXT = "text"
+	OUTPUT_FORMAT_JSON = "json"
+	OUTPUT_FORMAT_YAML = "yaml"
+)
+
+func checkOutputFormat() error {
+	switch nmutil.OutputFormat {
+	case OUTPUT_FORMAT_TEXT, OUTPUT_FORMAT_JSON, OUTPUT_FORMAT_YAML:
+		return nil
+	default:
+		return util.FmtNewtError("invalid output format: \"%s\"; must be "+
+			"one of: %s, %s, %s", nmutil.OutputFormat, OUTPUT_FORMAT_TEXT,
+			OUTPUT_FORMAT_JSON, OUTPUT_FORMAT_YAML)
+	}
+}
+
+// Indicates whether machine-readable output was requested.
+func outputStructured() bool {
+	return nmutil.OutputFormat != OUTPUT_FORMAT_TEXT
+}
+
+// Where to write progress messages.  When machine-readable output is
+// requested, stdout is reserved for the result.
+func progressWriter() io.Writer {
+	if outputStructured() {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+func newProgressBar(total int) *pb.ProgressBar {
+	bar := pb.New(total)
+	if outputStructured() {
+		bar.Output = os.Stderr
+	}
+	bar.SetUnits(pb.U_BYTES)
+	return bar.Start()
+}
+
+// Converts a decoded CBOR value into one that encoding/json can handle: map
+// keys become strings and byte strings become hex strings.
+func outputNormalize(itf interface{}) interface{} {
+	switch v := itf.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[fmt.Sprintf("%v", k)] = outputNormalize(e)
+		}
+		return m
+
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[k] = outputNormalize(e)
+		}
+		return m
+
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, e := range v {
+			l[i] = outputNormalize(e)
+		}
+		return l
+
+	case []byte:
+		return hex.EncodeToString(v)
+
+	default:
+		return v
+	}
+}
+
+func yamlScalar(itf interface{}) string {
+	switch v := itf.(type) {
+	case nil:
+		return "null"
+	case string:
+		// A JSON string is also a valid double-quoted YAML scalar.
+		return strconv.Quote(v)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+func yamlWrite(buf *bytes.Buffer, itf interface{}, indent int) {
+	pad := strings.Repeat(" ", indent)
+
+	switch v := itf.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k, _ := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			buf.WriteString(pad + strconv.Quote(k) + ":")
+			yamlWriteChild(buf, v[k], indent)
+		}
+
+	case []interface{}:
+		for _, e := range v {
+			buf.WriteString(pad + "-")
+			yamlWriteChild(buf, e, indent)
+		}
+
+	default:
+		buf.WriteString(pad + yamlScalar(v) + "\n")
+	}
+}
+
+func yamlWriteChild(buf *bytes.Buffer, itf interface{}, indent int) {
+	switch v := itf.(type) {
+	case map[string]interface{}:
+		if len(v) == 0 {
+			buf.WriteString(" {}\n")
+			return
+		}
+	case []interface{}:
+		if len(v) == 0 {
+			buf.WriteString(" []\n")
+			return
+		}
+	default:
+		buf.WriteString(" " + yamlScalar(v) + "\n")
+		return
+	}
+
+	buf.WriteString("\n")
+	yamlWrite(buf, itf, indent+2)
+}
+
+// Prints a value in the requested machine-readable format.  Structs are
+// converted using their codec tags, so field names match the ones in the
+// newtmgr protocol.
+func outputPrint(itf interface{}) {
+	cbor, err := nmxutil.EncodeCbor(itf)
+	if err != nil {
+		nmUsage(nil, util.ChildNewtError(err))
+	}
+	dec, err := nmxutil.DecodeCbor(cbor)
+	if err != nil {
+		nmUsage(nil, util.ChildNewtError(err))
+	}
+	outputPrintValue(outputNormalize(dec))
+}
+
+// Prints a value that only contains JSON-compatible types.
+func outputPrintValue(val interface{}) {
+	switch nmutil.OutputFormat {
+	case OUTPUT_FORMAT_JSON:
+		b, err := json.MarshalIndent(val, "", "    ")
+		if err != nil {
+			nmUsage(nil, util.ChildNewtError(err))
+		}
+		fmt.Printf("%s\n", b)
+
+	case OUTPUT_FORMAT_YAML:
+		buf := &bytes.Buffer{}
+		buf.WriteString("---\n")
+		yamlWrite(buf, val, 0)
+		fmt.Print(buf.String())
+	}
+}
+
+// Prints a response in the requested machine-readable format and exits with
+// a nonzero status if the device reported an error.
+func outputRsp(itf interface{}, rc int) {
+	outputPrint(itf)
+	if rc != 0 {
+		nmCmdExit(1)
+	}
+}
+
+// Reports an error status from the device and exits.
+func rspError(rc int) {
+	fmt.Printf("Error: %d\n", rc)
+	nmCmdExit(1)
+}
@@ -91,6 +91,27 @@ func resResponseStr(path string, cbor []byte) string {
 	return s
 }
 
+// Builds the machine-readable form of a CoAP response.  The payload is
+// included as decoded CBOR if possible, or as raw bytes otherwise.
+func resResponseMap(path string, status int,
+	cbor []byte) map[string]interface{} {
+
+	m := map[string]interface{}{
+		"path":   path,
+		"status": status,
+	}
+
+	if len(cbor) > 0 {
+		if val, err := nmxutil.DecodeCbor(cbor); err == nil {
+			m["value"] = val
+		} else {
+			m["raw"] = cbor
+		}
+	}
+
+	return m
+}
+
 func resGetCmd(cmd *cobra.Command, args []string) {
 	if len(args) < 2 {
 		nmUsage(cmd, nil)
@@ -113,16 +134,22 @@ func resGetCmd(cmd *cobra.Command, args []string) {
 	c.Path = path
 	c.Typ = rt
 
-	res, err := c.Run(s)
+	res, err := runXactCmd(c, s)
 	if err != nil {
 		nmUsage(nil, util.ChildNewtError(err))
 	}
 
 	sres := res.(*xact.GetResResult)
+	if outputStructured() {
+		outputRsp(resResponseMap(c.Path, sres.Status(), sres.Value),
+			sres.Status())
+		return
+	}
+
 	if sres.Status() != 0 {
 		fmt.Printf("Error: %s (%d)\n",
 			coap.COAPCode(sres.Status()), sres.Status())
-		return
+		nmCmdExit(1)
 	}
 
 	if sres.Value != nil {
@@ -164,16 +191,22 @@ func resPutCmd(cmd *cobra.Command, args []string) {
 	c.Typ = rt
 	c.Value = b
 
-	res, err := c.Run(s)
+	res, err := runXactCmd(c, s)
 	if err != nil {
 		nmUsage(nil, util.ChildNewtError(err))
 	}
 
 	sres := res.(*xact.PutResResult)
+	if outputStructured() {
+		outputRsp(resResponseMap(c.Path, sres.Status(), sres.Value),
+			sres.Status())
+		return
+	}
+
 	if sres.Status() != 0 {
 		fmt.Printf("Error: %s (%d)\n",
 			coap.COAPCode(sres.Status()), sres.Status())
-		return
+		nmCmdExit(1)
 	}
 
 	if sres.Value != nil {
@@ -215,16 +248,22 @@ func resPostCmd(cmd *cobra.Command, args []string) {
 	c.Typ = rt
 	c.Value = b
 
-	res, err := c.Run(s)
+	res, err := runXactCmd(c, s)
 	if err != nil {
 		nmUsage(nil, util.ChildNewtError(err))
 	}
 
 	sres := res.(*xact.PostResResult)
+	if outputStructured() {
+		outputRsp(resResponseMap(c.Path, sres.Status(), sres.Value),
+			sres.Status())
+		return
+	}
+
 	if sres.Status() != 0 {
 		fmt.Printf("Error: %s (%d)\n",
 			coap.COAPCode(sres.Status()), sres.Status())
-		return
+		nmCmdExit(1)
 	}
 
 	if sres.Value != nil {
@@ -254,16 +293,22 @@ func resDeleteCmd(cmd *cobra.Command, args []string) {
 	c.Path = path
 	c.Typ = rt
 
-	res, err := c.Run(s)
+	res, err := runXactCmd(c, s)
 	if err != nil {
 		nmUsage(nil, util.ChildNewtError(err))
 	}
 
 	sres := res.(*xact.DeleteResResult)
+	if outputStructured() {
+		outputRsp(resResponseMap(c.Path, sres.Status(), sres.Value),
+			sres.Status())
+		return
+	}
+
 	if sres.Status() != 0 {
 		fmt.Printf("Error: %s (%d)\n",
 			coap.COAPCode(sres.Status()), sres.Status())
-		return
+		nmCmdExit(1)
 	}
 
 	if sres.Value != nil {
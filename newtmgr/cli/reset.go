@@ -38,10 +38,16 @@ func resetRunCmd(cmd *cobra.Command, args []string) {
 	c := xact.NewResetCmd()
 	c.SetTxOptions(nmutil.TxOptions())
 
-	if _, err := c.Run(s); err != nil {
+	res, err := runXactCmd(c, s)
+	if err != nil {
 		nmUsage(nil, util.ChildNewtError(err))
 	}
 
+	if outputStructured() {
+		outputRsp(res.(*xact.ResetResult).Rsp, 0)
+		return
+	}
+
 	fmt.Printf("Done\n")
 }
 
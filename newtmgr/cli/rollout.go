@@ -0,0 +1,792 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"mynewt.apache.org/newt/util"
+	"mynewt.apache.org/newtmgr/newtmgr/config"
+	"mynewt.apache.org/newtmgr/newtmgr/nmutil"
+	"mynewt.apache.org/newtmgr/nmxact/nmp"
+	"mynewt.apache.org/newtmgr/nmxact/sesn"
+	"mynewt.apache.org/newtmgr/nmxact/xact"
+)
+
+// Number of bytes at the start of a core file used to tell cores apart.
+const ROLLOUT_CORE_ID_LEN = 256
+
+var rolloutCanary int
+var rolloutWaveSize int
+var rolloutMaxFailRate float64
+var rolloutEcho bool
+var rolloutCoreCheck bool
+var rolloutStats []string
+var rolloutBootTimeout int
+var rolloutRetryFailed bool
+
+// The result a device process reports to the campaign.
+type rolloutDevResult struct {
+	Ok    bool   `json:"ok"`
+	Stage string `json:"stage,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// Indicates whether the device holds a core file.
+func rolloutCorePresent(s sesn.Sesn) (bool, error) {
+	c := xact.NewCoreListCmd()
+	c.SetTxOptions(nmutil.TxOptions())
+
+	res, err := runXactCmd(c, s)
+	if err != nil {
+		return false, err
+	}
+
+	switch res.Status() {
+	case 0:
+		return true, nil
+	case nmp.NMP_ERR_ENOENT:
+		return false, nil
+	default:
+		return false, fmt.Errorf("core list failed: rc=%d", res.Status())
+	}
+}
+
+// Identifies the core file on the device, or returns "" if there is none.
+// Cores are compared by their first bytes, which hold the core's size, the
+// hash of the image that crashed and the registers at the time of the crash.
+func rolloutCoreId(s sesn.Sesn) (string, error) {
+	present, err := rolloutCorePresent(s)
+	if err != nil || !present {
+		return "", err
+	}
+
+	h := sha256.New()
+	c := xact.NewCoreLoadCmd()
+	c.SetTxOptions(nmutil.TxOptions())
+	c.NumBytes = ROLLOUT_CORE_ID_LEN
+	c.ProgressCb = func(c *xact.CoreLoadCmd, rsp *nmp.CoreLoadRsp) {
+		if rsp.Off == 0 {
+			fmt.Fprintf(h, "%d:", rsp.Len)
+		}
+		h.Write(rsp.Data)
+	}
+
+	res, err := runXactCmd(c, s)
+	if err != nil {
+		return "", err
+	}
+	if res.Status() != 0 {
+		return "", fmt.Errorf("core load failed: rc=%d", res.Status())
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func rolloutHealthCheck(s sesn.Sesn, checks config.RolloutChecks,
+	statChecks []*statCheck, coreBefore string) error {
+
+	if checks.Echo {
+		c := xact.NewEchoCmd()
+		c.SetTxOptions(nmutil.TxOptions())
+		c.Payload = "rollout"
+
+		res, err := runXactCmd(c, s)
+		if err != nil {
+			return fmt.Errorf("echo: %s", err.Error())
+		}
+		eres := res.(*xact.EchoResult)
+		if eres.Status() != 0 || eres.Rsp.Payload != c.Payload {
+			return fmt.Errorf("echo: bad response (rc=%d)", eres.Status())
+		}
+	}
+
+	for _, sc := range statChecks {
+		c := xact.NewStatReadCmd()
+		c.SetTxOptions(nmutil.TxOptions())
+		c.Name = sc.group
+
+		res, err := runXactCmd(c, s)
+		if err != nil {
+			return fmt.Errorf("stat %s: %s", sc.group, err.Error())
+		}
+		sres := res.(*xact.StatReadResult)
+		if sres.Status() != 0 {
+			return fmt.Errorf("stat %s: rc=%d", sc.group, sres.Status())
+		}
+
+		v, ok := statNum(sres.Rsp.Fields[sc.field])
+		if !ok {
+			return fmt.Errorf("stat %s.%s: not reported by device",
+				sc.group, sc.field)
+		}
+		if !sc.passes(v) {
+			return fmt.Errorf("stat %s.%s: %v is not %s %v",
+				sc.group, sc.field, v, sc.op, sc.val)
+		}
+	}
+
+	if checks.Core {
+		id, err := rolloutCoreId(s)
+		if err != nil {
+			return err
+		}
+		if id != "" && id != coreBefore {
+			return fmt.Errorf("device produced a core file")
+		}
+	}
+
+	return nil
+}
+
+// Upgrades the current device and reports the outcome as JSON.  This is run
+// in a separate process for each device in a campaign.
+func rolloutDeviceCmd(cmd *cobra.Command, args []string) {
+	result := rolloutDevResult{}
+	defer func() {
+		b, _ := json.Marshal(&result)
+		fmt.Printf("%s\n", b)
+		if !result.Ok {
+			nmCmdExit(1)
+		}
+	}()
+
+	fail := func(stage string, err error) {
+		result.Stage = stage
+		result.Error = err.Error()
+	}
+
+	if len(args) < 1 {
+		fail("image", util.NewNewtError("Need to specify image to upload"))
+		return
+	}
+
+	checks := config.RolloutChecks{
+		Echo:  rolloutEcho,
+		Core:  rolloutCoreCheck,
+		Stats: rolloutStats,
+	}
+	var statChecks []*statCheck
+	for _, str := range checks.Stats {
+		sc, err := parseStatCheck(str)
+		if err != nil {
+			fail("image", err)
+			return
+		}
+		statChecks = append(statChecks, sc)
+	}
+
+	img, hash, err := imageReadVerified(args[0])
+	if err != nil {
+		fail("image", err)
+		return
+	}
+
+	s, err := GetSesn()
+	if err != nil {
+		fail("connect", err)
+		return
+	}
+
+	// Remember which core, if any, the device already holds so that only a
+	// new one fails the device.
+	coreBefore := ""
+	if checks.Core {
+		coreBefore, err = rolloutCoreId(s)
+		if err != nil {
+			fail("core", err)
+			return
+		}
+		if coreBefore != "" {
+			fmt.Fprintf(os.Stderr, "Device already holds a core file; "+
+				"only a new one will fail the device\n")
+		}
+	}
+
+	stage := ""
+	c := xact.NewImageUpgradeVerifyCmd()
+	c.SetTxOptions(nmutil.TxOptions())
+	c.Data = img.Data
+	c.Hash = hash
+	c.BootTimeout = time.Duration(rolloutBootTimeout) * time.Second
+	c.StepCb = func(step xact.ImageUpgradeVerifyStep) {
+		stage = step.String()
+		fmt.Fprintf(os.Stderr, "%s...\n", stage)
+	}
+	c.HealthCb = func(s sesn.Sesn) error {
+		return rolloutHealthCheck(s, checks, statChecks, coreBefore)
+	}
+
+	res, err := runXactCmd(c, s)
+	if err != nil {
+		fail(stage, err)
+		return
+	}
+	vres := res.(*xact.ImageUpgradeVerifyResult)
+
+	switch {
+	case vres.Status() != 0:
+		fail(stage, fmt.Errorf("device returned rc=%d", vres.Status()))
+
+	case vres.RolledBack:
+		fail(stage, fmt.Errorf("device rolled back to its previous image"))
+
+	case vres.HealthErr != nil:
+		fail(stage, vres.HealthErr)
+
+		// The new image is unconfirmed; a reset reverts the device.
+		rc := xact.NewResetCmd()
+		rc.SetTxOptions(nmutil.TxOptions())
+		runXactCmd(rc, s)
+
+	default:
+		result.Ok = true
+	}
+}
+
+// Saves the campaign without losing a pause requested by another process.
+func rolloutSave(c *config.RolloutCampaign) error {
+	saved, err := config.GetRolloutCampaign(c.Name)
+	if err != nil {
+		return err
+	}
+
+	if saved != nil && saved.State == config.ROLLOUT_STATE_PAUSED &&
+		c.State == config.ROLLOUT_STATE_RUNNING {
+
+		c.State = config.ROLLOUT_STATE_PAUSED
+	}
+
+	return config.SaveRolloutCampaign(c)
+}
+
+// Selects the devices for the next wave.
+func rolloutNextWave(c *config.RolloutCampaign) []*config.RolloutDevice {
+	size := c.WaveSize
+	if c.Wave == 0 {
+		size = c.Canary
+	}
+
+	devs := []*config.RolloutDevice{}
+	for _, d := range c.Devices {
+		if len(devs) >= size {
+			break
+		}
+		if d.State == config.ROLLOUT_DEV_PENDING {
+			devs = append(devs, d)
+		}
+	}
+
+	return devs
+}
+
+// Moves the campaign on to the next wave, or halts it if any canary device
+// failed or too many devices have failed overall.
+func rolloutEndWave(c *config.RolloutCampaign, waveFailed int) {
+	_, ok, failed := c.Counts()
+	rate := float64(failed) / float64(ok+failed)
+
+	switch {
+	case c.Wave == 0 && waveFailed > 0:
+		c.State = config.ROLLOUT_STATE_HALTED
+		c.Reason = fmt.Sprintf("%d canary devices failed", waveFailed)
+
+	case rate > c.MaxFailRate:
+		c.State = config.ROLLOUT_STATE_HALTED
+		c.Reason = fmt.Sprintf("failure rate %.1f%% exceeds %.1f%%",
+			rate*100, c.MaxFailRate*100)
+
+	default:
+		c.Wave++
+	}
+}
+
+func rolloutDeviceArgs(c *config.RolloutCampaign) []string {
+	bootTimeout := c.Checks.BootTimeout
+	if bootTimeout == 0 {
+		bootTimeout = rolloutBootTimeout
+	}
+
+	args := []string{
+		"rollout", "device",
+		fmt.Sprintf("--echo=%t", c.Checks.Echo),
+		fmt.Sprintf("--core-check=%t", c.Checks.Core),
+		fmt.Sprintf("--boot-timeout=%d", bootTimeout),
+		fmt.Sprintf("--timeout=%g", nmutil.Timeout),
+		fmt.Sprintf("--tries=%d", nmutil.Tries),
+	}
+	for _, s := range c.Checks.Stats {
+		args = append(args, "--stat="+s)
+	}
+
+	return append(args, "--", c.ImagePath)
+}
+
+func rolloutRunDevice(exe string, sockPath string, args []string,
+	d *config.RolloutDevice) rolloutDevResult {
+
+	t, err := config.ConnTypeFromString(d.Type)
+	if err != nil {
+		return rolloutDevResult{Stage: "connect", Error: err.Error()}
+	}
+
+	fd := &fleetDevice{
+		label:      d.Label,
+		connType:   t,
+		connString: d.ConnString,
+		name:       d.Name,
+	}
+	fr := fleetRunDevice(exe, sockPath, args, fd, true)
+
+	var res rolloutDevResult
+	if err := json.Unmarshal(fr.output, &res); err != nil {
+		// The process failed before it could report; use its last error
+		// message.
+		res.Stage = "run"
+		res.Error = "no result from device process"
+		if fr.err != nil {
+			res.Error = fr.err.Error()
+		}
+		lines := strings.Split(strings.TrimSpace(string(fr.stderr)), "\n")
+		if last := lines[len(lines)-1]; last != "" {
+			res.Error += ": " + last
+		}
+	}
+
+	return res
+}
+
+func rolloutPrintStatus(c *config.RolloutCampaign) {
+	pending, ok, failed := c.Counts()
+	fmt.Printf("%s: %s", c.Name, c.State)
+	if c.Reason != "" {
+		fmt.Printf(" (%s)", c.Reason)
+	}
+	fmt.Printf("\n")
+	fmt.Printf("    image: %s (%s)\n", c.ImagePath, c.Hash)
+	fmt.Printf("    wave: %d\n", c.Wave)
+	fmt.Printf("    devices: %d ok, %d failed, %d pending\n", ok, failed,
+		pending)
+
+	for _, d := range c.Devices {
+		if d.State == config.ROLLOUT_DEV_FAILED {
+			fmt.Printf("    FAILED %s (wave %d, %s): %s\n", d.Label, d.Wave,
+				d.Stage, d.Error)
+		}
+	}
+}
+
+// Runs waves until the campaign finishes, halts, or is paused.
+func rolloutRun(c *config.RolloutCampaign) {
+	exe, err := os.Executable()
+	if err != nil {
+		nmUsage(nil, util.ChildNewtError(err))
+	}
+
+	prevOnExit := onExit
+	sockPath, stopDaemon := fleetStartDaemon()
+	defer SetOnExit(prevOnExit)
+
+	args := rolloutDeviceArgs(c)
+	var mtx sync.Mutex
+
+	for c.State == config.ROLLOUT_STATE_RUNNING {
+		devs := rolloutNextWave(c)
+		if len(devs) == 0 {
+			c.State = config.ROLLOUT_STATE_DONE
+			break
+		}
+
+		name := fmt.Sprintf("wave %d", c.Wave)
+		if c.Wave == 0 {
+			name = "canary wave"
+		}
+		fmt.Printf("Starting %s (%d devices)\n", name, len(devs))
+
+		devCh := make(chan *config.RolloutDevice)
+		var wg sync.WaitGroup
+		waveFailed := 0
+
+		for i := 0; i < nmutil.FleetJobs && i < len(devs); i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for d := range devCh {
+					res := rolloutRunDevice(exe, sockPath, args, d)
+
+					mtx.Lock()
+					d.Wave = c.Wave
+					d.Updated = time.Now()
+					if res.Ok {
+						d.State = config.ROLLOUT_DEV_OK
+						d.Stage = ""
+						d.Error = ""
+						fmt.Printf("    ok      %s\n", d.Label)
+					} else {
+						d.State = config.ROLLOUT_DEV_FAILED
+						d.Stage = res.Stage
+						d.Error = res.Error
+						waveFailed++
+						fmt.Printf("    FAILED  %s (%s): %s\n", d.Label,
+							res.Stage, res.Error)
+					}
+
+					// Record each result as it arrives so that an
+					// interrupted campaign doesn't redo finished devices.
+					if err := rolloutSave(c); err != nil {
+						fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+					}
+					mtx.Unlock()
+				}
+			}()
+		}
+
+		for _, d := range devs {
+			devCh <- d
+		}
+		close(devCh)
+		wg.Wait()
+
+		rolloutEndWave(c, waveFailed)
+
+		if err := rolloutSave(c); err != nil {
+			nmUsage(nil, err)
+		}
+	}
+
+	stopDaemon()
+
+	if err := rolloutSave(c); err != nil {
+		nmUsage(nil, err)
+	}
+
+	fmt.Printf("\n")
+	rolloutPrintStatus(c)
+
+	if c.State == config.ROLLOUT_STATE_HALTED {
+		nmCmdExit(1)
+	}
+}
+
+func rolloutGet(name string) *config.RolloutCampaign {
+	c, err := config.GetRolloutCampaign(name)
+	if err != nil {
+		nmUsage(nil, err)
+	}
+	if c == nil {
+		nmUsage(nil, util.FmtNewtError("no such campaign: \"%s\"", name))
+	}
+
+	return c
+}
+
+func rolloutStartCmd(cmd *cobra.Command, args []string) {
+	if len(args) < 3 {
+		nmUsage(cmd, util.NewNewtError(
+			"Need to specify campaign name, image, and device list"))
+	}
+
+	name := args[0]
+	if c, err := config.GetRolloutCampaign(name); err != nil {
+		nmUsage(nil, err)
+	} else if c != nil {
+		nmUsage(nil, util.FmtNewtError(
+			"campaign \"%s\" already exists; use \"rollout resume\"", name))
+	}
+
+	if rolloutCanary < 1 || rolloutWaveSize < 1 {
+		nmUsage(cmd, util.NewNewtError(
+			"canary and wave sizes must be at least 1"))
+	}
+	for _, s := range rolloutStats {
+		if _, err := parseStatCheck(s); err != nil {
+			nmUsage(cmd, err)
+		}
+	}
+
+	imagePath, err := filepath.Abs(args[1])
+	if err != nil {
+		nmUsage(nil, util.ChildNewtError(err))
+	}
+	_, hash, err := imageReadVerified(imagePath)
+	if err != nil {
+		nmUsage(nil, err)
+	}
+
+	fdevs, err := fleetReadDevices(args[2])
+	if err != nil {
+		nmUsage(nil, err)
+	}
+	if len(fdevs) == 0 {
+		nmUsage(nil, util.NewNewtError("device list contains no devices"))
+	}
+
+	c := &config.RolloutCampaign{
+		Name:        name,
+		ImagePath:   imagePath,
+		Hash:        hex.EncodeToString(hash),
+		Canary:      rolloutCanary,
+		WaveSize:    rolloutWaveSize,
+		MaxFailRate: rolloutMaxFailRate,
+		Checks: config.RolloutChecks{
+			Echo:        rolloutEcho,
+			Core:        rolloutCoreCheck,
+			Stats:       rolloutStats,
+			BootTimeout: rolloutBootTimeout,
+		},
+		State:   config.ROLLOUT_STATE_RUNNING,
+		Created: time.Now(),
+	}
+
+	for _, fd := range fdevs {
+		c.Devices = append(c.Devices, &config.RolloutDevice{
+			Label:      fd.label,
+			Type:       config.ConnTypeToString(fd.connType),
+			ConnString: fd.connString,
+			Name:       fd.name,
+			State:      config.ROLLOUT_DEV_PENDING,
+		})
+	}
+
+	if err := config.SaveRolloutCampaign(c); err != nil {
+		nmUsage(nil, err)
+	}
+
+	rolloutRun(c)
+}
+
+func rolloutResumeCmd(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		nmUsage(cmd, util.NewNewtError("Need to specify campaign name"))
+	}
+
+	c := rolloutGet(args[0])
+	if c.State == config.ROLLOUT_STATE_DONE && !rolloutRetryFailed {
+		fmt.Printf("Campaign %s is already done\n", c.Name)
+		return
+	}
+
+	// Refuse to install a different image than the one the campaign
+	// started with.
+	_, hash, err := imageReadVerified(c.ImagePath)
+	if err != nil {
+		nmUsage(nil, err)
+	}
+	if hex.EncodeToString(hash) != c.Hash {
+		nmUsage(nil, util.FmtNewtError(
+			"%s has changed since the campaign started", c.ImagePath))
+	}
+
+	if cmd.Flags().Changed("boot-timeout") {
+		c.Checks.BootTimeout = rolloutBootTimeout
+	}
+
+	if rolloutRetryFailed {
+		for _, d := range c.Devices {
+			if d.State == config.ROLLOUT_DEV_FAILED {
+				d.State = config.ROLLOUT_DEV_PENDING
+			}
+		}
+	}
+
+	c.State = config.ROLLOUT_STATE_RUNNING
+	c.Reason = ""
+	if err := config.SaveRolloutCampaign(c); err != nil {
+		nmUsage(nil, err)
+	}
+
+	rolloutRun(c)
+}
+
+func rolloutPauseCmd(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		nmUsage(cmd, util.NewNewtError("Need to specify campaign name"))
+	}
+
+	c := rolloutGet(args[0])
+	if c.State != config.ROLLOUT_STATE_RUNNING {
+		nmUsage(nil, util.FmtNewtError("campaign \"%s\" is %s", c.Name,
+			c.State))
+	}
+
+	c.State = config.ROLLOUT_STATE_PAUSED
+	c.Reason = "paused by user"
+	if err := config.SaveRolloutCampaign(c); err != nil {
+		nmUsage(nil, err)
+	}
+
+	fmt.Printf("Campaign %s will pause after the current wave\n", c.Name)
+}
+
+func rolloutStatusCmd(cmd *cobra.Command, args []string) {
+	names := args
+	if len(names) == 0 {
+		var err error
+		names, err = config.RolloutCampaignNames()
+		if err != nil {
+			nmUsage(nil, err)
+		}
+	}
+
+	if outputStructured() {
+		vals := []interface{}{}
+		for _, name := range names {
+			b, err := json.Marshal(rolloutGet(name))
+			if err != nil {
+				nmUsage(nil, util.ChildNewtError(err))
+			}
+			var val interface{}
+			json.Unmarshal(b, &val)
+			vals = append(vals, val)
+		}
+		outputPrintValue(vals)
+		return
+	}
+
+	if len(names) == 0 {
+		fmt.Printf("No campaigns\n")
+		return
+	}
+
+	for _, name := range names {
+		rolloutPrintStatus(rolloutGet(name))
+	}
+}
+
+func rolloutDeleteCmd(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		nmUsage(cmd, util.NewNewtError("Need to specify campaign name"))
+	}
+
+	if err := config.DeleteRolloutCampaign(args[0]); err != nil {
+		nmUsage(nil, err)
+	}
+}
+
+func rolloutAddCheckFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&rolloutEcho, "echo", true,
+		"Check that the device answers an echo request")
+	cmd.Flags().BoolVar(&rolloutCoreCheck, "core-check", true,
+		"Fail devices that produce a core file")
+	cmd.Flags().StringArrayVar(&rolloutStats, "stat", nil,
+		"Stat threshold of the form <group>.<field><op><value>, where <op> "+
+			"is one of <, <=, >, >=, ==, !=; may be repeated")
+	cmd.Flags().IntVar(&rolloutBootTimeout, "boot-timeout", 60,
+		"Seconds to wait for each device to come back after reset")
+}
+
+func rolloutCmd() *cobra.Command {
+	rolloutHelpText := "Upgrade a set of devices in stages.  Each device " +
+		"receives the image, test-boots it, and passes the health checks " +
+		"before the image is confirmed; a device that fails is reset back " +
+		"to its previous image.\n\n" +
+		"The canary wave runs first; any failure there halts the " +
+		"campaign.  The remaining devices are upgraded in waves, and the " +
+		"campaign halts when the overall failure rate exceeds " +
+		"--max-failure-rate.\n\n" +
+		"Campaign state is saved in ~/.newtmgr.rollouts after every " +
+		"device, so an interrupted, paused, or halted campaign can be " +
+		"continued with \"rollout resume\".  Devices are listed in the " +
+		"same forms that --fleet accepts."
+
+	rolloutEx := "  newtmgr rollout start fw-1.2 app.img devices.yaml " +
+		"--canary 3 --wave 25 --stat os.reboots<=1\n"
+	rolloutEx += "  newtmgr rollout pause fw-1.2\n"
+	rolloutEx += "  newtmgr rollout resume fw-1.2 --retry-failed\n"
+	rolloutEx += "  newtmgr rollout status\n"
+
+	rolloutCmd := &cobra.Command{
+		Use:     "rollout",
+		Short:   "Manage staged firmware rollout campaigns",
+		Long:    rolloutHelpText,
+		Example: rolloutEx,
+		Run: func(cmd *cobra.Command, args []string) {
+			cmd.HelpFunc()(cmd, args)
+		},
+	}
+
+	startCmd := &cobra.Command{
+		Use:   "start <campaign> <image-file> <device-list>",
+		Short: "Start a rollout campaign",
+		Run:   rolloutStartCmd,
+	}
+	startCmd.Flags().IntVar(&rolloutCanary, "canary", 1,
+		"Number of devices in the canary wave")
+	startCmd.Flags().IntVar(&rolloutWaveSize, "wave", 10,
+		"Number of devices in each subsequent wave")
+	startCmd.Flags().Float64Var(&rolloutMaxFailRate, "max-failure-rate", 0.1,
+		"Halt when more than this fraction of attempted devices failed")
+	rolloutAddCheckFlags(startCmd)
+	rolloutCmd.AddCommand(startCmd)
+
+	resumeCmd := &cobra.Command{
+		Use:   "resume <campaign>",
+		Short: "Continue a paused, halted, or interrupted campaign",
+		Run:   rolloutResumeCmd,
+	}
+	resumeCmd.Flags().BoolVar(&rolloutRetryFailed, "retry-failed", false,
+		"Retry devices that failed")
+	resumeCmd.Flags().IntVar(&rolloutBootTimeout, "boot-timeout", 60,
+		"Seconds to wait for each device to come back after reset; "+
+			"replaces the campaign's saved value")
+	rolloutCmd.AddCommand(resumeCmd)
+
+	pauseCmd := &cobra.Command{
+		Use:   "pause <campaign>",
+		Short: "Stop a running campaign after its current wave",
+		Run:   rolloutPauseCmd,
+	}
+	rolloutCmd.AddCommand(pauseCmd)
+
+	statusCmd := &cobra.Command{
+		Use:   "status [campaign]",
+		Short: "Show the state of one or all campaigns",
+		Run:   rolloutStatusCmd,
+	}
+	rolloutCmd.AddCommand(statusCmd)
+
+	deleteCmd := &cobra.Command{
+		Use:   "delete <campaign>",
+		Short: "Delete a campaign's saved state",
+		Run:   rolloutDeleteCmd,
+	}
+	rolloutCmd.AddCommand(deleteCmd)
+
+	deviceCmd := &cobra.Command{
+		Use:    "device <image-file> -c <conn_profile>",
+		Short:  "Upgrade and check a single device for a campaign",
+		Hidden: true,
+		Run:    rolloutDeviceCmd,
+	}
+	rolloutAddCheckFlags(deviceCmd)
+	rolloutCmd.AddCommand(deviceCmd)
+
+	return rolloutCmd
+}
@@ -0,0 +1,188 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"mynewt.apache.org/newtmgr/newtmgr/config"
+	"mynewt.apache.org/newtmgr/nmxact/sim"
+)
+
+func testCore(fill byte) []byte {
+	core := make([]byte, 600)
+	for i := range core {
+		core[i] = byte(i)
+	}
+	core[100] = fill
+	return core
+}
+
+func TestRolloutHealthCheckCore(t *testing.T) {
+	sx, done := testSimGlobalSesn(t, sim.NewXportCfg())
+	defer done()
+
+	s, err := GetSesn()
+	if err != nil {
+		t.Fatalf("failed to get session: %s", err.Error())
+	}
+
+	checks := config.RolloutChecks{Core: true}
+
+	tests := []struct {
+		name   string
+		before []byte
+		after  []byte
+		ok     bool
+	}{
+		{"none", nil, nil, true},
+		{"new", nil, testCore(1), false},
+		{"same", testCore(1), testCore(1), true},
+		{"replaced", testCore(1), testCore(2), false},
+		{"erased", testCore(1), nil, true},
+	}
+
+	for _, test := range tests {
+		sx.Device().SetCore(test.before)
+		before, err := rolloutCoreId(s)
+		if err != nil {
+			t.Fatalf("%s: failed to identify core: %s", test.name,
+				err.Error())
+		}
+		if (before != "") != (test.before != nil) {
+			t.Errorf("%s: core id before upgrade is \"%s\"", test.name,
+				before)
+		}
+
+		sx.Device().SetCore(test.after)
+		err = rolloutHealthCheck(s, checks, nil, before)
+		if (err == nil) != test.ok {
+			t.Errorf("%s: health check returned %v; want ok=%t",
+				test.name, err, test.ok)
+		}
+	}
+}
+
+func testRolloutCampaign(states ...string) *config.RolloutCampaign {
+	c := &config.RolloutCampaign{
+		Canary:      2,
+		WaveSize:    3,
+		MaxFailRate: 0.25,
+		State:       config.ROLLOUT_STATE_RUNNING,
+	}
+	for i, state := range states {
+		c.Devices = append(c.Devices, &config.RolloutDevice{
+			Label: fmt.Sprintf("dev%d", i),
+			State: state,
+		})
+	}
+
+	return c
+}
+
+func TestRolloutNextWave(t *testing.T) {
+	const P = config.ROLLOUT_DEV_PENDING
+	const O = config.ROLLOUT_DEV_OK
+	const F = config.ROLLOUT_DEV_FAILED
+
+	tests := []struct {
+		wave   int
+		states []string
+		want   []string
+	}{
+		{0, []string{P, P, P, P}, []string{"dev0", "dev1"}},
+		{0, []string{P}, []string{"dev0"}},
+		{1, []string{O, O, P, P, P, P}, []string{"dev2", "dev3", "dev4"}},
+
+		// Devices that have already been tried are skipped, e.g., when a
+		// campaign resumes.
+		{1, []string{O, F, P, O, P, F, P, P},
+			[]string{"dev2", "dev4", "dev6"}},
+		{2, []string{O, O, F, P}, []string{"dev3"}},
+		{2, []string{O, O, F}, []string{}},
+	}
+
+	for _, test := range tests {
+		c := testRolloutCampaign(test.states...)
+		c.Wave = test.wave
+
+		labels := []string{}
+		for _, d := range rolloutNextWave(c) {
+			labels = append(labels, d.Label)
+		}
+		if !reflect.DeepEqual(labels, test.want) {
+			t.Errorf("wave %d of %v: got %v; want %v", test.wave,
+				test.states, labels, test.want)
+		}
+	}
+}
+
+func TestRolloutEndWave(t *testing.T) {
+	const P = config.ROLLOUT_DEV_PENDING
+	const O = config.ROLLOUT_DEV_OK
+	const F = config.ROLLOUT_DEV_FAILED
+
+	tests := []struct {
+		name       string
+		wave       int
+		states     []string
+		waveFailed int
+		halt       bool
+	}{
+		{"canary ok", 0, []string{O, O, P, P}, 0, false},
+		{"canary failed", 0, []string{O, F, P, P}, 1, true},
+
+		// A failure left over from before a retry doesn't count against
+		// the canary wave.
+		{"canary retried", 0, []string{O, O, O, O, F, P, P}, 0, false},
+
+		{"rate ok", 1, []string{O, O, O, F, P}, 1, false},
+		{"rate at limit", 2, []string{O, O, O, O, O, O, F, F}, 1, false},
+		{"rate exceeded", 2, []string{O, O, O, O, O, F, F, F}, 2, true},
+		{"rate from earlier waves", 3, []string{O, F, F, O, O, P}, 0, true},
+	}
+
+	for _, test := range tests {
+		c := testRolloutCampaign(test.states...)
+		c.Wave = test.wave
+
+		rolloutEndWave(c, test.waveFailed)
+
+		if test.halt {
+			if c.State != config.ROLLOUT_STATE_HALTED || c.Reason == "" {
+				t.Errorf("%s: campaign not halted", test.name)
+			}
+			if c.Wave != test.wave {
+				t.Errorf("%s: halted campaign moved on to wave %d",
+					test.name, c.Wave)
+			}
+		} else {
+			if c.State != config.ROLLOUT_STATE_RUNNING {
+				t.Errorf("%s: campaign halted: %s", test.name, c.Reason)
+			}
+			if c.Wave != test.wave+1 {
+				t.Errorf("%s: campaign at wave %d; want %d", test.name,
+					c.Wave, test.wave+1)
+			}
+		}
+	}
+}
@@ -0,0 +1,353 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"golang.org/x/crypto/ssh/terminal"
+
+	"mynewt.apache.org/newt/util"
+)
+
+// Set while the shell is running; prevents it from being started twice.
+var shellActive bool
+
+// Splits a shell line into words.  Single and double quotes group words
+// containing spaces.
+func shellSplit(line string) ([]string, error) {
+	words := []string{}
+	cur := []rune{}
+	inWord := false
+	var quote rune
+
+	for _, c := range line {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				cur = append(cur, c)
+			}
+
+		case c == '"' || c == '\'':
+			quote = c
+			inWord = true
+
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, string(cur))
+				cur = cur[:0]
+				inWord = false
+			}
+
+		default:
+			cur = append(cur, c)
+			inWord = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, util.NewNewtError("unterminated quote")
+	}
+	if inWord {
+		words = append(words, string(cur))
+	}
+
+	return words, nil
+}
+
+func shellPrompt() string {
+	name := "unnamed"
+	if cp, err := getConnProfile(); err == nil {
+		name = cp.Name
+	}
+
+	state := "disconnected"
+	if s, err := GetSesnIfOpen(); err == nil && s.IsOpen() {
+		state = "connected"
+	}
+
+	return fmt.Sprintf("newtmgr (%s, %s)> ", name, state)
+}
+
+// Reopens the session if the peer disconnected since the last command.
+func shellEnsureOpen() {
+	s, err := GetSesnIfOpen()
+	if err != nil || s.IsOpen() {
+		return
+	}
+
+	fmt.Printf("Reconnecting...\n")
+	if err := s.Open(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+	}
+}
+
+// Runs a single shell line as a newtmgr command.  Flags specified when the
+// shell was started apply to every command unless overridden.  Returns the
+// status the command would have exited with.
+func shellExec(words []string, rootFlags map[string]string) (status int) {
+	root := Commands()
+	for name, val := range rootFlags {
+		root.PersistentFlags().Set(name, val)
+	}
+	root.SetArgs(words)
+
+	// Restore the previous state when done in case this command was run by
+	// another command (e.g., batch inside the shell).
+	prevActive := shellCmdActive
+	shellCmdActive = true
+	defer func() {
+		shellCmdActive = prevActive
+		if r := recover(); r != nil {
+			abort, ok := r.(shellCmdAbort)
+			if !ok {
+				panic(r)
+			}
+			status = abort.status
+		}
+	}()
+
+	if err := root.Execute(); err != nil {
+		return 1
+	}
+
+	return 0
+}
+
+// Retrieves the global flags that were specified on the command line.
+func shellRootFlags(cmd *cobra.Command) map[string]string {
+	flags := map[string]string{}
+	cmd.Root().PersistentFlags().Visit(func(f *pflag.Flag) {
+		flags[f.Name] = f.Value.String()
+	})
+
+	return flags
+}
+
+// Lists the names that could complete the last word of a partial line.
+func shellCandidates(words []string, prefix string) []string {
+	cmd := Commands()
+	for _, w := range words {
+		if strings.HasPrefix(w, "-") {
+			continue
+		}
+		for _, c := range cmd.Commands() {
+			if c.Name() == w {
+				cmd = c
+				break
+			}
+		}
+	}
+
+	names := []string{}
+	if strings.HasPrefix(prefix, "-") {
+		addFlag := func(f *pflag.Flag) {
+			names = append(names, "--"+f.Name)
+		}
+		cmd.Flags().VisitAll(addFlag)
+		cmd.InheritedFlags().VisitAll(addFlag)
+	} else {
+		for _, c := range cmd.Commands() {
+			if c.IsAvailableCommand() {
+				names = append(names, c.Name())
+			}
+		}
+		if !cmd.HasParent() {
+			names = append(names, "exit")
+		}
+	}
+
+	matches := []string{}
+	for _, n := range names {
+		if strings.HasPrefix(n, prefix) {
+			matches = append(matches, n)
+		}
+	}
+	sort.Strings(matches)
+
+	return matches
+}
+
+func shellCommonPrefix(strs []string) string {
+	prefix := strs[0]
+	for _, s := range strs[1:] {
+		for !strings.HasPrefix(s, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+
+	return prefix
+}
+
+func shellComplete(t *terminal.Terminal, line string, pos int,
+	key rune) (string, int, bool) {
+
+	if key != '\t' {
+		return "", 0, false
+	}
+
+	head := line[:pos]
+	words := strings.Fields(head)
+	prefix := ""
+	if len(words) > 0 && !strings.HasSuffix(head, " ") {
+		prefix = words[len(words)-1]
+		words = words[:len(words)-1]
+	}
+
+	matches := shellCandidates(words, prefix)
+	if len(matches) == 0 {
+		return line, pos, true
+	}
+
+	completion := shellCommonPrefix(matches)
+	if len(matches) == 1 {
+		completion += " "
+	} else if completion == prefix {
+		t.Write([]byte(strings.Join(matches, "  ") + "\n"))
+	}
+
+	newHead := head[:len(head)-len(prefix)] + completion
+	return newHead + line[pos:], len(newHead), true
+}
+
+// Reads lines from the terminal with editing, history and completion.  The
+// terminal is only in raw mode while a line is being read so that commands
+// produce normal output.
+func shellRunTerminal(rootFlags map[string]string) {
+	fd := int(os.Stdin.Fd())
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{stdinShared(), os.Stdout}
+
+	t := terminal.NewTerminal(rw, "")
+	t.AutoCompleteCallback = func(line string, pos int,
+		key rune) (string, int, bool) {
+
+		return shellComplete(t, line, pos, key)
+	}
+
+	for {
+		t.SetPrompt(shellPrompt())
+
+		state, err := terminal.MakeRaw(fd)
+		if err != nil {
+			nmUsage(nil, util.ChildNewtError(err))
+		}
+		line, err := t.ReadLine()
+		terminal.Restore(fd, state)
+
+		if err != nil {
+			// EOF (ctrl-d).
+			fmt.Printf("\n")
+			return
+		}
+
+		if !shellLine(line, rootFlags) {
+			return
+		}
+	}
+}
+
+// Reads lines from a non-interactive source, such as a script piped to
+// stdin.
+func shellRunPlain(rootFlags map[string]string) {
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		if !shellLine(scanner.Text(), rootFlags) {
+			return
+		}
+	}
+}
+
+// Processes one line of input.  Returns false if the shell should exit.
+func shellLine(line string, rootFlags map[string]string) bool {
+	words, err := shellSplit(line)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return true
+	}
+	if len(words) == 0 {
+		return true
+	}
+
+	switch words[0] {
+	case "exit", "quit":
+		return false
+	}
+
+	shellEnsureOpen()
+	shellExec(words, rootFlags)
+
+	return true
+}
+
+func shellRunCmd(cmd *cobra.Command, args []string) {
+	if shellActive {
+		fmt.Fprintf(os.Stderr, "Error: already in the shell\n")
+		return
+	}
+	shellActive = true
+
+	// Remember the global flags so that they apply to every command.
+	rootFlags := shellRootFlags(cmd)
+
+	// Connect up front; if that fails, the next command retries.
+	if _, err := GetSesn(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+	}
+
+	if terminal.IsTerminal(int(os.Stdin.Fd())) {
+		shellRunTerminal(rootFlags)
+	} else {
+		shellRunPlain(rootFlags)
+	}
+}
+
+func shellCmd() *cobra.Command {
+	shellHelpText := "Open a session to a device and run newtmgr commands " +
+		"interactively.  The session stays open between commands and is " +
+		"reopened automatically if the device disconnects.  Global flags " +
+		"given to the shell apply to every command; connection flags " +
+		"cannot be changed once the shell has started.  Enter \"exit\" or " +
+		"press ctrl-d to quit."
+
+	shellEx := "  newtmgr -c mybleprph shell\n"
+	shellEx += "  newtmgr (mybleprph, connected)> image list\n"
+
+	shellCmd := &cobra.Command{
+		Use:     "shell -c <conn_profile>",
+		Short:   "Run commands interactively over a single session",
+		Long:    shellHelpText,
+		Example: shellEx,
+		Run:     shellRunCmd,
+	}
+
+	return shellCmd
+}
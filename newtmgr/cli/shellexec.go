@@ -0,0 +1,100 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"mynewt.apache.org/newt/util"
+	"mynewt.apache.org/newtmgr/newtmgr/nmutil"
+	"mynewt.apache.org/newtmgr/nmxact/xact"
+)
+
+func shellExecRunCmd(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		nmUsage(cmd, nil)
+	}
+
+	s, err := GetSesn()
+	if err != nil {
+		nmUsage(nil, err)
+	}
+
+	c := xact.NewShellExecCmd()
+	c.SetTxOptions(nmutil.TxOptions())
+	c.Argv = args
+
+	res, err := runXactCmd(c, s)
+	if err != nil {
+		nmUsage(nil, util.ChildNewtError(err))
+	}
+
+	sres := res.(*xact.ShellExecResult)
+	if outputStructured() {
+		outputRsp(sres.Rsp, sres.Rsp.Rc)
+	} else {
+		if sres.Rsp.Rc != 0 {
+			rspError(sres.Rsp.Rc)
+		}
+
+		out := sres.Rsp.Output
+		fmt.Print(out)
+		if out != "" && !strings.HasSuffix(out, "\n") {
+			fmt.Printf("\n")
+		}
+	}
+
+	// Pass the command's return code on so that scripts can check it.
+	if ret := sres.Rsp.Ret; ret != 0 {
+		if !outputStructured() {
+			fmt.Fprintf(os.Stderr, "Command returned %d\n", ret)
+		}
+		if ret < 0 || ret > 255 {
+			ret = 1
+		}
+		nmCmdExit(ret)
+	}
+}
+
+func shellExecCmd() *cobra.Command {
+	shellExecHelpText := "Run a command in the device's shell and display " +
+		"its output.  The device must include the shell management group.  " +
+		"If the command returns a nonzero value, newtmgr exits with that " +
+		"status.\n\n" +
+		"Put -- before the command if any of its arguments start with a " +
+		"dash, so that newtmgr doesn't interpret them."
+
+	shellExecEx := "  newtmgr -c olimex shell-exec stat ble_ll\n"
+	shellExecEx += "  newtmgr -c olimex shell-exec -- log -l\n"
+
+	shellExecCmd := &cobra.Command{
+		Use:     "shell-exec <cmd> [args...] -c <conn_profile>",
+		Short:   "Run a shell command on a device",
+		Long:    shellExecHelpText,
+		Example: shellExecEx,
+		Run:     shellExecRunCmd,
+	}
+
+	return shellExecCmd
+}
@@ -0,0 +1,55 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"testing"
+
+	"mynewt.apache.org/newtmgr/nmxact/sim"
+)
+
+func TestShellExecStatus(t *testing.T) {
+	_, done := testSimGlobalSesn(t, sim.NewXportCfg())
+	defer done()
+
+	// The command's return code becomes the exit status; values that
+	// don't fit in one become 1.
+	tests := []struct {
+		argv   []string
+		status int
+	}{
+		{[]string{"echo", "hi"}, 0},
+		{[]string{"exit", "0"}, 0},
+		{[]string{"exit", "3"}, 3},
+		{[]string{"exit", "255"}, 255},
+		{[]string{"exit", "256"}, 1},
+		{[]string{"exit", "-5"}, 1},
+		{[]string{"bogus"}, 1},
+	}
+
+	for _, test := range tests {
+		words := append([]string{"shell-exec", "--"}, test.argv...)
+		status := shellExec(words, map[string]string{})
+		if status != test.status {
+			t.Errorf("%v: status=%d; want %d", test.argv, status,
+				test.status)
+		}
+	}
+}
@@ -39,14 +39,19 @@ func statsListRunCmd(cmd *cobra.Command, args []string) {
 	c := xact.NewStatListCmd()
 	c.SetTxOptions(nmutil.TxOptions())
 
-	res, err := c.Run(s)
+	res, err := runXactCmd(c, s)
 	if err != nil {
 		nmUsage(nil, util.ChildNewtError(err))
 	}
 
 	sres := res.(*xact.StatListResult)
+	if outputStructured() {
+		outputRsp(sres.Rsp, sres.Rsp.Rc)
+		return
+	}
+
 	if sres.Rsp.Rc != 0 {
-		fmt.Printf("Error: %d\n", sres.Rsp.Rc)
+		rspError(sres.Rsp.Rc)
 	} else if len(sres.Rsp.List) == 0 {
 		fmt.Printf("stat groups: none\n")
 	} else {
@@ -77,14 +82,19 @@ func statsRunCmd(cmd *cobra.Command, args []string) {
 	c.SetTxOptions(nmutil.TxOptions())
 	c.Name = args[0]
 
-	res, err := c.Run(s)
+	res, err := runXactCmd(c, s)
 	if err != nil {
 		nmUsage(nil, util.ChildNewtError(err))
 	}
 
 	sres := res.(*xact.StatReadResult)
+	if outputStructured() {
+		outputRsp(sres.Rsp, sres.Rsp.Rc)
+		return
+	}
+
 	if sres.Rsp.Rc != 0 {
-		fmt.Printf("Error: %d\n", sres.Rsp.Rc)
+		rspError(sres.Rsp.Rc)
 	} else {
 		fmt.Printf("stat group: %s\n", sres.Rsp.Name)
 		if len(sres.Rsp.Fields) == 0 {
@@ -119,6 +129,7 @@ func statsCmd() *cobra.Command {
 	}
 
 	statsCmd.AddCommand(ListCmd)
+	statsCmd.AddCommand(statRecordCmd())
 
 	return statsCmd
 }
@@ -0,0 +1,425 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"mynewt.apache.org/newt/util"
+	"mynewt.apache.org/newtmgr/newtmgr/nmutil"
+	"mynewt.apache.org/newtmgr/nmxact/sesn"
+	"mynewt.apache.org/newtmgr/nmxact/xact"
+)
+
+const (
+	STAT_RECORD_FORMAT_CSV    = "csv"
+	STAT_RECORD_FORMAT_NDJSON = "ndjson"
+	STAT_RECORD_FORMAT_NONE   = "none"
+)
+
+var statRecordInterval float64
+var statRecordCount int
+var statRecordFormat string
+var statRecordOut string
+var statRecordGroups string
+var statRecordListen string
+
+// One stat field at one point in time.  Delta and rate are relative to the
+// previous sample.  They are absent from the first sample, and from any sample
+// where the value decreased because the counter wrapped or the device reset.
+type statRecordRow struct {
+	Time    time.Time `json:"-"`
+	TimeStr string    `json:"time"`
+	Group   string    `json:"group"`
+	Field   string    `json:"field"`
+	Value   float64   `json:"value"`
+	Delta   *float64  `json:"delta,omitempty"`
+	Rate    *float64  `json:"rate,omitempty"`
+}
+
+type statRecorder struct {
+	groups []string
+
+	// Most recent row for each "group.field"; guarded by mtx since the
+	// Prometheus handler reads it concurrently.
+	latest map[string]*statRecordRow
+	mtx    sync.Mutex
+}
+
+func newStatRecorder(groups []string) *statRecorder {
+	return &statRecorder{
+		groups: groups,
+		latest: map[string]*statRecordRow{},
+	}
+}
+
+func statRecordListGroups(s sesn.Sesn) ([]string, error) {
+	c := xact.NewStatListCmd()
+	c.SetTxOptions(nmutil.TxOptions())
+
+	res, err := runXactCmd(c, s)
+	if err != nil {
+		return nil, err
+	}
+
+	rsp := res.(*xact.StatListResult).Rsp
+	if rsp.Rc != 0 {
+		return nil, util.FmtNewtError("stat list failed: rc=%d", rsp.Rc)
+	}
+
+	groups := append([]string{}, rsp.List...)
+	sort.Strings(groups)
+
+	return groups, nil
+}
+
+// Reads every group once and returns a row for each numeric field.
+func (sr *statRecorder) sample(s sesn.Sesn) ([]*statRecordRow, error) {
+	rows := []*statRecordRow{}
+
+	for _, g := range sr.groups {
+		c := xact.NewStatReadCmd()
+		c.SetTxOptions(nmutil.TxOptions())
+		c.Name = g
+
+		res, err := runXactCmd(c, s)
+		if err != nil {
+			return nil, err
+		}
+
+		rsp := res.(*xact.StatReadResult).Rsp
+		if rsp.Rc != 0 {
+			return nil, util.FmtNewtError("stat read of \"%s\" failed: rc=%d",
+				g, rsp.Rc)
+		}
+
+		now := time.Now()
+
+		fields := make([]string, 0, len(rsp.Fields))
+		for f, _ := range rsp.Fields {
+			fields = append(fields, f)
+		}
+		sort.Strings(fields)
+
+		for _, f := range fields {
+			v, ok := statNum(rsp.Fields[f])
+			if !ok {
+				continue
+			}
+
+			rows = append(rows, &statRecordRow{
+				Time:    now,
+				TimeStr: now.Format(time.RFC3339Nano),
+				Group:   g,
+				Field:   f,
+				Value:   v,
+			})
+		}
+	}
+
+	sr.mtx.Lock()
+	defer sr.mtx.Unlock()
+
+	for _, row := range rows {
+		key := row.Group + "." + row.Field
+		if prev := sr.latest[key]; prev != nil && row.Value >= prev.Value {
+			delta := row.Value - prev.Value
+			row.Delta = &delta
+
+			if secs := row.Time.Sub(prev.Time).Seconds(); secs > 0 {
+				rate := delta / secs
+				row.Rate = &rate
+			}
+		}
+		sr.latest[key] = row
+	}
+
+	return rows, nil
+}
+
+func statRecordFloat(f *float64) string {
+	if f == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*f, 'f', -1, 64)
+}
+
+type statRecordWriter interface {
+	write(rows []*statRecordRow) error
+}
+
+type statRecordCsvWriter struct {
+	w *csv.Writer
+}
+
+func newStatRecordCsvWriter(w io.Writer) (*statRecordCsvWriter, error) {
+	cw := &statRecordCsvWriter{w: csv.NewWriter(w)}
+	cw.w.Write([]string{"time", "group", "field", "value", "delta", "rate"})
+	cw.w.Flush()
+
+	return cw, cw.w.Error()
+}
+
+func (cw *statRecordCsvWriter) write(rows []*statRecordRow) error {
+	for _, row := range rows {
+		cw.w.Write([]string{
+			row.TimeStr,
+			row.Group,
+			row.Field,
+			strconv.FormatFloat(row.Value, 'f', -1, 64),
+			statRecordFloat(row.Delta),
+			statRecordFloat(row.Rate),
+		})
+	}
+	cw.w.Flush()
+
+	return cw.w.Error()
+}
+
+type statRecordJsonWriter struct {
+	w *bufio.Writer
+}
+
+func (jw *statRecordJsonWriter) write(rows []*statRecordRow) error {
+	for _, row := range rows {
+		b, err := json.Marshal(row)
+		if err != nil {
+			return err
+		}
+		jw.w.Write(b)
+		jw.w.WriteByte('\n')
+	}
+
+	return jw.w.Flush()
+}
+
+// Escapes a Prometheus label value.
+func statRecordLabel(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	return s
+}
+
+// Writes the most recent sample in the Prometheus text exposition format.
+func (sr *statRecorder) writePrometheus(w io.Writer, device string) {
+	sr.mtx.Lock()
+	keys := make([]string, 0, len(sr.latest))
+	for k, _ := range sr.latest {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	rows := make([]statRecordRow, len(keys))
+	for i, k := range keys {
+		rows[i] = *sr.latest[k]
+	}
+	sr.mtx.Unlock()
+
+	labels := func(row *statRecordRow) string {
+		l := ""
+		if device != "" {
+			l = fmt.Sprintf("device=\"%s\",", statRecordLabel(device))
+		}
+		return fmt.Sprintf("{%sgroup=\"%s\",field=\"%s\"}", l,
+			statRecordLabel(row.Group), statRecordLabel(row.Field))
+	}
+
+	buf := &bytes.Buffer{}
+
+	fmt.Fprintf(buf, "# HELP newtmgr_stat Value of a device stat.\n")
+	fmt.Fprintf(buf, "# TYPE newtmgr_stat untyped\n")
+	for i, _ := range rows {
+		fmt.Fprintf(buf, "newtmgr_stat%s %s %d\n", labels(&rows[i]),
+			strconv.FormatFloat(rows[i].Value, 'f', -1, 64),
+			rows[i].Time.UnixNano()/int64(time.Millisecond))
+	}
+
+	fmt.Fprintf(buf, "# HELP newtmgr_stat_rate Per-second change in a "+
+		"device stat over the last interval.\n")
+	fmt.Fprintf(buf, "# TYPE newtmgr_stat_rate gauge\n")
+	for i, _ := range rows {
+		if rows[i].Rate == nil {
+			continue
+		}
+		fmt.Fprintf(buf, "newtmgr_stat_rate%s %s %d\n", labels(&rows[i]),
+			statRecordFloat(rows[i].Rate),
+			rows[i].Time.UnixNano()/int64(time.Millisecond))
+	}
+
+	w.Write(buf.Bytes())
+}
+
+func statRecordServe(sr *statRecorder, addr string) error {
+	device := nmutil.ConnProfile
+	if nmutil.DeviceName != "" {
+		device = nmutil.DeviceName
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		sr.writePrometheus(w, device)
+	})
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	go http.Serve(l, mux)
+	return nil
+}
+
+func statRecordRunCmd(cmd *cobra.Command, args []string) {
+	if statRecordInterval <= 0 {
+		nmUsage(cmd, util.NewNewtError("interval must be positive"))
+	}
+
+	switch statRecordFormat {
+	case STAT_RECORD_FORMAT_CSV, STAT_RECORD_FORMAT_NDJSON,
+		STAT_RECORD_FORMAT_NONE:
+	default:
+		nmUsage(cmd, util.FmtNewtError("invalid format: \"%s\"",
+			statRecordFormat))
+	}
+
+	s, err := GetSesn()
+	if err != nil {
+		nmUsage(nil, err)
+	}
+
+	var groups []string
+	if statRecordGroups != "" {
+		groups = strings.Split(statRecordGroups, ",")
+	} else {
+		groups, err = statRecordListGroups(s)
+		if err != nil {
+			nmUsage(nil, util.ChildNewtError(err))
+		}
+	}
+
+	var out io.Writer = os.Stdout
+	if statRecordOut != "" && statRecordOut != "-" {
+		f, err := os.Create(statRecordOut)
+		if err != nil {
+			nmUsage(nil, util.ChildNewtError(err))
+		}
+		defer f.Close()
+		out = f
+	}
+
+	var w statRecordWriter
+	switch statRecordFormat {
+	case STAT_RECORD_FORMAT_CSV:
+		w, err = newStatRecordCsvWriter(out)
+		if err != nil {
+			nmUsage(nil, util.ChildNewtError(err))
+		}
+	case STAT_RECORD_FORMAT_NDJSON:
+		w = &statRecordJsonWriter{w: bufio.NewWriter(out)}
+	}
+
+	sr := newStatRecorder(groups)
+
+	if statRecordListen != "" {
+		if err := statRecordServe(sr, statRecordListen); err != nil {
+			nmUsage(nil, err)
+		}
+		fmt.Fprintf(os.Stderr, "Serving metrics at http://%s/metrics\n",
+			statRecordListen)
+	}
+
+	interval := time.Duration(statRecordInterval * float64(time.Second))
+	for i := 0; statRecordCount == 0 || i < statRecordCount; i++ {
+		if i > 0 {
+			time.Sleep(interval)
+		}
+
+		// A device that is rebooting or briefly out of range shouldn't end
+		// a long recording.
+		rows, err := sr.sample(s)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+			continue
+		}
+
+		if w != nil {
+			if err := w.write(rows); err != nil {
+				nmUsage(nil, util.ChildNewtError(err))
+			}
+		}
+	}
+}
+
+func statRecordCmd() *cobra.Command {
+	recordHelpText := "Periodically read stat groups from a device and " +
+		"record each field's value, its change since the previous sample, " +
+		"and its per-second rate.  The change and rate are left out when " +
+		"a value decreases, as it does when the device resets.  Samples " +
+		"are written as CSV or " +
+		"newline-delimited JSON.  With --listen, the most recent sample " +
+		"is also served in the Prometheus text format at /metrics.\n\n" +
+		"All groups reported by \"stat list\" are recorded unless --groups " +
+		"is specified."
+
+	recordEx := "  newtmgr -c olimex stat record --interval 10 --out stats.csv\n"
+	recordEx += "  newtmgr -c olimex stat record --groups ble_ll,os " +
+		"--format ndjson\n"
+	recordEx += "  newtmgr -c olimex stat record --listen :9110 --format none\n"
+
+	recordCmd := &cobra.Command{
+		Use:     "record -c <conn_profile>",
+		Short:   "Record statistics from a device over time",
+		Long:    recordHelpText,
+		Example: recordEx,
+		Run:     statRecordRunCmd,
+	}
+
+	recordCmd.Flags().Float64VarP(&statRecordInterval, "interval", "i", 5.0,
+		"Seconds between samples (partial seconds allowed)")
+	recordCmd.Flags().IntVarP(&statRecordCount, "count", "n", 0,
+		"Number of samples to take; 0 means until interrupted")
+	recordCmd.Flags().StringVarP(&statRecordFormat, "format", "f",
+		STAT_RECORD_FORMAT_CSV, "Output format: csv, ndjson, or none")
+	recordCmd.Flags().StringVar(&statRecordOut, "out", "",
+		"File to write samples to; stdout by default")
+	recordCmd.Flags().StringVar(&statRecordGroups, "groups", "",
+		"Comma-separated list of stat groups to record")
+	recordCmd.Flags().StringVar(&statRecordListen, "listen", "",
+		"Address to serve Prometheus metrics on (e.g., :9110)")
+
+	return recordCmd
+}
@@ -0,0 +1,110 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"testing"
+	"time"
+
+	"mynewt.apache.org/newtmgr/nmxact/sim"
+)
+
+func TestStatRecorderSample(t *testing.T) {
+	sx, done := testSimGlobalSesn(t, sim.NewXportCfg())
+	defer done()
+
+	s, err := GetSesn()
+	if err != nil {
+		t.Fatalf("failed to get session: %s", err.Error())
+	}
+
+	// A nil delta means the row shouldn't have a delta or a rate.
+	delta := func(f float64) *float64 { return &f }
+
+	tests := []struct {
+		name   string
+		vals   map[string]int
+		deltas map[string]*float64
+	}{
+		{"first", map[string]int{"a": 10, "b": 5},
+			map[string]*float64{"a": nil, "b": nil}},
+		{"increase", map[string]int{"a": 15, "b": 5},
+			map[string]*float64{"a": delta(5), "b": delta(0)}},
+		{"reset", map[string]int{"a": 3, "b": 7},
+			map[string]*float64{"a": nil, "b": delta(2)}},
+		{"after reset", map[string]int{"a": 4, "b": 7},
+			map[string]*float64{"a": delta(1), "b": delta(0)}},
+	}
+
+	sr := newStatRecorder([]string{"test"})
+	for _, test := range tests {
+		for f, v := range test.vals {
+			sx.Device().SetStat("test", f, v)
+		}
+
+		// Pretend the previous sample was taken a while ago so that rates
+		// are nonzero.
+		prevTimes := map[string]time.Time{}
+		for key, row := range sr.latest {
+			row.Time = row.Time.Add(-2 * time.Second)
+			prevTimes[key] = row.Time
+		}
+
+		rows, err := sr.sample(s)
+		if err != nil {
+			t.Fatalf("%s: failed to sample: %s", test.name, err.Error())
+		}
+		if len(rows) != len(test.vals) {
+			t.Fatalf("%s: got %d rows; want %d", test.name, len(rows),
+				len(test.vals))
+		}
+
+		for _, row := range rows {
+			key := row.Group + "." + row.Field
+			if row.Group != "test" || sr.latest[key] != row {
+				t.Errorf("%s: unexpected row for %s", test.name, key)
+				continue
+			}
+			if row.Value != float64(test.vals[row.Field]) {
+				t.Errorf("%s: %s=%v; want %d", test.name, key, row.Value,
+					test.vals[row.Field])
+			}
+
+			want := test.deltas[row.Field]
+			if want == nil {
+				if row.Delta != nil || row.Rate != nil {
+					t.Errorf("%s: %s has a delta or rate", test.name, key)
+				}
+				continue
+			}
+
+			if row.Delta == nil || *row.Delta != *want {
+				t.Errorf("%s: %s delta=%v; want %v", test.name, key,
+					statRecordFloat(row.Delta), *want)
+			}
+
+			rate := *want / row.Time.Sub(prevTimes[key]).Seconds()
+			if row.Rate == nil || *row.Rate != rate {
+				t.Errorf("%s: %s rate=%v; want %v", test.name, key,
+					statRecordFloat(row.Rate), rate)
+			}
+		}
+	}
+}
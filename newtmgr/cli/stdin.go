@@ -0,0 +1,156 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"os"
+	"sync"
+)
+
+// All interactive reads from stdin go through a single goroutine.  A command
+// that reads keys directly, such as top, can't interrupt a blocked read when
+// it finishes; this way, whatever that read returns is handed to the next
+// reader (e.g., the shell) instead of being lost.
+
+type stdinChunk struct {
+	data []byte
+	err  error
+}
+
+type stdinReader struct {
+	ch      chan stdinChunk
+	pending []byte
+	err     error
+	mtx     sync.Mutex
+}
+
+var sharedStdin *stdinReader
+var sharedStdinOnce sync.Once
+
+// Retrieves the shared stdin reader, starting it if necessary.
+func stdinShared() *stdinReader {
+	sharedStdinOnce.Do(func() {
+		r := &stdinReader{
+			ch: make(chan stdinChunk),
+		}
+
+		go func() {
+			for {
+				buf := make([]byte, 256)
+				n, err := os.Stdin.Read(buf)
+				r.ch <- stdinChunk{data: buf[:n], err: err}
+				if err != nil {
+					return
+				}
+			}
+		}()
+
+		sharedStdin = r
+	})
+
+	return sharedStdin
+}
+
+// Retrieves the next available input.  If done is closed first, nil is
+// returned with a nil error.
+func (r *stdinReader) next(done <-chan struct{}) ([]byte, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if len(r.pending) > 0 {
+		data := r.pending
+		r.pending = nil
+		return data, nil
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	for {
+		select {
+		case chunk := <-r.ch:
+			r.err = chunk.err
+			if len(chunk.data) > 0 {
+				return chunk.data, nil
+			}
+			if r.err != nil {
+				return nil, r.err
+			}
+
+		case <-done:
+			return nil, nil
+		}
+	}
+}
+
+// Returns input to the reader; the next read retrieves it first.
+func (r *stdinReader) unread(data []byte) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	r.pending = append(append([]byte(nil), data...), r.pending...)
+}
+
+func (r *stdinReader) Read(p []byte) (int, error) {
+	data, err := r.next(nil)
+	if len(data) == 0 {
+		return 0, err
+	}
+
+	n := copy(p, data)
+	if n < len(data) {
+		r.unread(data[n:])
+	}
+	return n, nil
+}
+
+// Delivers keystrokes until done is closed.  The returned channel is closed
+// when stdin reaches EOF or fails.  Call the returned function after closing
+// done; it waits until any keys that weren't delivered have been returned to
+// the reader.
+func (r *stdinReader) keys(done <-chan struct{}) (<-chan byte, func()) {
+	keys := make(chan byte)
+	finished := make(chan struct{})
+
+	go func() {
+		defer close(finished)
+
+		for {
+			data, err := r.next(done)
+			if len(data) == 0 {
+				if err != nil {
+					close(keys)
+				}
+				return
+			}
+
+			for i, b := range data {
+				select {
+				case keys <- b:
+				case <-done:
+					r.unread(data[i:])
+					return
+				}
+			}
+		}
+	}()
+
+	return keys, func() { <-finished }
+}
@@ -0,0 +1,441 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh/terminal"
+
+	"mynewt.apache.org/newt/util"
+	"mynewt.apache.org/newtmgr/nmxact/sesn"
+)
+
+const (
+	TOP_SORT_CPU   = "cpu"
+	TOP_SORT_CSW   = "csw"
+	TOP_SORT_STACK = "stack"
+	TOP_SORT_NAME  = "name"
+	TOP_SORT_PRIO  = "prio"
+)
+
+// Keys that change the sort order in the interactive view.
+var topSortKeys = map[byte]string{
+	'c': TOP_SORT_CPU,
+	'w': TOP_SORT_CSW,
+	's': TOP_SORT_STACK,
+	'n': TOP_SORT_NAME,
+	'p': TOP_SORT_PRIO,
+}
+
+var topInterval float64
+var topSort string
+var topIterations int
+
+type topSample struct {
+	time  time.Time
+	tasks map[string]map[string]int
+	pools map[string]map[string]int
+}
+
+type topTask struct {
+	Name     string  `json:"name"`
+	Prio     int     `json:"prio"`
+	State    int     `json:"state"`
+	CpuPct   float64 `json:"cpu_pct"`
+	CswRate  float64 `json:"csw_per_sec"`
+	StkUse   int     `json:"stkuse"`
+	StkSiz   int     `json:"stksiz"`
+	StkPct   float64 `json:"stack_pct"`
+	Runtime  int     `json:"runtime"`
+	CswCount int     `json:"cswcnt"`
+}
+
+type topPool struct {
+	Name  string `json:"name"`
+	BlkSz int    `json:"blksiz"`
+	Count int    `json:"nblks"`
+	Used  int    `json:"used"`
+	Min   int    `json:"min"`
+
+	// Change in blocks in use since the previous and first samples.
+	Delta      int `json:"delta"`
+	DeltaStart int `json:"delta_start"`
+}
+
+type topView struct {
+	first *topSample
+	prev  *topSample
+	cur   *topSample
+
+	tasks []topTask
+	pools []topPool
+
+	sortKey string
+	err     error
+}
+
+func topReadSample(s sesn.Sesn) (*topSample, error) {
+	trsp, err := taskStatRead(s)
+	if err != nil {
+		return nil, err
+	}
+
+	mrsp, err := mempoolStatRead(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return &topSample{
+		time:  time.Now(),
+		tasks: trsp.Tasks,
+		pools: mrsp.Mpools,
+	}, nil
+}
+
+// Records a new sample and recomputes the rates relative to the previous
+// one.
+func (v *topView) update(sample *topSample) {
+	if v.first == nil {
+		v.first = sample
+	}
+	v.prev = v.cur
+	v.cur = sample
+
+	// Counters can wrap or reset if the device reboots; treat a decrease as
+	// no activity.
+	delta := func(cur map[string]int, prev map[string]int,
+		key string) int {
+
+		if prev == nil || cur[key] < prev[key] {
+			return 0
+		}
+		return cur[key] - prev[key]
+	}
+
+	var prevTasks map[string]map[string]int
+	var elapsed float64
+	if v.prev != nil {
+		prevTasks = v.prev.tasks
+		elapsed = sample.time.Sub(v.prev.time).Seconds()
+	}
+
+	totalRuntime := 0
+	for n, t := range sample.tasks {
+		totalRuntime += delta(t, prevTasks[n], "runtime")
+	}
+
+	v.tasks = make([]topTask, 0, len(sample.tasks))
+	for n, t := range sample.tasks {
+		tt := topTask{
+			Name:     n,
+			Prio:     t["prio"],
+			State:    t["state"],
+			StkUse:   t["stkuse"],
+			StkSiz:   t["stksiz"],
+			Runtime:  t["runtime"],
+			CswCount: t["cswcnt"],
+		}
+
+		if t["stksiz"] != 0 {
+			tt.StkPct = 100 * float64(t["stkuse"]) / float64(t["stksiz"])
+		}
+		if totalRuntime != 0 {
+			tt.CpuPct = 100 * float64(delta(t, prevTasks[n], "runtime")) /
+				float64(totalRuntime)
+		}
+		if elapsed != 0 {
+			tt.CswRate = float64(delta(t, prevTasks[n], "cswcnt")) / elapsed
+		}
+
+		v.tasks = append(v.tasks, tt)
+	}
+
+	used := func(smpl *topSample, name string) (int, bool) {
+		if smpl == nil {
+			return 0, false
+		}
+		mp, ok := smpl.pools[name]
+		if !ok {
+			return 0, false
+		}
+		return mp["nblks"] - mp["nfree"], true
+	}
+
+	v.pools = make([]topPool, 0, len(sample.pools))
+	for n, mp := range sample.pools {
+		tp := topPool{
+			Name:  n,
+			BlkSz: mp["blksiz"],
+			Count: mp["nblks"],
+			Used:  mp["nblks"] - mp["nfree"],
+			Min:   mp["min"],
+		}
+		if u, ok := used(v.prev, n); ok {
+			tp.Delta = tp.Used - u
+		}
+		if u, ok := used(v.first, n); ok {
+			tp.DeltaStart = tp.Used - u
+		}
+
+		v.pools = append(v.pools, tp)
+	}
+	sort.Slice(v.pools, func(i, j int) bool {
+		return v.pools[i].Name < v.pools[j].Name
+	})
+
+	v.sort()
+}
+
+func (v *topView) sort() {
+	less := func(i int, j int) bool {
+		a := &v.tasks[i]
+		b := &v.tasks[j]
+
+		switch v.sortKey {
+		case TOP_SORT_CPU:
+			if a.CpuPct != b.CpuPct {
+				return a.CpuPct > b.CpuPct
+			}
+		case TOP_SORT_CSW:
+			if a.CswRate != b.CswRate {
+				return a.CswRate > b.CswRate
+			}
+		case TOP_SORT_STACK:
+			if a.StkPct != b.StkPct {
+				return a.StkPct > b.StkPct
+			}
+		case TOP_SORT_PRIO:
+			if a.Prio != b.Prio {
+				return a.Prio < b.Prio
+			}
+		}
+
+		return a.Name < b.Name
+	}
+
+	sort.Slice(v.tasks, less)
+}
+
+func topSigned(n int) string {
+	if n > 0 {
+		return fmt.Sprintf("+%d", n)
+	}
+	return fmt.Sprintf("%d", n)
+}
+
+func (v *topView) render(interactive bool) string {
+	buf := &bytes.Buffer{}
+
+	if v.cur != nil {
+		fmt.Fprintf(buf, "%s  interval %gs  sort: %s\n",
+			v.cur.time.Format("15:04:05"), topInterval, v.sortKey)
+	}
+	if interactive {
+		fmt.Fprintf(buf, "sort: (c)pu (w)csw (s)tack (n)ame (p)rio  "+
+			"(q)uit\n")
+	}
+	if v.err != nil {
+		fmt.Fprintf(buf, "Error: %s\n", v.err.Error())
+	}
+	fmt.Fprintf(buf, "\n")
+
+	fmt.Fprintf(buf, "%12s %4s %6s %8s %6s %6s %5s\n",
+		"task", "pri", "cpu%", "csw/s", "stkuse", "stksz", "stk%")
+	for _, t := range v.tasks {
+		cpu := "-"
+		csw := "-"
+		if v.prev != nil {
+			cpu = fmt.Sprintf("%.1f", t.CpuPct)
+			csw = fmt.Sprintf("%.1f", t.CswRate)
+		}
+
+		fmt.Fprintf(buf, "%12s %4d %6s %8s %6d %6d %5.0f\n",
+			t.Name, t.Prio, cpu, csw, t.StkUse, t.StkSiz, t.StkPct)
+	}
+
+	fmt.Fprintf(buf, "\n")
+	fmt.Fprintf(buf, "%20s %5s %4s %4s %4s %5s %6s\n",
+		"mempool", "blksz", "cnt", "used", "min", "chg", "total")
+	for _, p := range v.pools {
+		fmt.Fprintf(buf, "%20s %5d %4d %4d %4d %5s %6s\n",
+			p.Name, p.BlkSz, p.Count, p.Used, p.Min,
+			topSigned(p.Delta), topSigned(p.DeltaStart))
+	}
+
+	return buf.String()
+}
+
+func (v *topView) printStructured() {
+	outputPrintValue(map[string]interface{}{
+		"time":     v.cur.time.Format(time.RFC3339Nano),
+		"tasks":    v.tasks,
+		"mempools": v.pools,
+	})
+}
+
+// Polls without redrawing the screen; each sample is printed in turn.  This
+// is used when the output isn't a terminal or a structured format is
+// requested.
+func topRunPlain(s sesn.Sesn, v *topView) {
+	interval := time.Duration(topInterval * float64(time.Second))
+
+	for i := 0; topIterations == 0 || i < topIterations; i++ {
+		if i > 0 {
+			time.Sleep(interval)
+		}
+
+		sample, err := topReadSample(s)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+			continue
+		}
+		v.update(sample)
+
+		if outputStructured() {
+			v.printStructured()
+		} else {
+			if i > 0 {
+				fmt.Printf("\n")
+			}
+			fmt.Print(v.render(false))
+		}
+	}
+}
+
+// Redraws the screen after each sample and accepts single-key commands.
+func topRunTerminal(s sesn.Sesn, v *topView) {
+	fd := int(os.Stdin.Fd())
+	state, err := terminal.MakeRaw(fd)
+	if err != nil {
+		nmUsage(nil, util.ChildNewtError(err))
+	}
+	defer terminal.Restore(fd, state)
+
+	// Keys typed after the view exits are left for the next reader.
+	done := make(chan struct{})
+	keys, wait := stdinShared().keys(done)
+	defer wait()
+	defer close(done)
+
+	draw := func() {
+		// The terminal is in raw mode, so newlines don't return the cursor.
+		out := strings.Replace(v.render(true), "\n", "\r\n", -1)
+		fmt.Print("\033[H\033[2J" + out)
+	}
+
+	interval := time.Duration(topInterval * float64(time.Second))
+	poll := time.After(0)
+	for i := 0; topIterations == 0 || i < topIterations; {
+		select {
+		case <-poll:
+			sample, err := topReadSample(s)
+			v.err = err
+			if err == nil {
+				v.update(sample)
+			}
+			draw()
+			i++
+			poll = time.After(interval)
+
+		case key, ok := <-keys:
+			if !ok {
+				return
+			}
+
+			// 'q', ctrl-c, or ctrl-d.
+			if key == 'q' || key == 3 || key == 4 {
+				return
+			}
+			if sortKey, ok := topSortKeys[key]; ok {
+				v.sortKey = sortKey
+				v.sort()
+				draw()
+			}
+		}
+	}
+}
+
+func topRunCmd(cmd *cobra.Command, args []string) {
+	if topInterval <= 0 {
+		nmUsage(cmd, util.NewNewtError("interval must be positive"))
+	}
+
+	switch topSort {
+	case TOP_SORT_CPU, TOP_SORT_CSW, TOP_SORT_STACK, TOP_SORT_NAME,
+		TOP_SORT_PRIO:
+	default:
+		nmUsage(cmd, util.FmtNewtError("invalid sort key: \"%s\"", topSort))
+	}
+
+	s, err := GetSesn()
+	if err != nil {
+		nmUsage(nil, err)
+	}
+
+	v := &topView{sortKey: topSort}
+
+	if !outputStructured() &&
+		terminal.IsTerminal(int(os.Stdin.Fd())) &&
+		terminal.IsTerminal(int(os.Stdout.Fd())) {
+
+		topRunTerminal(s, v)
+	} else {
+		topRunPlain(s, v)
+	}
+}
+
+func topCmd() *cobra.Command {
+	topHelpText := "Repeatedly read task and mempool statistics from a " +
+		"device and display them.  For each task, shows its share of the " +
+		"CPU and its context switch rate since the previous sample, and " +
+		"its stack high-water mark.  For each mempool, shows the blocks in " +
+		"use and how that changed since the previous and first samples.\n\n" +
+		"On a terminal, the display is redrawn in place and the sort order " +
+		"can be changed with single keys; press q to quit.  Otherwise, each " +
+		"sample is printed in turn."
+
+	topEx := "  newtmgr -c olimex top\n"
+	topEx += "  newtmgr -c olimex top --interval 0.5 --sort stack\n"
+	topEx += "  newtmgr -c olimex top -n 10 -o json > samples.json\n"
+
+	topCmd := &cobra.Command{
+		Use:     "top -c <conn_profile>",
+		Short:   "Display live task and mempool statistics from a device",
+		Long:    topHelpText,
+		Example: topEx,
+		Run:     topRunCmd,
+	}
+
+	topCmd.Flags().Float64VarP(&topInterval, "interval", "i", 1.0,
+		"Seconds between samples (partial seconds allowed)")
+	topCmd.Flags().StringVar(&topSort, "sort", TOP_SORT_CPU,
+		"Sort tasks by: cpu, csw, stack, name, or prio")
+	topCmd.Flags().IntVarP(&topIterations, "iterations", "n", 0,
+		"Number of samples to take; 0 means until interrupted")
+
+	return topCmd
+}
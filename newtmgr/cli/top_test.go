@@ -0,0 +1,197 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testTopTask(runtime int, cswcnt int) map[string]int {
+	return map[string]int{
+		"prio":    10,
+		"state":   1,
+		"stkuse":  25,
+		"stksiz":  100,
+		"runtime": runtime,
+		"cswcnt":  cswcnt,
+	}
+}
+
+func testTopPool(nfree int) map[string]int {
+	return map[string]int{
+		"blksiz": 128,
+		"nblks":  10,
+		"nfree":  nfree,
+		"min":    2,
+	}
+}
+
+func TestTopViewUpdate(t *testing.T) {
+	start := time.Now()
+
+	task := func(name string, cpu float64, csw float64, runtime int,
+		cswcnt int) topTask {
+
+		return topTask{
+			Name:     name,
+			Prio:     10,
+			State:    1,
+			CpuPct:   cpu,
+			CswRate:  csw,
+			StkUse:   25,
+			StkSiz:   100,
+			StkPct:   25,
+			Runtime:  runtime,
+			CswCount: cswcnt,
+		}
+	}
+	pool := func(used int, delta int, deltaStart int) topPool {
+		return topPool{
+			Name:       "msys_1",
+			BlkSz:      128,
+			Count:      10,
+			Used:       used,
+			Min:        2,
+			Delta:      delta,
+			DeltaStart: deltaStart,
+		}
+	}
+
+	tests := []struct {
+		name   string
+		sample *topSample
+		tasks  []topTask
+		pools  []topPool
+	}{
+		{
+			// The first sample has nothing to compare against.
+			name: "first",
+			sample: &topSample{
+				time: start,
+				tasks: map[string]map[string]int{
+					"idle": testTopTask(1000, 10),
+					"main": testTopTask(500, 5),
+				},
+				pools: map[string]map[string]int{
+					"msys_1": testTopPool(8),
+				},
+			},
+			tasks: []topTask{
+				task("idle", 0, 0, 1000, 10),
+				task("main", 0, 0, 500, 5),
+			},
+			pools: []topPool{pool(2, 0, 0)},
+		},
+		{
+			// A task that wasn't in the previous sample gets no share of
+			// the CPU.
+			name: "deltas",
+			sample: &topSample{
+				time: start.Add(2 * time.Second),
+				tasks: map[string]map[string]int{
+					"idle": testTopTask(1300, 14),
+					"main": testTopTask(1400, 25),
+					"ble":  testTopTask(10000, 100),
+				},
+				pools: map[string]map[string]int{
+					"msys_1": testTopPool(5),
+				},
+			},
+			tasks: []topTask{
+				task("main", 75, 10, 1400, 25),
+				task("idle", 25, 2, 1300, 14),
+				task("ble", 0, 0, 10000, 100),
+			},
+			pools: []topPool{pool(5, 3, 3)},
+		},
+		{
+			// Counters that go backwards, e.g., after a reboot, count as
+			// no activity.
+			name: "reset",
+			sample: &topSample{
+				time: start.Add(4 * time.Second),
+				tasks: map[string]map[string]int{
+					"idle": testTopTask(100, 1),
+					"main": testTopTask(50, 0),
+				},
+				pools: map[string]map[string]int{
+					"msys_1": testTopPool(9),
+				},
+			},
+			tasks: []topTask{
+				task("idle", 0, 0, 100, 1),
+				task("main", 0, 0, 50, 0),
+			},
+			pools: []topPool{pool(1, -4, -1)},
+		},
+	}
+
+	v := &topView{sortKey: TOP_SORT_CPU}
+	for _, test := range tests {
+		v.update(test.sample)
+
+		if !reflect.DeepEqual(v.tasks, test.tasks) {
+			t.Errorf("%s: got tasks %+v; want %+v", test.name, v.tasks,
+				test.tasks)
+		}
+		if !reflect.DeepEqual(v.pools, test.pools) {
+			t.Errorf("%s: got pools %+v; want %+v", test.name, v.pools,
+				test.pools)
+		}
+	}
+}
+
+func TestTopViewSort(t *testing.T) {
+	tasks := []topTask{
+		{Name: "a", Prio: 3, CpuPct: 10, CswRate: 5, StkPct: 90},
+		{Name: "b", Prio: 1, CpuPct: 30, CswRate: 5, StkPct: 10},
+		{Name: "c", Prio: 2, CpuPct: 20, CswRate: 50, StkPct: 50},
+	}
+
+	tests := []struct {
+		key  string
+		want []string
+	}{
+		{TOP_SORT_CPU, []string{"b", "c", "a"}},
+		{TOP_SORT_CSW, []string{"c", "a", "b"}},
+		{TOP_SORT_STACK, []string{"a", "c", "b"}},
+		{TOP_SORT_PRIO, []string{"b", "c", "a"}},
+		{TOP_SORT_NAME, []string{"a", "b", "c"}},
+	}
+
+	for _, test := range tests {
+		v := &topView{
+			sortKey: test.key,
+			tasks:   append([]topTask(nil), tasks...),
+		}
+		v.sort()
+
+		names := make([]string, len(v.tasks))
+		for i, tt := range v.tasks {
+			names[i] = tt.Name
+		}
+		if !reflect.DeepEqual(names, test.want) {
+			t.Errorf("sort by %s: got %v; want %v", test.key, names,
+				test.want)
+		}
+	}
+}
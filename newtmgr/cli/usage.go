@@ -34,6 +34,14 @@ var onExit func()
 var exiting int32
 var silenceErrors bool
 
+// Set while the shell is executing a command.  Errors that would normally
+// terminate newtmgr only abort the command instead.
+var shellCmdActive bool
+
+type shellCmdAbort struct {
+	status int
+}
+
 func SetOnExit(cb func()) {
 	onExit = cb
 }
@@ -56,6 +64,16 @@ func NmExit(status int) {
 	os.Exit(status)
 }
 
+// Terminates the current command.  In the shell, control returns to the
+// prompt; otherwise, newtmgr exits.
+func nmCmdExit(status int) {
+	if shellCmdActive {
+		panic(shellCmdAbort{status: status})
+	}
+
+	NmExit(status)
+}
+
 func nmUsage(cmd *cobra.Command, err error) {
 	if !silenceErrors {
 		if err != nil {
@@ -75,5 +93,5 @@ func nmUsage(cmd *cobra.Command, err error) {
 		}
 	}
 
-	NmExit(1)
+	nmCmdExit(1)
 }
@@ -60,6 +60,10 @@ const (
 	CONN_TYPE_UDP_PLAIN
 	CONN_TYPE_UDP_OIC
 	CONN_TYPE_MTECH_LORA_OIC
+	CONN_TYPE_SIM_PLAIN
+	CONN_TYPE_SIM_OIC
+	CONN_TYPE_TCP_PLAIN
+	CONN_TYPE_TCP_OIC
 )
 
 var connTypeNameMap = map[ConnType]string{
@@ -72,6 +76,10 @@ var connTypeNameMap = map[ConnType]string{
 	CONN_TYPE_UDP_PLAIN:      "udp",
 	CONN_TYPE_UDP_OIC:        "oic_udp",
 	CONN_TYPE_MTECH_LORA_OIC: "oic_mtech",
+	CONN_TYPE_SIM_PLAIN:      "sim",
+	CONN_TYPE_SIM_OIC:        "oic_sim",
+	CONN_TYPE_TCP_PLAIN:      "tcp",
+	CONN_TYPE_TCP_OIC:        "oic_tcp",
 	CONN_TYPE_NONE:           "???",
 }
 
@@ -0,0 +1,233 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package config
+
+import (
+	"github.com/mitchellh/go-homedir"
+
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"mynewt.apache.org/newt/util"
+)
+
+const (
+	ROLLOUT_STATE_RUNNING = "running"
+	ROLLOUT_STATE_PAUSED  = "paused"
+	ROLLOUT_STATE_HALTED  = "halted"
+	ROLLOUT_STATE_DONE    = "done"
+)
+
+const (
+	ROLLOUT_DEV_PENDING = "pending"
+	ROLLOUT_DEV_OK      = "ok"
+	ROLLOUT_DEV_FAILED  = "failed"
+)
+
+type RolloutDevice struct {
+	Label      string `json:"label"`
+	Type       string `json:"type"`
+	ConnString string `json:"connstring"`
+	Name       string `json:"name,omitempty"`
+
+	State string `json:"state"`
+	Wave  int    `json:"wave"`
+
+	// The step that failed and why.
+	Stage string `json:"stage,omitempty"`
+	Error string `json:"error,omitempty"`
+
+	Updated time.Time `json:"updated,omitempty"`
+}
+
+// Health checks to run on each device before its new image is confirmed.
+type RolloutChecks struct {
+	Echo bool `json:"echo"`
+
+	// Fail if a core file appears during the upgrade.
+	Core bool `json:"core"`
+
+	// Stat thresholds of the form <group>.<field><op><value>.
+	Stats []string `json:"stats,omitempty"`
+
+	// Seconds to wait for a device to come back after it is reset.  Zero in
+	// campaigns saved before the timeout was recorded.
+	BootTimeout int `json:"boot_timeout,omitempty"`
+}
+
+// The persistent state of a firmware rollout campaign.
+type RolloutCampaign struct {
+	Name      string `json:"name"`
+	ImagePath string `json:"image"`
+	Hash      string `json:"hash"`
+
+	// Number of devices in the canary wave.  Any failure there halts the
+	// campaign.
+	Canary int `json:"canary"`
+
+	// Number of devices in each subsequent wave.
+	WaveSize int `json:"wave_size"`
+
+	// The campaign halts when the fraction of attempted devices that
+	// failed exceeds this.
+	MaxFailRate float64 `json:"max_fail_rate"`
+
+	Checks RolloutChecks `json:"checks"`
+
+	State   string           `json:"state"`
+	Reason  string           `json:"reason,omitempty"`
+	Wave    int              `json:"wave"`
+	Devices []*RolloutDevice `json:"devices"`
+
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+}
+
+var rolloutNameRe = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+func rolloutStateDir() (string, error) {
+	dir, err := homedir.Dir()
+	if err != nil {
+		return "", util.NewNewtError(err.Error())
+	}
+
+	return dir + "/.newtmgr.rollouts", nil
+}
+
+func rolloutStateFilename(name string) (string, error) {
+	if !rolloutNameRe.MatchString(name) {
+		return "", util.FmtNewtError("invalid campaign name: \"%s\"", name)
+	}
+
+	dir, err := rolloutStateDir()
+	if err != nil {
+		return "", err
+	}
+
+	return dir + "/" + name + ".json", nil
+}
+
+// Tallies the devices in each state.
+func (c *RolloutCampaign) Counts() (pending int, ok int, failed int) {
+	for _, d := range c.Devices {
+		switch d.State {
+		case ROLLOUT_DEV_OK:
+			ok++
+		case ROLLOUT_DEV_FAILED:
+			failed++
+		default:
+			pending++
+		}
+	}
+
+	return
+}
+
+// Reads a campaign's saved state.  Returns nil if there is no such campaign.
+func GetRolloutCampaign(name string) (*RolloutCampaign, error) {
+	filename, err := rolloutStateFilename(name)
+	if err != nil {
+		return nil, err
+	}
+
+	blob, err := ioutil.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		} else {
+			return nil, util.ChildNewtError(err)
+		}
+	}
+
+	c := &RolloutCampaign{}
+	if err := json.Unmarshal(blob, c); err != nil {
+		return nil, util.FmtNewtError("error reading campaign state (%s): %s",
+			filename, err.Error())
+	}
+
+	return c, nil
+}
+
+func SaveRolloutCampaign(c *RolloutCampaign) error {
+	filename, err := rolloutStateFilename(c.Name)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	c.Updated = time.Now()
+	b, err := json.MarshalIndent(c, "", "    ")
+	if err != nil {
+		return util.NewNewtError(err.Error())
+	}
+
+	return writeFileAtomic(filename, b, 0644)
+}
+
+func DeleteRolloutCampaign(name string) error {
+	filename, err := rolloutStateFilename(name)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(filename); err != nil {
+		if os.IsNotExist(err) {
+			return util.FmtNewtError("no such campaign: \"%s\"", name)
+		}
+		return util.ChildNewtError(err)
+	}
+
+	return nil
+}
+
+// Lists the names of all saved campaigns.
+func RolloutCampaignNames() ([]string, error) {
+	dir, err := rolloutStateDir()
+	if err != nil {
+		return nil, err
+	}
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, util.ChildNewtError(err)
+	}
+
+	names := []string{}
+	for _, info := range infos {
+		if strings.HasSuffix(info.Name(), ".json") {
+			names = append(names, strings.TrimSuffix(info.Name(), ".json"))
+		}
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
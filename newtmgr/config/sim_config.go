@@ -0,0 +1,73 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package config
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"mynewt.apache.org/newt/util"
+	"mynewt.apache.org/newtmgr/nmxact/sim"
+)
+
+func ParseSimConnString(cs string) (*sim.XportCfg, error) {
+	sc := sim.NewXportCfg()
+
+	if len(cs) == 0 {
+		return sc, nil
+	}
+	parts := strings.Split(cs, ",")
+	for _, p := range parts {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) != 2 {
+			return nil, util.FmtNewtError("expected comma-separated "+
+				"key=value pairs; no '=' in: %s", p)
+		}
+
+		k := kv[0]
+		v := kv[1]
+
+		switch k {
+		case "mtu":
+			var err error
+			sc.Mtu, err = strconv.Atoi(v)
+			if err != nil || sc.Mtu <= 0 {
+				return nil, util.FmtNewtError("Invalid mtu: %s", v)
+			}
+		case "resetdelay":
+			var err error
+			sc.ResetDelay, err = time.ParseDuration(v)
+			if err != nil {
+				return nil, util.FmtNewtError("Invalid resetdelay: %s", v)
+			}
+		case "closeonreset":
+			var err error
+			sc.CloseOnReset, err = strconv.ParseBool(v)
+			if err != nil {
+				return nil, util.FmtNewtError("Invalid closeonreset: %s", v)
+			}
+		default:
+			return nil, util.FmtNewtError("Unrecognized key: %s", k)
+		}
+	}
+
+	return sc, nil
+}
@@ -0,0 +1,136 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package config
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"github.com/mitchellh/go-homedir"
+
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"mynewt.apache.org/newt/util"
+)
+
+// Records the progress of an interrupted image upload so that a later
+// invocation can resume it.  At most one upload is tracked per peer.
+type UploadState struct {
+	Peer string `json:"peer"`
+	Hash string `json:"hash"`
+	Off  uint32 `json:"off"`
+}
+
+// Identifies the device a connection profile talks to.
+func (p *ConnProfile) PeerId() string {
+	return ConnTypeToString(p.Type) + ":" + p.ConnString
+}
+
+func uploadStateDir() (string, error) {
+	dir, err := homedir.Dir()
+	if err != nil {
+		return "", util.NewNewtError(err.Error())
+	}
+
+	return dir + "/.newtmgr.uploads", nil
+}
+
+// Each peer's state is kept in its own file so that concurrent uploads to
+// different peers don't overwrite each other's progress.  The peer ID may
+// contain characters that aren't valid in a filename, so it is hashed.
+func uploadStateFilename(peer string) (string, error) {
+	dir, err := uploadStateDir()
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256([]byte(peer))
+	return dir + "/" + hex.EncodeToString(sum[:8]) + ".json", nil
+}
+
+// Retrieves the saved upload state for the specified peer.  Returns nil if
+// no upload to the peer is in progress.
+func GetUploadState(peer string) (*UploadState, error) {
+	filename, err := uploadStateFilename(peer)
+	if err != nil {
+		return nil, err
+	}
+
+	blob, err := ioutil.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		} else {
+			return nil, util.ChildNewtError(err)
+		}
+	}
+
+	state := &UploadState{}
+	if err := json.Unmarshal(blob, state); err != nil {
+		return nil, util.FmtNewtError("error reading upload state (%s): %s",
+			filename, err.Error())
+	}
+
+	// Guard against a hash collision.
+	if state.Peer != peer {
+		return nil, nil
+	}
+
+	return state, nil
+}
+
+// Saves the upload state, replacing any existing state for the same peer.
+func SaveUploadState(state *UploadState) error {
+	filename, err := uploadStateFilename(state.Peer)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	b, err := json.MarshalIndent(state, "", "    ")
+	if err != nil {
+		return util.NewNewtError(err.Error())
+	}
+
+	log.Debugf("Saving upload state: peer=%s hash=%s off=%d",
+		state.Peer, state.Hash, state.Off)
+
+	return writeFileAtomic(filename, b, 0644)
+}
+
+// Discards the saved upload state for the specified peer.
+func ClearUploadState(peer string) error {
+	filename, err := uploadStateFilename(peer)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+		return util.ChildNewtError(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,126 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/mitchellh/go-homedir"
+)
+
+func testHomeDir(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "newtmgr-config")
+	if err != nil {
+		t.Fatalf("failed to create directory: %s", err.Error())
+	}
+
+	oldHome := os.Getenv("HOME")
+	os.Setenv("HOME", dir)
+	homedir.DisableCache = true
+
+	return func() {
+		os.Setenv("HOME", oldHome)
+		homedir.DisableCache = false
+		os.RemoveAll(dir)
+	}
+}
+
+func TestUploadStateConcurrent(t *testing.T) {
+	defer testHomeDir(t)()
+
+	const numPeers = 8
+	const numSaves = 50
+
+	var wg sync.WaitGroup
+	for i := 0; i < numPeers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			state := &UploadState{
+				Peer: fmt.Sprintf("serial:/dev/ttyUSB%d", i),
+				Hash: fmt.Sprintf("%064x", i),
+			}
+			for off := 1; off <= numSaves; off++ {
+				state.Off = uint32(off * 100)
+				if err := SaveUploadState(state); err != nil {
+					t.Errorf("failed to save upload state: %s",
+						err.Error())
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < numPeers; i++ {
+		peer := fmt.Sprintf("serial:/dev/ttyUSB%d", i)
+		state, err := GetUploadState(peer)
+		if err != nil {
+			t.Fatalf("failed to read upload state: %s", err.Error())
+		}
+		if state == nil || state.Off != numSaves*100 ||
+			state.Hash != fmt.Sprintf("%064x", i) {
+
+			t.Errorf("peer %s: got state %+v; want off=%d", peer, state,
+				numSaves*100)
+		}
+	}
+
+	dir, _ := uploadStateDir()
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read state directory: %s", err.Error())
+	}
+	if len(infos) != numPeers {
+		t.Errorf("state directory contains %d files; want %d", len(infos),
+			numPeers)
+	}
+}
+
+func TestUploadStateClear(t *testing.T) {
+	defer testHomeDir(t)()
+
+	a := &UploadState{Peer: "ble:aa", Hash: "01", Off: 10}
+	b := &UploadState{Peer: "ble:bb", Hash: "02", Off: 20}
+	for _, s := range []*UploadState{a, b} {
+		if err := SaveUploadState(s); err != nil {
+			t.Fatalf("failed to save upload state: %s", err.Error())
+		}
+	}
+
+	if err := ClearUploadState(a.Peer); err != nil {
+		t.Fatalf("failed to clear upload state: %s", err.Error())
+	}
+	if err := ClearUploadState(a.Peer); err != nil {
+		t.Fatalf("failed to clear missing upload state: %s", err.Error())
+	}
+
+	if s, _ := GetUploadState(a.Peer); s != nil {
+		t.Errorf("cleared upload state still present: %+v", s)
+	}
+	if s, _ := GetUploadState(b.Peer); s == nil || *s != *b {
+		t.Errorf("got upload state %+v; want %+v", s, b)
+	}
+}
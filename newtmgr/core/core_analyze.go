@@ -0,0 +1,501 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package core
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"mynewt.apache.org/newt/util"
+)
+
+// Indices into the REGS TLV of a Cortex-M core.  The device stores r0-r12
+// followed by sp, lr, pc and xpsr.
+const (
+	COREDUMP_REG_SP   = 13
+	COREDUMP_REG_LR   = 14
+	COREDUMP_REG_PC   = 15
+	COREDUMP_REG_XPSR = 16
+)
+
+// Cortex-M system control block fault registers.  These are only available
+// if the device included the SCB in the core's memory regions.
+const (
+	SCB_CFSR  = 0xe000ed28
+	SCB_HFSR  = 0xe000ed2c
+	SCB_MMFAR = 0xe000ed34
+	SCB_BFAR  = 0xe000ed38
+)
+
+type CoreDumpMem struct {
+	Addr uint32
+	Data []byte
+}
+
+// The parsed contents of a core file as read from the device.
+type CoreDump struct {
+	ImageHash []byte
+	Regs      []uint32
+	Mems      []CoreDumpMem
+}
+
+// A code address resolved against an ELF file.  Func, File and Line are empty
+// if the address could not be resolved.
+type CoreLoc struct {
+	Addr    uint32
+	Func    string
+	FuncOff uint32
+	File    string
+	Line    int
+}
+
+func ReadCoreDump(filename string) (*CoreDump, error) {
+	cc := NewCoreConvert()
+
+	var err error
+	cc.Source, err = os.OpenFile(filename, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, util.FmtNewtError("Cannot open file %s - %s",
+			filename, err.Error())
+	}
+	defer cc.Source.Close()
+
+	if err := cc.readHdr(); err != nil {
+		return nil, err
+	}
+
+	cd := &CoreDump{}
+	for {
+		tlv, err := cc.readTlv()
+		if err != nil {
+			return nil, err
+		}
+		if tlv == nil {
+			break
+		}
+
+		data := make([]byte, tlv.Len)
+		cnt, err := cc.Source.Read(data)
+		if err != nil {
+			return nil, util.NewNewtError(fmt.Sprintf("Error reading: %s",
+				err.Error()))
+		}
+		if cnt != int(tlv.Len) {
+			return nil, util.NewNewtError("Short file")
+		}
+
+		switch tlv.Type {
+		case COREDUMP_TLV_MEM:
+			cd.Mems = append(cd.Mems, CoreDumpMem{
+				Addr: tlv.Off,
+				Data: data,
+			})
+		case COREDUMP_TLV_IMAGE:
+			cd.ImageHash = data
+		case COREDUMP_TLV_REGS:
+			if tlv.Len%4 != 0 {
+				return nil, util.NewNewtError("Invalid register area size")
+			}
+			for off := 0; off < len(data); off += 4 {
+				cd.Regs = append(cd.Regs,
+					binary.LittleEndian.Uint32(data[off:off+4]))
+			}
+		default:
+			return nil, util.NewNewtError("Unknown TLV type")
+		}
+	}
+
+	return cd, nil
+}
+
+// Retrieves a register value from the REGS TLV.
+func (cd *CoreDump) Reg(idx int) (uint32, bool) {
+	if idx >= len(cd.Regs) {
+		return 0, false
+	}
+
+	return cd.Regs[idx], true
+}
+
+// Reads a 32-bit word from the core's memory regions.
+func (cd *CoreDump) ReadWord(addr uint32) (uint32, bool) {
+	for _, m := range cd.Mems {
+		if addr >= m.Addr && uint64(addr)+4 <= uint64(m.Addr)+
+			uint64(len(m.Data)) {
+
+			off := addr - m.Addr
+			return binary.LittleEndian.Uint32(m.Data[off : off+4]), true
+		}
+	}
+
+	return 0, false
+}
+
+// Returns the end of the memory region containing the specified address, or
+// 0 if the address is not in the core.
+func (cd *CoreDump) memEnd(addr uint32) uint32 {
+	for _, m := range cd.Mems {
+		end := m.Addr + uint32(len(m.Data))
+		if addr >= m.Addr && addr < end {
+			return end
+		}
+	}
+
+	return 0
+}
+
+var exceptionNameMap = map[uint32]string{
+	0:  "Thread",
+	1:  "Reset",
+	2:  "NMI",
+	3:  "HardFault",
+	4:  "MemManage",
+	5:  "BusFault",
+	6:  "UsageFault",
+	11: "SVCall",
+	12: "DebugMonitor",
+	14: "PendSV",
+	15: "SysTick",
+}
+
+// Describes the exception that was active according to the IPSR field of the
+// specified xPSR value.
+func ExceptionString(xpsr uint32) string {
+	num := xpsr & 0x1ff
+	if name, ok := exceptionNameMap[num]; ok {
+		return name
+	}
+	if num >= 16 {
+		return fmt.Sprintf("IRQ%d", num-16)
+	}
+
+	return "???"
+}
+
+var cfsrBitNames = []string{
+	0:  "IACCVIOL",
+	1:  "DACCVIOL",
+	3:  "MUNSTKERR",
+	4:  "MSTKERR",
+	5:  "MLSPERR",
+	7:  "MMARVALID",
+	8:  "IBUSERR",
+	9:  "PRECISERR",
+	10: "IMPRECISERR",
+	11: "UNSTKERR",
+	12: "STKERR",
+	13: "LSPERR",
+	15: "BFARVALID",
+	16: "UNDEFINSTR",
+	17: "INVSTATE",
+	18: "INVPC",
+	19: "NOCP",
+	24: "UNALIGNED",
+	25: "DIVBYZERO",
+}
+
+// Describes the bits set in a configurable fault status register value.
+func CfsrString(cfsr uint32) string {
+	strs := []string{}
+	for i, name := range cfsrBitNames {
+		if name != "" && cfsr&(1<<uint(i)) != 0 {
+			strs = append(strs, name)
+		}
+	}
+
+	return strings.Join(strs, " ")
+}
+
+// Describes the bits set in a hard fault status register value.
+func HfsrString(hfsr uint32) string {
+	strs := []string{}
+	if hfsr&(1<<1) != 0 {
+		strs = append(strs, "VECTTBL")
+	}
+	if hfsr&(1<<30) != 0 {
+		strs = append(strs, "FORCED")
+	}
+	if hfsr&(1<<31) != 0 {
+		strs = append(strs, "DEBUGEVT")
+	}
+
+	return strings.Join(strs, " ")
+}
+
+type coreLine struct {
+	addr uint32
+	file string
+	line int
+	end  bool
+}
+
+type coreText struct {
+	addr uint32
+	data []byte
+}
+
+// Resolves code addresses to functions and source lines using an ELF file's
+// symbol table and DWARF line information.
+type CoreSymbolizer struct {
+	// The ELF file's machine type.
+	Machine elf.Machine
+
+	funcs []elf.Symbol
+	lines []coreLine
+	texts []coreText
+}
+
+func NewCoreSymbolizer(elfFilename string) (*CoreSymbolizer, error) {
+	f, err := elf.Open(elfFilename)
+	if err != nil {
+		return nil, util.FmtNewtError("Cannot read ELF file %s - %s",
+			elfFilename, err.Error())
+	}
+	defer f.Close()
+
+	cs := &CoreSymbolizer{
+		Machine: f.Machine,
+	}
+
+	syms, err := f.Symbols()
+	if err != nil {
+		return nil, util.FmtNewtError("Cannot read symbols from %s - %s",
+			elfFilename, err.Error())
+	}
+	for _, sym := range syms {
+		if elf.ST_TYPE(sym.Info) == elf.STT_FUNC && sym.Value != 0 {
+			// Clear the thumb bit.
+			sym.Value &^= 1
+			cs.funcs = append(cs.funcs, sym)
+		}
+	}
+	sort.Slice(cs.funcs, func(i, j int) bool {
+		return cs.funcs[i].Value < cs.funcs[j].Value
+	})
+
+	for _, sec := range f.Sections {
+		if sec.Type == elf.SHT_PROGBITS &&
+			sec.Flags&elf.SHF_EXECINSTR != 0 {
+
+			data, err := sec.Data()
+			if err != nil {
+				return nil, util.ChildNewtError(err)
+			}
+			cs.texts = append(cs.texts, coreText{
+				addr: uint32(sec.Addr),
+				data: data,
+			})
+		}
+	}
+
+	// Line information is optional; without it, addresses are only resolved
+	// to functions.
+	if dw, err := f.DWARF(); err == nil {
+		cs.readLines(dw)
+	}
+
+	return cs, nil
+}
+
+func (cs *CoreSymbolizer) readLines(dw *dwarf.Data) {
+	r := dw.Reader()
+	for {
+		ent, err := r.Next()
+		if err != nil || ent == nil {
+			break
+		}
+		if ent.Tag != dwarf.TagCompileUnit {
+			r.SkipChildren()
+			continue
+		}
+
+		lr, err := dw.LineReader(ent)
+		if err != nil || lr == nil {
+			continue
+		}
+
+		var le dwarf.LineEntry
+		for lr.Next(&le) == nil {
+			cl := coreLine{
+				addr: uint32(le.Address),
+				line: le.Line,
+				end:  le.EndSequence,
+			}
+			if le.File != nil {
+				cl.file = le.File.Name
+			}
+			cs.lines = append(cs.lines, cl)
+		}
+	}
+
+	// Keep the rows of each address in table order so that a sequence end
+	// doesn't hide the row that starts the next sequence.
+	sort.SliceStable(cs.lines, func(i, j int) bool {
+		return cs.lines[i].addr < cs.lines[j].addr
+	})
+}
+
+func (cs *CoreSymbolizer) lookupFunc(addr uint32) *elf.Symbol {
+	i := sort.Search(len(cs.funcs), func(i int) bool {
+		return cs.funcs[i].Value > uint64(addr)
+	})
+	if i == 0 {
+		return nil
+	}
+
+	sym := &cs.funcs[i-1]
+	if sym.Size != 0 && uint64(addr) >= sym.Value+sym.Size {
+		return nil
+	}
+
+	return sym
+}
+
+func (cs *CoreSymbolizer) lookupLine(addr uint32) *coreLine {
+	i := sort.Search(len(cs.lines), func(i int) bool {
+		return cs.lines[i].addr > addr
+	})
+	if i == 0 {
+		return nil
+	}
+
+	cl := &cs.lines[i-1]
+	if cl.end {
+		return nil
+	}
+
+	return cl
+}
+
+// Resolves a code address.  The thumb bit is ignored.
+func (cs *CoreSymbolizer) Lookup(addr uint32) CoreLoc {
+	addr &^= 1
+	loc := CoreLoc{Addr: addr}
+
+	if sym := cs.lookupFunc(addr); sym != nil {
+		loc.Func = sym.Name
+		loc.FuncOff = addr - uint32(sym.Value)
+	}
+	if cl := cs.lookupLine(addr); cl != nil {
+		loc.File = cl.file
+		loc.Line = cl.line
+	}
+
+	return loc
+}
+
+// Resolves a return address to the location of the call that produced it.
+func (cs *CoreSymbolizer) LookupRet(addr uint32) CoreLoc {
+	addr &^= 1
+	loc := cs.Lookup(addr - 1)
+	loc.Addr = addr
+
+	return loc
+}
+
+func (cs *CoreSymbolizer) readHalfword(addr uint32) (uint16, bool) {
+	for _, t := range cs.texts {
+		if addr >= t.addr && uint64(addr)+2 <= uint64(t.addr)+
+			uint64(len(t.data)) {
+
+			off := addr - t.addr
+			return binary.LittleEndian.Uint16(t.data[off : off+2]), true
+		}
+	}
+
+	return 0, false
+}
+
+// Indicates whether the specified value looks like a thumb return address;
+// i.e., it points just past a BL or BLX instruction.
+func (cs *CoreSymbolizer) isRetAddr(val uint32) bool {
+	if val&1 == 0 || val < 5 {
+		return false
+	}
+	addr := val &^ 1
+
+	if cs.lookupFunc(addr-1) == nil {
+		return false
+	}
+
+	// BLX <reg> (16-bit).
+	if hw, ok := cs.readHalfword(addr - 2); ok && hw&0xff87 == 0x4780 {
+		return true
+	}
+
+	// BL / BLX <imm> (32-bit).
+	hw1, ok1 := cs.readHalfword(addr - 4)
+	hw2, ok2 := cs.readHalfword(addr - 2)
+	if ok1 && ok2 && hw1&0xf800 == 0xf000 && hw2&0xc000 == 0xc000 {
+		return true
+	}
+
+	return false
+}
+
+// Attempts to reconstruct the call stack of a Cortex-M core at the time of
+// the crash.  The first entries are the faulting PC and the LR.  The
+// remaining entries are found by scanning the stack for words that look like
+// thumb return addresses, so they may include stale frames.
+func (cs *CoreSymbolizer) Backtrace(cd *CoreDump, maxFrames int) []CoreLoc {
+	locs := []CoreLoc{}
+
+	if pc, ok := cd.Reg(COREDUMP_REG_PC); ok {
+		locs = append(locs, cs.Lookup(pc))
+	}
+	if lr, ok := cd.Reg(COREDUMP_REG_LR); ok && cs.isRetAddr(lr) {
+		locs = append(locs, cs.LookupRet(lr))
+	}
+
+	sp, ok := cd.Reg(COREDUMP_REG_SP)
+	if !ok {
+		return locs
+	}
+
+	end := cd.memEnd(sp)
+	for addr := sp &^ 3; addr < end && len(locs) < maxFrames; addr += 4 {
+		val, ok := cd.ReadWord(addr)
+		if !ok {
+			break
+		}
+		if cs.isRetAddr(val) {
+			locs = append(locs, cs.LookupRet(val))
+		}
+	}
+
+	return locs
+}
+
+func (loc CoreLoc) String() string {
+	s := fmt.Sprintf("0x%08x", loc.Addr)
+	if loc.Func != "" {
+		s += fmt.Sprintf(" %s+0x%x", loc.Func, loc.FuncOff)
+	}
+	if loc.File != "" {
+		s += fmt.Sprintf(" (%s:%d)", loc.File, loc.Line)
+	}
+
+	return s
+}
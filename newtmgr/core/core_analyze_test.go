@@ -0,0 +1,206 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package core
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func appendTestTlv(buf *bytes.Buffer, tlvType uint8, off uint32,
+	data []byte) {
+
+	tlv := make([]byte, 8)
+	tlv[0] = tlvType
+	binary.LittleEndian.PutUint16(tlv[2:4], uint16(len(data)))
+	binary.LittleEndian.PutUint32(tlv[4:8], off)
+
+	buf.Write(tlv)
+	buf.Write(data)
+}
+
+func buildTestCore(magic uint32, tlvs func(buf *bytes.Buffer)) []byte {
+	body := bytes.NewBuffer(nil)
+	tlvs(body)
+
+	hdr := make([]byte, 8)
+	binary.LittleEndian.PutUint32(hdr[0:4], magic)
+	binary.LittleEndian.PutUint32(hdr[4:8], uint32(len(hdr)+body.Len()))
+
+	return append(hdr, body.Bytes()...)
+}
+
+func writeTestCore(t *testing.T, data []byte) string {
+	f, err := ioutil.TempFile("", "newtmgr-core-test")
+	if err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+	defer f.Close()
+
+	if _, err := f.Write(data); err != nil {
+		os.Remove(f.Name())
+		t.Fatalf("%s", err.Error())
+	}
+
+	return f.Name()
+}
+
+func TestReadCoreDump(t *testing.T) {
+	hash := bytes.Repeat([]byte{0xab}, 32)
+
+	regs := make([]byte, 17*4)
+	for i := 0; i < 17; i++ {
+		binary.LittleEndian.PutUint32(regs[i*4:], uint32(0x100+i))
+	}
+
+	mem := make([]byte, 16)
+	binary.LittleEndian.PutUint32(mem[4:8], 0xdeadbeef)
+
+	data := buildTestCore(COREDUMP_MAGIC, func(buf *bytes.Buffer) {
+		appendTestTlv(buf, COREDUMP_TLV_IMAGE, 0, hash)
+		appendTestTlv(buf, COREDUMP_TLV_REGS, 0, regs)
+		appendTestTlv(buf, COREDUMP_TLV_MEM, 0x20000000, mem)
+	})
+
+	filename := writeTestCore(t, data)
+	defer os.Remove(filename)
+
+	cd, err := ReadCoreDump(filename)
+	if err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+
+	if !bytes.Equal(cd.ImageHash, hash) {
+		t.Errorf("image hash %x; want %x", cd.ImageHash, hash)
+	}
+	if len(cd.Regs) != 17 {
+		t.Fatalf("%d registers; want 17", len(cd.Regs))
+	}
+	if pc, ok := cd.Reg(COREDUMP_REG_PC); !ok || pc != 0x10f {
+		t.Errorf("pc = 0x%x; want 0x10f", pc)
+	}
+	if len(cd.Mems) != 1 || cd.Mems[0].Addr != 0x20000000 {
+		t.Fatalf("unexpected memory regions: %+v", cd.Mems)
+	}
+	if w, ok := cd.ReadWord(0x20000004); !ok || w != 0xdeadbeef {
+		t.Errorf("word at 0x20000004 = 0x%x; want 0xdeadbeef", w)
+	}
+	if _, ok := cd.ReadWord(0x2000000e); ok {
+		t.Errorf("read a word that extends past the end of memory")
+	}
+}
+
+func TestReadCoreDumpInvalid(t *testing.T) {
+	regs := make([]byte, 8)
+
+	tests := map[string][]byte{
+		"bad magic": buildTestCore(0x12345678, func(buf *bytes.Buffer) {
+			appendTestTlv(buf, COREDUMP_TLV_REGS, 0, regs)
+		}),
+		"unknown tlv": buildTestCore(COREDUMP_MAGIC,
+			func(buf *bytes.Buffer) {
+				appendTestTlv(buf, 99, 0, regs)
+			}),
+		"odd register size": buildTestCore(COREDUMP_MAGIC,
+			func(buf *bytes.Buffer) {
+				appendTestTlv(buf, COREDUMP_TLV_REGS, 0, regs[:6])
+			}),
+	}
+
+	// A TLV that claims more data than the file contains.
+	short := buildTestCore(COREDUMP_MAGIC, func(buf *bytes.Buffer) {
+		appendTestTlv(buf, COREDUMP_TLV_REGS, 0, regs)
+	})
+	tests["short tlv"] = short[:len(short)-1]
+
+	for name, data := range tests {
+		filename := writeTestCore(t, data)
+		_, err := ReadCoreDump(filename)
+		os.Remove(filename)
+
+		if err == nil {
+			t.Errorf("%s: read succeeded", name)
+		}
+	}
+}
+
+func TestExceptionString(t *testing.T) {
+	tests := map[uint32]string{
+		0x61000000: "Thread",
+		0x61000003: "HardFault",
+		0x61000015: "IRQ5",
+	}
+
+	for xpsr, want := range tests {
+		if got := ExceptionString(xpsr); got != want {
+			t.Errorf("ExceptionString(0x%08x) = %s; want %s", xpsr, got,
+				want)
+		}
+	}
+}
+
+func TestCoreArchs(t *testing.T) {
+	for _, name := range CoreArchNames() {
+		arch, err := CoreArchFromString(name)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err.Error())
+		}
+
+		if n := len(arch.RegNames); n > arch.NumRegs-arch.RegOff {
+			t.Errorf("%s: %d register names don't fit in pr_reg", name, n)
+		}
+		for _, idx := range []int{arch.PcReg, arch.LrReg, arch.SpReg} {
+			if idx < 0 || idx >= len(arch.RegNames) {
+				t.Errorf("%s: register index %d out of range", name, idx)
+			}
+		}
+
+		if a, err := CoreArchFromMachine(arch.Machine); err != nil ||
+			a != arch {
+
+			t.Errorf("%s: lookup by machine type %s failed", name,
+				arch.Machine.String())
+		}
+	}
+
+	if _, err := CoreArchFromMachine(elf.EM_X86_64); err == nil {
+		t.Errorf("accepted unsupported machine type")
+	}
+
+	riscv, _ := CoreArchFromString("riscv")
+	if riscv.RegNames[riscv.PcReg] != "pc" ||
+		riscv.RegNames[riscv.LrReg] != "ra" ||
+		riscv.RegNames[riscv.SpReg] != "sp" {
+
+		t.Errorf("wrong riscv register indices")
+	}
+
+	mips, _ := CoreArchFromString("mips")
+	if mips.RegNames[mips.PcReg] != "epc" ||
+		mips.RegNames[mips.LrReg] != "ra" ||
+		mips.RegNames[mips.SpReg] != "sp" {
+
+		t.Errorf("wrong mips register indices")
+	}
+}
@@ -26,14 +26,125 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strings"
 
 	"mynewt.apache.org/newt/util"
 )
 
+// Describes how an architecture's registers are laid out in the NT_PRSTATUS
+// note that gdb expects.  The REGS TLV is copied into pr_reg starting at
+// RegOff; the device is expected to dump its registers in the same order as
+// gdb.
+type CoreArch struct {
+	Name    string
+	Machine elf.Machine
+
+	// Number of words in pr_reg.
+	NumRegs int
+
+	// Index in pr_reg of the first register in the REGS TLV.
+	RegOff int
+
+	// Names of the registers in the REGS TLV, in order.
+	RegNames []string
+
+	// Indices in the REGS TLV of the program counter, the register holding
+	// the return address and the stack pointer.
+	PcReg int
+	LrReg int
+	SpReg int
+}
+
+var coreArchs = []*CoreArch{
+	&CoreArch{
+		Name:    CORE_ARCH_CORTEX_M,
+		Machine: elf.EM_ARM,
+		NumRegs: 18,
+		RegOff:  0,
+		RegNames: []string{
+			"r0", "r1", "r2", "r3", "r4", "r5", "r6", "r7",
+			"r8", "r9", "r10", "r11", "r12", "sp", "lr", "pc",
+			"xpsr",
+		},
+		PcReg: COREDUMP_REG_PC,
+		LrReg: COREDUMP_REG_LR,
+		SpReg: COREDUMP_REG_SP,
+	},
+
+	&CoreArch{
+		Name:    "riscv",
+		Machine: elf.EM_RISCV,
+		NumRegs: 32,
+		RegOff:  0,
+		RegNames: []string{
+			"pc", "ra", "sp", "gp", "tp", "t0", "t1", "t2",
+			"s0", "s1", "a0", "a1", "a2", "a3", "a4", "a5",
+			"a6", "a7", "s2", "s3", "s4", "s5", "s6", "s7",
+			"s8", "s9", "s10", "s11", "t3", "t4", "t5", "t6",
+		},
+		PcReg: 0,
+		LrReg: 1,
+		SpReg: 2,
+	},
+
+	&CoreArch{
+		Name:    "mips",
+		Machine: elf.EM_MIPS,
+		NumRegs: 45,
+		RegOff:  6,
+		RegNames: []string{
+			"zero", "at", "v0", "v1", "a0", "a1", "a2", "a3",
+			"t0", "t1", "t2", "t3", "t4", "t5", "t6", "t7",
+			"s0", "s1", "s2", "s3", "s4", "s5", "s6", "s7",
+			"t8", "t9", "k0", "k1", "gp", "sp", "s8", "ra",
+			"lo", "hi", "epc", "badvaddr", "status", "cause",
+		},
+		PcReg: 34,
+		LrReg: 31,
+		SpReg: 29,
+	},
+}
+
+const CORE_ARCH_CORTEX_M = "cortex_m"
+const CORE_ARCH_DFLT = CORE_ARCH_CORTEX_M
+
+func CoreArchFromString(name string) (*CoreArch, error) {
+	for _, a := range coreArchs {
+		if a.Name == name {
+			return a, nil
+		}
+	}
+
+	return nil, util.FmtNewtError("Unknown core architecture \"%s\"; "+
+		"must be one of: %s", name, strings.Join(CoreArchNames(), ", "))
+}
+
+// Finds the architecture of an ELF file's machine type.
+func CoreArchFromMachine(machine elf.Machine) (*CoreArch, error) {
+	for _, a := range coreArchs {
+		if a.Machine == machine {
+			return a, nil
+		}
+	}
+
+	return nil, util.FmtNewtError("Unsupported ELF machine type %s",
+		machine.String())
+}
+
+func CoreArchNames() []string {
+	names := make([]string, len(coreArchs))
+	for i, a := range coreArchs {
+		names[i] = a.Name
+	}
+
+	return names
+}
+
 type CoreConvert struct {
 	Source    *os.File
 	Target    *os.File
 	ImageHash []byte
+	Arch      *CoreArch
 	elfHdr    *elf.Header32
 	phdrs     []*elf.Prog32
 	data      [][]byte
@@ -62,7 +173,11 @@ type CoreDumpTlv struct {
 }
 
 func NewCoreConvert() *CoreConvert {
-	return &CoreConvert{}
+	arch, _ := CoreArchFromString(CORE_ARCH_DFLT)
+
+	return &CoreConvert{
+		Arch: arch,
+	}
 }
 
 func (cc *CoreConvert) readHdr() error {
@@ -134,7 +249,7 @@ func (cc *CoreConvert) makeElfHdr() {
 	hdr.Ident[elf.EI_ABIVERSION] = 0
 	hdr.Ident[elf.EI_PAD] = 0
 	hdr.Type = uint16(elf.ET_CORE)
-	hdr.Machine = uint16(elf.EM_ARM)
+	hdr.Machine = uint16(cc.Arch.Machine)
 	hdr.Version = uint32(elf.EV_CURRENT)
 	hdr.Entry = 0
 	hdr.Phoff = uint32(binary.Size(hdr))
@@ -179,25 +294,23 @@ func (cc *CoreConvert) makeRegData(regs []byte) []byte {
 		Ntype  uint32
 	}
 
-	type Elf32_Prstatus struct {
-		Dummy  [18]uint32
-		Regs   [18]uint32
-		Dummy2 uint32
-	}
+	// elf_prstatus is a fixed-size header, pr_reg, and pr_fpvalid.  Only the
+	// size of pr_reg varies between architectures.
+	var prHdr [18]uint32
+	prRegs := make([]uint32, cc.Arch.NumRegs)
+	var prFpvalid uint32
 
-	var note Elf32_Note
-	var sts Elf32_Prstatus
-
-	idx := 0
+	idx := cc.Arch.RegOff
 	for off := 0; off < len(regs); off += 4 {
-		reg := binary.LittleEndian.Uint32(regs[off : off+4])
-		sts.Regs[idx] = reg
-		idx++
-		if idx >= 18 {
+		if idx >= len(prRegs) {
 			break
 		}
+		prRegs[idx] = binary.LittleEndian.Uint32(regs[off : off+4])
+		idx++
 	}
 
+	var note Elf32_Note
+
 	noteName := ".reg"
 	noteLen := len(noteName) + 1
 	if noteLen%4 != 0 {
@@ -207,13 +320,16 @@ func (cc *CoreConvert) makeRegData(regs []byte) []byte {
 	copy(noteBytes[:], noteName)
 
 	note.Namesz = uint32(len(noteName) + 1) /* include terminating '\0' */
-	note.Descsz = uint32(binary.Size(sts))
+	note.Descsz = uint32(binary.Size(prHdr) + binary.Size(prRegs) +
+		binary.Size(prFpvalid))
 	note.Ntype = uint32(elf.NT_PRSTATUS)
 
 	buffer := new(bytes.Buffer)
 	binary.Write(buffer, binary.LittleEndian, note)
 	buffer.Write(noteBytes)
-	binary.Write(buffer, binary.LittleEndian, sts)
+	binary.Write(buffer, binary.LittleEndian, prHdr)
+	binary.Write(buffer, binary.LittleEndian, prRegs)
+	binary.Write(buffer, binary.LittleEndian, prFpvalid)
 	return buffer.Bytes()
 }
 
@@ -306,7 +422,15 @@ func (cc *CoreConvert) Convert() error {
 func ConvertFilenames(srcFilename string,
 	dstFilename string) (*CoreConvert, error) {
 
+	arch, _ := CoreArchFromString(CORE_ARCH_DFLT)
+	return ConvertFilenamesArch(srcFilename, dstFilename, arch)
+}
+
+func ConvertFilenamesArch(srcFilename string, dstFilename string,
+	arch *CoreArch) (*CoreConvert, error) {
+
 	coreConvert := NewCoreConvert()
+	coreConvert.Arch = arch
 
 	var err error
 
@@ -0,0 +1,367 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package daemon
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/runtimeco/go-coap"
+
+	"mynewt.apache.org/newtmgr/nmxact/nmp"
+	"mynewt.apache.org/newtmgr/nmxact/nmxutil"
+	"mynewt.apache.org/newtmgr/nmxact/sesn"
+)
+
+// Talks to a daemon.  The address is either the path of the daemon's Unix
+// socket or an "http://host:port" URL.
+type Client struct {
+	base  string
+	hc    *http.Client
+	token string
+}
+
+// Finds the access token for a TCP daemon: the environment variable if it
+// is set, or else the default token file.
+func clientToken() string {
+	if token := os.Getenv(TOKEN_ENV); token != "" {
+		return token
+	}
+
+	b, err := ioutil.ReadFile(DefaultTokenPath())
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(b))
+}
+
+func NewClient(addr string) *Client {
+	if strings.HasPrefix(addr, "http://") {
+		return &Client{
+			base:  strings.TrimSuffix(addr, "/"),
+			hc:    &http.Client{},
+			token: clientToken(),
+		}
+	}
+
+	return &Client{
+		// The host is ignored; all connections go to the socket.
+		base: "http://newtmgr",
+		hc: &http.Client{
+			Transport: &http.Transport{
+				Dial: func(network, a string) (net.Conn, error) {
+					return net.Dial("unix", addr)
+				},
+			},
+		},
+	}
+}
+
+func rspToError(rsp *http.Response, body []byte) error {
+	var er ErrRsp
+	if err := json.Unmarshal(body, &er); err != nil || er.Error == "" {
+		return nmxutil.NewXportError(fmt.Sprintf(
+			"daemon returned %s", rsp.Status))
+	}
+
+	switch er.Kind {
+	case ERR_KIND_TIMEOUT:
+		return nmxutil.NewRspTimeoutError(er.Error)
+	case ERR_KIND_CLOSED:
+		return nmxutil.NewSesnClosedError(er.Error)
+	default:
+		return fmt.Errorf("%s", er.Error)
+	}
+}
+
+func (c *Client) authorize(hreq *http.Request) {
+	if c.token != "" {
+		hreq.Header.Set("Authorization", "Bearer "+c.token)
+	}
+}
+
+// Sends a JSON request to the daemon and decodes the JSON response into rsp
+// (if non-nil).
+func (c *Client) call(method string, path string, req interface{},
+	rsp interface{}) error {
+
+	var body []byte
+	if req != nil {
+		var err error
+		body, err = json.Marshal(req)
+		if err != nil {
+			return err
+		}
+	}
+
+	hreq, err := http.NewRequest(method, c.base+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	hreq.Header.Set("Content-Type", "application/json")
+	c.authorize(hreq)
+
+	hrsp, err := c.hc.Do(hreq)
+	if err != nil {
+		return nmxutil.NewXportError(
+			"failed to contact newtmgr daemon: " + err.Error())
+	}
+	defer hrsp.Body.Close()
+
+	rbody, err := ioutil.ReadAll(hrsp.Body)
+	if err != nil {
+		return nmxutil.NewXportError(err.Error())
+	}
+
+	if hrsp.StatusCode != http.StatusOK {
+		return rspToError(hrsp, rbody)
+	}
+
+	if rsp != nil {
+		if err := json.Unmarshal(rbody, rsp); err != nil {
+			return fmt.Errorf("invalid response from daemon: %s",
+				err.Error())
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) Open(cs ConnSpec) (OpenRsp, error) {
+	var rsp OpenRsp
+	err := c.call("POST", PATH_OPEN, &OpenReq{Conn: cs}, &rsp)
+	return rsp, err
+}
+
+// Closes the daemon's session with the specified peer.
+func (c *Client) Close(cs ConnSpec) error {
+	return c.call("POST", PATH_CLOSE, &CloseReq{Conn: cs}, nil)
+}
+
+func (c *Client) Sessions() ([]SesnInfo, error) {
+	var infos []SesnInfo
+	err := c.call("GET", PATH_SESSIONS, nil, &infos)
+	return infos, err
+}
+
+// Streams daemon events to the callback until the connection fails or the
+// callback returns false.
+func (c *Client) Events(cb func(e Event) bool) error {
+	hreq, err := http.NewRequest("GET", c.base+PATH_EVENTS, nil)
+	if err != nil {
+		return err
+	}
+	c.authorize(hreq)
+
+	hrsp, err := c.hc.Do(hreq)
+	if err != nil {
+		return nmxutil.NewXportError(
+			"failed to contact newtmgr daemon: " + err.Error())
+	}
+	defer hrsp.Body.Close()
+
+	if hrsp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(hrsp.Body)
+		return rspToError(hrsp, body)
+	}
+
+	scanner := bufio.NewScanner(hrsp.Body)
+	for scanner.Scan() {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return fmt.Errorf("invalid event from daemon: %s", err.Error())
+		}
+		if !cb(e) {
+			return nil
+		}
+	}
+
+	return scanner.Err()
+}
+
+// A session whose requests are carried out by a daemon.  Closing a
+// DaemonSesn leaves the daemon's session open so that later clients can
+// reuse it.
+type DaemonSesn struct {
+	clt    *Client
+	conn   ConnSpec
+	info   OpenRsp
+	isOpen bool
+
+	// Protects info and isOpen.
+	m sync.Mutex
+}
+
+func NewDaemonSesn(addr string, cs ConnSpec) *DaemonSesn {
+	return &DaemonSesn{
+		clt:  NewClient(addr),
+		conn: cs,
+	}
+}
+
+func (s *DaemonSesn) Open() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.isOpen {
+		return nmxutil.NewSesnAlreadyOpenError(
+			"Attempt to open an already-open daemon session")
+	}
+
+	info, err := s.clt.Open(s.conn)
+	if err != nil {
+		return err
+	}
+
+	s.info = info
+	s.isOpen = true
+	return nil
+}
+
+func (s *DaemonSesn) Close() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if !s.isOpen {
+		return nmxutil.NewSesnClosedError(
+			"Attempt to close an unopened daemon session")
+	}
+
+	s.isOpen = false
+	return nil
+}
+
+func (s *DaemonSesn) IsOpen() bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.isOpen
+}
+
+// Retrieves the session parameters the daemon reported when the session was
+// opened.
+func (s *DaemonSesn) curInfo() OpenRsp {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.info
+}
+
+func (s *DaemonSesn) MtuIn() int {
+	return s.curInfo().MtuIn
+}
+
+func (s *DaemonSesn) MtuOut() int {
+	return s.curInfo().MtuOut
+}
+
+func (s *DaemonSesn) MgmtProto() sesn.MgmtProto {
+	if s.curInfo().MgmtProto == MGMT_PROTO_OMP {
+		return sesn.MGMT_PROTO_OMP
+	}
+	return sesn.MGMT_PROTO_NMP
+}
+
+func (s *DaemonSesn) CoapIsTcp() bool {
+	return s.curInfo().CoapTcp
+}
+
+func (s *DaemonSesn) AbortRx(seq uint8) error {
+	return s.clt.call("POST", PATH_ABORT,
+		&AbortReq{Conn: s.conn, Seq: int(seq)}, nil)
+}
+
+// Records a closed-session error so that callers reopen before the next
+// request.
+func (s *DaemonSesn) checkErr(err error) error {
+	if nmxutil.IsSesnClosed(err) {
+		s.m.Lock()
+		s.isOpen = false
+		s.m.Unlock()
+	}
+	return err
+}
+
+func (s *DaemonSesn) TxNmpOnce(m *nmp.NmpMsg, opt sesn.TxOptions) (
+	nmp.NmpRsp, error) {
+
+	if !s.IsOpen() {
+		return nil, nmxutil.NewSesnClosedError(
+			"Attempt to transmit over closed daemon session")
+	}
+
+	data, err := nmp.EncodeNmpPlain(m)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &NmpReq{
+		Conn:    s.conn,
+		Timeout: opt.Timeout.Seconds(),
+		Data:    data,
+	}
+	var rsp NmpRsp
+	if err := s.clt.call("POST", PATH_NMP, req, &rsp); err != nil {
+		return nil, s.checkErr(err)
+	}
+
+	hdr, err := nmp.DecodeNmpHdr(rsp.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	return nmp.DecodeRspBody(hdr, rsp.Data[nmp.NMP_HDR_SIZE:])
+}
+
+func (s *DaemonSesn) TxCoapOnce(m coap.Message, resType sesn.ResourceType,
+	opt sesn.TxOptions) (coap.COAPCode, []byte, error) {
+
+	if !s.IsOpen() {
+		return 0, nil, nmxutil.NewSesnClosedError(
+			"Attempt to transmit over closed daemon session")
+	}
+
+	data, err := m.MarshalBinary()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	req := &CoapReq{
+		Conn:    s.conn,
+		Timeout: opt.Timeout.Seconds(),
+		ResType: resType.String(),
+		Data:    data,
+	}
+	var rsp CoapRsp
+	if err := s.clt.call("POST", PATH_COAP, req, &rsp); err != nil {
+		return 0, nil, s.checkErr(err)
+	}
+
+	return coap.COAPCode(rsp.Code), rsp.Payload, nil
+}
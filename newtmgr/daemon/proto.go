@@ -0,0 +1,208 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+// Package daemon implements a long-running process that owns newtmgr
+// transports and sessions, and a client session that forwards management
+// requests to it.  Clients talk to the daemon using JSON over HTTP, either
+// on a Unix domain socket or on a local TCP port.  The socket is only
+// accessible to its owner; TCP clients must present the daemon's access
+// token as a bearer token.
+package daemon
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/mitchellh/go-homedir"
+)
+
+const API_PREFIX = "/v1"
+
+const (
+	PATH_OPEN     = API_PREFIX + "/open"
+	PATH_CLOSE    = API_PREFIX + "/close"
+	PATH_NMP      = API_PREFIX + "/nmp"
+	PATH_COAP     = API_PREFIX + "/coap"
+	PATH_ABORT    = API_PREFIX + "/abort"
+	PATH_SESSIONS = API_PREFIX + "/sessions"
+	PATH_EVENTS   = API_PREFIX + "/events"
+)
+
+// Environment variable that supplies the access token to TCP clients.  If it
+// isn't set, clients read the default token file.
+const TOKEN_ENV = "NEWTMGR_DAEMON_TOKEN"
+
+const (
+	MGMT_PROTO_NMP = "nmp"
+	MGMT_PROTO_OMP = "omp"
+)
+
+// Error kinds let clients tell apart the failures that the nmxact retry logic
+// cares about.
+const (
+	ERR_KIND_TIMEOUT = "timeout"
+	ERR_KIND_CLOSED  = "closed"
+	ERR_KIND_OTHER   = "other"
+)
+
+const (
+	EVENT_OPEN  = "open"
+	EVENT_CLOSE = "close"
+	EVENT_TX    = "tx"
+	EVENT_RSP   = "rsp"
+	EVENT_ERROR = "error"
+)
+
+// Identifies a peer.  Requests that specify the same connection share a
+// session; sessions with the same transport (e.g., all BLE peers) share the
+// transport.
+type ConnSpec struct {
+	Type       string `json:"type"`
+	ConnString string `json:"connstring"`
+
+	// Overrides the profile's peer name (--name).
+	Name string `json:"name,omitempty"`
+
+	// Use acked BLE writes (--write-rsp).
+	WriteRsp bool `json:"write_rsp,omitempty"`
+}
+
+func (cs ConnSpec) Key() string {
+	return fmt.Sprintf("%s|%s|%s|%t", cs.Type, cs.ConnString, cs.Name,
+		cs.WriteRsp)
+}
+
+func (cs ConnSpec) String() string {
+	s := fmt.Sprintf("type=%s connstring=%s", cs.Type, cs.ConnString)
+	if cs.Name != "" {
+		s += " name=" + cs.Name
+	}
+	return s
+}
+
+type OpenReq struct {
+	Conn ConnSpec `json:"conn"`
+}
+
+type OpenRsp struct {
+	Id        int    `json:"id"`
+	MtuIn     int    `json:"mtu_in"`
+	MtuOut    int    `json:"mtu_out"`
+	MgmtProto string `json:"mgmt_proto"`
+	CoapTcp   bool   `json:"coap_tcp"`
+}
+
+type CloseReq struct {
+	Conn ConnSpec `json:"conn"`
+}
+
+// Carries a single NMP request; the data is a complete NMP packet (header
+// followed by CBOR body).  The response carries the peer's NMP response in
+// the same form.
+type NmpReq struct {
+	Conn    ConnSpec `json:"conn"`
+	Timeout float64  `json:"timeout"`
+	Data    []byte   `json:"data"`
+}
+
+type NmpRsp struct {
+	Data []byte `json:"data"`
+}
+
+// Carries a single CoAP request; the data is an encoded CoAP message in the
+// form the session expects (see OpenRsp.CoapTcp).
+type CoapReq struct {
+	Conn    ConnSpec `json:"conn"`
+	Timeout float64  `json:"timeout"`
+	ResType string   `json:"res_type"`
+	Data    []byte   `json:"data"`
+}
+
+type CoapRsp struct {
+	Code    int    `json:"code"`
+	Payload []byte `json:"payload"`
+}
+
+type AbortReq struct {
+	Conn ConnSpec `json:"conn"`
+	Seq  int      `json:"seq"`
+}
+
+type ErrRsp struct {
+	Error string `json:"error"`
+	Kind  string `json:"kind"`
+}
+
+type SesnInfo struct {
+	Id       int       `json:"id"`
+	Conn     ConnSpec  `json:"conn"`
+	Open     bool      `json:"open"`
+	Pending  int       `json:"pending"`
+	TxCount  int       `json:"tx_count"`
+	Created  time.Time `json:"created"`
+	LastUsed time.Time `json:"last_used"`
+}
+
+// Reports session activity.  The events endpoint streams these as one JSON
+// object per line.
+type Event struct {
+	Time    time.Time `json:"time"`
+	Type    string    `json:"type"`
+	Sesn    int       `json:"sesn"`
+	Conn    ConnSpec  `json:"conn"`
+	Pending int       `json:"pending"`
+
+	// NMP request details; zero for CoAP requests.
+	Op    int `json:"op,omitempty"`
+	Group int `json:"group,omitempty"`
+	Id    int `json:"id,omitempty"`
+	Seq   int `json:"seq,omitempty"`
+
+	// CoAP request details.
+	Uri string `json:"uri,omitempty"`
+
+	// Offset reported by upload responses; lets listeners follow the
+	// progress of a transfer driven by another client.
+	Off int `json:"off,omitempty"`
+
+	Rc  int    `json:"rc,omitempty"`
+	Err string `json:"err,omitempty"`
+}
+
+// The socket a daemon listens on by default (~/.newtmgr.daemon.sock).
+func DefaultSockPath() string {
+	dir, err := homedir.Dir()
+	if err != nil {
+		return "/tmp/newtmgr.daemon.sock"
+	}
+
+	return filepath.Join(dir, ".newtmgr.daemon.sock")
+}
+
+// The file a daemon writes its TCP access token to by default
+// (~/.newtmgr.daemon.token).
+func DefaultTokenPath() string {
+	dir, err := homedir.Dir()
+	if err != nil {
+		return "/tmp/newtmgr.daemon.token"
+	}
+
+	return filepath.Join(dir, ".newtmgr.daemon.token")
+}
@@ -0,0 +1,802 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package daemon
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/runtimeco/go-coap"
+	"github.com/ugorji/go/codec"
+
+	"mynewt.apache.org/newt/util"
+	"mynewt.apache.org/newtmgr/nmxact/nmp"
+	"mynewt.apache.org/newtmgr/nmxact/nmxutil"
+	"mynewt.apache.org/newtmgr/nmxact/sesn"
+	"mynewt.apache.org/newtmgr/nmxact/xport"
+)
+
+type ServerCfg struct {
+	// Path of the Unix domain socket to listen on.
+	SockPath string
+
+	// Optional TCP address to listen on as well (e.g., "127.0.0.1:8900").
+	// It must be a loopback address unless AllowRemote is set.
+	HttpAddr    string
+	AllowRemote bool
+
+	// File to write the access token for TCP clients to.  The token is
+	// generated when the server starts.
+	TokenPath string
+
+	// Maps a connection to the transport it uses.  Connections that map to
+	// the same key share a transport.
+	XportKey func(cs ConnSpec) (string, error)
+
+	// Creates a transport for a connection.  The server starts it.
+	BuildXport func(cs ConnSpec) (xport.Xport, error)
+
+	// Creates a session for a connection over its transport.  The server
+	// opens it.
+	BuildSesn func(cs ConnSpec, x xport.Xport) (sesn.Sesn, error)
+}
+
+type serverSesn struct {
+	id   int
+	conn ConnSpec
+
+	// Written with both txMtx and Server.mtx held, so holding either is
+	// enough to read it.
+	s sesn.Sesn
+
+	// Serializes requests to the peer; requests queue here.
+	txMtx sync.Mutex
+
+	// Protected by Server.mtx.
+	pending  int
+	txCount  int
+	created  time.Time
+	lastUsed time.Time
+}
+
+type Server struct {
+	cfg ServerCfg
+
+	// Protects the maps and the session counters.
+	mtx    sync.Mutex
+	xports map[string]xport.Xport
+	sesns  map[string]*serverSesn
+	evChs  map[chan Event]struct{}
+	nextId int
+
+	// Serializes transport and session creation.  Building a session can
+	// take a while (e.g., scanning for a BLE peer by name) and may depend
+	// on global settings.
+	buildMtx sync.Mutex
+
+	listeners []net.Listener
+
+	// Required of TCP clients.
+	token string
+}
+
+func NewServer(cfg ServerCfg) *Server {
+	return &Server{
+		cfg:    cfg,
+		xports: map[string]xport.Xport{},
+		sesns:  map[string]*serverSesn{},
+		evChs:  map[chan Event]struct{}{},
+		nextId: 1,
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeErr(w http.ResponseWriter, err error) {
+	kind := ERR_KIND_OTHER
+	status := http.StatusInternalServerError
+
+	switch {
+	case nmxutil.IsRspTimeout(err):
+		kind = ERR_KIND_TIMEOUT
+		status = http.StatusGatewayTimeout
+	case nmxutil.IsSesnClosed(err), nmxutil.IsBleSesnDisconnect(err):
+		kind = ERR_KIND_CLOSED
+		status = http.StatusBadGateway
+	}
+
+	writeJSON(w, status, &ErrRsp{Error: err.Error(), Kind: kind})
+}
+
+func readReq(w http.ResponseWriter, r *http.Request, req interface{}) bool {
+	if r.Method != "POST" {
+		writeJSON(w, http.StatusMethodNotAllowed,
+			&ErrRsp{Error: "method not allowed", Kind: ERR_KIND_OTHER})
+		return false
+	}
+
+	// Browsers can only send other content types cross-origin without a
+	// preflight.
+	ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if ct != "application/json" {
+		writeJSON(w, http.StatusUnsupportedMediaType, &ErrRsp{
+			Error: "content type must be application/json",
+			Kind:  ERR_KIND_OTHER,
+		})
+		return false
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeJSON(w, http.StatusBadRequest, &ErrRsp{
+			Error: "invalid request: " + err.Error(),
+			Kind:  ERR_KIND_OTHER,
+		})
+		return false
+	}
+
+	return true
+}
+
+func secsToDuration(secs float64) time.Duration {
+	return time.Duration(secs * float64(time.Second))
+}
+
+func (srv *Server) emit(ss *serverSesn, e Event) {
+	e.Time = time.Now()
+	e.Sesn = ss.id
+	e.Conn = ss.conn
+
+	srv.mtx.Lock()
+	defer srv.mtx.Unlock()
+
+	e.Pending = ss.pending
+	for ch, _ := range srv.evChs {
+		// Drop the event rather than stall requests on a slow listener.
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+func (srv *Server) findSesn(cs ConnSpec, create bool) *serverSesn {
+	srv.mtx.Lock()
+	defer srv.mtx.Unlock()
+
+	key := cs.Key()
+	ss := srv.sesns[key]
+	if ss == nil && create {
+		ss = &serverSesn{
+			id:      srv.nextId,
+			conn:    cs,
+			created: time.Now(),
+		}
+		srv.nextId++
+		srv.sesns[key] = ss
+	}
+
+	return ss
+}
+
+func (srv *Server) getXport(cs ConnSpec) (xport.Xport, error) {
+	key, err := srv.cfg.XportKey(cs)
+	if err != nil {
+		return nil, err
+	}
+
+	srv.mtx.Lock()
+	x := srv.xports[key]
+	srv.mtx.Unlock()
+
+	if x != nil {
+		return x, nil
+	}
+
+	x, err = srv.cfg.BuildXport(cs)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debugf("Starting transport %s", key)
+	if err := x.Start(); err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+
+	srv.mtx.Lock()
+	srv.xports[key] = x
+	srv.mtx.Unlock()
+
+	return x, nil
+}
+
+// Opens the session if necessary.  The caller must hold the session's tx
+// lock.
+func (srv *Server) openSesn(ss *serverSesn) error {
+	if ss.s != nil && ss.s.IsOpen() {
+		return nil
+	}
+
+	if ss.s == nil {
+		var s sesn.Sesn
+
+		srv.buildMtx.Lock()
+		x, err := srv.getXport(ss.conn)
+		if err == nil {
+			s, err = srv.cfg.BuildSesn(ss.conn, x)
+		}
+		srv.buildMtx.Unlock()
+
+		if err != nil {
+			return err
+		}
+
+		srv.mtx.Lock()
+		ss.s = s
+		srv.mtx.Unlock()
+	}
+
+	if err := ss.s.Open(); err != nil && !nmxutil.IsSesnAlreadyOpen(err) {
+		return err
+	}
+
+	srv.emit(ss, Event{Type: EVENT_OPEN})
+	return nil
+}
+
+// Waits for the session to become free and opens it.  On success, the
+// caller must call release() when done.
+func (srv *Server) acquire(cs ConnSpec) (*serverSesn, error) {
+	ss := srv.findSesn(cs, true)
+
+	srv.mtx.Lock()
+	ss.pending++
+	srv.mtx.Unlock()
+
+	ss.txMtx.Lock()
+	if err := srv.openSesn(ss); err != nil {
+		srv.release(ss)
+		return nil, err
+	}
+
+	return ss, nil
+}
+
+func (srv *Server) release(ss *serverSesn) {
+	srv.mtx.Lock()
+	ss.pending--
+	ss.lastUsed = time.Now()
+	srv.mtx.Unlock()
+
+	ss.txMtx.Unlock()
+}
+
+func (srv *Server) openHandler(w http.ResponseWriter, r *http.Request) {
+	var req OpenReq
+	if !readReq(w, r, &req) {
+		return
+	}
+
+	ss, err := srv.acquire(req.Conn)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	defer srv.release(ss)
+
+	rsp := OpenRsp{
+		Id:        ss.id,
+		MtuIn:     ss.s.MtuIn(),
+		MtuOut:    ss.s.MtuOut(),
+		MgmtProto: MGMT_PROTO_NMP,
+		CoapTcp:   ss.s.CoapIsTcp(),
+	}
+	if ss.s.MgmtProto() == sesn.MGMT_PROTO_OMP {
+		rsp.MgmtProto = MGMT_PROTO_OMP
+	}
+
+	writeJSON(w, http.StatusOK, &rsp)
+}
+
+func (srv *Server) closeSesn(ss *serverSesn) error {
+	ss.txMtx.Lock()
+	defer ss.txMtx.Unlock()
+
+	if ss.s == nil || !ss.s.IsOpen() {
+		return nil
+	}
+
+	if err := ss.s.Close(); err != nil && !nmxutil.IsSesnClosed(err) {
+		return err
+	}
+
+	srv.emit(ss, Event{Type: EVENT_CLOSE})
+	return nil
+}
+
+func (srv *Server) closeHandler(w http.ResponseWriter, r *http.Request) {
+	var req CloseReq
+	if !readReq(w, r, &req) {
+		return
+	}
+
+	ss := srv.findSesn(req.Conn, false)
+	if ss == nil {
+		writeJSON(w, http.StatusNotFound, &ErrRsp{
+			Error: "no session for " + req.Conn.String(),
+			Kind:  ERR_KIND_OTHER,
+		})
+		return
+	}
+
+	if err := srv.closeSesn(ss); err != nil {
+		writeErr(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, struct{}{})
+}
+
+// Extracts the rc and off fields from a response, if present.
+func rspRcOff(rsp nmp.NmpRsp) (int, int) {
+	v := reflect.Indirect(reflect.ValueOf(rsp))
+	if v.Kind() != reflect.Struct {
+		return 0, 0
+	}
+
+	rc := 0
+	if f := v.FieldByName("Rc"); f.IsValid() && f.Kind() == reflect.Int {
+		rc = int(f.Int())
+	}
+
+	off := 0
+	if f := v.FieldByName("Off"); f.IsValid() && f.Kind() == reflect.Uint32 {
+		off = int(f.Uint())
+	}
+
+	return rc, off
+}
+
+// Decodes an NMP packet into a message whose body is keyed by wire name.
+func decodeNmpReq(data []byte) (*nmp.NmpMsg, error) {
+	hdr, err := nmp.DecodeNmpHdr(data)
+	if err != nil {
+		return nil, err
+	}
+
+	body := map[string]interface{}{}
+	if len(data) > nmp.NMP_HDR_SIZE {
+		ch := new(codec.CborHandle)
+		ch.MapType = reflect.TypeOf(body)
+
+		dec := codec.NewDecoderBytes(data[nmp.NMP_HDR_SIZE:], ch)
+		if err := dec.Decode(&body); err != nil {
+			return nil, fmt.Errorf("invalid NMP request body: %s",
+				err.Error())
+		}
+	}
+
+	return &nmp.NmpMsg{Hdr: *hdr, Body: body}, nil
+}
+
+func (srv *Server) nmpHandler(w http.ResponseWriter, r *http.Request) {
+	var req NmpReq
+	if !readReq(w, r, &req) {
+		return
+	}
+
+	m, err := decodeNmpReq(req.Data)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest,
+			&ErrRsp{Error: err.Error(), Kind: ERR_KIND_OTHER})
+		return
+	}
+
+	ss, err := srv.acquire(req.Conn)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+
+	ev := Event{
+		Type:  EVENT_TX,
+		Op:    int(m.Hdr.Op),
+		Group: int(m.Hdr.Group),
+		Id:    int(m.Hdr.Id),
+		Seq:   int(m.Hdr.Seq),
+	}
+	srv.emit(ss, ev)
+
+	opt := sesn.TxOptions{
+		Timeout: secsToDuration(req.Timeout),
+		Tries:   1,
+	}
+	rsp, err := ss.s.TxNmpOnce(m, opt)
+
+	srv.mtx.Lock()
+	ss.txCount++
+	srv.mtx.Unlock()
+	srv.release(ss)
+
+	if err != nil {
+		ev.Type = EVENT_ERROR
+		ev.Err = err.Error()
+		srv.emit(ss, ev)
+
+		writeErr(w, err)
+		return
+	}
+
+	body, err := nmp.BodyBytes(rsp)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+
+	hdr := *rsp.Hdr()
+	hdr.Len = uint16(len(body))
+
+	ev.Type = EVENT_RSP
+	ev.Rc, ev.Off = rspRcOff(rsp)
+	srv.emit(ss, ev)
+
+	writeJSON(w, http.StatusOK, &NmpRsp{Data: append(hdr.Bytes(), body...)})
+}
+
+func (srv *Server) coapHandler(w http.ResponseWriter, r *http.Request) {
+	var req CoapReq
+	if !readReq(w, r, &req) {
+		return
+	}
+
+	resType, err := sesn.ParseResType(req.ResType)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest,
+			&ErrRsp{Error: err.Error(), Kind: ERR_KIND_OTHER})
+		return
+	}
+
+	ss, err := srv.acquire(req.Conn)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+
+	var m coap.Message
+	if ss.s.CoapIsTcp() {
+		m, _, err = coap.PullTcp(req.Data)
+	} else {
+		m, err = coap.ParseDgramMessage(req.Data)
+	}
+	if err != nil {
+		srv.release(ss)
+		writeJSON(w, http.StatusBadRequest, &ErrRsp{
+			Error: "invalid CoAP request: " + err.Error(),
+			Kind:  ERR_KIND_OTHER,
+		})
+		return
+	}
+
+	ev := Event{Type: EVENT_TX, Uri: m.PathString()}
+	srv.emit(ss, ev)
+
+	opt := sesn.TxOptions{
+		Timeout: secsToDuration(req.Timeout),
+		Tries:   1,
+	}
+	code, payload, err := ss.s.TxCoapOnce(m, resType, opt)
+
+	srv.mtx.Lock()
+	ss.txCount++
+	srv.mtx.Unlock()
+	srv.release(ss)
+
+	if err != nil {
+		ev.Type = EVENT_ERROR
+		ev.Err = err.Error()
+		srv.emit(ss, ev)
+
+		writeErr(w, err)
+		return
+	}
+
+	ev.Type = EVENT_RSP
+	ev.Rc = int(code)
+	srv.emit(ss, ev)
+
+	writeJSON(w, http.StatusOK, &CoapRsp{Code: int(code), Payload: payload})
+}
+
+func (srv *Server) abortHandler(w http.ResponseWriter, r *http.Request) {
+	var req AbortReq
+	if !readReq(w, r, &req) {
+		return
+	}
+
+	// Don't take the tx lock; the request being aborted holds it.
+	ss := srv.findSesn(req.Conn, false)
+	var s sesn.Sesn
+	if ss != nil {
+		srv.mtx.Lock()
+		s = ss.s
+		srv.mtx.Unlock()
+	}
+	if s == nil {
+		writeJSON(w, http.StatusOK, struct{}{})
+		return
+	}
+
+	if err := s.AbortRx(uint8(req.Seq)); err != nil {
+		writeErr(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, struct{}{})
+}
+
+func (srv *Server) Sessions() []SesnInfo {
+	srv.mtx.Lock()
+	defer srv.mtx.Unlock()
+
+	infos := make([]SesnInfo, 0, len(srv.sesns))
+	for _, ss := range srv.sesns {
+		infos = append(infos, SesnInfo{
+			Id:       ss.id,
+			Conn:     ss.conn,
+			Open:     ss.s != nil && ss.s.IsOpen(),
+			Pending:  ss.pending,
+			TxCount:  ss.txCount,
+			Created:  ss.created,
+			LastUsed: ss.lastUsed,
+		})
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Id < infos[j].Id
+	})
+
+	return infos
+}
+
+func (srv *Server) sessionsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, srv.Sessions())
+}
+
+func (srv *Server) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSON(w, http.StatusInternalServerError,
+			&ErrRsp{Error: "streaming not supported", Kind: ERR_KIND_OTHER})
+		return
+	}
+
+	ch := make(chan Event, 64)
+
+	srv.mtx.Lock()
+	srv.evChs[ch] = struct{}{}
+	srv.mtx.Unlock()
+
+	defer func() {
+		srv.mtx.Lock()
+		delete(srv.evChs, ch)
+		srv.mtx.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	enc := json.NewEncoder(w)
+	done := r.Context().Done()
+	for {
+		select {
+		case e := <-ch:
+			if err := enc.Encode(&e); err != nil {
+				return
+			}
+			flusher.Flush()
+
+		case <-done:
+			return
+		}
+	}
+}
+
+// Checks whether another daemon is listening on the socket.
+func sockInUse(path string) bool {
+	c, err := net.DialTimeout("unix", path, time.Second)
+	if err != nil {
+		return false
+	}
+	c.Close()
+	return true
+}
+
+// Rejects requests made by web pages, and, if a token is specified,
+// requests that don't present it.
+func (srv *Server) guard(h http.Handler, token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Origin") != "" {
+			writeJSON(w, http.StatusForbidden, &ErrRsp{
+				Error: "cross-origin requests are not allowed",
+				Kind:  ERR_KIND_OTHER,
+			})
+			return
+		}
+
+		if token != "" {
+			auth := r.Header.Get("Authorization")
+			if subtle.ConstantTimeCompare(
+				[]byte(auth), []byte("Bearer "+token)) != 1 {
+
+				writeJSON(w, http.StatusUnauthorized, &ErrRsp{
+					Error: "missing or invalid access token",
+					Kind:  ERR_KIND_OTHER,
+				})
+				return
+			}
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Ensures a TCP listen address only accepts local connections.
+func checkLoopback(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+
+	return util.FmtNewtError(
+		"refusing to listen on non-loopback address %s; allow remote "+
+			"clients explicitly to do so", addr)
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", util.ChildNewtError(err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+func (srv *Server) Start() error {
+	mux := http.NewServeMux()
+	mux.HandleFunc(PATH_OPEN, srv.openHandler)
+	mux.HandleFunc(PATH_CLOSE, srv.closeHandler)
+	mux.HandleFunc(PATH_NMP, srv.nmpHandler)
+	mux.HandleFunc(PATH_COAP, srv.coapHandler)
+	mux.HandleFunc(PATH_ABORT, srv.abortHandler)
+	mux.HandleFunc(PATH_SESSIONS, srv.sessionsHandler)
+	mux.HandleFunc(PATH_EVENTS, srv.eventsHandler)
+
+	if sockInUse(srv.cfg.SockPath) {
+		return util.FmtNewtError("a daemon is already listening on %s",
+			srv.cfg.SockPath)
+	}
+
+	// Remove a stale socket left by a daemon that didn't exit cleanly.
+	os.Remove(srv.cfg.SockPath)
+
+	l, err := net.Listen("unix", srv.cfg.SockPath)
+	if err != nil {
+		return util.ChildNewtError(err)
+	}
+	if err := os.Chmod(srv.cfg.SockPath, 0600); err != nil {
+		l.Close()
+		return util.ChildNewtError(err)
+	}
+	srv.listeners = append(srv.listeners, l)
+
+	log.Infof("newtmgr daemon listening on %s", l.Addr().String())
+	go http.Serve(l, srv.guard(mux, ""))
+
+	if srv.cfg.HttpAddr != "" {
+		if !srv.cfg.AllowRemote {
+			if err := checkLoopback(srv.cfg.HttpAddr); err != nil {
+				srv.Stop()
+				return err
+			}
+		}
+
+		token, err := newToken()
+		if err != nil {
+			srv.Stop()
+			return err
+		}
+		srv.token = token
+
+		if srv.cfg.TokenPath != "" {
+			err := ioutil.WriteFile(srv.cfg.TokenPath, []byte(token), 0600)
+			if err != nil {
+				srv.Stop()
+				return util.ChildNewtError(err)
+			}
+		}
+
+		l, err := net.Listen("tcp", srv.cfg.HttpAddr)
+		if err != nil {
+			srv.Stop()
+			return util.ChildNewtError(err)
+		}
+		srv.listeners = append(srv.listeners, l)
+
+		log.Infof("newtmgr daemon listening on %s", l.Addr().String())
+		go http.Serve(l, srv.guard(mux, token))
+	}
+
+	return nil
+}
+
+// Stops listening and closes all sessions and transports.
+func (srv *Server) Stop() {
+	for _, l := range srv.listeners {
+		l.Close()
+	}
+	srv.listeners = nil
+	os.Remove(srv.cfg.SockPath)
+	if srv.token != "" && srv.cfg.TokenPath != "" {
+		os.Remove(srv.cfg.TokenPath)
+	}
+
+	srv.mtx.Lock()
+	sesns := make([]*serverSesn, 0, len(srv.sesns))
+	for _, ss := range srv.sesns {
+		sesns = append(sesns, ss)
+	}
+	xports := srv.xports
+	srv.xports = map[string]xport.Xport{}
+	srv.mtx.Unlock()
+
+	for _, ss := range sesns {
+		if err := srv.closeSesn(ss); err != nil {
+			log.Debugf("Error closing session %d: %s", ss.id, err.Error())
+		}
+	}
+
+	for _, x := range xports {
+		x.Stop()
+	}
+}
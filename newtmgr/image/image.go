@@ -0,0 +1,423 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+// Package image parses Mynewt / MCUboot image files.  Both the original
+// (version 1) format and the current (version 2) format are supported.
+package image
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"mynewt.apache.org/newt/util"
+)
+
+const (
+	IMAGE_MAGIC_V1 = 0x96f3b83c
+	IMAGE_MAGIC    = 0x96f3b83d
+)
+
+const IMAGE_HEADER_SIZE = 32
+
+// Precedes the TLVs in a version 2 image.
+const (
+	IMAGE_TLV_INFO_MAGIC = 0x6907
+	IMAGE_TLV_INFO_SIZE  = 4
+)
+
+const IMAGE_TLV_HDR_SIZE = 4
+
+/*
+ * Image header flags.
+ */
+const (
+	IMAGE_F_PIC                      = 0x00000001
+	IMAGE_F_SHA256                   = 0x00000002
+	IMAGE_F_PKCS15_RSA2048_SHA256    = 0x00000004
+	IMAGE_F_ECDSA224_SHA256          = 0x00000008
+	IMAGE_F_NON_BOOTABLE             = 0x00000010
+	IMAGE_F_ECDSA256_SHA256          = 0x00000020
+	IMAGE_F_PKCS1_PSS_RSA2048_SHA256 = 0x00000040
+)
+
+/*
+ * Image trailer TLV types (version 1).
+ */
+const (
+	IMAGE_TLV_V1_SHA256   = 1
+	IMAGE_TLV_V1_RSA2048  = 2
+	IMAGE_TLV_V1_ECDSA224 = 3
+	IMAGE_TLV_V1_ECDSA256 = 4
+)
+
+/*
+ * Image trailer TLV types (version 2).
+ */
+const (
+	IMAGE_TLV_KEYHASH  = 0x01
+	IMAGE_TLV_SHA256   = 0x10
+	IMAGE_TLV_RSA2048  = 0x20
+	IMAGE_TLV_ECDSA224 = 0x21
+	IMAGE_TLV_ECDSA256 = 0x22
+)
+
+var imageFlagNameMap = map[uint32]string{
+	IMAGE_F_PIC:                      "PIC",
+	IMAGE_F_SHA256:                   "SHA256",
+	IMAGE_F_PKCS15_RSA2048_SHA256:    "PKCS15_RSA2048_SHA256",
+	IMAGE_F_ECDSA224_SHA256:          "ECDSA224_SHA256",
+	IMAGE_F_NON_BOOTABLE:             "NON_BOOTABLE",
+	IMAGE_F_ECDSA256_SHA256:          "ECDSA256_SHA256",
+	IMAGE_F_PKCS1_PSS_RSA2048_SHA256: "PKCS1_PSS_RSA2048_SHA256",
+}
+
+var imageTlvV1NameMap = map[uint8]string{
+	IMAGE_TLV_V1_SHA256:   "SHA256",
+	IMAGE_TLV_V1_RSA2048:  "RSA2048",
+	IMAGE_TLV_V1_ECDSA224: "ECDSA224",
+	IMAGE_TLV_V1_ECDSA256: "ECDSA256",
+}
+
+var imageTlvNameMap = map[uint8]string{
+	IMAGE_TLV_KEYHASH:  "KEYHASH",
+	IMAGE_TLV_SHA256:   "SHA256",
+	IMAGE_TLV_RSA2048:  "RSA2048",
+	IMAGE_TLV_ECDSA224: "ECDSA224",
+	IMAGE_TLV_ECDSA256: "ECDSA256",
+}
+
+type ImageVersion struct {
+	Major    uint8
+	Minor    uint8
+	Rev      uint16
+	BuildNum uint32
+}
+
+type ImageHdr struct {
+	Magic uint32
+	TlvSz uint16 // Version 1 only.
+	KeyId uint8  // Version 1 only.
+	HdrSz uint16
+	ImgSz uint32
+	Flags uint32
+	Vers  ImageVersion
+}
+
+type ImageTlv struct {
+	Type uint8
+	Data []byte
+
+	// Offset of the TLV header within the image file.
+	Offset int
+}
+
+type Image struct {
+	Header ImageHdr
+	Body   []byte
+	Tlvs   []ImageTlv
+
+	// The full contents of the image file.
+	Data []byte
+}
+
+func (ver ImageVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d.%d",
+		ver.Major, ver.Minor, ver.Rev, ver.BuildNum)
+}
+
+// Returns a human-readable list of the flags that are set.
+func FlagsString(flags uint32) string {
+	strs := []string{}
+	for i := uint(0); i < 32; i++ {
+		f := uint32(1) << i
+		if flags&f == 0 {
+			continue
+		}
+
+		name := imageFlagNameMap[f]
+		if name == "" {
+			name = fmt.Sprintf("0x%08x", f)
+		}
+		strs = append(strs, name)
+	}
+
+	return strings.Join(strs, " ")
+}
+
+func (img *Image) IsV1() bool {
+	return img.Header.Magic == IMAGE_MAGIC_V1
+}
+
+// Returns the name of the specified TLV type.
+func (img *Image) TlvTypeName(tlvType uint8) string {
+	var name string
+	if img.IsV1() {
+		name = imageTlvV1NameMap[tlvType]
+	} else {
+		name = imageTlvNameMap[tlvType]
+	}
+
+	if name == "" {
+		name = fmt.Sprintf("UNKNOWN(0x%02x)", tlvType)
+	}
+	return name
+}
+
+func (img *Image) isSigTlv(tlvType uint8) bool {
+	if img.IsV1() {
+		return tlvType == IMAGE_TLV_V1_RSA2048 ||
+			tlvType == IMAGE_TLV_V1_ECDSA224 ||
+			tlvType == IMAGE_TLV_V1_ECDSA256
+	} else {
+		return tlvType == IMAGE_TLV_RSA2048 ||
+			tlvType == IMAGE_TLV_ECDSA224 ||
+			tlvType == IMAGE_TLV_ECDSA256
+	}
+}
+
+func (img *Image) sha256TlvType() uint8 {
+	if img.IsV1() {
+		return IMAGE_TLV_V1_SHA256
+	} else {
+		return IMAGE_TLV_SHA256
+	}
+}
+
+// Retrieves all TLVs of the specified type.
+func (img *Image) FindTlvs(tlvType uint8) []ImageTlv {
+	var tlvs []ImageTlv
+	for _, tlv := range img.Tlvs {
+		if tlv.Type == tlvType {
+			tlvs = append(tlvs, tlv)
+		}
+	}
+
+	return tlvs
+}
+
+// Retrieves the signature TLVs.
+func (img *Image) SigTlvs() []ImageTlv {
+	var tlvs []ImageTlv
+	for _, tlv := range img.Tlvs {
+		if img.isSigTlv(tlv.Type) {
+			tlvs = append(tlvs, tlv)
+		}
+	}
+
+	return tlvs
+}
+
+// Retrieves the key hash TLVs.  Only version 2 images contain these.
+func (img *Image) KeyHashTlvs() []ImageTlv {
+	if img.IsV1() {
+		return nil
+	}
+	return img.FindTlvs(IMAGE_TLV_KEYHASH)
+}
+
+// Retrieves the hash stored in the image's SHA256 TLV.
+func (img *Image) Hash() ([]byte, error) {
+	tlvs := img.FindTlvs(img.sha256TlvType())
+	if len(tlvs) == 0 {
+		return nil, util.NewNewtError("Image does not contain a hash TLV")
+	}
+	if len(tlvs) > 1 {
+		return nil, util.NewNewtError(
+			"Image contains more than one hash TLV")
+	}
+
+	return tlvs[0].Data, nil
+}
+
+// Calculates the SHA256 of the image header and body.  For a split app
+// image, the image tool seeds the hash with the loader's hash, so the result
+// will not match the image's hash TLV.
+func (img *Image) CalcHash() []byte {
+	end := int(img.Header.HdrSz) + int(img.Header.ImgSz)
+	sum := sha256.Sum256(img.Data[:end])
+	return sum[:]
+}
+
+// Ensures the hash TLV matches the contents of the image.  Non-bootable
+// (split app) images cannot be verified without the loader, so they are
+// accepted as long as they contain a hash.
+func (img *Image) VerifyHash() error {
+	hash, err := img.Hash()
+	if err != nil {
+		return err
+	}
+
+	if img.Header.Flags&IMAGE_F_NON_BOOTABLE != 0 {
+		return nil
+	}
+
+	calc := img.CalcHash()
+	if !bytes.Equal(hash, calc) {
+		return util.FmtNewtError(
+			"Image hash mismatch; tlv=%x calculated=%x", hash, calc)
+	}
+
+	return nil
+}
+
+func parseHdr(data []byte) (ImageHdr, error) {
+	hdr := ImageHdr{}
+
+	if len(data) < IMAGE_HEADER_SIZE {
+		return hdr, util.FmtNewtError(
+			"Image too small for header: %d bytes", len(data))
+	}
+
+	hdr.Magic = binary.LittleEndian.Uint32(data[0:4])
+	switch hdr.Magic {
+	case IMAGE_MAGIC_V1:
+		hdr.TlvSz = binary.LittleEndian.Uint16(data[4:6])
+		hdr.KeyId = data[6]
+	case IMAGE_MAGIC:
+	default:
+		return hdr, util.FmtNewtError("Invalid image magic: 0x%08x",
+			hdr.Magic)
+	}
+
+	hdr.HdrSz = binary.LittleEndian.Uint16(data[8:10])
+	hdr.ImgSz = binary.LittleEndian.Uint32(data[12:16])
+	hdr.Flags = binary.LittleEndian.Uint32(data[16:20])
+	hdr.Vers.Major = data[20]
+	hdr.Vers.Minor = data[21]
+	hdr.Vers.Rev = binary.LittleEndian.Uint16(data[22:24])
+	hdr.Vers.BuildNum = binary.LittleEndian.Uint32(data[24:28])
+
+	if hdr.HdrSz < IMAGE_HEADER_SIZE {
+		return hdr, util.FmtNewtError("Invalid image header size: %d",
+			hdr.HdrSz)
+	}
+
+	return hdr, nil
+}
+
+// Parses a sequence of TLVs occupying the entirety of the supplied buffer.
+// The base argument is the offset of the buffer within the image file.
+func parseTlvs(data []byte, base int) ([]ImageTlv, error) {
+	var tlvs []ImageTlv
+
+	off := 0
+	for off < len(data) {
+		if len(data)-off < IMAGE_TLV_HDR_SIZE {
+			return nil, util.FmtNewtError(
+				"Truncated TLV header at offset %d", base+off)
+		}
+
+		tlvType := data[off]
+		tlvLen := int(binary.LittleEndian.Uint16(data[off+2 : off+4]))
+		start := off + IMAGE_TLV_HDR_SIZE
+		if start+tlvLen > len(data) {
+			return nil, util.FmtNewtError(
+				"Truncated TLV at offset %d; type=0x%02x len=%d",
+				base+off, tlvType, tlvLen)
+		}
+
+		tlvs = append(tlvs, ImageTlv{
+			Type:   tlvType,
+			Data:   data[start : start+tlvLen],
+			Offset: base + off,
+		})
+		off = start + tlvLen
+	}
+
+	return tlvs, nil
+}
+
+// Parses the contents of an image file.
+func Parse(data []byte) (*Image, error) {
+	hdr, err := parseHdr(data)
+	if err != nil {
+		return nil, err
+	}
+
+	img := &Image{
+		Header: hdr,
+		Data:   data,
+	}
+
+	bodyEnd := int(hdr.HdrSz) + int(hdr.ImgSz)
+	if bodyEnd > len(data) {
+		return nil, util.FmtNewtError(
+			"Image truncated; header indicates %d bytes of header and body, "+
+				"file contains %d", bodyEnd, len(data))
+	}
+	img.Body = data[hdr.HdrSz:bodyEnd]
+
+	var tlvStart int
+	var tlvEnd int
+	if img.IsV1() {
+		tlvStart = bodyEnd
+		tlvEnd = tlvStart + int(hdr.TlvSz)
+	} else {
+		if bodyEnd+IMAGE_TLV_INFO_SIZE > len(data) {
+			return nil, util.NewNewtError("Image is missing TLV info header")
+		}
+
+		magic := binary.LittleEndian.Uint16(data[bodyEnd : bodyEnd+2])
+		if magic != IMAGE_TLV_INFO_MAGIC {
+			return nil, util.FmtNewtError(
+				"Invalid TLV info magic: 0x%04x", magic)
+		}
+
+		tlvTot := int(binary.LittleEndian.Uint16(data[bodyEnd+2 : bodyEnd+4]))
+		if tlvTot < IMAGE_TLV_INFO_SIZE {
+			return nil, util.FmtNewtError("Invalid TLV area size: %d",
+				tlvTot)
+		}
+		tlvStart = bodyEnd + IMAGE_TLV_INFO_SIZE
+		tlvEnd = bodyEnd + tlvTot
+	}
+
+	if tlvEnd > len(data) {
+		return nil, util.FmtNewtError(
+			"Image truncated; TLV area ends at offset %d, file contains %d "+
+				"bytes", tlvEnd, len(data))
+	}
+
+	img.Tlvs, err = parseTlvs(data[tlvStart:tlvEnd], tlvStart)
+	if err != nil {
+		return nil, err
+	}
+
+	return img, nil
+}
+
+// Reads and parses an image file.
+func ReadImage(filename string) (*Image, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+
+	img, err := Parse(data)
+	if err != nil {
+		return nil, util.FmtNewtError("Invalid image file %s: %s",
+			filename, err.Error())
+	}
+
+	return img, nil
+}
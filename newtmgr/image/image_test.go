@@ -0,0 +1,156 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package image
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"testing"
+)
+
+// Builds an image with the specified magic and a single SHA256 TLV.
+func buildTestImage(magic uint32, body []byte) []byte {
+	hdr := make([]byte, IMAGE_HEADER_SIZE)
+	binary.LittleEndian.PutUint32(hdr[0:4], magic)
+	binary.LittleEndian.PutUint16(hdr[8:10], IMAGE_HEADER_SIZE)
+	binary.LittleEndian.PutUint32(hdr[12:16], uint32(len(body)))
+	binary.LittleEndian.PutUint32(hdr[16:20], IMAGE_F_SHA256)
+	hdr[20] = 1
+	hdr[21] = 2
+	binary.LittleEndian.PutUint16(hdr[22:24], 3)
+	binary.LittleEndian.PutUint32(hdr[24:28], 4)
+
+	var tlvType uint8
+	if magic == IMAGE_MAGIC_V1 {
+		tlvType = IMAGE_TLV_V1_SHA256
+		binary.LittleEndian.PutUint16(hdr[4:6],
+			IMAGE_TLV_HDR_SIZE+sha256.Size)
+	} else {
+		tlvType = IMAGE_TLV_SHA256
+	}
+
+	buf := bytes.NewBuffer(nil)
+	buf.Write(hdr)
+	buf.Write(body)
+	hash := sha256.Sum256(buf.Bytes())
+
+	if magic != IMAGE_MAGIC_V1 {
+		info := make([]byte, IMAGE_TLV_INFO_SIZE)
+		binary.LittleEndian.PutUint16(info[0:2], IMAGE_TLV_INFO_MAGIC)
+		binary.LittleEndian.PutUint16(info[2:4],
+			IMAGE_TLV_INFO_SIZE+IMAGE_TLV_HDR_SIZE+sha256.Size)
+		buf.Write(info)
+	}
+
+	tlv := make([]byte, IMAGE_TLV_HDR_SIZE)
+	tlv[0] = tlvType
+	binary.LittleEndian.PutUint16(tlv[2:4], sha256.Size)
+	buf.Write(tlv)
+	buf.Write(hash[:])
+
+	return buf.Bytes()
+}
+
+func TestParse(t *testing.T) {
+	body := []byte("image body")
+
+	for _, magic := range []uint32{IMAGE_MAGIC, IMAGE_MAGIC_V1} {
+		data := buildTestImage(magic, body)
+
+		img, err := Parse(data)
+		if err != nil {
+			t.Fatalf("magic=0x%08x: %s", magic, err.Error())
+		}
+
+		if img.IsV1() != (magic == IMAGE_MAGIC_V1) {
+			t.Errorf("magic=0x%08x: wrong format version", magic)
+		}
+		if v := img.Header.Vers.String(); v != "1.2.3.4" {
+			t.Errorf("magic=0x%08x: version %s; want 1.2.3.4", magic, v)
+		}
+		if !bytes.Equal(img.Body, body) {
+			t.Errorf("magic=0x%08x: body %q; want %q", magic, img.Body,
+				body)
+		}
+		if len(img.Tlvs) != 1 {
+			t.Fatalf("magic=0x%08x: %d TLVs; want 1", magic, len(img.Tlvs))
+		}
+
+		hash, err := img.Hash()
+		if err != nil {
+			t.Fatalf("magic=0x%08x: %s", magic, err.Error())
+		}
+		if !bytes.Equal(hash, data[len(data)-sha256.Size:]) {
+			t.Errorf("magic=0x%08x: wrong hash", magic)
+		}
+		if err := img.VerifyHash(); err != nil {
+			t.Errorf("magic=0x%08x: %s", magic, err.Error())
+		}
+	}
+}
+
+func TestParseHashMismatch(t *testing.T) {
+	data := buildTestImage(IMAGE_MAGIC, []byte("image body"))
+	data[IMAGE_HEADER_SIZE] ^= 0xff
+
+	img, err := Parse(data)
+	if err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+	if err := img.VerifyHash(); err == nil {
+		t.Errorf("corrupt image passed hash verification")
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	good := buildTestImage(IMAGE_MAGIC, []byte("image body"))
+	bodyEnd := IMAGE_HEADER_SIZE + len("image body")
+
+	corrupt := func(fn func(data []byte) []byte) []byte {
+		return fn(append([]byte(nil), good...))
+	}
+
+	tests := map[string][]byte{
+		"empty":            []byte{},
+		"short header":     good[:IMAGE_HEADER_SIZE-1],
+		"truncated body":   good[:bodyEnd-1],
+		"missing tlv info": good[:bodyEnd],
+		"truncated tlv":    good[:len(good)-1],
+		"bad magic": corrupt(func(data []byte) []byte {
+			data[0] ^= 0xff
+			return data
+		}),
+		"bad header size": corrupt(func(data []byte) []byte {
+			binary.LittleEndian.PutUint16(data[8:10], 8)
+			return data
+		}),
+		"bad tlv info magic": corrupt(func(data []byte) []byte {
+			data[bodyEnd] ^= 0xff
+			return data
+		}),
+	}
+
+	for name, data := range tests {
+		if _, err := Parse(data); err == nil {
+			t.Errorf("%s: parse succeeded", name)
+		}
+	}
+}
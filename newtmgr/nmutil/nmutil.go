@@ -35,6 +35,10 @@ var BleWriteRsp bool
 var ConnType string
 var ConnString string
 var ConnExtra string
+var OutputFormat string
+var Daemon string
+var Fleet string
+var FleetJobs int
 
 func TxOptions() sesn.TxOptions {
 	return sesn.TxOptions{
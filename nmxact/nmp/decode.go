@@ -36,6 +36,7 @@ const gr_log = NMP_GROUP_LOG
 const gr_cra = NMP_GROUP_CRASH
 const gr_run = NMP_GROUP_RUN
 const gr_fil = NMP_GROUP_FS
+const gr_shl = NMP_GROUP_SHELL
 
 // Op-Group-Id
 type Ogi struct {
@@ -70,8 +71,15 @@ func runTestRspCtor() NmpRsp       { return NewRunTestRsp() }
 func runListRspCtor() NmpRsp       { return NewRunListRsp() }
 func fsDownloadRspCtor() NmpRsp    { return NewFsDownloadRsp() }
 func fsUploadRspCtor() NmpRsp      { return NewFsUploadRsp() }
+func fsStatRspCtor() NmpRsp        { return NewFsStatRsp() }
+func fsHashRspCtor() NmpRsp        { return NewFsHashRsp() }
+func fsDirListRspCtor() NmpRsp     { return NewFsDirListRsp() }
+func fsMkdirRspCtor() NmpRsp       { return NewFsMkdirRsp() }
+func fsUnlinkRspCtor() NmpRsp      { return NewFsUnlinkRsp() }
+func fsRenameRspCtor() NmpRsp      { return NewFsRenameRsp() }
 func configReadRspCtor() NmpRsp    { return NewConfigReadRsp() }
 func configWriteRspCtor() NmpRsp   { return NewConfigWriteRsp() }
+func shellExecRspCtor() NmpRsp     { return NewShellExecRsp() }
 
 var rspCtorMap = map[Ogi]rspCtor{
 	{op_wr, gr_def, NMP_ID_DEF_ECHO}:         echoRspCtor,
@@ -99,8 +107,15 @@ var rspCtorMap = map[Ogi]rspCtor{
 	{op_rr, gr_run, NMP_ID_RUN_LIST}:         runListRspCtor,
 	{op_rr, gr_fil, NMP_ID_FS_FILE}:          fsDownloadRspCtor,
 	{op_wr, gr_fil, NMP_ID_FS_FILE}:          fsUploadRspCtor,
+	{op_rr, gr_fil, NMP_ID_FS_STAT}:          fsStatRspCtor,
+	{op_rr, gr_fil, NMP_ID_FS_HASH}:          fsHashRspCtor,
+	{op_rr, gr_fil, NMP_ID_FS_DIR}:           fsDirListRspCtor,
+	{op_wr, gr_fil, NMP_ID_FS_DIR}:           fsMkdirRspCtor,
+	{op_wr, gr_fil, NMP_ID_FS_UNLINK}:        fsUnlinkRspCtor,
+	{op_wr, gr_fil, NMP_ID_FS_RENAME}:        fsRenameRspCtor,
 	{op_rr, gr_cfg, NMP_ID_CONFIG_VAL}:       configReadRspCtor,
 	{op_wr, gr_cfg, NMP_ID_CONFIG_VAL}:       configWriteRspCtor,
+	{op_wr, gr_shl, NMP_ID_SHELL_EXEC}:       shellExecRspCtor,
 }
 
 func DecodeRspBody(hdr *NmpHdr, body []byte) (NmpRsp, error) {
@@ -48,6 +48,7 @@ const (
 	NMP_GROUP_SPLIT   = 6
 	NMP_GROUP_RUN     = 7
 	NMP_GROUP_FS      = 8
+	NMP_GROUP_SHELL   = 9
 	NMP_GROUP_PERUSER = 64
 )
 
@@ -102,7 +103,19 @@ const (
 	NMP_ID_RUN_LIST = 1
 )
 
-// File system group (8).
+// File system group (8).  Firmware already assigns IDs 0 through 4 (mcumgr
+// uses 3 for the supported hash types and 4 for closing an open file), so the
+// directory and file management commands start at 64.
 const (
-	NMP_ID_FS_FILE = 0
+	NMP_ID_FS_FILE   = 0
+	NMP_ID_FS_STAT   = 1
+	NMP_ID_FS_HASH   = 2
+	NMP_ID_FS_DIR    = 64
+	NMP_ID_FS_UNLINK = 65
+	NMP_ID_FS_RENAME = 66
+)
+
+// Shell group (9).
+const (
+	NMP_ID_SHELL_EXEC = 0
 )
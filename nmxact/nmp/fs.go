@@ -84,3 +84,205 @@ func NewFsUploadRsp() *FsUploadRsp {
 }
 
 func (r *FsUploadRsp) Msg() *NmpMsg { return MsgFromReq(r) }
+
+//////////////////////////////////////////////////////////////////////////////
+// $stat                                                                    //
+//////////////////////////////////////////////////////////////////////////////
+
+type FsStatReq struct {
+	NmpBase
+	Name string `codec:"name"`
+}
+
+type FsStatRsp struct {
+	NmpBase
+	Rc  int    `codec:"rc" codec:",omitempty"`
+	Len uint32 `codec:"len"`
+	Dir bool   `codec:"dir"`
+
+	// Seconds since the epoch; 0 if the file system doesn't record it.
+	Mtime int64 `codec:"mtime"`
+}
+
+func NewFsStatReq() *FsStatReq {
+	r := &FsStatReq{}
+	fillNmpReq(r, NMP_OP_READ, NMP_GROUP_FS, NMP_ID_FS_STAT)
+	return r
+}
+
+func (r *FsStatReq) Msg() *NmpMsg { return MsgFromReq(r) }
+
+func NewFsStatRsp() *FsStatRsp {
+	return &FsStatRsp{}
+}
+
+func (r *FsStatRsp) Msg() *NmpMsg { return MsgFromReq(r) }
+
+//////////////////////////////////////////////////////////////////////////////
+// $hash                                                                    //
+//////////////////////////////////////////////////////////////////////////////
+
+const (
+	FS_HASH_CRC32  = "crc32"
+	FS_HASH_SHA256 = "sha256"
+)
+
+type FsHashReq struct {
+	NmpBase
+	Name string `codec:"name"`
+	Type string `codec:"type" codec:",omitempty"`
+	Off  uint32 `codec:"off"`
+
+	// 0 means the rest of the file.
+	Len uint32 `codec:"len" codec:",omitempty"`
+}
+
+type FsHashRsp struct {
+	NmpBase
+	Rc   int    `codec:"rc" codec:",omitempty"`
+	Type string `codec:"type"`
+	Off  uint32 `codec:"off"`
+	Len  uint32 `codec:"len"`
+
+	// An integer for crc32; a byte string for sha256.
+	Output interface{} `codec:"output"`
+}
+
+func NewFsHashReq() *FsHashReq {
+	r := &FsHashReq{}
+	fillNmpReq(r, NMP_OP_READ, NMP_GROUP_FS, NMP_ID_FS_HASH)
+	return r
+}
+
+func (r *FsHashReq) Msg() *NmpMsg { return MsgFromReq(r) }
+
+func NewFsHashRsp() *FsHashRsp {
+	return &FsHashRsp{}
+}
+
+func (r *FsHashRsp) Msg() *NmpMsg { return MsgFromReq(r) }
+
+//////////////////////////////////////////////////////////////////////////////
+// $dir                                                                     //
+//////////////////////////////////////////////////////////////////////////////
+
+type FsDirEntry struct {
+	Name  string `codec:"name"`
+	Dir   bool   `codec:"dir"`
+	Len   uint32 `codec:"len"`
+	Mtime int64  `codec:"mtime"`
+}
+
+// Off is the index of the first entry to return.  A device sets More if the
+// listing didn't fit in one response.
+type FsDirListReq struct {
+	NmpBase
+	Name string `codec:"name"`
+	Off  uint32 `codec:"off"`
+}
+
+type FsDirListRsp struct {
+	NmpBase
+	Rc      int          `codec:"rc" codec:",omitempty"`
+	Entries []FsDirEntry `codec:"entries"`
+	More    bool         `codec:"more"`
+}
+
+func NewFsDirListReq() *FsDirListReq {
+	r := &FsDirListReq{}
+	fillNmpReq(r, NMP_OP_READ, NMP_GROUP_FS, NMP_ID_FS_DIR)
+	return r
+}
+
+func (r *FsDirListReq) Msg() *NmpMsg { return MsgFromReq(r) }
+
+func NewFsDirListRsp() *FsDirListRsp {
+	return &FsDirListRsp{}
+}
+
+func (r *FsDirListRsp) Msg() *NmpMsg { return MsgFromReq(r) }
+
+//////////////////////////////////////////////////////////////////////////////
+// $mkdir                                                                   //
+//////////////////////////////////////////////////////////////////////////////
+
+type FsMkdirReq struct {
+	NmpBase
+	Name string `codec:"name"`
+}
+
+type FsMkdirRsp struct {
+	NmpBase
+	Rc int `codec:"rc" codec:",omitempty"`
+}
+
+func NewFsMkdirReq() *FsMkdirReq {
+	r := &FsMkdirReq{}
+	fillNmpReq(r, NMP_OP_WRITE, NMP_GROUP_FS, NMP_ID_FS_DIR)
+	return r
+}
+
+func (r *FsMkdirReq) Msg() *NmpMsg { return MsgFromReq(r) }
+
+func NewFsMkdirRsp() *FsMkdirRsp {
+	return &FsMkdirRsp{}
+}
+
+func (r *FsMkdirRsp) Msg() *NmpMsg { return MsgFromReq(r) }
+
+//////////////////////////////////////////////////////////////////////////////
+// $unlink                                                                  //
+//////////////////////////////////////////////////////////////////////////////
+
+type FsUnlinkReq struct {
+	NmpBase
+	Name string `codec:"name"`
+}
+
+type FsUnlinkRsp struct {
+	NmpBase
+	Rc int `codec:"rc" codec:",omitempty"`
+}
+
+func NewFsUnlinkReq() *FsUnlinkReq {
+	r := &FsUnlinkReq{}
+	fillNmpReq(r, NMP_OP_WRITE, NMP_GROUP_FS, NMP_ID_FS_UNLINK)
+	return r
+}
+
+func (r *FsUnlinkReq) Msg() *NmpMsg { return MsgFromReq(r) }
+
+func NewFsUnlinkRsp() *FsUnlinkRsp {
+	return &FsUnlinkRsp{}
+}
+
+func (r *FsUnlinkRsp) Msg() *NmpMsg { return MsgFromReq(r) }
+
+//////////////////////////////////////////////////////////////////////////////
+// $rename                                                                  //
+//////////////////////////////////////////////////////////////////////////////
+
+type FsRenameReq struct {
+	NmpBase
+	From string `codec:"from"`
+	To   string `codec:"to"`
+}
+
+type FsRenameRsp struct {
+	NmpBase
+	Rc int `codec:"rc" codec:",omitempty"`
+}
+
+func NewFsRenameReq() *FsRenameReq {
+	r := &FsRenameReq{}
+	fillNmpReq(r, NMP_OP_WRITE, NMP_GROUP_FS, NMP_ID_FS_RENAME)
+	return r
+}
+
+func (r *FsRenameReq) Msg() *NmpMsg { return MsgFromReq(r) }
+
+func NewFsRenameRsp() *FsRenameRsp {
+	return &FsRenameRsp{}
+}
+
+func (r *FsRenameRsp) Msg() *NmpMsg { return MsgFromReq(r) }
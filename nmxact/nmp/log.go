@@ -19,7 +19,11 @@
 
 package nmp
 
-import ()
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
 
 //////////////////////////////////////////////////////////////////////////////
 // $defs                                                                    //
@@ -102,6 +106,38 @@ func LogTypeToString(lm int) string {
 	return name
 }
 
+// Parses a log module specified either by name or by number.
+func LogModuleFromString(s string) (int, error) {
+	for k, v := range LogModuleNameMap {
+		if strings.EqualFold(s, v) {
+			return k, nil
+		}
+	}
+
+	n, err := strconv.ParseUint(s, 0, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid log module: \"%s\"", s)
+	}
+
+	return int(n), nil
+}
+
+// Parses a log level specified either by name or by number.
+func LogLevelFromString(s string) (int, error) {
+	for k, v := range LogLevelNameMap {
+		if strings.EqualFold(s, v) {
+			return k, nil
+		}
+	}
+
+	n, err := strconv.ParseUint(s, 0, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid log level: \"%s\"", s)
+	}
+
+	return int(n), nil
+}
+
 //////////////////////////////////////////////////////////////////////////////
 // $show                                                                    //
 //////////////////////////////////////////////////////////////////////////////
@@ -0,0 +1,80 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package nmp
+
+import (
+	"testing"
+)
+
+func TestLogModuleFromString(t *testing.T) {
+	tests := []struct {
+		s     string
+		mod   int
+		valid bool
+	}{
+		{"newtmgr", MODULE_NEWTMGR, true},
+		{"NIMBLE_HOST", MODULE_NIMBLE_HOST, true},
+		{"3", 3, true},
+		{"0x40", 64, true},
+		{"256", 0, false},
+		{"nosuchmodule", 0, false},
+	}
+
+	for _, test := range tests {
+		mod, err := LogModuleFromString(test.s)
+		if test.valid {
+			if err != nil {
+				t.Errorf("\"%s\": %s", test.s, err.Error())
+			} else if mod != test.mod {
+				t.Errorf("\"%s\": got %d; want %d", test.s, mod, test.mod)
+			}
+		} else if err == nil {
+			t.Errorf("\"%s\": accepted invalid module", test.s)
+		}
+	}
+}
+
+func TestLogLevelFromString(t *testing.T) {
+	tests := []struct {
+		s     string
+		level int
+		valid bool
+	}{
+		{"warn", LEVEL_WARN, true},
+		{"CRITICAL", LEVEL_CRITICAL, true},
+		{"1", 1, true},
+		{"-1", 0, false},
+		{"verbose", 0, false},
+	}
+
+	for _, test := range tests {
+		level, err := LogLevelFromString(test.s)
+		if test.valid {
+			if err != nil {
+				t.Errorf("\"%s\": %s", test.s, err.Error())
+			} else if level != test.level {
+				t.Errorf("\"%s\": got %d; want %d", test.s, level,
+					test.level)
+			}
+		} else if err == nil {
+			t.Errorf("\"%s\": accepted invalid level", test.s)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package nmp
+
+import ()
+
+//////////////////////////////////////////////////////////////////////////////
+// $exec                                                                    //
+//////////////////////////////////////////////////////////////////////////////
+
+type ShellExecReq struct {
+	NmpBase
+	Argv []string `codec:"argv"`
+}
+
+type ShellExecRsp struct {
+	NmpBase
+	Rc     int    `codec:"rc" codec:",omitempty"`
+	Output string `codec:"o"`
+	Ret    int    `codec:"ret"`
+}
+
+func NewShellExecReq() *ShellExecReq {
+	r := &ShellExecReq{}
+	fillNmpReq(r, NMP_OP_WRITE, NMP_GROUP_SHELL, NMP_ID_SHELL_EXEC)
+	return r
+}
+
+func (r *ShellExecReq) Msg() *NmpMsg { return MsgFromReq(r) }
+
+func NewShellExecRsp() *ShellExecRsp {
+	return &ShellExecRsp{}
+}
+
+func (r *ShellExecRsp) Msg() *NmpMsg { return MsgFromReq(r) }
@@ -109,11 +109,20 @@ func encodeOmpBase(isTcp bool, nmr *nmp.NmpMsg) (encodeRecord, error) {
 	payload := []byte{}
 	enc := codec.NewEncoderBytes(&payload, new(codec.CborHandle))
 
-	fields := structs.Fields(nmr.Body)
-	er.fieldMap = make(map[string]interface{}, len(fields))
-	for _, f := range fields {
-		if cname := f.Tag("codec"); cname != "" {
-			er.fieldMap[cname] = f.Value()
+	if m, ok := nmr.Body.(map[string]interface{}); ok {
+		// The body is already keyed by wire name (e.g., a request relayed
+		// from another process).
+		er.fieldMap = make(map[string]interface{}, len(m))
+		for k, v := range m {
+			er.fieldMap[k] = v
+		}
+	} else {
+		fields := structs.Fields(nmr.Body)
+		er.fieldMap = make(map[string]interface{}, len(fields))
+		for _, f := range fields {
+			if cname := f.Tag("codec"); cname != "" {
+				er.fieldMap[cname] = f.Value()
+			}
 		}
 	}
 
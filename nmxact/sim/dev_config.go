@@ -0,0 +1,83 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package sim
+
+import (
+	"mynewt.apache.org/newtmgr/nmxact/nmp"
+)
+
+func (d *Device) SetConfig(name string, val string) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	d.configs[name] = val
+}
+
+// Retrieves the value of a setting.  The second return value indicates
+// whether the setting exists.
+func (d *Device) Config(name string) (string, bool) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	val, ok := d.configs[name]
+	return val, ok
+}
+
+//////////////////////////////////////////////////////////////////////////////
+// $read                                                                    //
+//////////////////////////////////////////////////////////////////////////////
+
+func (d *Device) configRead(body []byte) (interface{}, error) {
+	req := nmp.NewConfigReadReq()
+	if err := decodeReq(body, req); err != nil {
+		return nil, err
+	}
+
+	if req.Name == "" {
+		return errRsp(nmp.NMP_ERR_EINVAL), nil
+	}
+
+	val, ok := d.configs[req.Name]
+	if !ok {
+		return errRsp(nmp.NMP_ERR_ENOENT), nil
+	}
+
+	rsp := nmp.NewConfigReadRsp()
+	rsp.Val = val
+	return rsp, nil
+}
+
+//////////////////////////////////////////////////////////////////////////////
+// $write                                                                   //
+//////////////////////////////////////////////////////////////////////////////
+
+func (d *Device) configWrite(body []byte) (interface{}, error) {
+	req := nmp.NewConfigWriteReq()
+	if err := decodeReq(body, req); err != nil {
+		return nil, err
+	}
+
+	if req.Name == "" {
+		return errRsp(nmp.NMP_ERR_EINVAL), nil
+	}
+
+	d.configs[req.Name] = req.Val
+	return nmp.NewConfigWriteRsp(), nil
+}
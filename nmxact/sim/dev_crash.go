@@ -0,0 +1,112 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package sim
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"mynewt.apache.org/newtmgr/nmxact/nmp"
+)
+
+const (
+	COREDUMP_MAGIC     = 0x690c47c3
+	COREDUMP_TLV_IMAGE = 1
+	COREDUMP_TLV_MEM   = 2
+	COREDUMP_TLV_REGS  = 3
+)
+
+// Base address of the RAM region included in simulated core dumps.
+const CORE_RAM_ADDR = 0x20000000
+
+// Program counter values reported for each crash type.
+var crashPcMap = map[string]uint32{
+	"div0":   0x00008124,
+	"jump0":  0x00000000,
+	"ref0":   0x00008210,
+	"assert": 0x00008302,
+	"wdog":   0x00008400,
+}
+
+func appendCoreTlv(buf *bytes.Buffer, tlvType uint8, off uint32,
+	data []byte) {
+
+	tlv := make([]byte, 8)
+	tlv[0] = tlvType
+	binary.LittleEndian.PutUint16(tlv[2:4], uint16(len(data)))
+	binary.LittleEndian.PutUint32(tlv[4:8], off)
+
+	buf.Write(tlv)
+	buf.Write(data)
+}
+
+// Builds a Cortex-M core dump in the Mynewt format.  The dump contains the
+// hash of the running image, a register set, and a small chunk of RAM.
+func (d *Device) buildCore(pc uint32) []byte {
+	regs := make([]byte, 17*4)
+	for i := 0; i < 13; i++ {
+		binary.LittleEndian.PutUint32(regs[i*4:], uint32(i))
+	}
+	sp := uint32(CORE_RAM_ADDR + 0x200)
+	binary.LittleEndian.PutUint32(regs[13*4:], sp)         // sp
+	binary.LittleEndian.PutUint32(regs[14*4:], 0x00008001) // lr
+	binary.LittleEndian.PutUint32(regs[15*4:], pc)         // pc
+	binary.LittleEndian.PutUint32(regs[16*4:], 0x61000000) // xpsr
+
+	mem := make([]byte, 0x400)
+	for i := 0; i < len(mem); i += 4 {
+		binary.LittleEndian.PutUint32(mem[i:], 0xdeadbeef)
+	}
+
+	body := bytes.NewBuffer(nil)
+	if d.images[0] != nil {
+		appendCoreTlv(body, COREDUMP_TLV_IMAGE, 0, d.images[0].hash)
+	}
+	appendCoreTlv(body, COREDUMP_TLV_REGS, 0, regs)
+	appendCoreTlv(body, COREDUMP_TLV_MEM, CORE_RAM_ADDR, mem)
+
+	hdr := make([]byte, 8)
+	binary.LittleEndian.PutUint32(hdr[0:4], COREDUMP_MAGIC)
+	binary.LittleEndian.PutUint32(hdr[4:8], uint32(len(hdr)+body.Len()))
+
+	return append(hdr, body.Bytes()...)
+}
+
+func (d *Device) crash(body []byte) (interface{}, error) {
+	req := nmp.NewCrashReq()
+	if err := decodeReq(body, req); err != nil {
+		return nil, err
+	}
+
+	pc, ok := crashPcMap[req.CrashType]
+	if !ok {
+		return errRsp(nmp.NMP_ERR_EINVAL), nil
+	}
+
+	// Only keep the first core; a real device does not overwrite an
+	// unretrieved dump.
+	if d.core == nil {
+		d.core = d.buildCore(pc)
+	}
+	d.resetPending = true
+
+	// The device crashes before it can respond.
+	return nil, nil
+}
@@ -0,0 +1,162 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package sim
+
+import (
+	"time"
+
+	"mynewt.apache.org/newtmgr/nmxact/nmp"
+)
+
+// Describes a simulated OS task.  Runtime and context switch counters grow
+// with the device's uptime according to CpuShare and CswRate.
+type Task struct {
+	Prio     int
+	Tid      int
+	StkSiz   int
+	StkUse   int
+	CpuShare float64 // Fraction of CPU time consumed by the task.
+	CswRate  float64 // Context switches per second.
+}
+
+type Mempool struct {
+	BlkSiz int
+	NBlks  int
+	NFree  int
+	Min    int
+}
+
+func (d *Device) SetTask(name string, t Task) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	d.tasks[name] = t
+}
+
+func (d *Device) SetMempool(name string, mp Mempool) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	d.mpools[name] = mp
+}
+
+//////////////////////////////////////////////////////////////////////////////
+// $echo                                                                    //
+//////////////////////////////////////////////////////////////////////////////
+
+func (d *Device) echo(body []byte) (interface{}, error) {
+	req := nmp.NewEchoReq()
+	if err := decodeReq(body, req); err != nil {
+		return nil, err
+	}
+
+	rsp := nmp.NewEchoRsp()
+	rsp.Payload = req.Payload
+	return rsp, nil
+}
+
+//////////////////////////////////////////////////////////////////////////////
+// $taskstat                                                                //
+//////////////////////////////////////////////////////////////////////////////
+
+func (d *Device) taskStat(body []byte) (interface{}, error) {
+	upMs := float64(d.uptime() / time.Millisecond)
+
+	rsp := nmp.NewTaskStatRsp()
+	rsp.Tasks = make(map[string]map[string]int, len(d.tasks))
+	for name, t := range d.tasks {
+		rsp.Tasks[name] = map[string]int{
+			"prio":         t.Prio,
+			"tid":          t.Tid,
+			"state":        1,
+			"stkuse":       t.StkUse,
+			"stksiz":       t.StkSiz,
+			"cswcnt":       int(t.CswRate * upMs / 1000),
+			"runtime":      int(t.CpuShare * upMs),
+			"last_checkin": 0,
+			"next_checkin": 0,
+		}
+	}
+
+	return rsp, nil
+}
+
+//////////////////////////////////////////////////////////////////////////////
+// $mpstat                                                                  //
+//////////////////////////////////////////////////////////////////////////////
+
+func (d *Device) mempoolStat(body []byte) (interface{}, error) {
+	rsp := nmp.NewMempoolStatRsp()
+	rsp.Mpools = make(map[string]map[string]int, len(d.mpools))
+	for name, mp := range d.mpools {
+		rsp.Mpools[name] = map[string]int{
+			"blksiz": mp.BlkSiz,
+			"nblks":  mp.NBlks,
+			"nfree":  mp.NFree,
+			"min":    mp.Min,
+		}
+	}
+
+	return rsp, nil
+}
+
+//////////////////////////////////////////////////////////////////////////////
+// $datetime                                                                //
+//////////////////////////////////////////////////////////////////////////////
+
+const dateTimeRspFmt = "2006-01-02T15:04:05.000000-07:00"
+
+// Formats accepted by Mynewt's datetime parser.
+var dateTimeReqFmts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+}
+
+func (d *Device) dateTimeRead(body []byte) (interface{}, error) {
+	rsp := nmp.NewDateTimeReadRsp()
+	rsp.DateTime = time.Now().Add(d.dateTimeOff).UTC().Format(dateTimeRspFmt)
+	return rsp, nil
+}
+
+func (d *Device) dateTimeWrite(body []byte) (interface{}, error) {
+	req := nmp.NewDateTimeWriteReq()
+	if err := decodeReq(body, req); err != nil {
+		return nil, err
+	}
+
+	for _, f := range dateTimeReqFmts {
+		t, err := time.Parse(f, req.DateTime)
+		if err == nil {
+			d.dateTimeOff = t.Sub(time.Now())
+			return nmp.NewDateTimeWriteRsp(), nil
+		}
+	}
+
+	return errRsp(nmp.NMP_ERR_EINVAL), nil
+}
+
+//////////////////////////////////////////////////////////////////////////////
+// $reset                                                                   //
+//////////////////////////////////////////////////////////////////////////////
+
+func (d *Device) resetReq(body []byte) (interface{}, error) {
+	d.resetPending = true
+	return nmp.NewResetRsp(), nil
+}
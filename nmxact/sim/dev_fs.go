@@ -0,0 +1,424 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package sim
+
+import (
+	"crypto/sha256"
+	"hash/crc32"
+	"path"
+	"sort"
+	"strings"
+
+	"mynewt.apache.org/newtmgr/nmxact/nmp"
+)
+
+// Maximum number of directory entries the device returns in a single
+// response.
+const DEVICE_MAX_DIR_ENTRIES = 8
+
+// Replaces the contents of a file.  A nil slice deletes the file.
+func (d *Device) SetFile(name string, data []byte) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if data == nil {
+		delete(d.files, name)
+	} else {
+		d.files[name] = append([]byte(nil), data...)
+	}
+}
+
+// Retrieves the contents of a file.  The second return value indicates
+// whether the file exists.
+func (d *Device) File(name string) ([]byte, bool) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	data, ok := d.files[name]
+	if !ok {
+		return nil, false
+	}
+	return append([]byte(nil), data...), true
+}
+
+//////////////////////////////////////////////////////////////////////////////
+// $download                                                                //
+//////////////////////////////////////////////////////////////////////////////
+
+func (d *Device) fsDownload(body []byte) (interface{}, error) {
+	req := nmp.NewFsDownloadReq()
+	if err := decodeReq(body, req); err != nil {
+		return nil, err
+	}
+
+	data, ok := d.files[req.Name]
+	if !ok {
+		return errRsp(nmp.NMP_ERR_ENOENT), nil
+	}
+
+	if int(req.Off) > len(data) {
+		return errRsp(nmp.NMP_ERR_EINVAL), nil
+	}
+
+	rsp := nmp.NewFsDownloadRsp()
+	rsp.Off = req.Off
+	rsp.Len = uint32(len(data))
+	rsp.Data = chunk(data, int(req.Off))
+
+	return rsp, nil
+}
+
+//////////////////////////////////////////////////////////////////////////////
+// $upload                                                                  //
+//////////////////////////////////////////////////////////////////////////////
+
+func (d *Device) fsUpload(body []byte) (interface{}, error) {
+	req := nmp.NewFsUploadReq()
+	if err := decodeReq(body, req); err != nil {
+		return nil, err
+	}
+
+	if req.Off == 0 {
+		if req.Name == "" {
+			return errRsp(nmp.NMP_ERR_EINVAL), nil
+		}
+
+		d.fileUpload = uploadState{
+			data:   make([]byte, 0, req.Len),
+			total:  int(req.Len),
+			name:   req.Name,
+			active: true,
+		}
+		d.files[req.Name] = []byte{}
+	}
+
+	rsp := nmp.NewFsUploadRsp()
+
+	if !d.fileUpload.active {
+		// No upload in progress, or the upload already completed and this
+		// is a retransmission.  Either way, report the current offset.
+		rsp.Off = uint32(len(d.fileUpload.data))
+		return rsp, nil
+	}
+
+	if int(req.Off) != len(d.fileUpload.data) {
+		// Unexpected offset; tell the client where to resume.
+		rsp.Off = uint32(len(d.fileUpload.data))
+		return rsp, nil
+	}
+
+	data := req.Data
+	room := d.fileUpload.total - len(d.fileUpload.data)
+	if len(data) > room {
+		data = data[:room]
+	}
+	d.fileUpload.data = append(d.fileUpload.data, data...)
+	d.files[d.fileUpload.name] = append([]byte(nil), d.fileUpload.data...)
+
+	if len(d.fileUpload.data) == d.fileUpload.total {
+		d.fileUpload.active = false
+	}
+
+	rsp.Off = uint32(len(d.fileUpload.data))
+	return rsp, nil
+}
+
+//////////////////////////////////////////////////////////////////////////////
+// $helpers                                                                 //
+//////////////////////////////////////////////////////////////////////////////
+
+func fsCleanPath(name string) string {
+	return path.Clean("/" + name)
+}
+
+// Returns the first path component of p below dir, and whether p lies below
+// dir at all.
+func fsChildOf(dir string, p string) (string, bool) {
+	prefix := dir
+	if prefix != "/" {
+		prefix += "/"
+	}
+	if p == dir || !strings.HasPrefix(p, prefix) {
+		return "", false
+	}
+
+	return strings.SplitN(p[len(prefix):], "/", 2)[0], true
+}
+
+// Indicates whether p names a directory.  Directories exist if they were
+// created explicitly or if they contain a file.
+func (d *Device) fsIsDir(p string) bool {
+	if p == "/" || d.dirs[p] {
+		return true
+	}
+
+	for name := range d.files {
+		if _, ok := fsChildOf(p, fsCleanPath(name)); ok {
+			return true
+		}
+	}
+	for name := range d.dirs {
+		if _, ok := fsChildOf(p, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+//////////////////////////////////////////////////////////////////////////////
+// $stat                                                                    //
+//////////////////////////////////////////////////////////////////////////////
+
+func (d *Device) fsStat(body []byte) (interface{}, error) {
+	req := nmp.NewFsStatReq()
+	if err := decodeReq(body, req); err != nil {
+		return nil, err
+	}
+
+	rsp := nmp.NewFsStatRsp()
+
+	p := fsCleanPath(req.Name)
+	if data, ok := d.files[p]; ok {
+		rsp.Len = uint32(len(data))
+	} else if d.fsIsDir(p) {
+		rsp.Dir = true
+	} else {
+		return errRsp(nmp.NMP_ERR_ENOENT), nil
+	}
+
+	return rsp, nil
+}
+
+//////////////////////////////////////////////////////////////////////////////
+// $hash                                                                    //
+//////////////////////////////////////////////////////////////////////////////
+
+func (d *Device) fsHash(body []byte) (interface{}, error) {
+	req := nmp.NewFsHashReq()
+	if err := decodeReq(body, req); err != nil {
+		return nil, err
+	}
+
+	data, ok := d.files[fsCleanPath(req.Name)]
+	if !ok {
+		return errRsp(nmp.NMP_ERR_ENOENT), nil
+	}
+
+	if int(req.Off) > len(data) {
+		return errRsp(nmp.NMP_ERR_EINVAL), nil
+	}
+	data = data[req.Off:]
+	if req.Len != 0 && int(req.Len) < len(data) {
+		data = data[:req.Len]
+	}
+
+	rsp := nmp.NewFsHashRsp()
+	rsp.Off = req.Off
+	rsp.Len = uint32(len(data))
+
+	switch req.Type {
+	case "", nmp.FS_HASH_CRC32:
+		rsp.Type = nmp.FS_HASH_CRC32
+		rsp.Output = crc32.ChecksumIEEE(data)
+
+	case nmp.FS_HASH_SHA256:
+		sum := sha256.Sum256(data)
+		rsp.Type = nmp.FS_HASH_SHA256
+		rsp.Output = sum[:]
+
+	default:
+		return errRsp(nmp.NMP_ERR_EINVAL), nil
+	}
+
+	return rsp, nil
+}
+
+//////////////////////////////////////////////////////////////////////////////
+// $dir                                                                     //
+//////////////////////////////////////////////////////////////////////////////
+
+func (d *Device) fsDirList(body []byte) (interface{}, error) {
+	req := nmp.NewFsDirListReq()
+	if err := decodeReq(body, req); err != nil {
+		return nil, err
+	}
+
+	dir := fsCleanPath(req.Name)
+	if _, ok := d.files[dir]; ok || !d.fsIsDir(dir) {
+		return errRsp(nmp.NMP_ERR_ENOENT), nil
+	}
+
+	entries := map[string]nmp.FsDirEntry{}
+	for name, data := range d.files {
+		p := fsCleanPath(name)
+		if child, ok := fsChildOf(dir, p); ok {
+			if path.Join(dir, child) == p {
+				entries[child] = nmp.FsDirEntry{
+					Name: child,
+					Len:  uint32(len(data)),
+				}
+			} else {
+				entries[child] = nmp.FsDirEntry{Name: child, Dir: true}
+			}
+		}
+	}
+	for name := range d.dirs {
+		if child, ok := fsChildOf(dir, name); ok {
+			entries[child] = nmp.FsDirEntry{Name: child, Dir: true}
+		}
+	}
+
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	rsp := nmp.NewFsDirListRsp()
+	rsp.Entries = []nmp.FsDirEntry{}
+
+	for i := int(req.Off); i < len(names); i++ {
+		if len(rsp.Entries) >= DEVICE_MAX_DIR_ENTRIES {
+			rsp.More = true
+			break
+		}
+		rsp.Entries = append(rsp.Entries, entries[names[i]])
+	}
+
+	return rsp, nil
+}
+
+//////////////////////////////////////////////////////////////////////////////
+// $mkdir                                                                   //
+//////////////////////////////////////////////////////////////////////////////
+
+func (d *Device) fsMkdir(body []byte) (interface{}, error) {
+	req := nmp.NewFsMkdirReq()
+	if err := decodeReq(body, req); err != nil {
+		return nil, err
+	}
+
+	p := fsCleanPath(req.Name)
+	if _, ok := d.files[p]; ok || d.fsIsDir(p) {
+		return errRsp(nmp.NMP_ERR_EINVAL), nil
+	}
+	if !d.fsIsDir(path.Dir(p)) {
+		return errRsp(nmp.NMP_ERR_ENOENT), nil
+	}
+
+	d.dirs[p] = true
+
+	return nmp.NewFsMkdirRsp(), nil
+}
+
+//////////////////////////////////////////////////////////////////////////////
+// $unlink                                                                  //
+//////////////////////////////////////////////////////////////////////////////
+
+func (d *Device) fsUnlink(body []byte) (interface{}, error) {
+	req := nmp.NewFsUnlinkReq()
+	if err := decodeReq(body, req); err != nil {
+		return nil, err
+	}
+
+	p := fsCleanPath(req.Name)
+	if _, ok := d.files[p]; ok {
+		delete(d.files, p)
+		return nmp.NewFsUnlinkRsp(), nil
+	}
+
+	if !d.dirs[p] {
+		if d.fsIsDir(p) {
+			// Not empty.
+			return errRsp(nmp.NMP_ERR_EINVAL), nil
+		}
+		return errRsp(nmp.NMP_ERR_ENOENT), nil
+	}
+
+	delete(d.dirs, p)
+	if d.fsIsDir(p) {
+		// Not empty; put it back.
+		d.dirs[p] = true
+		return errRsp(nmp.NMP_ERR_EINVAL), nil
+	}
+
+	return nmp.NewFsUnlinkRsp(), nil
+}
+
+//////////////////////////////////////////////////////////////////////////////
+// $rename                                                                  //
+//////////////////////////////////////////////////////////////////////////////
+
+func (d *Device) fsRename(body []byte) (interface{}, error) {
+	req := nmp.NewFsRenameReq()
+	if err := decodeReq(body, req); err != nil {
+		return nil, err
+	}
+
+	from := fsCleanPath(req.From)
+	to := fsCleanPath(req.To)
+
+	if _, ok := d.files[to]; ok || d.fsIsDir(to) {
+		return errRsp(nmp.NMP_ERR_EINVAL), nil
+	}
+	if !d.fsIsDir(path.Dir(to)) {
+		return errRsp(nmp.NMP_ERR_ENOENT), nil
+	}
+
+	if data, ok := d.files[from]; ok {
+		delete(d.files, from)
+		d.files[to] = data
+		return nmp.NewFsRenameRsp(), nil
+	}
+
+	if from == "/" || !d.fsIsDir(from) {
+		return errRsp(nmp.NMP_ERR_ENOENT), nil
+	}
+	if _, ok := fsChildOf(from, to); ok {
+		// Can't move a directory into itself.
+		return errRsp(nmp.NMP_ERR_EINVAL), nil
+	}
+
+	files := map[string][]byte{}
+	for name, data := range d.files {
+		p := fsCleanPath(name)
+		if _, ok := fsChildOf(from, p); ok {
+			delete(d.files, name)
+			files[to+p[len(from):]] = data
+		}
+	}
+	for name, data := range files {
+		d.files[name] = data
+	}
+
+	dirs := map[string]bool{}
+	for name := range d.dirs {
+		if _, ok := fsChildOf(from, name); ok || name == from {
+			delete(d.dirs, name)
+			dirs[to+name[len(from):]] = true
+		}
+	}
+	for name := range dirs {
+		d.dirs[name] = true
+	}
+
+	return nmp.NewFsRenameRsp(), nil
+}
@@ -0,0 +1,453 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package sim
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"mynewt.apache.org/newtmgr/nmxact/nmp"
+)
+
+const (
+	IMAGE_MAGIC          = 0x96f3b83d
+	IMAGE_HEADER_SIZE    = 32
+	IMAGE_TLV_INFO_MAGIC = 0x6907
+	IMAGE_TLV_SHA256     = 0x10
+)
+
+type simImage struct {
+	data    []byte
+	hash    []byte
+	version string
+}
+
+// Holds the state of an in-progress image or file upload.
+type uploadState struct {
+	data   []byte
+	total  int
+	name   string
+	active bool
+}
+
+func parseVersion(s string) ([]byte, error) {
+	b := make([]byte, 8)
+
+	parts := strings.Split(s, ".")
+	if len(parts) < 1 || len(parts) > 4 {
+		return nil, fmt.Errorf("Invalid version string: %s", s)
+	}
+
+	// major.minor.revision.build
+	limits := []uint64{0xff, 0xff, 0xffff, 0xffffffff}
+	vals := make([]uint64, 4)
+	for i, p := range parts {
+		v, err := strconv.ParseUint(p, 10, 32)
+		if err != nil || v > limits[i] {
+			return nil, fmt.Errorf("Invalid version string: %s", s)
+		}
+		vals[i] = v
+	}
+
+	b[0] = uint8(vals[0])
+	b[1] = uint8(vals[1])
+	binary.LittleEndian.PutUint16(b[2:4], uint16(vals[2]))
+	binary.LittleEndian.PutUint32(b[4:8], uint32(vals[3]))
+
+	return b, nil
+}
+
+func versionBytesString(b []byte) string {
+	return fmt.Sprintf("%d.%d.%d.%d", b[0], b[1],
+		binary.LittleEndian.Uint16(b[2:4]),
+		binary.LittleEndian.Uint32(b[4:8]))
+}
+
+// Builds a minimal Mynewt image containing the specified body.  The image
+// has a version 2 header and a single SHA256 TLV.
+func BuildImage(version string, body []byte) []byte {
+	ver, err := parseVersion(version)
+	if err != nil {
+		ver = make([]byte, 8)
+	}
+
+	hdr := make([]byte, IMAGE_HEADER_SIZE)
+	binary.LittleEndian.PutUint32(hdr[0:4], IMAGE_MAGIC)
+	binary.LittleEndian.PutUint16(hdr[8:10], IMAGE_HEADER_SIZE)
+	binary.LittleEndian.PutUint32(hdr[12:16], uint32(len(body)))
+	copy(hdr[20:28], ver)
+
+	buf := bytes.NewBuffer(nil)
+	buf.Write(hdr)
+	buf.Write(body)
+
+	hash := sha256.Sum256(buf.Bytes())
+
+	tlvInfo := make([]byte, 4)
+	binary.LittleEndian.PutUint16(tlvInfo[0:2], IMAGE_TLV_INFO_MAGIC)
+	binary.LittleEndian.PutUint16(tlvInfo[2:4], uint16(4+4+len(hash)))
+	buf.Write(tlvInfo)
+
+	tlv := make([]byte, 4)
+	tlv[0] = IMAGE_TLV_SHA256
+	binary.LittleEndian.PutUint16(tlv[2:4], uint16(len(hash)))
+	buf.Write(tlv)
+	buf.Write(hash[:])
+
+	return buf.Bytes()
+}
+
+// Extracts the version and hash from an image.  If the data is not a
+// recognizable image, the hash is calculated over the entire blob.
+func newSimImage(data []byte) *simImage {
+	img := &simImage{
+		data:    data,
+		version: "0.0.0",
+	}
+
+	if len(data) >= IMAGE_HEADER_SIZE &&
+		binary.LittleEndian.Uint32(data[0:4]) == IMAGE_MAGIC {
+
+		img.version = versionBytesString(data[20:28])
+
+		hdrSize := int(binary.LittleEndian.Uint16(data[8:10]))
+		imgSize := int(binary.LittleEndian.Uint32(data[12:16]))
+		off := hdrSize + imgSize
+		if off+4 <= len(data) &&
+			binary.LittleEndian.Uint16(data[off:off+2]) ==
+				IMAGE_TLV_INFO_MAGIC {
+
+			off += 4
+		}
+
+		for off+4 <= len(data) {
+			tlvType := data[off]
+			tlvLen := int(binary.LittleEndian.Uint16(data[off+2 : off+4]))
+			off += 4
+			if off+tlvLen > len(data) {
+				break
+			}
+			if tlvType == IMAGE_TLV_SHA256 {
+				img.hash = data[off : off+tlvLen]
+				break
+			}
+			off += tlvLen
+		}
+	}
+
+	if img.hash == nil {
+		hash := sha256.Sum256(data)
+		img.hash = hash[:]
+	}
+
+	return img
+}
+
+func (img *simImage) versionString() string {
+	if img == nil {
+		return ""
+	}
+	return img.version
+}
+
+// Indicates whether slot 1 can be overwritten.  An image that is pending or
+// that the device would revert to must be preserved.
+func (d *Device) slot1Busy() bool {
+	return d.images[1] != nil && (d.testPending || !d.confirmed)
+}
+
+// Applies pending image state changes during a reboot.
+func (d *Device) bootSwap() {
+	if d.testPending && d.images[1] != nil {
+		d.images[0], d.images[1] = d.images[1], d.images[0]
+		d.confirmed = d.permPending
+	} else if !d.confirmed {
+		// The running image was never confirmed; revert.
+		if d.images[1] != nil {
+			d.images[0], d.images[1] = d.images[1], d.images[0]
+		}
+		d.confirmed = true
+	}
+
+	d.testPending = false
+	d.permPending = false
+}
+
+// Replaces the contents of the specified image slot (0 or 1).  A nil slice
+// empties the slot.
+func (d *Device) SetImage(slot int, data []byte) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if data == nil {
+		d.images[slot] = nil
+	} else {
+		d.images[slot] = newSimImage(append([]byte(nil), data...))
+	}
+}
+
+// Retrieves the contents of the specified image slot (0 or 1); nil if the
+// slot is empty.
+func (d *Device) ImageData(slot int) []byte {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if d.images[slot] == nil {
+		return nil
+	}
+	return append([]byte(nil), d.images[slot].data...)
+}
+
+// Sets the core dump that the device reports.  A nil slice clears it.
+func (d *Device) SetCore(core []byte) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	d.core = append([]byte(nil), core...)
+	if core == nil {
+		d.core = nil
+	}
+}
+
+func (d *Device) Core() []byte {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if d.core == nil {
+		return nil
+	}
+	return append([]byte(nil), d.core...)
+}
+
+//////////////////////////////////////////////////////////////////////////////
+// $upload                                                                  //
+//////////////////////////////////////////////////////////////////////////////
+
+func (d *Device) imageUpload(body []byte) (interface{}, error) {
+	req := nmp.NewImageUploadReq()
+	if err := decodeReq(body, req); err != nil {
+		return nil, err
+	}
+
+	if req.Off == 0 {
+		if req.Len == 0 {
+			return errRsp(nmp.NMP_ERR_EINVAL), nil
+		}
+		if d.slot1Busy() {
+			return errRsp(nmp.NMP_ERR_EINVAL), nil
+		}
+
+		d.images[1] = nil
+		d.upload = uploadState{
+			data:   make([]byte, 0, req.Len),
+			total:  int(req.Len),
+			active: true,
+		}
+	}
+
+	rsp := nmp.NewImageUploadRsp()
+
+	if !d.upload.active {
+		// No upload in progress, or the upload already completed and this
+		// is a retransmission.  Either way, report the current offset.
+		rsp.Off = uint32(len(d.upload.data))
+		return rsp, nil
+	}
+
+	if int(req.Off) != len(d.upload.data) {
+		// Unexpected offset; tell the client where to resume.
+		rsp.Off = uint32(len(d.upload.data))
+		return rsp, nil
+	}
+
+	data := req.Data
+	room := d.upload.total - len(d.upload.data)
+	if len(data) > room {
+		data = data[:room]
+	}
+	d.upload.data = append(d.upload.data, data...)
+
+	if len(d.upload.data) == d.upload.total {
+		d.images[1] = newSimImage(d.upload.data)
+		d.upload.active = false
+	}
+
+	rsp.Off = uint32(len(d.upload.data))
+	return rsp, nil
+}
+
+//////////////////////////////////////////////////////////////////////////////
+// $state                                                                   //
+//////////////////////////////////////////////////////////////////////////////
+
+func (d *Device) imageState() *nmp.ImageStateRsp {
+	rsp := nmp.NewImageStateRsp()
+	rsp.Images = []nmp.ImageStateEntry{}
+
+	if img := d.images[0]; img != nil {
+		rsp.Images = append(rsp.Images, nmp.ImageStateEntry{
+			Slot:      0,
+			Version:   img.version,
+			Hash:      img.hash,
+			Bootable:  true,
+			Confirmed: d.confirmed,
+			Active:    true,
+		})
+	}
+
+	if img := d.images[1]; img != nil {
+		rsp.Images = append(rsp.Images, nmp.ImageStateEntry{
+			Slot:      1,
+			Version:   img.version,
+			Hash:      img.hash,
+			Bootable:  true,
+			Pending:   d.testPending,
+			Confirmed: !d.confirmed,
+			Permanent: d.permPending,
+		})
+	}
+
+	return rsp
+}
+
+func (d *Device) imageStateRead(body []byte) (interface{}, error) {
+	return d.imageState(), nil
+}
+
+func (d *Device) imageStateWrite(body []byte) (interface{}, error) {
+	req := nmp.NewImageStateWriteReq()
+	if err := decodeReq(body, req); err != nil {
+		return nil, err
+	}
+
+	if len(req.Hash) == 0 {
+		if !req.Confirm {
+			return errRsp(nmp.NMP_ERR_EINVAL), nil
+		}
+
+		// Confirm the running image.
+		d.confirmed = true
+		return d.imageState(), nil
+	}
+
+	slot := -1
+	for i, img := range d.images {
+		if img != nil && bytes.Equal(img.hash, req.Hash) {
+			slot = i
+			break
+		}
+	}
+
+	switch slot {
+	case 0:
+		if req.Confirm {
+			d.confirmed = true
+		}
+
+	case 1:
+		if !d.confirmed {
+			// Slot 1 contains the fallback image.
+			return errRsp(nmp.NMP_ERR_EINVAL), nil
+		}
+		d.testPending = true
+		d.permPending = req.Confirm
+
+	default:
+		return errRsp(nmp.NMP_ERR_EINVAL), nil
+	}
+
+	return d.imageState(), nil
+}
+
+//////////////////////////////////////////////////////////////////////////////
+// $erase                                                                   //
+//////////////////////////////////////////////////////////////////////////////
+
+func (d *Device) imageErase(body []byte) (interface{}, error) {
+	if d.slot1Busy() {
+		return errRsp(nmp.NMP_ERR_EINVAL), nil
+	}
+
+	d.images[1] = nil
+	d.upload = uploadState{}
+
+	return nmp.NewImageEraseRsp(), nil
+}
+
+//////////////////////////////////////////////////////////////////////////////
+// $corelist                                                                //
+//////////////////////////////////////////////////////////////////////////////
+
+func (d *Device) coreList(body []byte) (interface{}, error) {
+	rsp := nmp.NewCoreListRsp()
+	if d.core == nil {
+		rsp.Rc = nmp.NMP_ERR_ENOENT
+	}
+	return rsp, nil
+}
+
+//////////////////////////////////////////////////////////////////////////////
+// $coreload                                                                //
+//////////////////////////////////////////////////////////////////////////////
+
+func (d *Device) coreLoad(body []byte) (interface{}, error) {
+	req := nmp.NewCoreLoadReq()
+	if err := decodeReq(body, req); err != nil {
+		return nil, err
+	}
+
+	if d.core == nil {
+		return errRsp(nmp.NMP_ERR_ENOENT), nil
+	}
+
+	rsp := nmp.NewCoreLoadRsp()
+	rsp.Off = req.Off
+	rsp.Len = uint32(len(d.core))
+	rsp.Data = chunk(d.core, int(req.Off))
+
+	return rsp, nil
+}
+
+// Returns the slice of data that fits in a single response starting at the
+// specified offset.
+func chunk(data []byte, off int) []byte {
+	if off >= len(data) {
+		return []byte{}
+	}
+
+	end := off + DEVICE_MAX_CHUNK
+	if end > len(data) {
+		end = len(data)
+	}
+	return data[off:end]
+}
+
+//////////////////////////////////////////////////////////////////////////////
+// $coreerase                                                               //
+//////////////////////////////////////////////////////////////////////////////
+
+func (d *Device) coreErase(body []byte) (interface{}, error) {
+	d.core = nil
+	return nmp.NewCoreEraseRsp(), nil
+}
@@ -0,0 +1,173 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package sim
+
+import (
+	"time"
+
+	"mynewt.apache.org/newtmgr/nmxact/nmp"
+)
+
+type simLog struct {
+	Name    string
+	Type    int
+	Entries []nmp.LogEntry
+}
+
+func (d *Device) findLog(name string) *simLog {
+	for _, l := range d.logs {
+		if l.Name == name {
+			return l
+		}
+	}
+
+	return nil
+}
+
+// Adds an entry to the named log.  The log is created as a memory log if it
+// does not exist.
+func (d *Device) appendLog(name string, module int, level int, msg string) {
+	l := d.findLog(name)
+	if l == nil {
+		l = &simLog{Name: name, Type: nmp.MEMORY_LOG}
+		d.logs = append(d.logs, l)
+	}
+
+	l.Entries = append(l.Entries, nmp.LogEntry{
+		Index:     d.nextLogIdx,
+		Timestamp: int64(d.uptime() / time.Microsecond),
+		Module:    uint8(module),
+		Level:     uint8(level),
+		Msg:       msg,
+	})
+	d.nextLogIdx++
+}
+
+func (d *Device) AppendLog(name string, module int, level int, msg string) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	d.appendLog(name, module, level, msg)
+}
+
+//////////////////////////////////////////////////////////////////////////////
+// $show                                                                    //
+//////////////////////////////////////////////////////////////////////////////
+
+func (d *Device) logShow(body []byte) (interface{}, error) {
+	req := nmp.NewLogShowReq()
+	if err := decodeReq(body, req); err != nil {
+		return nil, err
+	}
+
+	var logs []*simLog
+	if req.Name == "" {
+		logs = d.logs
+	} else {
+		l := d.findLog(req.Name)
+		if l == nil {
+			return errRsp(nmp.NMP_ERR_ENOENT), nil
+		}
+		logs = []*simLog{l}
+	}
+
+	rsp := nmp.NewLogShowRsp()
+	rsp.NextIndex = d.nextLogIdx
+	rsp.Logs = []nmp.LogShowLog{}
+
+	for _, l := range logs {
+		sl := nmp.LogShowLog{
+			Name:    l.Name,
+			Type:    l.Type,
+			Entries: []nmp.LogEntry{},
+		}
+
+		if req.Timestamp == -1 {
+			// Only report the most recent entry.
+			if len(l.Entries) > 0 {
+				sl.Entries = append(sl.Entries, l.Entries[len(l.Entries)-1])
+			}
+		} else {
+			for _, e := range l.Entries {
+				if e.Index >= req.Index && e.Timestamp >= req.Timestamp {
+					sl.Entries = append(sl.Entries, e)
+				}
+			}
+		}
+
+		rsp.Logs = append(rsp.Logs, sl)
+	}
+
+	return rsp, nil
+}
+
+//////////////////////////////////////////////////////////////////////////////
+// $list                                                                    //
+//////////////////////////////////////////////////////////////////////////////
+
+func (d *Device) logList(body []byte) (interface{}, error) {
+	rsp := nmp.NewLogListRsp()
+	rsp.List = []string{}
+	for _, l := range d.logs {
+		rsp.List = append(rsp.List, l.Name)
+	}
+
+	return rsp, nil
+}
+
+//////////////////////////////////////////////////////////////////////////////
+// $module list                                                             //
+//////////////////////////////////////////////////////////////////////////////
+
+func (d *Device) logModuleList(body []byte) (interface{}, error) {
+	rsp := nmp.NewLogModuleListRsp()
+	rsp.Map = map[string]int{}
+	for id, name := range nmp.LogModuleNameMap {
+		rsp.Map[name] = id
+	}
+
+	return rsp, nil
+}
+
+//////////////////////////////////////////////////////////////////////////////
+// $level list                                                              //
+//////////////////////////////////////////////////////////////////////////////
+
+func (d *Device) logLevelList(body []byte) (interface{}, error) {
+	rsp := nmp.NewLogLevelListRsp()
+	rsp.Map = map[string]int{}
+	for id, name := range nmp.LogLevelNameMap {
+		rsp.Map[name] = id
+	}
+
+	return rsp, nil
+}
+
+//////////////////////////////////////////////////////////////////////////////
+// $clear                                                                   //
+//////////////////////////////////////////////////////////////////////////////
+
+func (d *Device) logClear(body []byte) (interface{}, error) {
+	for _, l := range d.logs {
+		l.Entries = nil
+	}
+
+	return nmp.NewLogClearRsp(), nil
+}
@@ -0,0 +1,82 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package sim
+
+import (
+	"github.com/runtimeco/go-coap"
+)
+
+// Replaces the contents of a CoAP resource.  A nil slice deletes the
+// resource.
+func (d *Device) SetResource(uri string, val []byte) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if val == nil {
+		delete(d.resources, uri)
+	} else {
+		d.resources[uri] = append([]byte(nil), val...)
+	}
+}
+
+// Retrieves the contents of a CoAP resource.  The second return value
+// indicates whether the resource exists.
+func (d *Device) Resource(uri string) ([]byte, bool) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	val, ok := d.resources[uri]
+	if !ok {
+		return nil, false
+	}
+	return append([]byte(nil), val...), true
+}
+
+// Handles a request for a plain CoAP resource (i.e., anything other than
+// the OMP endpoint).
+func (d *Device) processRes(req coap.Message) (coap.COAPCode, []byte) {
+	uri := req.PathString()
+	val, ok := d.resources[uri]
+
+	switch req.Code() {
+	case coap.GET:
+		if !ok {
+			return coap.NotFound, nil
+		}
+		return coap.Content, val
+
+	case coap.PUT, coap.POST:
+		d.resources[uri] = append([]byte(nil), req.Payload()...)
+		if !ok {
+			return coap.Created, nil
+		}
+		return coap.Changed, nil
+
+	case coap.DELETE:
+		if !ok {
+			return coap.NotFound, nil
+		}
+		delete(d.resources, uri)
+		return coap.Deleted, nil
+
+	default:
+		return coap.MethodNotAllowed, nil
+	}
+}
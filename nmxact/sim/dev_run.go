@@ -0,0 +1,66 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package sim
+
+import (
+	"fmt"
+
+	"mynewt.apache.org/newtmgr/nmxact/nmp"
+)
+
+//////////////////////////////////////////////////////////////////////////////
+// $test                                                                    //
+//////////////////////////////////////////////////////////////////////////////
+
+func (d *Device) runTest(body []byte) (interface{}, error) {
+	req := nmp.NewRunTestReq()
+	if err := decodeReq(body, req); err != nil {
+		return nil, err
+	}
+
+	found := false
+	for _, t := range d.tests {
+		if t == req.Testname {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return errRsp(nmp.NMP_ERR_ENOENT), nil
+	}
+
+	msg := fmt.Sprintf("test %s passed", req.Testname)
+	if req.Token != "" {
+		msg += fmt.Sprintf(" (%s)", req.Token)
+	}
+	d.appendLog("log", nmp.MODULE_TEST, nmp.LEVEL_INFO, msg)
+
+	return nmp.NewRunTestRsp(), nil
+}
+
+//////////////////////////////////////////////////////////////////////////////
+// $list                                                                    //
+//////////////////////////////////////////////////////////////////////////////
+
+func (d *Device) runList(body []byte) (interface{}, error) {
+	rsp := nmp.NewRunListRsp()
+	rsp.List = append([]string{}, d.tests...)
+	return rsp, nil
+}
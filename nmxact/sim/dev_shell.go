@@ -0,0 +1,74 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package sim
+
+import (
+	"strconv"
+	"strings"
+
+	"mynewt.apache.org/newtmgr/nmxact/nmp"
+)
+
+// The return code of an unrecognized shell command; Mynewt's shell returns
+// -EINVAL.
+const SHELL_RET_UNKNOWN = -22
+
+//////////////////////////////////////////////////////////////////////////////
+// $exec                                                                    //
+//////////////////////////////////////////////////////////////////////////////
+
+// Runs one of a few built-in commands: "echo [args...]" prints its
+// arguments, and "exit <ret>" returns the specified value.
+func (d *Device) shellExec(body []byte) (interface{}, error) {
+	req := nmp.NewShellExecReq()
+	if err := decodeReq(body, req); err != nil {
+		return nil, err
+	}
+
+	if len(req.Argv) == 0 {
+		return errRsp(nmp.NMP_ERR_EINVAL), nil
+	}
+
+	rsp := nmp.NewShellExecRsp()
+	switch req.Argv[0] {
+	case "echo":
+		rsp.Output = strings.Join(req.Argv[1:], " ") + "\n"
+
+	case "exit":
+		if len(req.Argv) != 2 {
+			rsp.Output = "usage: exit <ret>\n"
+			rsp.Ret = SHELL_RET_UNKNOWN
+			break
+		}
+		ret, err := strconv.Atoi(req.Argv[1])
+		if err != nil {
+			rsp.Output = "invalid return value: " + req.Argv[1] + "\n"
+			rsp.Ret = SHELL_RET_UNKNOWN
+			break
+		}
+		rsp.Ret = ret
+
+	default:
+		rsp.Output = "Unrecognized command: " + req.Argv[0] + "\n"
+		rsp.Ret = SHELL_RET_UNKNOWN
+	}
+
+	return rsp, nil
+}
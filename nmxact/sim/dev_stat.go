@@ -0,0 +1,78 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package sim
+
+import (
+	"sort"
+
+	"mynewt.apache.org/newtmgr/nmxact/nmp"
+)
+
+// Sets the value of a single statistic, creating the group if necessary.
+func (d *Device) SetStat(group string, field string, val int) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if d.stats[group] == nil {
+		d.stats[group] = map[string]int{}
+	}
+	d.stats[group][field] = val
+}
+
+//////////////////////////////////////////////////////////////////////////////
+// $read                                                                    //
+//////////////////////////////////////////////////////////////////////////////
+
+func (d *Device) statRead(body []byte) (interface{}, error) {
+	req := nmp.NewStatReadReq()
+	if err := decodeReq(body, req); err != nil {
+		return nil, err
+	}
+
+	fields := d.stats[req.Name]
+	if fields == nil {
+		return errRsp(nmp.NMP_ERR_ENOENT), nil
+	}
+
+	rsp := nmp.NewStatReadRsp()
+	rsp.Name = req.Name
+	rsp.Group = req.Name
+	rsp.Fields = make(map[string]interface{}, len(fields))
+	for k, v := range fields {
+		rsp.Fields[k] = v
+	}
+
+	return rsp, nil
+}
+
+//////////////////////////////////////////////////////////////////////////////
+// $list                                                                    //
+//////////////////////////////////////////////////////////////////////////////
+
+func (d *Device) statList(body []byte) (interface{}, error) {
+	rsp := nmp.NewStatListRsp()
+	rsp.List = []string{}
+	for name, _ := range d.stats {
+		rsp.List = append(rsp.List, name)
+	}
+	sort.Strings(rsp.List)
+
+	return rsp, nil
+}
@@ -0,0 +1,506 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package sim
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/runtimeco/go-coap"
+	"github.com/ugorji/go/codec"
+
+	"mynewt.apache.org/newtmgr/nmxact/nmp"
+	"mynewt.apache.org/newtmgr/nmxact/nmxutil"
+	"mynewt.apache.org/newtmgr/nmxact/sesn"
+)
+
+// Maximum amount of file or core data the device returns in a single
+// response.
+const DEVICE_MAX_CHUNK = 512
+
+type rxReq struct {
+	proto      sesn.MgmtProto
+	data       []byte
+	dispatchCb func(data []byte)
+}
+
+// Handles a single decoded NMP request.  A nil response indicates that the
+// device does not respond to the request.
+type reqHandler func(d *Device, body []byte) (interface{}, error)
+
+// These aliases just allow the handler map to fit within 79 columns.
+const op_r = nmp.NMP_OP_READ
+const op_w = nmp.NMP_OP_WRITE
+const gr_def = nmp.NMP_GROUP_DEFAULT
+const gr_img = nmp.NMP_GROUP_IMAGE
+const gr_sta = nmp.NMP_GROUP_STAT
+const gr_cfg = nmp.NMP_GROUP_CONFIG
+const gr_log = nmp.NMP_GROUP_LOG
+const gr_cra = nmp.NMP_GROUP_CRASH
+const gr_run = nmp.NMP_GROUP_RUN
+const gr_fil = nmp.NMP_GROUP_FS
+const gr_she = nmp.NMP_GROUP_SHELL
+
+// Local copy of nmp.Ogi; allows the handler map to use unkeyed literals.
+type ogi nmp.Ogi
+
+var reqHandlerMap = map[ogi]reqHandler{
+	{op_w, gr_def, nmp.NMP_ID_DEF_ECHO}:         (*Device).echo,
+	{op_r, gr_def, nmp.NMP_ID_DEF_TASKSTAT}:     (*Device).taskStat,
+	{op_r, gr_def, nmp.NMP_ID_DEF_MPSTAT}:       (*Device).mempoolStat,
+	{op_r, gr_def, nmp.NMP_ID_DEF_DATETIME_STR}: (*Device).dateTimeRead,
+	{op_w, gr_def, nmp.NMP_ID_DEF_DATETIME_STR}: (*Device).dateTimeWrite,
+	{op_w, gr_def, nmp.NMP_ID_DEF_RESET}:        (*Device).resetReq,
+	{op_w, gr_img, nmp.NMP_ID_IMAGE_UPLOAD}:     (*Device).imageUpload,
+	{op_r, gr_img, nmp.NMP_ID_IMAGE_STATE}:      (*Device).imageStateRead,
+	{op_w, gr_img, nmp.NMP_ID_IMAGE_STATE}:      (*Device).imageStateWrite,
+	{op_r, gr_img, nmp.NMP_ID_IMAGE_CORELIST}:   (*Device).coreList,
+	{op_r, gr_img, nmp.NMP_ID_IMAGE_CORELOAD}:   (*Device).coreLoad,
+	{op_w, gr_img, nmp.NMP_ID_IMAGE_CORELOAD}:   (*Device).coreErase,
+	{op_w, gr_img, nmp.NMP_ID_IMAGE_ERASE}:      (*Device).imageErase,
+	{op_r, gr_sta, nmp.NMP_ID_STAT_READ}:        (*Device).statRead,
+	{op_r, gr_sta, nmp.NMP_ID_STAT_LIST}:        (*Device).statList,
+	{op_r, gr_cfg, nmp.NMP_ID_CONFIG_VAL}:       (*Device).configRead,
+	{op_w, gr_cfg, nmp.NMP_ID_CONFIG_VAL}:       (*Device).configWrite,
+	{op_r, gr_log, nmp.NMP_ID_LOG_SHOW}:         (*Device).logShow,
+	{op_w, gr_log, nmp.NMP_ID_LOG_CLEAR}:        (*Device).logClear,
+	{op_r, gr_log, nmp.NMP_ID_LOG_MODULE_LIST}:  (*Device).logModuleList,
+	{op_r, gr_log, nmp.NMP_ID_LOG_LEVEL_LIST}:   (*Device).logLevelList,
+	{op_r, gr_log, nmp.NMP_ID_LOG_LIST}:         (*Device).logList,
+	{op_w, gr_cra, nmp.NMP_ID_CRASH_TRIGGER}:    (*Device).crash,
+	{op_w, gr_run, nmp.NMP_ID_RUN_TEST}:         (*Device).runTest,
+	{op_r, gr_run, nmp.NMP_ID_RUN_LIST}:         (*Device).runList,
+	{op_r, gr_fil, nmp.NMP_ID_FS_FILE}:          (*Device).fsDownload,
+	{op_w, gr_fil, nmp.NMP_ID_FS_FILE}:          (*Device).fsUpload,
+	{op_r, gr_fil, nmp.NMP_ID_FS_STAT}:          (*Device).fsStat,
+	{op_r, gr_fil, nmp.NMP_ID_FS_HASH}:          (*Device).fsHash,
+	{op_r, gr_fil, nmp.NMP_ID_FS_DIR}:           (*Device).fsDirList,
+	{op_w, gr_fil, nmp.NMP_ID_FS_DIR}:           (*Device).fsMkdir,
+	{op_w, gr_fil, nmp.NMP_ID_FS_UNLINK}:        (*Device).fsUnlink,
+	{op_w, gr_fil, nmp.NMP_ID_FS_RENAME}:        (*Device).fsRename,
+	{op_w, gr_she, nmp.NMP_ID_SHELL_EXEC}:       (*Device).shellExec,
+}
+
+// An in-memory model of a Mynewt device.  It implements the server side of
+// the newtmgr protocol.  All exported methods are safe to call while the
+// device is in use; they are intended for seeding and inspecting device state
+// from tests.
+type Device struct {
+	bootTime    time.Time
+	bootCount   int
+	rebootUntil time.Time
+
+	// Set by a handler when the device should reset after responding.
+	resetPending bool
+
+	dateTimeOff time.Duration
+	tasks       map[string]Task
+	mpools      map[string]Mempool
+
+	images      [2]*simImage
+	confirmed   bool
+	testPending bool
+	permPending bool
+	upload      uploadState
+	core        []byte
+
+	stats      map[string]map[string]int
+	configs    map[string]string
+	logs       []*simLog
+	nextLogIdx uint32
+	tests      []string
+
+	files      map[string][]byte
+	dirs       map[string]bool
+	fileUpload uploadState
+
+	resources map[string][]byte
+
+	resetDelay time.Duration
+	onReset    func()
+	rxCh       chan rxReq
+	stopCh     chan struct{}
+	running    bool
+	wg         sync.WaitGroup
+	mtx        sync.Mutex
+}
+
+// Creates a device with a confirmed image in slot 0 and a handful of tasks,
+// mempools, stats, settings, and logs.
+func NewDevice() *Device {
+	d := &Device{
+		bootTime:  time.Now(),
+		tasks:     map[string]Task{},
+		mpools:    map[string]Mempool{},
+		stats:     map[string]map[string]int{},
+		configs:   map[string]string{},
+		files:     map[string][]byte{},
+		dirs:      map[string]bool{},
+		resources: map[string][]byte{},
+		confirmed: true,
+	}
+
+	d.images[0] = newSimImage(BuildImage("1.0.0", []byte("sim-app-1.0.0")))
+
+	d.tasks["main"] = Task{Prio: 127, Tid: 1, StkSiz: 1024, StkUse: 340,
+		CpuShare: 0.05, CswRate: 10}
+	d.tasks["idle"] = Task{Prio: 255, Tid: 0, StkSiz: 64, StkUse: 28,
+		CpuShare: 0.9, CswRate: 20}
+	d.tasks["ble_ll"] = Task{Prio: 0, Tid: 2, StkSiz: 80, StkUse: 56,
+		CpuShare: 0.05, CswRate: 100}
+
+	d.mpools["msys_1"] = Mempool{BlkSiz: 292, NBlks: 12, NFree: 10, Min: 7}
+	d.mpools["ble_hs_hci_ev_pool"] = Mempool{BlkSiz: 72, NBlks: 10,
+		NFree: 10, Min: 9}
+
+	d.stats["sim"] = map[string]int{
+		"rx_frames": 0,
+		"tx_frames": 0,
+		"resets":    0,
+	}
+	d.stats["ble_phy"] = map[string]int{
+		"phy_isrs":  0,
+		"tx_good":   0,
+		"tx_fail":   0,
+		"rx_starts": 0,
+		"rx_valid":  0,
+	}
+
+	d.configs["id/serial"] = "sim-0001"
+	d.configs["app/mode"] = "normal"
+
+	d.tests = []string{"all", "os_test", "nffs_test"}
+
+	d.logs = []*simLog{
+		&simLog{Name: "log", Type: nmp.MEMORY_LOG},
+		&simLog{Name: "reboot_log", Type: nmp.STORAGE_LOG},
+	}
+	d.appendLog("log", nmp.MODULE_DEFAULT, nmp.LEVEL_INFO, "sim device booted")
+
+	return d
+}
+
+func (d *Device) uptime() time.Duration {
+	return time.Since(d.bootTime)
+}
+
+// Retrieves the number of times the device has reset.
+func (d *Device) BootCount() int {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	return d.bootCount
+}
+
+func (d *Device) start(resetDelay time.Duration, onReset func()) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	d.resetDelay = resetDelay
+	d.onReset = onReset
+	d.rxCh = make(chan rxReq, 16)
+	d.stopCh = make(chan struct{})
+	d.running = true
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+
+		for {
+			select {
+			case req := <-d.rxCh:
+				d.process(req)
+
+			case <-d.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+func (d *Device) stop() {
+	d.mtx.Lock()
+	if !d.running {
+		d.mtx.Unlock()
+		return
+	}
+	d.running = false
+	close(d.stopCh)
+	d.mtx.Unlock()
+
+	d.wg.Wait()
+}
+
+// Queues an incoming frame for processing.
+func (d *Device) rx(proto sesn.MgmtProto, data []byte,
+	dispatchCb func(data []byte)) error {
+
+	d.mtx.Lock()
+	if !d.running {
+		d.mtx.Unlock()
+		return nmxutil.NewXportError("Sim device not running")
+	}
+	rxCh := d.rxCh
+	stopCh := d.stopCh
+	d.mtx.Unlock()
+
+	req := rxReq{
+		proto:      proto,
+		data:       append([]byte(nil), data...),
+		dispatchCb: dispatchCb,
+	}
+
+	select {
+	case rxCh <- req:
+		return nil
+	case <-stopCh:
+		return nmxutil.NewXportError("Sim device not running")
+	}
+}
+
+func (d *Device) process(req rxReq) {
+	d.mtx.Lock()
+
+	if time.Now().Before(d.rebootUntil) {
+		d.mtx.Unlock()
+		log.Debugf("Sim device rebooting; dropping frame")
+		return
+	}
+
+	d.stats["sim"]["rx_frames"]++
+
+	var rsp []byte
+	var err error
+	if req.proto == sesn.MGMT_PROTO_NMP {
+		rsp, err = d.processNmp(req.data)
+	} else {
+		rsp, err = d.processCoap(req.data)
+	}
+	if err != nil {
+		log.Debugf("Sim device failed to process frame: %s\n%s",
+			err.Error(), hex.Dump(req.data))
+	}
+
+	if rsp != nil {
+		d.stats["sim"]["tx_frames"]++
+	}
+
+	reset := d.resetPending
+	d.resetPending = false
+
+	d.mtx.Unlock()
+
+	if rsp != nil {
+		req.dispatchCb(rsp)
+	}
+
+	if reset {
+		d.reset()
+	}
+}
+
+func decodeReq(body []byte, req interface{}) error {
+	dec := codec.NewDecoderBytes(body, new(codec.CborHandle))
+	if err := dec.Decode(req); err != nil {
+		return fmt.Errorf("Invalid request: %s", err.Error())
+	}
+
+	return nil
+}
+
+func errRsp(rc int) map[string]interface{} {
+	return map[string]interface{}{"rc": rc}
+}
+
+// Executes the handler corresponding to an NMP request.  Returns the response
+// header and body; a nil body indicates that no response should be sent.
+func (d *Device) handleNmp(hdr *nmp.NmpHdr, body []byte) (
+	*nmp.NmpHdr, interface{}) {
+
+	rspHdr := *hdr
+	rspHdr.Len = 0
+	switch hdr.Op {
+	case nmp.NMP_OP_READ:
+		rspHdr.Op = nmp.NMP_OP_READ_RSP
+	case nmp.NMP_OP_WRITE:
+		rspHdr.Op = nmp.NMP_OP_WRITE_RSP
+	default:
+		// Not a request.
+		return nil, nil
+	}
+
+	h := reqHandlerMap[ogi{hdr.Op, hdr.Group, hdr.Id}]
+	if h == nil {
+		return &rspHdr, errRsp(nmp.NMP_ERR_ENOENT)
+	}
+
+	rsp, err := h(d, body)
+	if err != nil {
+		log.Debugf("Sim device rejecting request: %s", err.Error())
+		return &rspHdr, errRsp(nmp.NMP_ERR_EINVAL)
+	}
+
+	return &rspHdr, rsp
+}
+
+func (d *Device) processNmp(data []byte) ([]byte, error) {
+	hdr, err := nmp.DecodeNmpHdr(data)
+	if err != nil {
+		return nil, err
+	}
+
+	body := data[nmp.NMP_HDR_SIZE:]
+	if len(body) < int(hdr.Len) {
+		return nil, fmt.Errorf("Truncated NMP request")
+	}
+	body = body[:hdr.Len]
+
+	rspHdr, rsp := d.handleNmp(hdr, body)
+	if rsp == nil {
+		return nil, nil
+	}
+
+	return nmp.EncodeNmpPlain(&nmp.NmpMsg{
+		Hdr:  *rspHdr,
+		Body: rsp,
+	})
+}
+
+func (d *Device) processCoap(data []byte) ([]byte, error) {
+	req, err := coap.ParseDgramMessage(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var code coap.COAPCode
+	var payload []byte
+
+	if req.PathString() == "omgr" {
+		code, payload, err = d.processOmp(req)
+		if err != nil {
+			return nil, err
+		}
+		if payload == nil {
+			return nil, nil
+		}
+	} else {
+		code, payload = d.processRes(req)
+	}
+
+	rsp := coap.NewDgramMessage(coap.MessageParams{
+		Type:      coap.Acknowledgement,
+		Code:      code,
+		MessageID: req.MessageID(),
+		Token:     req.Token(),
+		Payload:   payload,
+	})
+
+	return rsp.MarshalBinary()
+}
+
+func (d *Device) processOmp(req coap.Message) (coap.COAPCode, []byte, error) {
+	if req.Code() != coap.PUT && req.Code() != coap.GET {
+		return coap.MethodNotAllowed, []byte{}, nil
+	}
+
+	m, err := nmxutil.DecodeCborMap(req.Payload())
+	if err != nil {
+		return coap.BadRequest, []byte{}, nil
+	}
+
+	hdrBytes, ok := m["_h"].([]byte)
+	if !ok {
+		return coap.BadRequest, []byte{}, nil
+	}
+
+	hdr, err := nmp.DecodeNmpHdr(hdrBytes)
+	if err != nil {
+		return coap.BadRequest, []byte{}, nil
+	}
+
+	rspHdr, rsp := d.handleNmp(hdr, req.Payload())
+	if rsp == nil {
+		return 0, nil, nil
+	}
+
+	// Convert the response to a generic map so that the NMP header can be
+	// added to it.
+	bb, err := nmp.BodyBytes(rsp)
+	if err != nil {
+		return 0, nil, err
+	}
+	rspMap, err := nmxutil.DecodeCborMap(bb)
+	if err != nil {
+		return 0, nil, err
+	}
+	rspMap["_h"] = rspHdr.Bytes()
+
+	payload, err := nmxutil.EncodeCborMap(rspMap)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return coap.Changed, payload, nil
+}
+
+// Reboots the device.  Images get swapped or reverted according to the
+// pending image state, memory logs are lost, and the device stays
+// unresponsive for the configured reset delay.
+func (d *Device) reset() {
+	d.mtx.Lock()
+
+	d.bootSwap()
+
+	for _, l := range d.logs {
+		if l.Type == nmp.MEMORY_LOG {
+			l.Entries = nil
+		}
+	}
+	d.nextLogIdx = 0
+	for _, l := range d.logs {
+		for _, e := range l.Entries {
+			if e.Index >= d.nextLogIdx {
+				d.nextLogIdx = e.Index + 1
+			}
+		}
+	}
+
+	d.bootCount++
+	d.bootTime = time.Now()
+	d.rebootUntil = d.bootTime.Add(d.resetDelay)
+	d.upload = uploadState{}
+	d.fileUpload = uploadState{}
+	d.stats["sim"]["resets"]++
+
+	d.appendLog("reboot_log", nmp.MODULE_REBOOT, nmp.LEVEL_CRITICAL,
+		fmt.Sprintf("rsn:SOFT, cnt:%d, img:%s", d.bootCount,
+			d.images[0].versionString()))
+
+	onReset := d.onReset
+
+	d.mtx.Unlock()
+
+	if onReset != nil {
+		onReset()
+	}
+}
@@ -0,0 +1,204 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package sim
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/runtimeco/go-coap"
+
+	"mynewt.apache.org/newtmgr/nmxact/mgmt"
+	"mynewt.apache.org/newtmgr/nmxact/nmp"
+	"mynewt.apache.org/newtmgr/nmxact/nmxutil"
+	"mynewt.apache.org/newtmgr/nmxact/omp"
+	"mynewt.apache.org/newtmgr/nmxact/sesn"
+)
+
+type SimSesn struct {
+	cfg    sesn.SesnCfg
+	sx     *SimXport
+	txvr   *mgmt.Transceiver
+	isOpen bool
+
+	// Protects isOpen and txvr.
+	m sync.Mutex
+}
+
+func NewSimSesn(sx *SimXport, cfg sesn.SesnCfg) (*SimSesn, error) {
+	s := &SimSesn{
+		cfg: cfg,
+		sx:  sx,
+	}
+
+	return s, nil
+}
+
+func (s *SimSesn) Open() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.isOpen {
+		return nmxutil.NewSesnAlreadyOpenError(
+			"Attempt to open an already-open sim session")
+	}
+
+	if !s.sx.isStarted() {
+		return nmxutil.NewXportError("Sim xport not started")
+	}
+
+	txvr, err := mgmt.NewTransceiver(false, s.cfg.MgmtProto, 3)
+	if err != nil {
+		return err
+	}
+	s.txvr = txvr
+
+	s.sx.addSesn(s)
+	s.isOpen = true
+	return nil
+}
+
+// Closes the session, failing all pending transactions with the specified
+// error.  The close callback is only executed for closes that the user did
+// not request.
+func (s *SimSesn) closeWithErr(err error, notify bool) error {
+	s.m.Lock()
+
+	if !s.isOpen {
+		s.m.Unlock()
+		return nmxutil.NewSesnClosedError(
+			"Attempt to close an unopened sim session")
+	}
+
+	s.sx.removeSesn(s)
+	s.txvr.ErrorAll(err)
+	s.txvr.Stop()
+	s.isOpen = false
+
+	s.m.Unlock()
+
+	if notify && s.cfg.OnCloseCb != nil {
+		s.cfg.OnCloseCb(s, err)
+	}
+
+	return nil
+}
+
+func (s *SimSesn) Close() error {
+	return s.closeWithErr(fmt.Errorf("closed"), false)
+}
+
+func (s *SimSesn) IsOpen() bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.isOpen
+}
+
+func (s *SimSesn) MtuIn() int {
+	return MAX_PACKET_SIZE -
+		omp.OMP_MSG_OVERHEAD -
+		nmp.NMP_HDR_SIZE
+}
+
+func (s *SimSesn) MtuOut() int {
+	return s.sx.cfg.Mtu -
+		omp.OMP_MSG_OVERHEAD -
+		nmp.NMP_HDR_SIZE
+}
+
+func (s *SimSesn) AbortRx(seq uint8) error {
+	txvr := s.curTxvr()
+	if txvr == nil {
+		return nmxutil.NewSesnClosedError("Attempt to abort closed session")
+	}
+
+	txvr.ErrorAll(fmt.Errorf("Rx aborted"))
+	return nil
+}
+
+func (s *SimSesn) curTxvr() *mgmt.Transceiver {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if !s.isOpen {
+		return nil
+	}
+	return s.txvr
+}
+
+// Hands an outgoing frame to the simulated device.  The device delivers its
+// response asynchronously through the supplied dispatch function.
+func (s *SimSesn) txFrame(coapFrame bool, b []byte,
+	dispatchCb func(data []byte)) error {
+
+	proto := s.cfg.MgmtProto
+	if coapFrame {
+		proto = sesn.MGMT_PROTO_OMP
+	}
+
+	return s.sx.dev.rx(proto, b, dispatchCb)
+}
+
+func (s *SimSesn) TxNmpOnce(m *nmp.NmpMsg, opt sesn.TxOptions) (
+	nmp.NmpRsp, error) {
+
+	txvr := s.curTxvr()
+	if txvr == nil {
+		return nil, nmxutil.NewSesnClosedError(
+			"Attempt to transmit over closed sim session")
+	}
+
+	txRaw := func(b []byte) error {
+		return s.txFrame(false, b, txvr.DispatchNmpRsp)
+	}
+	return txvr.TxNmp(txRaw, m, s.MtuOut(), opt.Timeout)
+}
+
+func (s *SimSesn) TxCoapOnce(m coap.Message, resType sesn.ResourceType,
+	opt sesn.TxOptions) (coap.COAPCode, []byte, error) {
+
+	txvr := s.curTxvr()
+	if txvr == nil {
+		return 0, nil, nmxutil.NewSesnClosedError(
+			"Attempt to transmit over closed sim session")
+	}
+
+	txRaw := func(b []byte) error {
+		return s.txFrame(true, b, txvr.DispatchCoap)
+	}
+
+	rsp, err := txvr.TxOic(txRaw, m, s.MtuOut(), opt.Timeout)
+	if err != nil {
+		return 0, nil, err
+	} else if rsp == nil {
+		return 0, nil, nil
+	} else {
+		return rsp.Code(), rsp.Payload(), nil
+	}
+}
+
+func (s *SimSesn) MgmtProto() sesn.MgmtProto {
+	return s.cfg.MgmtProto
+}
+
+func (s *SimSesn) CoapIsTcp() bool {
+	return false
+}
@@ -0,0 +1,166 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package sim
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"mynewt.apache.org/newtmgr/nmxact/nmxutil"
+	"mynewt.apache.org/newtmgr/nmxact/sesn"
+)
+
+const MAX_PACKET_SIZE = 2048
+
+type XportCfg struct {
+	// Maximum size of a single frame exchanged with the simulated device.
+	Mtu int
+
+	// How long the device stays unresponsive after it resets.  Requests
+	// received during this window are silently dropped.
+	ResetDelay time.Duration
+
+	// Whether a device reset closes all open sessions.  This mimics
+	// connection-oriented transports such as BLE.
+	CloseOnReset bool
+
+	// The device to talk to; if nil, a default device is created.
+	Device *Device
+}
+
+func NewXportCfg() *XportCfg {
+	return &XportCfg{
+		Mtu:        512,
+		ResetDelay: 250 * time.Millisecond,
+	}
+}
+
+// Transport backed by an in-process simulated Mynewt device.  Useful for
+// exercising nmxact commands without any hardware.
+type SimXport struct {
+	cfg     *XportCfg
+	dev     *Device
+	started bool
+	sesns   map[*SimSesn]struct{}
+	mtx     sync.Mutex
+}
+
+func NewSimXport(cfg *XportCfg) *SimXport {
+	dev := cfg.Device
+	if dev == nil {
+		dev = NewDevice()
+	}
+
+	return &SimXport{
+		cfg:   cfg,
+		dev:   dev,
+		sesns: map[*SimSesn]struct{}{},
+	}
+}
+
+// Retrieves the simulated device this transport talks to.
+func (sx *SimXport) Device() *Device {
+	return sx.dev
+}
+
+func (sx *SimXport) BuildSesn(cfg sesn.SesnCfg) (sesn.Sesn, error) {
+	return NewSimSesn(sx, cfg)
+}
+
+func (sx *SimXport) Start() error {
+	sx.mtx.Lock()
+	defer sx.mtx.Unlock()
+
+	if sx.started {
+		return nmxutil.NewXportError("Sim xport started twice")
+	}
+
+	sx.dev.start(sx.cfg.ResetDelay, sx.onReset)
+	sx.started = true
+	return nil
+}
+
+func (sx *SimXport) Stop() error {
+	sx.mtx.Lock()
+	if !sx.started {
+		sx.mtx.Unlock()
+		return nmxutil.NewXportError("Sim xport stopped twice")
+	}
+	sx.started = false
+	sesns := sx.sesnList()
+	sx.mtx.Unlock()
+
+	for _, s := range sesns {
+		s.closeWithErr(nmxutil.NewXportError("Sim xport stopped"), true)
+	}
+
+	sx.dev.stop()
+	return nil
+}
+
+func (sx *SimXport) Tx(bytes []byte) error {
+	return fmt.Errorf("unsupported")
+}
+
+func (sx *SimXport) isStarted() bool {
+	sx.mtx.Lock()
+	defer sx.mtx.Unlock()
+
+	return sx.started
+}
+
+func (sx *SimXport) addSesn(s *SimSesn) {
+	sx.mtx.Lock()
+	defer sx.mtx.Unlock()
+
+	sx.sesns[s] = struct{}{}
+}
+
+func (sx *SimXport) removeSesn(s *SimSesn) {
+	sx.mtx.Lock()
+	defer sx.mtx.Unlock()
+
+	delete(sx.sesns, s)
+}
+
+func (sx *SimXport) sesnList() []*SimSesn {
+	sesns := make([]*SimSesn, 0, len(sx.sesns))
+	for s, _ := range sx.sesns {
+		sesns = append(sesns, s)
+	}
+
+	return sesns
+}
+
+// Called by the device whenever it resets.
+func (sx *SimXport) onReset() {
+	if !sx.cfg.CloseOnReset {
+		return
+	}
+
+	sx.mtx.Lock()
+	sesns := sx.sesnList()
+	sx.mtx.Unlock()
+
+	for _, s := range sesns {
+		s.closeWithErr(fmt.Errorf("Simulated device reset"), true)
+	}
+}
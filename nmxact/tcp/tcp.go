@@ -0,0 +1,125 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package tcp
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/runtimeco/go-coap"
+
+	"mynewt.apache.org/newtmgr/nmxact/nmp"
+)
+
+const MAX_PACKET_SIZE = 2048
+
+const DIAL_TIMEOUT = 10 * time.Second
+
+// Extracts a single message from the front of a stream buffer.  Returns the
+// message and the remaining bytes.  A nil message indicates that the buffer
+// does not contain a complete message yet.
+type PullFn func(buf []byte) ([]byte, []byte, error)
+
+// Extracts a plain NMP packet from a stream buffer.  NMP packets are
+// delimited by the length field in the NMP header.
+func PullNmp(buf []byte) ([]byte, []byte, error) {
+	hdr, err := nmp.DecodeNmpHdr(buf)
+	if err != nil {
+		// Incomplete header.
+		return nil, buf, nil
+	}
+
+	pktLen := nmp.NMP_HDR_SIZE + int(hdr.Len)
+	if len(buf) < pktLen {
+		return nil, buf, nil
+	}
+
+	return buf[:pktLen], buf[pktLen:], nil
+}
+
+// Extracts an RFC 8323 CoAP message from a stream buffer.
+func PullCoap(buf []byte) ([]byte, []byte, error) {
+	m, rest, err := coap.PullTcp(buf)
+	if err != nil {
+		return nil, buf, err
+	}
+	if m == nil {
+		return nil, buf, nil
+	}
+
+	return buf[:len(buf)-len(rest)], rest, nil
+}
+
+func Dial(peerString string) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", peerString, DIAL_TIMEOUT)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to connect to TCP peer %s: %s",
+			peerString, err.Error())
+	}
+
+	return conn, nil
+}
+
+// Starts reading from the specified connection.  Each complete message is
+// passed to dispatchCb.  When the connection fails, errCb is called and the
+// read loop terminates.
+func Listen(conn net.Conn, pullCb PullFn, dispatchCb func(data []byte),
+	errCb func(err error)) {
+
+	go func() {
+		var stream []byte
+		data := make([]byte, MAX_PACKET_SIZE)
+
+		for {
+			nr, err := conn.Read(data)
+			if err != nil {
+				// Connection closed or read error.
+				errCb(err)
+				return
+			}
+
+			log.Debugf("Received %d bytes from %v", nr, conn.RemoteAddr())
+			stream = append(stream, data[:nr]...)
+
+			for {
+				msg, rest, err := pullCb(stream)
+				if err != nil {
+					// The stream is corrupt; there is no way to
+					// resynchronize.
+					conn.Close()
+					errCb(fmt.Errorf("Invalid data from TCP peer: %s",
+						err.Error()))
+					return
+				}
+				if msg == nil {
+					break
+				}
+
+				dispatchCb(msg)
+				stream = rest
+			}
+
+			// Don't hold on to the underlying array indefinitely.
+			stream = append([]byte(nil), stream...)
+		}
+	}()
+}
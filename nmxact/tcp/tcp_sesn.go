@@ -0,0 +1,222 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package tcp
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/runtimeco/go-coap"
+
+	"mynewt.apache.org/newtmgr/nmxact/mgmt"
+	"mynewt.apache.org/newtmgr/nmxact/nmp"
+	"mynewt.apache.org/newtmgr/nmxact/nmxutil"
+	"mynewt.apache.org/newtmgr/nmxact/omp"
+	"mynewt.apache.org/newtmgr/nmxact/sesn"
+)
+
+type TcpSesn struct {
+	cfg  sesn.SesnCfg
+	conn net.Conn
+	txvr *mgmt.Transceiver
+
+	// Protects conn and txvr.
+	m sync.Mutex
+}
+
+func NewTcpSesn(cfg sesn.SesnCfg) (*TcpSesn, error) {
+	s := &TcpSesn{
+		cfg: cfg,
+	}
+
+	return s, nil
+}
+
+func (s *TcpSesn) Open() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.conn != nil {
+		return nmxutil.NewSesnAlreadyOpenError(
+			"Attempt to open an already-open TCP session")
+	}
+
+	// A new transceiver is required each time the session is opened; the
+	// old one was stopped when the session closed.
+	txvr, err := mgmt.NewTransceiver(true, s.cfg.MgmtProto, 3)
+	if err != nil {
+		return err
+	}
+
+	pullCb := PullNmp
+	if s.cfg.MgmtProto == sesn.MGMT_PROTO_OMP {
+		pullCb = PullCoap
+	}
+
+	conn, err := Dial(s.cfg.PeerSpec.Tcp)
+	if err != nil {
+		txvr.Stop()
+		return err
+	}
+
+	s.conn = conn
+	s.txvr = txvr
+
+	Listen(conn, pullCb,
+		func(data []byte) {
+			txvr.DispatchNmpRsp(data)
+		},
+		func(err error) {
+			s.closeConn(conn, err)
+		})
+
+	return nil
+}
+
+// Closes the session in response to a connection failure.  This is a no-op
+// if the specified connection has already been replaced or closed.
+func (s *TcpSesn) closeConn(conn net.Conn, err error) {
+	s.m.Lock()
+	if s.conn != conn {
+		s.m.Unlock()
+		return
+	}
+	s.shutdown(err)
+	s.m.Unlock()
+
+	if s.cfg.OnCloseCb != nil {
+		s.cfg.OnCloseCb(s, err)
+	}
+}
+
+// Releases the session's connection and fails all pending transactions.
+// The caller must hold the session mutex.
+func (s *TcpSesn) shutdown(err error) {
+	s.conn.Close()
+	s.txvr.ErrorAll(err)
+	s.txvr.Stop()
+	s.conn = nil
+}
+
+func (s *TcpSesn) Close() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.conn == nil {
+		return nmxutil.NewSesnClosedError(
+			"Attempt to close an unopened TCP session")
+	}
+
+	s.shutdown(fmt.Errorf("closed"))
+	return nil
+}
+
+func (s *TcpSesn) IsOpen() bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.conn != nil
+}
+
+func (s *TcpSesn) MtuIn() int {
+	return MAX_PACKET_SIZE -
+		omp.OMP_MSG_OVERHEAD -
+		nmp.NMP_HDR_SIZE
+}
+
+func (s *TcpSesn) MtuOut() int {
+	return MAX_PACKET_SIZE -
+		omp.OMP_MSG_OVERHEAD -
+		nmp.NMP_HDR_SIZE
+}
+
+// Retrieves the current connection and transceiver; nil if the session is
+// closed.
+func (s *TcpSesn) cur() (net.Conn, *mgmt.Transceiver) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.conn, s.txvr
+}
+
+func (s *TcpSesn) TxNmpOnce(m *nmp.NmpMsg, opt sesn.TxOptions) (
+	nmp.NmpRsp, error) {
+
+	conn, txvr := s.cur()
+	if conn == nil {
+		return nil, nmxutil.NewSesnClosedError(
+			"Attempt to transmit over closed TCP session")
+	}
+
+	txRaw := func(b []byte) error {
+		_, err := conn.Write(b)
+		return err
+	}
+	return txvr.TxNmp(txRaw, m, s.MtuOut(), opt.Timeout)
+}
+
+func (s *TcpSesn) AbortRx(seq uint8) error {
+	conn, txvr := s.cur()
+	if conn == nil {
+		return nmxutil.NewSesnClosedError("Attempt to abort closed session")
+	}
+
+	txvr.ErrorAll(fmt.Errorf("Rx aborted"))
+	return nil
+}
+
+func (s *TcpSesn) TxCoapOnce(m coap.Message, resType sesn.ResourceType,
+	opt sesn.TxOptions) (coap.COAPCode, []byte, error) {
+
+	if !s.CoapIsTcp() {
+		return 0, nil, fmt.Errorf(
+			"CoAP requests require an OIC TCP session (conntype oic_tcp)")
+	}
+
+	conn, txvr := s.cur()
+	if conn == nil {
+		return 0, nil, nmxutil.NewSesnClosedError(
+			"Attempt to transmit over closed TCP session")
+	}
+
+	txRaw := func(b []byte) error {
+		_, err := conn.Write(b)
+		return err
+	}
+
+	rsp, err := txvr.TxOic(txRaw, m, s.MtuOut(), opt.Timeout)
+	if err != nil {
+		return 0, nil, err
+	} else if rsp == nil {
+		return 0, nil, nil
+	} else {
+		return rsp.Code(), rsp.Payload(), nil
+	}
+}
+
+func (s *TcpSesn) MgmtProto() sesn.MgmtProto {
+	return s.cfg.MgmtProto
+}
+
+// Only OIC TCP sessions carry CoAP; plain TCP sessions speak bare NMP.
+func (s *TcpSesn) CoapIsTcp() bool {
+	return s.cfg.MgmtProto == sesn.MGMT_PROTO_OMP
+}
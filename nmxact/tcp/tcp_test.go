@@ -0,0 +1,178 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package tcp
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/runtimeco/go-coap"
+
+	"mynewt.apache.org/newtmgr/nmxact/nmp"
+	"mynewt.apache.org/newtmgr/nmxact/sesn"
+)
+
+func testNmpPacket(t *testing.T, payload string) []byte {
+	r := nmp.NewEchoReq()
+	r.Payload = payload
+
+	data, err := nmp.EncodeNmpPlain(r.Msg())
+	if err != nil {
+		t.Fatalf("failed to encode NMP packet: %s", err.Error())
+	}
+
+	return data
+}
+
+func testCoapPacket(t *testing.T, payload string) []byte {
+	m := coap.NewTcpMessage(coap.MessageParams{
+		Code:    coap.Content,
+		Token:   []byte{1, 2, 3, 4},
+		Payload: []byte(payload),
+	})
+
+	data, err := m.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to encode CoAP packet: %s", err.Error())
+	}
+
+	return data
+}
+
+// Feeds every prefix of two back-to-back packets to the pull function.
+// Nothing should be extracted until the first packet is complete.
+func testPull(t *testing.T, pull PullFn, pkt1 []byte, pkt2 []byte) {
+	stream := append(append([]byte(nil), pkt1...), pkt2...)
+
+	for i := 0; i <= len(stream); i++ {
+		msg, rest, err := pull(stream[:i])
+		if err != nil {
+			t.Fatalf("%d bytes: %s", i, err.Error())
+		}
+
+		if i < len(pkt1) {
+			if msg != nil {
+				t.Fatalf("%d bytes: extracted an incomplete packet", i)
+			}
+			if !bytes.Equal(rest, stream[:i]) {
+				t.Fatalf("%d bytes: incomplete packet consumed", i)
+			}
+			continue
+		}
+
+		if !bytes.Equal(msg, pkt1) {
+			t.Fatalf("%d bytes: extracted %x; want %x", i, msg, pkt1)
+		}
+		if !bytes.Equal(rest, stream[len(pkt1):i]) {
+			t.Fatalf("%d bytes: remainder %x; want %x", i, rest,
+				stream[len(pkt1):i])
+		}
+	}
+}
+
+func TestPullNmp(t *testing.T) {
+	testPull(t, PullNmp, testNmpPacket(t, "first"), testNmpPacket(t, "2nd"))
+}
+
+func TestPullCoap(t *testing.T) {
+	testPull(t, PullCoap, testCoapPacket(t, "first"),
+		testCoapPacket(t, "second packet"))
+}
+
+// Writes packets split into small, unaligned pieces and checks that each is
+// dispatched intact.
+func TestListen(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	var pkts [][]byte
+	var stream []byte
+	for i := 0; i < 5; i++ {
+		pkt := testNmpPacket(t, fmt.Sprintf("packet %d", i))
+		pkts = append(pkts, pkt)
+		stream = append(stream, pkt...)
+	}
+
+	msgCh := make(chan []byte, len(pkts))
+	errCh := make(chan error, 1)
+	Listen(client, PullNmp,
+		func(data []byte) { msgCh <- append([]byte(nil), data...) },
+		func(err error) { errCh <- err })
+
+	for len(stream) > 0 {
+		n := 7
+		if n > len(stream) {
+			n = len(stream)
+		}
+		if _, err := server.Write(stream[:n]); err != nil {
+			t.Fatalf("write failed: %s", err.Error())
+		}
+		stream = stream[n:]
+	}
+
+	for i, pkt := range pkts {
+		select {
+		case msg := <-msgCh:
+			if !bytes.Equal(msg, pkt) {
+				t.Errorf("packet %d: got %x; want %x", i, msg, pkt)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("packet %d not dispatched", i)
+		}
+	}
+
+	server.Close()
+	select {
+	case <-errCh:
+	case <-time.After(time.Second):
+		t.Fatalf("closed connection not reported")
+	}
+}
+
+func TestSesnCoapIsTcp(t *testing.T) {
+	for _, proto := range []sesn.MgmtProto{
+		sesn.MGMT_PROTO_NMP, sesn.MGMT_PROTO_OMP} {
+
+		cfg := sesn.NewSesnCfg()
+		cfg.MgmtProto = proto
+		s, err := NewTcpSesn(cfg)
+		if err != nil {
+			t.Fatalf("failed to create session: %s", err.Error())
+		}
+
+		wantCoap := proto == sesn.MGMT_PROTO_OMP
+		if s.CoapIsTcp() != wantCoap {
+			t.Errorf("proto %d: CoapIsTcp=%v; want %v",
+				proto, s.CoapIsTcp(), wantCoap)
+		}
+
+		if !wantCoap {
+			m := coap.NewTcpMessage(coap.MessageParams{Code: coap.GET})
+			if _, _, err := s.TxCoapOnce(m, sesn.RES_TYPE_PUBLIC,
+				sesn.NewTxOptions()); err == nil {
+
+				t.Errorf("proto %d: CoAP request not rejected", proto)
+			}
+		}
+	}
+}
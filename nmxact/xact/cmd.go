@@ -21,6 +21,7 @@ package xact
 
 import (
 	"fmt"
+	"sync"
 
 	"mynewt.apache.org/newtmgr/nmxact/sesn"
 )
@@ -40,9 +41,19 @@ type Cmd interface {
 
 type CmdBase struct {
 	txOptions sesn.TxOptions
-	curNmpSeq uint8
 	curSesn   sesn.Sesn
 	abortErr  error
+
+	// Sequence numbers of the requests awaiting a response.  Some commands
+	// have several requests in flight at once.
+	curNmpSeqs map[uint8]struct{}
+
+	// Commands being run on behalf of this one.
+	subCmds map[Cmd]struct{}
+
+	// Protects curSesn, abortErr, curNmpSeqs and subCmds; Abort() can be
+	// called from any goroutine.
+	mtx sync.Mutex
 }
 
 func NewCmdBase() CmdBase {
@@ -60,8 +71,16 @@ func (c *CmdBase) SetTxOptions(opt sesn.TxOptions) {
 }
 
 func (c *CmdBase) Abort() error {
-	if c.curSesn != nil {
-		if err := c.curSesn.AbortRx(c.curNmpSeq); err != nil {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for seq := range c.curNmpSeqs {
+		if err := c.curSesn.AbortRx(seq); err != nil {
+			return err
+		}
+	}
+	for sub := range c.subCmds {
+		if err := sub.Abort(); err != nil {
 			return err
 		}
 	}
@@ -69,3 +88,64 @@ func (c *CmdBase) Abort() error {
 	c.abortErr = fmt.Errorf("Command aborted")
 	return nil
 }
+
+// Returns a non-nil error if the command has been aborted.
+func (c *CmdBase) aborted() error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return c.abortErr
+}
+
+// Runs a command on behalf of this one.  Aborting this command aborts the
+// other one too.
+func (c *CmdBase) runSub(sub Cmd, s sesn.Sesn) (Result, error) {
+	c.mtx.Lock()
+	if c.abortErr != nil {
+		c.mtx.Unlock()
+		return nil, c.abortErr
+	}
+	if c.subCmds == nil {
+		c.subCmds = map[Cmd]struct{}{}
+	}
+	c.subCmds[sub] = struct{}{}
+	c.mtx.Unlock()
+
+	defer func() {
+		c.mtx.Lock()
+		delete(c.subCmds, sub)
+		c.mtx.Unlock()
+	}()
+
+	return sub.Run(s)
+}
+
+// Records that a request is about to be sent so that Abort() can cancel
+// it.  Fails if the command has been aborted.
+func (c *CmdBase) txStart(s sesn.Sesn, seq uint8) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.abortErr != nil {
+		return c.abortErr
+	}
+
+	if c.curNmpSeqs == nil {
+		c.curNmpSeqs = map[uint8]struct{}{}
+	}
+	c.curNmpSeqs[seq] = struct{}{}
+	c.curSesn = s
+
+	return nil
+}
+
+// Records that a request has completed.
+func (c *CmdBase) txDone(seq uint8) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	delete(c.curNmpSeqs, seq)
+	if len(c.curNmpSeqs) == 0 {
+		c.curSesn = nil
+	}
+}
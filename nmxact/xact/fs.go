@@ -31,10 +31,14 @@ import (
 // $download                                                                //
 //////////////////////////////////////////////////////////////////////////////
 
+// If StartOff is nonzero, the download resumes at that offset.  If the
+// session drops, it is reopened and the last request is retried.
+
 type FsDownloadProgressCb func(c *FsDownloadCmd, r *nmp.FsDownloadRsp)
 type FsDownloadCmd struct {
 	CmdBase
 	Name       string
+	StartOff   int
 	ProgressCb FsDownloadProgressCb
 }
 
@@ -46,6 +50,9 @@ func NewFsDownloadCmd() *FsDownloadCmd {
 
 type FsDownloadResult struct {
 	Rsps []*nmp.FsDownloadRsp
+
+	// The file length reported by the device; 0 if it wasn't reported.
+	Len uint32
 }
 
 func newFsDownloadResult() *FsDownloadResult {
@@ -57,9 +64,10 @@ func (r *FsDownloadResult) Status() int {
 	return rsp.Rc
 }
 
-func (c *FsDownloadCmd) Run(s sesn.Sesn) (Result, error) {
-	res := newFsDownloadResult()
-	off := 0
+// Requests a single chunk.  If the session drops, it is reopened and the
+// request is retried.
+func (c *FsDownloadCmd) txChunk(s sesn.Sesn, off int) (
+	*nmp.FsDownloadRsp, error) {
 
 	for {
 		r := nmp.NewFsDownloadReq()
@@ -67,22 +75,66 @@ func (c *FsDownloadCmd) Run(s sesn.Sesn) (Result, error) {
 		r.Off = uint32(off)
 
 		rsp, err := txReq(s, r.Msg(), &c.CmdBase)
+		if err == nil {
+			return rsp.(*nmp.FsDownloadRsp), nil
+		}
+
+		if err := rescueSesn(s, err, &c.CmdBase); err != nil {
+			return nil, err
+		}
+
+		// Disconnected but recovered; retry last request.
+	}
+}
+
+func (c *FsDownloadCmd) Run(s sesn.Sesn) (Result, error) {
+	res := newFsDownloadResult()
+	off := c.StartOff
+
+	// Some devices only report the length in the response to a request for
+	// offset 0.  When resuming, ask for it so the caller can check the
+	// result.
+	if off > 0 {
+		frsp, err := c.txChunk(s, 0)
+		if err != nil {
+			return nil, err
+		}
+		res.Rsps = append(res.Rsps, frsp)
+
+		if frsp.Rc != 0 {
+			return res, nil
+		}
+		res.Len = frsp.Len
+
+		// Nothing left to download, or the caller's partial copy is
+		// longer than the file.
+		if res.Len != 0 && off >= int(res.Len) {
+			return res, nil
+		}
+	}
+
+	for {
+		frsp, err := c.txChunk(s, off)
 		if err != nil {
 			return nil, err
 		}
-		frsp := rsp.(*nmp.FsDownloadRsp)
 		res.Rsps = append(res.Rsps, frsp)
 
 		if frsp.Rc != 0 {
 			break
 		}
 
+		if frsp.Len != 0 {
+			res.Len = frsp.Len
+		}
+
 		if c.ProgressCb != nil {
 			c.ProgressCb(c, frsp)
 		}
 
+		// Without a length, keep reading until the device runs out of data.
 		off = int(frsp.Off) + len(frsp.Data)
-		if off >= int(frsp.Len) {
+		if len(frsp.Data) == 0 || (res.Len != 0 && off >= int(res.Len)) {
 			break
 		}
 	}
@@ -94,11 +146,17 @@ func (c *FsDownloadCmd) Run(s sesn.Sesn) (Result, error) {
 // $upload                                                                  //
 //////////////////////////////////////////////////////////////////////////////
 
+// If StartOff is nonzero, the upload resumes at that offset.  If the device
+// expects a different offset, it says so in its response and the upload
+// continues from there.  If the session drops, it is reopened and the last
+// request is retried.
+
 type FsUploadProgressCb func(c *FsUploadCmd, r *nmp.FsUploadRsp)
 type FsUploadCmd struct {
 	CmdBase
 	Name       string
 	Data       []byte
+	StartOff   int
 	ProgressCb FsUploadProgressCb
 }
 
@@ -153,6 +211,11 @@ func nextFsUploadReq(s sesn.Sesn, name string, data []byte, off int) (
 			"MTU too low to fit any image data")
 	}
 
+	if off+room > len(data) {
+		// Final chunk.
+		room = len(data) - off
+	}
+
 	// Assume all the unused space can hold image data.  This assumption may
 	// not be valid for some encodings (e.g., CBOR uses variable length fields
 	// to encodes byte string lengths).
@@ -174,7 +237,8 @@ func nextFsUploadReq(s sesn.Sesn, name string, data []byte, off int) (
 func (c *FsUploadCmd) Run(s sesn.Sesn) (Result, error) {
 	res := newFsUploadResult()
 
-	for off := 0; off < len(c.Data); {
+	// An empty file still takes one request to create.
+	for off := c.StartOff; off < len(c.Data) || len(res.Rsps) == 0; {
 		r, err := nextFsUploadReq(s, c.Name, c.Data, off)
 		if err != nil {
 			return nil, err
@@ -182,7 +246,12 @@ func (c *FsUploadCmd) Run(s sesn.Sesn) (Result, error) {
 
 		rsp, err := txReq(s, r.Msg(), &c.CmdBase)
 		if err != nil {
-			return nil, err
+			if err := rescueSesn(s, err, &c.CmdBase); err != nil {
+				return nil, err
+			}
+
+			// Disconnected but recovered; retry last request.
+			continue
 		}
 		crsp := rsp.(*nmp.FsUploadRsp)
 
@@ -200,3 +269,286 @@ func (c *FsUploadCmd) Run(s sesn.Sesn) (Result, error) {
 
 	return res, nil
 }
+
+//////////////////////////////////////////////////////////////////////////////
+// $stat                                                                    //
+//////////////////////////////////////////////////////////////////////////////
+
+type FsStatCmd struct {
+	CmdBase
+	Name string
+}
+
+func NewFsStatCmd() *FsStatCmd {
+	return &FsStatCmd{
+		CmdBase: NewCmdBase(),
+	}
+}
+
+type FsStatResult struct {
+	Rsp *nmp.FsStatRsp
+}
+
+func newFsStatResult() *FsStatResult {
+	return &FsStatResult{}
+}
+
+func (r *FsStatResult) Status() int {
+	return r.Rsp.Rc
+}
+
+func (c *FsStatCmd) Run(s sesn.Sesn) (Result, error) {
+	r := nmp.NewFsStatReq()
+	r.Name = c.Name
+
+	rsp, err := txReq(s, r.Msg(), &c.CmdBase)
+	if err != nil {
+		return nil, err
+	}
+	srsp := rsp.(*nmp.FsStatRsp)
+
+	res := newFsStatResult()
+	res.Rsp = srsp
+	return res, nil
+}
+
+//////////////////////////////////////////////////////////////////////////////
+// $hash                                                                    //
+//////////////////////////////////////////////////////////////////////////////
+
+type FsHashCmd struct {
+	CmdBase
+	Name string
+	Type string
+	Off  uint32
+	Len  uint32
+}
+
+func NewFsHashCmd() *FsHashCmd {
+	return &FsHashCmd{
+		CmdBase: NewCmdBase(),
+	}
+}
+
+type FsHashResult struct {
+	Rsp *nmp.FsHashRsp
+}
+
+func newFsHashResult() *FsHashResult {
+	return &FsHashResult{}
+}
+
+func (r *FsHashResult) Status() int {
+	return r.Rsp.Rc
+}
+
+func (c *FsHashCmd) Run(s sesn.Sesn) (Result, error) {
+	r := nmp.NewFsHashReq()
+	r.Name = c.Name
+	r.Type = c.Type
+	r.Off = c.Off
+	r.Len = c.Len
+
+	rsp, err := txReq(s, r.Msg(), &c.CmdBase)
+	if err != nil {
+		return nil, err
+	}
+	srsp := rsp.(*nmp.FsHashRsp)
+
+	res := newFsHashResult()
+	res.Rsp = srsp
+	return res, nil
+}
+
+//////////////////////////////////////////////////////////////////////////////
+// $dir                                                                     //
+//////////////////////////////////////////////////////////////////////////////
+
+type FsDirListCmd struct {
+	CmdBase
+	Name string
+}
+
+func NewFsDirListCmd() *FsDirListCmd {
+	return &FsDirListCmd{
+		CmdBase: NewCmdBase(),
+	}
+}
+
+// Contains one response per page of the listing.
+type FsDirListResult struct {
+	Rsps []*nmp.FsDirListRsp
+}
+
+func newFsDirListResult() *FsDirListResult {
+	return &FsDirListResult{}
+}
+
+func (r *FsDirListResult) Status() int {
+	rsp := r.Rsps[len(r.Rsps)-1]
+	return rsp.Rc
+}
+
+// Returns the entries from every page.
+func (r *FsDirListResult) Entries() []nmp.FsDirEntry {
+	entries := []nmp.FsDirEntry{}
+	for _, rsp := range r.Rsps {
+		entries = append(entries, rsp.Entries...)
+	}
+
+	return entries
+}
+
+func (c *FsDirListCmd) Run(s sesn.Sesn) (Result, error) {
+	res := newFsDirListResult()
+	off := 0
+
+	for {
+		r := nmp.NewFsDirListReq()
+		r.Name = c.Name
+		r.Off = uint32(off)
+
+		rsp, err := txReq(s, r.Msg(), &c.CmdBase)
+		if err != nil {
+			return nil, err
+		}
+		frsp := rsp.(*nmp.FsDirListRsp)
+		res.Rsps = append(res.Rsps, frsp)
+
+		if frsp.Rc != 0 || !frsp.More || len(frsp.Entries) == 0 {
+			break
+		}
+
+		off += len(frsp.Entries)
+	}
+
+	return res, nil
+}
+
+//////////////////////////////////////////////////////////////////////////////
+// $mkdir                                                                   //
+//////////////////////////////////////////////////////////////////////////////
+
+type FsMkdirCmd struct {
+	CmdBase
+	Name string
+}
+
+func NewFsMkdirCmd() *FsMkdirCmd {
+	return &FsMkdirCmd{
+		CmdBase: NewCmdBase(),
+	}
+}
+
+type FsMkdirResult struct {
+	Rsp *nmp.FsMkdirRsp
+}
+
+func newFsMkdirResult() *FsMkdirResult {
+	return &FsMkdirResult{}
+}
+
+func (r *FsMkdirResult) Status() int {
+	return r.Rsp.Rc
+}
+
+func (c *FsMkdirCmd) Run(s sesn.Sesn) (Result, error) {
+	r := nmp.NewFsMkdirReq()
+	r.Name = c.Name
+
+	rsp, err := txReq(s, r.Msg(), &c.CmdBase)
+	if err != nil {
+		return nil, err
+	}
+	srsp := rsp.(*nmp.FsMkdirRsp)
+
+	res := newFsMkdirResult()
+	res.Rsp = srsp
+	return res, nil
+}
+
+//////////////////////////////////////////////////////////////////////////////
+// $unlink                                                                  //
+//////////////////////////////////////////////////////////////////////////////
+
+type FsUnlinkCmd struct {
+	CmdBase
+	Name string
+}
+
+func NewFsUnlinkCmd() *FsUnlinkCmd {
+	return &FsUnlinkCmd{
+		CmdBase: NewCmdBase(),
+	}
+}
+
+type FsUnlinkResult struct {
+	Rsp *nmp.FsUnlinkRsp
+}
+
+func newFsUnlinkResult() *FsUnlinkResult {
+	return &FsUnlinkResult{}
+}
+
+func (r *FsUnlinkResult) Status() int {
+	return r.Rsp.Rc
+}
+
+func (c *FsUnlinkCmd) Run(s sesn.Sesn) (Result, error) {
+	r := nmp.NewFsUnlinkReq()
+	r.Name = c.Name
+
+	rsp, err := txReq(s, r.Msg(), &c.CmdBase)
+	if err != nil {
+		return nil, err
+	}
+	srsp := rsp.(*nmp.FsUnlinkRsp)
+
+	res := newFsUnlinkResult()
+	res.Rsp = srsp
+	return res, nil
+}
+
+//////////////////////////////////////////////////////////////////////////////
+// $rename                                                                  //
+//////////////////////////////////////////////////////////////////////////////
+
+type FsRenameCmd struct {
+	CmdBase
+	From string
+	To   string
+}
+
+func NewFsRenameCmd() *FsRenameCmd {
+	return &FsRenameCmd{
+		CmdBase: NewCmdBase(),
+	}
+}
+
+type FsRenameResult struct {
+	Rsp *nmp.FsRenameRsp
+}
+
+func newFsRenameResult() *FsRenameResult {
+	return &FsRenameResult{}
+}
+
+func (r *FsRenameResult) Status() int {
+	return r.Rsp.Rc
+}
+
+func (c *FsRenameCmd) Run(s sesn.Sesn) (Result, error) {
+	r := nmp.NewFsRenameReq()
+	r.From = c.From
+	r.To = c.To
+
+	rsp, err := txReq(s, r.Msg(), &c.CmdBase)
+	if err != nil {
+		return nil, err
+	}
+	srsp := rsp.(*nmp.FsRenameRsp)
+
+	res := newFsRenameResult()
+	res.Rsp = srsp
+	return res, nil
+}
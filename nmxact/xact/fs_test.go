@@ -0,0 +1,173 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package xact
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"mynewt.apache.org/newtmgr/nmxact/nmp"
+	"mynewt.apache.org/newtmgr/nmxact/sim"
+)
+
+func testFileData(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i * 13)
+	}
+
+	return data
+}
+
+func TestFsUpload(t *testing.T) {
+	sx, s := newSimSesn(t, sim.NewXportCfg())
+	defer sx.Stop()
+
+	for _, size := range []int{0, 100, 3000} {
+		name := fmt.Sprintf("/cfg/file%d", size)
+		data := testFileData(size)
+
+		c := NewFsUploadCmd()
+		c.Name = name
+		c.Data = data
+		runCmd(t, c, s)
+
+		got, ok := sx.Device().File(name)
+		if !ok {
+			t.Errorf("%s not created", name)
+		} else if !bytes.Equal(got, data) {
+			t.Errorf("%s contains %d bytes; want %d", name, len(got),
+				len(data))
+		}
+	}
+}
+
+func TestFsDownloadResume(t *testing.T) {
+	sx, s := newSimSesn(t, sim.NewXportCfg())
+	defer sx.Stop()
+
+	data := testFileData(2000)
+	sx.Device().SetFile("/log.txt", data)
+
+	tests := []struct {
+		off  int
+		want []byte
+	}{
+		{700, data[700:]},
+		{2000, nil},
+		{2500, nil},
+	}
+
+	for _, test := range tests {
+		var got []byte
+		c := NewFsDownloadCmd()
+		c.Name = "/log.txt"
+		c.StartOff = test.off
+		c.ProgressCb = func(c *FsDownloadCmd, rsp *nmp.FsDownloadRsp) {
+			got = append(got, rsp.Data...)
+		}
+		res := runCmd(t, c, s).(*FsDownloadResult)
+
+		if res.Len != uint32(len(data)) {
+			t.Errorf("download from %d reports length %d; want %d",
+				test.off, res.Len, len(data))
+		}
+		if !bytes.Equal(got, test.want) {
+			t.Errorf("download from %d returned %d bytes; want %d",
+				test.off, len(got), len(test.want))
+		}
+	}
+}
+
+func TestFsDirOps(t *testing.T) {
+	sx, s := newSimSesn(t, sim.NewXportCfg())
+	defer sx.Stop()
+
+	for i := 0; i < 20; i++ {
+		sx.Device().SetFile(fmt.Sprintf("/data/f%02d", i), []byte("x"))
+	}
+
+	mc := NewFsMkdirCmd()
+	mc.Name = "/data/sub"
+	runCmd(t, mc, s)
+
+	// The listing doesn't fit in one response.
+	lc := NewFsDirListCmd()
+	lc.Name = "/data"
+	entries := runCmd(t, lc, s).(*FsDirListResult).Entries()
+	if len(entries) != 21 {
+		t.Fatalf("listed %d entries; want 21", len(entries))
+	}
+	if e := entries[20]; e.Name != "sub" || !e.Dir {
+		t.Errorf("unexpected last entry: %+v", e)
+	}
+
+	rc := NewFsRenameCmd()
+	rc.From = "/data/f00"
+	rc.To = "/data/sub/g"
+	runCmd(t, rc, s)
+
+	if _, ok := sx.Device().File("/data/sub/g"); !ok {
+		t.Errorf("rename didn't create /data/sub/g")
+	}
+
+	// A directory can't be removed until it's empty.
+	uc := NewFsUnlinkCmd()
+	uc.Name = "/data/sub"
+	res, err := uc.Run(s)
+	if err != nil {
+		t.Fatalf("unlink failed: %s", err.Error())
+	}
+	if res.Status() == 0 {
+		t.Errorf("removed a non-empty directory")
+	}
+
+	uc.Name = "/data/sub/g"
+	runCmd(t, uc, s)
+
+	sc := NewFsStatCmd()
+	sc.Name = "/data/sub/g"
+	res, err = sc.Run(s)
+	if err != nil {
+		t.Fatalf("stat failed: %s", err.Error())
+	}
+	if res.Status() != nmp.NMP_ERR_ENOENT {
+		t.Errorf("stat of removed file: rc=%d; want %d", res.Status(),
+			nmp.NMP_ERR_ENOENT)
+	}
+}
+
+func TestFsHash(t *testing.T) {
+	sx, s := newSimSesn(t, sim.NewXportCfg())
+	defer sx.Stop()
+
+	sx.Device().SetFile("/f", []byte("123456789"))
+
+	c := NewFsHashCmd()
+	c.Name = "/f"
+	c.Type = nmp.FS_HASH_CRC32
+	res := runCmd(t, c, s).(*FsHashResult)
+
+	// Standard CRC-32 check value.
+	if v, ok := res.Rsp.Output.(uint64); !ok || v != 0xcbf43926 {
+		t.Errorf("crc32 = %v; want 0xcbf43926", res.Rsp.Output)
+	}
+}
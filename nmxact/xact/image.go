@@ -20,12 +20,15 @@
 package xact
 
 import (
+	"bytes"
 	"fmt"
+	"time"
 
 	"github.com/cheggaaa/pb"
 
 	"mynewt.apache.org/newtmgr/nmxact/mgmt"
 	"mynewt.apache.org/newtmgr/nmxact/nmp"
+	"mynewt.apache.org/newtmgr/nmxact/nmxutil"
 	"mynewt.apache.org/newtmgr/nmxact/sesn"
 )
 
@@ -40,6 +43,9 @@ type ImageUploadCmd struct {
 	Data       []byte
 	StartOff   int
 	ProgressCb ImageUploadProgressFn
+
+	// Maximum number of upload requests that can be outstanding at once.
+	MaxWinSz int
 }
 
 type ImageUploadResult struct {
@@ -48,7 +54,8 @@ type ImageUploadResult struct {
 
 func NewImageUploadCmd() *ImageUploadCmd {
 	return &ImageUploadCmd{
-		CmdBase: NewCmdBase(),
+		CmdBase:  NewCmdBase(),
+		MaxWinSz: 1,
 	}
 }
 
@@ -123,7 +130,9 @@ func nextImageUploadReq(s sesn.Sesn, data []byte, off int) (
 	return r, nil
 }
 
-func (c *ImageUploadCmd) Run(s sesn.Sesn) (Result, error) {
+// Sends image chunks one at a time, waiting for each response before sending
+// the next chunk.
+func (c *ImageUploadCmd) runSerial(s sesn.Sesn) (Result, error) {
 	res := newImageUploadResult()
 
 	for off := c.StartOff; off < len(c.Data); {
@@ -153,6 +162,149 @@ func (c *ImageUploadCmd) Run(s sesn.Sesn) (Result, error) {
 	return res, nil
 }
 
+type imageUploadWinRsp struct {
+	req *nmp.ImageUploadReq
+	rsp *nmp.ImageUploadRsp
+	gen int
+	err error
+}
+
+// Keeps up to MaxWinSz image chunks in flight at once.  The device only
+// accepts the chunk that starts at its current offset; it responds to any
+// other chunk with the offset it expects.  When that happens, the upload
+// rewinds to the reported offset.  Responses to chunks sent before the
+// rewind are stale and do not trigger further rewinds.
+func (c *ImageUploadCmd) runWindowed(s sesn.Sesn) (Result, error) {
+	res := newImageUploadResult()
+
+	// Responses are delivered by per-request goroutines; the buffer ensures
+	// none of them blocks if the upload terminates early.
+	rspCh := make(chan imageUploadWinRsp, c.MaxWinSz)
+
+	// Next offset to send.
+	off := c.StartOff
+
+	// Highest offset the device has acknowledged.
+	devOff := c.StartOff
+
+	// Incremented every time the upload rewinds.
+	gen := 0
+
+	// The first chunk initiates the upload on the device; don't send any
+	// more until it has been accepted.
+	winSz := 1
+
+	// Each goroutine signals this just before it transmits its request.
+	// Waiting for the signal keeps the requests mostly in order.
+	txCh := make(chan struct{})
+
+	inFlight := 0
+	var err error
+	done := false
+
+	for {
+		for !done && err == nil && inFlight < winSz &&
+			off < len(c.Data) {
+
+			if aerr := c.aborted(); aerr != nil {
+				err = aerr
+				break
+			}
+
+			r, rerr := nextImageUploadReq(s, c.Data, off)
+			if rerr != nil {
+				err = rerr
+				break
+			}
+
+			reqGen := gen
+			go func() {
+				txCh <- struct{}{}
+				rsp, err := txReq(s, r.Msg(), &c.CmdBase)
+				wr := imageUploadWinRsp{req: r, gen: reqGen, err: err}
+				if err == nil {
+					wr.rsp = rsp.(*nmp.ImageUploadRsp)
+				}
+				rspCh <- wr
+			}()
+			<-txCh
+
+			off += len(r.Data)
+			inFlight++
+		}
+
+		if inFlight == 0 {
+			if done || err != nil || devOff >= len(c.Data) {
+				break
+			}
+
+			// Everything was sent, but the device did not acknowledge all
+			// of it.  Resume from the last acknowledged offset.
+			off = devOff
+			gen++
+			continue
+		}
+
+		wr := <-rspCh
+		inFlight--
+
+		if wr.err != nil {
+			// Wait for the remaining requests to complete before
+			// reporting the error.
+			if err == nil {
+				err = wr.err
+			}
+			continue
+		}
+		if done || err != nil {
+			continue
+		}
+
+		irsp := wr.rsp
+		res.Rsps = append(res.Rsps, irsp)
+
+		if irsp.Rc != 0 {
+			if c.ProgressCb != nil {
+				c.ProgressCb(c, irsp)
+			}
+			done = true
+			continue
+		}
+
+		// Responses can arrive out of order; only report progress when the
+		// acknowledged offset advances.
+		if int(irsp.Off) > devOff {
+			devOff = int(irsp.Off)
+			winSz = c.MaxWinSz
+
+			if c.ProgressCb != nil {
+				c.ProgressCb(c, irsp)
+			}
+		}
+
+		expOff := int(wr.req.Off) + len(wr.req.Data)
+		if int(irsp.Off) != expOff && wr.gen == gen {
+			// The device rejected the chunk; rewind.
+			off = int(irsp.Off)
+			gen++
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (c *ImageUploadCmd) Run(s sesn.Sesn) (Result, error) {
+	if c.MaxWinSz <= 1 {
+		return c.runSerial(s)
+	} else {
+		return c.runWindowed(s)
+	}
+}
+
 //////////////////////////////////////////////////////////////////////////////
 // $upgrade                                                                 //
 //////////////////////////////////////////////////////////////////////////////
@@ -170,14 +322,21 @@ func (c *ImageUploadCmd) Run(s sesn.Sesn) (Result, error) {
 //    to step 5.
 // 5. Execute the upload command.  If the connection drops before the final
 //    part is uploaded, reconnect and retry the previous part.
+//
+// If StartOff is nonzero, the command resumes an upload that was previously
+// interrupted: the erase is skipped and the upload starts at the specified
+// offset.  If the device expects a different offset, it says so in its first
+// response and the upload continues from there.
 
 type ImageUpgradeCmd struct {
 	CmdBase
 	Data        []byte
 	NoErase     bool
+	StartOff    int
 	ProgressCb  ImageUploadProgressFn
 	LastOff     uint32
 	ProgressBar *pb.ProgressBar
+	MaxWinSz    int
 }
 
 type ImageUpgradeResult struct {
@@ -187,8 +346,9 @@ type ImageUpgradeResult struct {
 
 func NewImageUpgradeCmd() *ImageUpgradeCmd {
 	return &ImageUpgradeCmd{
-		CmdBase: NewCmdBase(),
-		NoErase: false,
+		CmdBase:  NewCmdBase(),
+		NoErase:  false,
+		MaxWinSz: 1,
 	}
 }
 
@@ -208,21 +368,13 @@ func (r *ImageUpgradeResult) Status() int {
 
 // Attempts to recover from a disconnect.
 func (c *ImageUpgradeCmd) rescue(s sesn.Sesn, err error) error {
-	if err != nil {
-		if !s.IsOpen() {
-			if err := s.Open(); err == nil {
-				return nil
-			}
-		}
-	}
-
-	return err
+	return rescueSesn(s, err, &c.CmdBase)
 }
 
 func (c *ImageUpgradeCmd) runErase(s sesn.Sesn) (*ImageEraseResult, error) {
 	cmd := NewImageEraseCmd()
 	cmd.SetTxOptions(c.TxOptions())
-	res, err := cmd.Run(s)
+	res, err := c.runSub(cmd, s)
 
 	if err := c.rescue(s, err); err != nil {
 		return nil, err
@@ -238,12 +390,14 @@ func (c *ImageUpgradeCmd) runErase(s sesn.Sesn) (*ImageEraseResult, error) {
 }
 
 func (c *ImageUpgradeCmd) runUpload(s sesn.Sesn) (*ImageUploadResult, error) {
-	startOff := 0
+	startOff := c.StartOff
 	progressCb := func(uc *ImageUploadCmd, r *nmp.ImageUploadRsp) {
 		if r.Rc == 0 {
 			startOff = int(r.Off)
 		}
-		c.ProgressCb(uc, r)
+		if c.ProgressCb != nil {
+			c.ProgressCb(uc, r)
+		}
 	}
 
 	for {
@@ -251,9 +405,10 @@ func (c *ImageUpgradeCmd) runUpload(s sesn.Sesn) (*ImageUploadResult, error) {
 		cmd.Data = c.Data
 		cmd.StartOff = startOff
 		cmd.ProgressCb = progressCb
+		cmd.MaxWinSz = c.MaxWinSz
 		cmd.SetTxOptions(c.TxOptions())
 
-		res, err := cmd.Run(s)
+		res, err := c.runSub(cmd, s)
 		if err == nil {
 			return res.(*ImageUploadResult), nil
 		}
@@ -271,7 +426,7 @@ func (c *ImageUpgradeCmd) Run(s sesn.Sesn) (Result, error) {
 	var eres *ImageEraseResult = nil
 	var err error
 
-	if c.NoErase == false {
+	if c.NoErase == false && c.StartOff == 0 {
 		eres, err = c.runErase(s)
 		if err != nil {
 			return nil, err
@@ -374,6 +529,285 @@ func (c *ImageStateWriteCmd) Run(s sesn.Sesn) (Result, error) {
 	return res, nil
 }
 
+//////////////////////////////////////////////////////////////////////////////
+// $upgrade and verify                                                      //
+//////////////////////////////////////////////////////////////////////////////
+
+type ImageUpgradeVerifyStep int
+
+const (
+	IMAGE_UPGRADE_VERIFY_STEP_UPLOAD ImageUpgradeVerifyStep = iota
+	IMAGE_UPGRADE_VERIFY_STEP_TEST
+	IMAGE_UPGRADE_VERIFY_STEP_RESET
+	IMAGE_UPGRADE_VERIFY_STEP_BOOT
+	IMAGE_UPGRADE_VERIFY_STEP_HEALTH
+	IMAGE_UPGRADE_VERIFY_STEP_CONFIRM
+)
+
+var imageUpgradeVerifyStepNameMap = map[ImageUpgradeVerifyStep]string{
+	IMAGE_UPGRADE_VERIFY_STEP_UPLOAD:  "upload",
+	IMAGE_UPGRADE_VERIFY_STEP_TEST:    "test",
+	IMAGE_UPGRADE_VERIFY_STEP_RESET:   "reset",
+	IMAGE_UPGRADE_VERIFY_STEP_BOOT:    "boot",
+	IMAGE_UPGRADE_VERIFY_STEP_HEALTH:  "health",
+	IMAGE_UPGRADE_VERIFY_STEP_CONFIRM: "confirm",
+}
+
+func (s ImageUpgradeVerifyStep) String() string {
+	name := imageUpgradeVerifyStepNameMap[s]
+	if name == "" {
+		name = "???"
+	}
+
+	return name
+}
+
+type ImageUpgradeVerifyStepFn func(step ImageUpgradeVerifyStep)
+
+// Checks a device that has booted into a test image.  A non-nil error leaves
+// the image unconfirmed.
+type ImageUpgradeVerifyHealthFn func(s sesn.Sesn) error
+
+// Uploads an image, marks it for test, resets the device, and confirms the
+// image if the device boots into it.  If the device rolls back to its
+// previous image, the result's RolledBack field is set and nothing is
+// confirmed.  Likewise if the optional health check fails.
+type ImageUpgradeVerifyCmd struct {
+	CmdBase
+	Data       []byte
+	Hash       []byte
+	NoErase    bool
+	ProgressCb ImageUploadProgressFn
+	StepCb     ImageUpgradeVerifyStepFn
+	HealthCb   ImageUpgradeVerifyHealthFn
+	MaxWinSz   int
+
+	// How long to wait for the device to respond after it is reset.
+	BootTimeout time.Duration
+
+	// Minimum time to wait before reading the image state if the session
+	// survives the reset.  Mynewt resets a short while after it responds, so
+	// an early read can reach the old image.
+	BootSettle time.Duration
+}
+
+type ImageUpgradeVerifyResult struct {
+	UpgradeRes *ImageUpgradeResult
+	TestRes    *ImageStateWriteResult
+	BootRes    *ImageStateReadResult
+	ConfirmRes *ImageStateWriteResult
+
+	// Whether the device booted into an image other than the uploaded one.
+	RolledBack bool
+
+	// The health check failure, if any.
+	HealthErr error
+}
+
+func NewImageUpgradeVerifyCmd() *ImageUpgradeVerifyCmd {
+	return &ImageUpgradeVerifyCmd{
+		CmdBase:     NewCmdBase(),
+		MaxWinSz:    1,
+		BootTimeout: 60 * time.Second,
+		BootSettle:  2 * time.Second,
+	}
+}
+
+func newImageUpgradeVerifyResult() *ImageUpgradeVerifyResult {
+	return &ImageUpgradeVerifyResult{}
+}
+
+// Indicates the status of the last step that was run.
+func (r *ImageUpgradeVerifyResult) Status() int {
+	if r.ConfirmRes != nil {
+		return r.ConfirmRes.Status()
+	} else if r.BootRes != nil {
+		return r.BootRes.Status()
+	} else if r.TestRes != nil {
+		return r.TestRes.Status()
+	} else if r.UpgradeRes != nil {
+		return r.UpgradeRes.Status()
+	} else {
+		return nmp.NMP_ERR_EUNKNOWN
+	}
+}
+
+func (c *ImageUpgradeVerifyCmd) step(step ImageUpgradeVerifyStep) {
+	if c.StepCb != nil {
+		c.StepCb(step)
+	}
+}
+
+func (c *ImageUpgradeVerifyCmd) runReset(s sesn.Sesn) error {
+	cmd := NewResetCmd()
+	cmd.SetTxOptions(c.TxOptions())
+
+	_, err := c.runSub(cmd, s)
+	if err == nil {
+		return nil
+	}
+
+	if aerr := c.aborted(); aerr != nil {
+		return aerr
+	}
+
+	// The device may reset before its response makes it back to us.
+	if nmxutil.IsRspTimeout(err) || !s.IsOpen() {
+		return nil
+	}
+
+	return err
+}
+
+// Indicates whether an image state response still shows the specified image
+// waiting to be tested, i.e., the device hasn't rebooted since it was marked.
+func imageTestPending(rsp *nmp.ImageStateRsp, hash []byte) bool {
+	for _, e := range rsp.Images {
+		if bytes.Equal(e.Hash, hash) {
+			return e.Pending && !e.Active
+		}
+	}
+
+	return false
+}
+
+// Repeatedly reads the image state until the device responds from its new
+// boot or the boot timeout expires.  The session is reopened if the reset
+// closed it.  If the session stayed open, nothing indicates that the device
+// has actually reset, so the settle delay is observed and responses that
+// still show the test image as pending are disregarded.
+func (c *ImageUpgradeVerifyCmd) runBoot(
+	s sesn.Sesn) (*ImageStateReadResult, error) {
+
+	deadline := time.Now().Add(c.BootTimeout)
+
+	if s.IsOpen() {
+		time.Sleep(c.BootSettle)
+	}
+
+	for {
+		if aerr := c.aborted(); aerr != nil {
+			return nil, aerr
+		}
+
+		var err error
+		if !s.IsOpen() {
+			err = s.Open()
+		}
+
+		if err == nil {
+			cmd := NewImageStateReadCmd()
+			cmd.SetTxOptions(c.TxOptions())
+
+			var res Result
+			res, err = c.runSub(cmd, s)
+			if err == nil {
+				sres := res.(*ImageStateReadResult)
+				if sres.Status() != 0 ||
+					!imageTestPending(sres.Rsp, c.Hash) {
+
+					return sres, nil
+				}
+				err = fmt.Errorf("device has not reset")
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf(
+				"device did not respond within %s of reset: %s",
+				c.BootTimeout.String(), err.Error())
+		}
+
+		// Don't spin if the device is refusing connections.
+		time.Sleep(250 * time.Millisecond)
+	}
+}
+
+func (c *ImageUpgradeVerifyCmd) Run(s sesn.Sesn) (Result, error) {
+	res := newImageUpgradeVerifyResult()
+
+	c.step(IMAGE_UPGRADE_VERIFY_STEP_UPLOAD)
+	ucmd := NewImageUpgradeCmd()
+	ucmd.SetTxOptions(c.TxOptions())
+	ucmd.Data = c.Data
+	ucmd.NoErase = c.NoErase
+	ucmd.ProgressCb = c.ProgressCb
+	ucmd.MaxWinSz = c.MaxWinSz
+
+	ures, err := c.runSub(ucmd, s)
+	if err != nil {
+		return nil, err
+	}
+	res.UpgradeRes = ures.(*ImageUpgradeResult)
+	if res.Status() != 0 {
+		return res, nil
+	}
+
+	c.step(IMAGE_UPGRADE_VERIFY_STEP_TEST)
+	tcmd := NewImageStateWriteCmd()
+	tcmd.SetTxOptions(c.TxOptions())
+	tcmd.Hash = c.Hash
+	tcmd.Confirm = false
+
+	tres, err := c.runSub(tcmd, s)
+	if err != nil {
+		return nil, err
+	}
+	res.TestRes = tres.(*ImageStateWriteResult)
+	if res.Status() != 0 {
+		return res, nil
+	}
+
+	c.step(IMAGE_UPGRADE_VERIFY_STEP_RESET)
+	if err := c.runReset(s); err != nil {
+		return nil, err
+	}
+
+	c.step(IMAGE_UPGRADE_VERIFY_STEP_BOOT)
+	bres, err := c.runBoot(s)
+	if err != nil {
+		return nil, err
+	}
+	res.BootRes = bres
+	if res.Status() != 0 {
+		return res, nil
+	}
+
+	active := false
+	for _, e := range bres.Rsp.Images {
+		if e.Active && bytes.Equal(e.Hash, c.Hash) {
+			active = true
+			break
+		}
+	}
+	if !active {
+		res.RolledBack = true
+		return res, nil
+	}
+
+	if c.HealthCb != nil {
+		c.step(IMAGE_UPGRADE_VERIFY_STEP_HEALTH)
+		if err := c.HealthCb(s); err != nil {
+			res.HealthErr = err
+			return res, nil
+		}
+	}
+
+	c.step(IMAGE_UPGRADE_VERIFY_STEP_CONFIRM)
+	ccmd := NewImageStateWriteCmd()
+	ccmd.SetTxOptions(c.TxOptions())
+	ccmd.Hash = c.Hash
+	ccmd.Confirm = true
+
+	cres, err := c.runSub(ccmd, s)
+	if err != nil {
+		return nil, err
+	}
+	res.ConfirmRes = cres.(*ImageStateWriteResult)
+
+	return res, nil
+}
+
 //////////////////////////////////////////////////////////////////////////////
 // $corelist                                                                //
 //////////////////////////////////////////////////////////////////////////////
@@ -462,6 +896,13 @@ type CoreLoadProgressFn func(c *CoreLoadCmd, r *nmp.CoreLoadRsp)
 type CoreLoadCmd struct {
 	CmdBase
 	ProgressCb CoreLoadProgressFn
+
+	// Offset within the core to start downloading from.
+	StartOff int
+
+	// Maximum number of bytes to download; 0 means read to the end of the
+	// core.
+	NumBytes int
 }
 
 type CoreLoadResult struct {
@@ -479,13 +920,21 @@ func newCoreLoadResult() *CoreLoadResult {
 }
 
 func (r *CoreLoadResult) Status() int {
-	rsp := r.Rsps[len(r.Rsps)-1]
-	return rsp.Rc
+	if len(r.Rsps) > 0 {
+		return r.Rsps[len(r.Rsps)-1].Rc
+	} else {
+		return nmp.NMP_ERR_EUNKNOWN
+	}
 }
 
 func (c *CoreLoadCmd) Run(s sesn.Sesn) (Result, error) {
 	res := newCoreLoadResult()
-	off := 0
+	off := c.StartOff
+
+	endOff := -1
+	if c.NumBytes > 0 {
+		endOff = c.StartOff + c.NumBytes
+	}
 
 	for {
 		r := nmp.NewCoreLoadReq()
@@ -497,6 +946,17 @@ func (c *CoreLoadCmd) Run(s sesn.Sesn) (Result, error) {
 		}
 		irsp := rsp.(*nmp.CoreLoadRsp)
 
+		// Don't report data beyond the requested range.
+		if endOff >= 0 {
+			room := endOff - int(irsp.Off)
+			if room < 0 {
+				room = 0
+			}
+			if len(irsp.Data) > room {
+				irsp.Data = irsp.Data[:room]
+			}
+		}
+
 		if c.ProgressCb != nil {
 			c.ProgressCb(c, irsp)
 		}
@@ -512,6 +972,9 @@ func (c *CoreLoadCmd) Run(s sesn.Sesn) (Result, error) {
 		}
 
 		off = int(irsp.Off) + len(irsp.Data)
+		if endOff >= 0 && off >= endOff {
+			break
+		}
 	}
 
 	return res, nil
@@ -0,0 +1,235 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package xact
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"mynewt.apache.org/newtmgr/nmxact/nmp"
+	"mynewt.apache.org/newtmgr/nmxact/sesn"
+	"mynewt.apache.org/newtmgr/nmxact/sim"
+)
+
+func testImage(version string, size int) []byte {
+	body := make([]byte, size)
+	for i := range body {
+		body[i] = byte(i * 7)
+	}
+
+	return sim.BuildImage(version, body)
+}
+
+// The SHA256 TLV is the last thing in an image built by the simulator.
+func testImageHash(data []byte) []byte {
+	return data[len(data)-32:]
+}
+
+func TestImageUpgrade(t *testing.T) {
+	for _, winSz := range []int{1, 4} {
+		sx, s := newSimSesn(t, sim.NewXportCfg())
+
+		data := testImage("2.0.0", 10000)
+
+		c := NewImageUpgradeCmd()
+		c.Data = data
+		c.MaxWinSz = winSz
+		runCmd(t, c, s)
+
+		if !bytes.Equal(sx.Device().ImageData(1), data) {
+			t.Errorf("window=%d: slot 1 doesn't contain the uploaded image",
+				winSz)
+		}
+
+		sx.Stop()
+	}
+}
+
+func TestImageUploadWindowed(t *testing.T) {
+	for _, winSz := range []int{2, 3, 8} {
+		sx, s := newSimSesn(t, sim.NewXportCfg())
+
+		data := testImage("2.0.0", 20000)
+
+		var offs []uint32
+		c := NewImageUploadCmd()
+		c.Data = data
+		c.MaxWinSz = winSz
+		c.ProgressCb = func(c *ImageUploadCmd, rsp *nmp.ImageUploadRsp) {
+			offs = append(offs, rsp.Off)
+		}
+		runCmd(t, c, s)
+
+		if !bytes.Equal(sx.Device().ImageData(1), data) {
+			t.Errorf("window=%d: slot 1 doesn't contain the uploaded image",
+				winSz)
+		}
+		if len(offs) == 0 || offs[len(offs)-1] != uint32(len(data)) {
+			t.Errorf("window=%d: final offset %v; want %d", winSz, offs,
+				len(data))
+		}
+
+		sx.Stop()
+	}
+}
+
+// Records the sequence numbers of the requests sent over a session and of
+// the receives that were aborted.
+type seqRecSesn struct {
+	sesn.Sesn
+
+	txSeqs    []int
+	abortSeqs []int
+	mtx       sync.Mutex
+}
+
+func (s *seqRecSesn) TxNmpOnce(m *nmp.NmpMsg, opt sesn.TxOptions) (
+	nmp.NmpRsp, error) {
+
+	s.mtx.Lock()
+	s.txSeqs = append(s.txSeqs, int(m.Hdr.Seq))
+	s.mtx.Unlock()
+
+	return s.Sesn.TxNmpOnce(m, opt)
+}
+
+func (s *seqRecSesn) AbortRx(seq uint8) error {
+	s.mtx.Lock()
+	s.abortSeqs = append(s.abortSeqs, int(seq))
+	s.mtx.Unlock()
+
+	return s.Sesn.AbortRx(seq)
+}
+
+func TestImageUploadWindowedAbort(t *testing.T) {
+	// The device ignores requests while it reboots, so the first chunk
+	// stays in flight until the command is aborted.
+	cfg := sim.NewXportCfg()
+	cfg.ResetDelay = time.Hour
+	sx, ss := newSimSesn(t, cfg)
+	defer sx.Stop()
+
+	runCmd(t, NewResetCmd(), ss)
+
+	s := &seqRecSesn{Sesn: ss}
+
+	c := NewImageUploadCmd()
+	c.Data = testImage("2.0.0", 20000)
+	c.MaxWinSz = 4
+	c.SetTxOptions(sesn.TxOptions{Timeout: time.Minute, Tries: 1})
+
+	errCh := make(chan error)
+	go func() {
+		_, err := c.Run(s)
+		errCh <- err
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	if err := c.Abort(); err != nil {
+		t.Fatalf("abort failed: %s", err.Error())
+	}
+
+	select {
+	case err := <-errCh:
+		if err == nil {
+			t.Errorf("aborted upload succeeded")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("abort didn't cancel the requests in flight")
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	sort.Ints(s.txSeqs)
+	sort.Ints(s.abortSeqs)
+	if len(s.txSeqs) == 0 || !reflect.DeepEqual(s.abortSeqs, s.txSeqs) {
+		t.Errorf("aborted receives %v; want %v", s.abortSeqs, s.txSeqs)
+	}
+}
+
+func TestImageUpgradeAbort(t *testing.T) {
+	cfg := sim.NewXportCfg()
+	cfg.ResetDelay = time.Hour
+	sx, s := newSimSesn(t, cfg)
+	defer sx.Stop()
+
+	runCmd(t, NewResetCmd(), s)
+
+	// The upload is run by a sub-command; aborting the upgrade has to reach
+	// it.
+	c := NewImageUpgradeCmd()
+	c.Data = testImage("2.0.0", 5000)
+	c.NoErase = true
+	c.SetTxOptions(sesn.TxOptions{Timeout: time.Minute, Tries: 1})
+
+	errCh := make(chan error)
+	go func() {
+		_, err := c.Run(s)
+		errCh <- err
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	if err := c.Abort(); err != nil {
+		t.Fatalf("abort failed: %s", err.Error())
+	}
+
+	select {
+	case err := <-errCh:
+		if err == nil {
+			t.Errorf("aborted upgrade succeeded")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("abort didn't stop the upload")
+	}
+}
+
+func TestImageUpgradeVerify(t *testing.T) {
+	// The session survives the reset, so the command has to notice on its
+	// own that the device rebooted.
+	sx, s := newSimSesn(t, sim.NewXportCfg())
+	defer sx.Stop()
+
+	data := testImage("2.0.0", 5000)
+
+	c := NewImageUpgradeVerifyCmd()
+	c.SetTxOptions(simTxOptions())
+	c.Data = data
+	c.Hash = testImageHash(data)
+	c.BootSettle = 0
+	res := runCmd(t, c, s).(*ImageUpgradeVerifyResult)
+
+	if res.RolledBack {
+		t.Fatalf("upgrade reported a rollback")
+	}
+	if res.ConfirmRes == nil {
+		t.Fatalf("image not confirmed")
+	}
+	if sx.Device().BootCount() != 1 {
+		t.Errorf("device booted %d times; want 1", sx.Device().BootCount())
+	}
+	if !bytes.Equal(sx.Device().ImageData(0), data) {
+		t.Errorf("slot 0 doesn't contain the new image")
+	}
+}
@@ -0,0 +1,71 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package xact
+
+import (
+	"testing"
+
+	"mynewt.apache.org/newtmgr/nmxact/nmp"
+	"mynewt.apache.org/newtmgr/nmxact/sim"
+)
+
+func TestLogShow(t *testing.T) {
+	sx, s := newSimSesn(t, sim.NewXportCfg())
+	defer sx.Stop()
+
+	msgs := []string{"one", "two", "three"}
+	for _, msg := range msgs {
+		sx.Device().AppendLog("app", nmp.MODULE_DEFAULT, nmp.LEVEL_WARN, msg)
+	}
+
+	c := NewLogShowCmd()
+	c.Name = "app"
+	rsp := runCmd(t, c, s).(*LogShowResult).Rsp
+
+	if len(rsp.Logs) != 1 || rsp.Logs[0].Name != "app" {
+		t.Fatalf("unexpected logs in response: %+v", rsp.Logs)
+	}
+	entries := rsp.Logs[0].Entries
+	if len(entries) != len(msgs) {
+		t.Fatalf("got %d entries; want %d", len(entries), len(msgs))
+	}
+	for i, e := range entries {
+		if e.Msg != msgs[i] || e.Level != nmp.LEVEL_WARN {
+			t.Errorf("entry %d: %+v", i, e)
+		}
+	}
+
+	// Only entries at or after the requested index are returned.
+	c.Index = entries[1].Index
+	rsp = runCmd(t, c, s).(*LogShowResult).Rsp
+	if n := len(rsp.Logs[0].Entries); n != 2 {
+		t.Errorf("got %d entries from index %d; want 2", n, c.Index)
+	}
+
+	c.Name = "nosuchlog"
+	res, err := c.Run(s)
+	if err != nil {
+		t.Fatalf("log show failed: %s", err.Error())
+	}
+	if res.Status() != nmp.NMP_ERR_ENOENT {
+		t.Errorf("unknown log: rc=%d; want %d", res.Status(),
+			nmp.NMP_ERR_ENOENT)
+	}
+}
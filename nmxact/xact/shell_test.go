@@ -0,0 +1,67 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package xact
+
+import (
+	"testing"
+
+	"mynewt.apache.org/newtmgr/nmxact/nmp"
+	"mynewt.apache.org/newtmgr/nmxact/sim"
+)
+
+func TestShellExec(t *testing.T) {
+	sx, s := newSimSesn(t, sim.NewXportCfg())
+	defer sx.Stop()
+
+	tests := []struct {
+		argv   []string
+		rc     int
+		output string
+		ret    int
+	}{
+		{[]string{"echo", "hello", "world"}, 0, "hello world\n", 0},
+		{[]string{"echo"}, 0, "\n", 0},
+		{[]string{"exit", "3"}, 0, "", 3},
+		{[]string{"exit", "-5"}, 0, "", -5},
+		{[]string{"bogus"}, 0, "Unrecognized command: bogus\n",
+			sim.SHELL_RET_UNKNOWN},
+		{[]string{}, nmp.NMP_ERR_EINVAL, "", 0},
+	}
+
+	for _, test := range tests {
+		c := NewShellExecCmd()
+		c.SetTxOptions(simTxOptions())
+		c.Argv = test.argv
+
+		res, err := c.Run(s)
+		if err != nil {
+			t.Fatalf("%v: command failed: %s", test.argv, err.Error())
+		}
+		rsp := res.(*ShellExecResult).Rsp
+
+		if res.Status() != test.rc {
+			t.Errorf("%v: rc=%d; want %d", test.argv, res.Status(), test.rc)
+		}
+		if rsp.Output != test.output || rsp.Ret != test.ret {
+			t.Errorf("%v: got output %q, ret %d; want %q, %d", test.argv,
+				rsp.Output, rsp.Ret, test.output, test.ret)
+		}
+	}
+}
@@ -27,16 +27,10 @@ import (
 func txReq(s sesn.Sesn, m *nmp.NmpMsg, c *CmdBase) (
 	nmp.NmpRsp, error) {
 
-	if c.abortErr != nil {
-		return nil, c.abortErr
+	if err := c.txStart(s, m.Hdr.Seq); err != nil {
+		return nil, err
 	}
-
-	c.curNmpSeq = m.Hdr.Seq
-	c.curSesn = s
-	defer func() {
-		c.curNmpSeq = 0
-		c.curSesn = nil
-	}()
+	defer c.txDone(m.Hdr.Seq)
 
 	rsp, err := sesn.TxNmp(s, m, c.TxOptions())
 	if err != nil {
@@ -45,3 +39,18 @@ func txReq(s sesn.Sesn, m *nmp.NmpMsg, c *CmdBase) (
 
 	return rsp, nil
 }
+
+// Attempts to recover from a disconnect by reopening the session.  Returns
+// nil if the session was reopened; otherwise, the original error.  An aborted
+// command is never rescued.
+func rescueSesn(s sesn.Sesn, err error, c *CmdBase) error {
+	if err != nil && c.aborted() == nil {
+		if !s.IsOpen() {
+			if err := s.Open(); err == nil {
+				return nil
+			}
+		}
+	}
+
+	return err
+}
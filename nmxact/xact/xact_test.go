@@ -0,0 +1,96 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package xact
+
+import (
+	"testing"
+	"time"
+
+	"mynewt.apache.org/newtmgr/nmxact/sesn"
+	"mynewt.apache.org/newtmgr/nmxact/sim"
+)
+
+// Starts a simulated transport and opens a session to its device.  The
+// caller stops the transport when done.
+func newSimSesn(t *testing.T, cfg *sim.XportCfg) (*sim.SimXport, sesn.Sesn) {
+	return newSimSesnCfg(t, cfg, sesn.NewSesnCfg())
+}
+
+func newSimSesnCfg(t *testing.T, cfg *sim.XportCfg,
+	sc sesn.SesnCfg) (*sim.SimXport, sesn.Sesn) {
+
+	sx := sim.NewSimXport(cfg)
+	if err := sx.Start(); err != nil {
+		t.Fatalf("failed to start sim transport: %s", err.Error())
+	}
+
+	s, err := sx.BuildSesn(sc)
+	if err != nil {
+		sx.Stop()
+		t.Fatalf("failed to build sim session: %s", err.Error())
+	}
+	if err := s.Open(); err != nil {
+		sx.Stop()
+		t.Fatalf("failed to open sim session: %s", err.Error())
+	}
+
+	return sx, s
+}
+
+// Transmit options that keep tests from stalling on frames the simulated
+// device drops while it reboots.
+func simTxOptions() sesn.TxOptions {
+	return sesn.TxOptions{
+		Timeout: time.Second,
+		Tries:   1,
+	}
+}
+
+func runCmd(t *testing.T, c Cmd, s sesn.Sesn) Result {
+	res, err := c.Run(s)
+	if err != nil {
+		t.Fatalf("command failed: %s", err.Error())
+	}
+	if res.Status() != 0 {
+		t.Fatalf("command failed: rc=%d", res.Status())
+	}
+
+	return res
+}
+
+func TestEcho(t *testing.T) {
+	for _, proto := range []sesn.MgmtProto{
+		sesn.MGMT_PROTO_NMP, sesn.MGMT_PROTO_OMP} {
+
+		sc := sesn.NewSesnCfg()
+		sc.MgmtProto = proto
+		sx, s := newSimSesnCfg(t, sim.NewXportCfg(), sc)
+
+		c := NewEchoCmd()
+		c.Payload = "hello"
+		res := runCmd(t, c, s).(*EchoResult)
+		if res.Rsp.Payload != c.Payload {
+			t.Errorf("proto %d: echo returned \"%s\"; want \"%s\"",
+				proto, res.Rsp.Payload, c.Payload)
+		}
+
+		sx.Stop()
+	}
+}